@@ -0,0 +1,204 @@
+package magitrickle
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"magitrickle/models"
+	"magitrickle/net-namespace"
+	"magitrickle/netfilter-helper"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// addrReceiveBufferSize is the netlink socket buffer for address updates, prefix delegation
+// renumbering removes and adds addresses of every LAN interface at once
+const addrReceiveBufferSize = 1 << 20
+
+// linkAddresses returns addresses of the LAN interfaces
+func (a *App) linkAddresses() ([]netlink.Addr, error) {
+	var addrList []netlink.Addr
+	for _, linkName := range a.config.Link {
+		link, err := netNamespace.Netlink.LinkByName(linkName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find link %s: %w", linkName, err)
+		}
+		linkAddrList, err := netNamespace.Netlink.AddrList(link, nl.FAMILY_ALL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list address of interface: %w", err)
+		}
+		addrList = append(addrList, linkAddrList...)
+	}
+	return addrList, nil
+}
+
+// lanAddresses are addresses of the router on LAN interfaces and subnets of LAN clients
+type lanAddresses struct {
+	own      []net.IP
+	networks []*net.IPNet
+}
+
+func (a *App) setLANAddresses(addrList []netlink.Addr) {
+	lan := &lanAddresses{}
+	for _, addr := range addrList {
+		lan.own = append(lan.own, addr.IP)
+		if len(a.config.DNSProxy.FakePTRSubnets) == 0 && addr.IPNet != nil {
+			lan.networks = append(lan.networks, &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask})
+		}
+	}
+	for _, subnet := range a.config.DNSProxy.FakePTRSubnets {
+		network, err := models.ParseCIDR(subnet)
+		if err != nil {
+			continue
+		}
+		lan.networks = append(lan.networks, network)
+	}
+	a.lan.Store(lan)
+}
+
+// originalClient returns the client of the query translated to an address of the router on the way
+// to the proxy, the peer itself otherwise
+func (a *App) originalClient(peerAddr net.Addr, network string) net.Addr {
+	ip := clientIP(peerAddr)
+	lan := a.lan.Load()
+	if ip == nil || lan == nil || !slices.ContainsFunc(lan.own, ip.Equal) {
+		return peerAddr
+	}
+	proto, port := uint8(netfilterHelper.ProtoUDP), 0
+	switch v := peerAddr.(type) {
+	case *net.UDPAddr:
+		port = v.Port
+	case *net.TCPAddr:
+		proto, port = netfilterHelper.ProtoTCP, v.Port
+	}
+	src, srcPort, ok, err := netfilterHelper.OriginalSource(proto, ip, uint16(port), a.config.DNSProxy.Host.Port)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to recover DNS client")
+		return peerAddr
+	}
+	if !ok {
+		return peerAddr
+	}
+	log.Trace().Str("peer", peerAddr.String()).Str("client", src.String()).Str("network", network).Msg("recovered DNS client")
+	if network == "tcp" {
+		return &net.TCPAddr{IP: src, Port: int(srcPort)}
+	}
+	return &net.UDPAddr{IP: src, Port: int(srcPort)}
+}
+
+// isLANClient reports whether the DNS client is a LAN device, the router itself is not
+func (a *App) isLANClient(clientAddr net.Addr) bool {
+	ip := clientIP(clientAddr)
+	lan := a.lan.Load()
+	if ip == nil || lan == nil {
+		return false
+	}
+	for _, own := range lan.own {
+		if own.Equal(ip) {
+			return false
+		}
+	}
+	for _, network := range lan.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameAddresses(a, b []netlink.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	known := make(map[string]struct{}, len(a))
+	for _, addr := range a {
+		known[addr.IP.String()] = struct{}{}
+	}
+	for _, addr := range b {
+		if _, ok := known[addr.IP.String()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// handleAddr refreshes LAN addresses on change (DHCP renew, bridge reconfiguration) and re-installs the DNS remap
+func (a *App) handleAddr(event netlink.AddrUpdate) {
+	link, err := netNamespace.Netlink.LinkByIndex(event.LinkIndex)
+	if err != nil {
+		return
+	}
+	isLAN := false
+	for _, linkName := range a.config.Link {
+		if link.Attrs().Name == linkName {
+			isLAN = true
+			break
+		}
+	}
+	if !isLAN {
+		return
+	}
+
+	log.Debug().
+		Str("interface", link.Attrs().Name).
+		Str("address", event.LinkAddress.String()).
+		Bool("added", event.NewAddr).
+		Msg("LAN address event")
+	a.syncLANAddresses()
+}
+
+// syncLANAddresses re-reads LAN addresses, IPv6 ones change on prefix delegation renumbering
+func (a *App) syncLANAddresses() {
+	addrList, err := a.linkAddresses()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list LAN addresses")
+		return
+	}
+	a.setLANAddresses(addrList)
+	if a.dnsOverrider4 == nil || sameAddresses(a.dnsOverrider4.Addresses, addrList) {
+		return
+	}
+
+	log.Info().Int("addresses", len(addrList)).Msg("LAN addresses changed, re-installing DNS remap")
+	for _, dnsOverrider := range []*netfilterHelper.PortRemap{a.dnsOverrider4, a.dnsOverrider6} {
+		if dnsOverrider == nil {
+			continue
+		}
+		err = dnsOverrider.SetAddresses(addrList)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to update DNS remap")
+		}
+	}
+}
+
+// subscribeAddr subscribes to address updates, the channel is closed on a receive error
+// (e.g. the socket buffer overflowed by a burst of IPv6 renumbering events)
+func subscribeAddr(done chan struct{}) (chan netlink.AddrUpdate, error) {
+	ch := make(chan netlink.AddrUpdate)
+	err := netlink.AddrSubscribeWithOptions(ch, done, netlink.AddrSubscribeOptions{
+		Namespace: netNamespace.Handle(),
+		ErrorCallback: func(err error) {
+			log.Debug().Err(err).Msg("address updates error")
+		},
+		ReceiveBufferSize: addrReceiveBufferSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to address updates: %w", err)
+	}
+	return ch, nil
+}
+
+// resubscribeAddr subscribes again and re-reads addresses as updates may have been lost,
+// it returns nil on failure so the next heartbeat retries
+func (a *App) resubscribeAddr(done chan struct{}) chan netlink.AddrUpdate {
+	ch, err := subscribeAddr(done)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to re-subscribe to address updates")
+		return nil
+	}
+	a.syncLANAddresses()
+	return ch
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"magitrickle"
+	"magitrickle/app-errors"
+	"magitrickle/log-buffer"
+	"magitrickle/models"
+	"magitrickle/netfilter-helper"
+	"magitrickle/socket-activation"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Server struct {
+	app    *magitrickle.App
+	logs   *logBuffer.Buffer
+	mux    *http.ServeMux
+	tokens []models.APIToken
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) ListenAndServe(ctx context.Context, address string, port uint16) error {
+	// The socket passed by systemd or the previous daemon is taken over, so clients aren't refused during restarts
+	listener, err := socketActivation.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(int(port))), net.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen api port: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().Str("address", listener.Addr().String()).Msg("serving api")
+	err = srv.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve api: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the error with its kind (see appErrors.Kind) if it's known
+func writeError(w http.ResponseWriter, status int, err error) {
+	body := map[string]string{"error": err.Error()}
+	if kind := appErrors.Kind(err); kind != "" {
+		body["kind"] = kind
+	}
+	writeJSON(w, status, body)
+}
+
+// writeAppError writes the error of the app with the status of its kind
+func writeAppError(w http.ResponseWriter, err error) {
+	writeError(w, statusOf(err), err)
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, appErrors.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, appErrors.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, appErrors.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, appErrors.ErrDNSUpstream):
+		return http.StatusBadGateway
+	case errors.Is(err, netfilterHelper.ErrTimeout):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func New(app *magitrickle.App, logs *logBuffer.Buffer) *Server {
+	s := &Server{
+		app:    app,
+		logs:   logs,
+		mux:    http.NewServeMux(),
+		tokens: app.ExportConfig().App.API.Tokens,
+	}
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/api/logs", s.handleLogs)
+	s.mux.HandleFunc("/api/devices", s.handleDevices)
+	s.mux.HandleFunc("/api/interfaces", s.handleInterfaces)
+	s.mux.HandleFunc("/api/summary", s.handleSummary)
+	s.mux.HandleFunc("/api/flows", s.handleFlows)
+	s.mux.HandleFunc("/api/records", s.handleRecords)
+	s.mux.HandleFunc("/api/records/stats", s.handleRecordsStats)
+	s.mux.HandleFunc("/api/records/flush", s.handleRecordsFlush)
+	s.mux.HandleFunc("/api/records/", s.handleRecord)
+	s.mux.HandleFunc("/api/capture.pcap", s.handleCapture)
+	s.mux.HandleFunc("/api/debug/matcher", s.handleMatcherStats)
+	s.mux.HandleFunc("/api/debug/decisions", s.handleDecisions)
+	s.mux.HandleFunc("/api/suggestions", s.handleSuggestions)
+	s.mux.HandleFunc("/api/discovery", s.handleDiscovery)
+	s.mux.HandleFunc("/api/discovery/", s.handleDiscoverySession)
+	s.mux.HandleFunc("/api/pause", s.handlePause)
+	s.mux.HandleFunc("/api/resume", s.handleResume)
+	s.mux.HandleFunc("/api/ids", s.handleIDs)
+	s.mux.HandleFunc("/api/groups", s.handleGroups)
+	s.mux.HandleFunc("/api/groups/", s.handleGroup)
+	s.mux.HandleFunc("/api/subscriptions/held", s.handleHeldUpdates)
+	s.mux.HandleFunc("/api/subscriptions/sources", s.handleSubscriptionSources)
+	s.mux.HandleFunc("/api/history/top", s.handleHistoryTop)
+	s.mux.HandleFunc("/api/stats/export", s.handleStatsExport)
+	s.mux.HandleFunc("/api/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/api/metrics/", s.handleMetric)
+	s.mux.HandleFunc("/api/config/simulate", s.handleConfigSimulate)
+	s.mux.HandleFunc("/api/config/schema", s.handleConfigSchema)
+	s.mux.HandleFunc("/api/migrate/kvas", s.handleMigrateKVAS)
+	return s
+}
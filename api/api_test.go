@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"magitrickle"
+	"magitrickle/log-buffer"
+	"magitrickle/models"
+	"magitrickle/netfilter-helper"
+)
+
+func TestErrorKinds(t *testing.T) {
+	app := magitrickle.New()
+	err := app.ImportConfig(models.Config{ConfigVersion: "0.1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(app, logBuffer.New(10))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/groups/missing/resync", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	var body map[string]string
+	err = json.NewDecoder(rec.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotFound || body["kind"] != "notFound" {
+		t.Fatalf("unexpected response %d %v", rec.Code, body)
+	}
+
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("failed to import: %w", models.ErrInvalidSlug), http.StatusUnprocessableEntity},
+		{magitrickle.ErrSubscriptionNotHeld, http.StatusConflict},
+		{fmt.Errorf("failed to add entry: %w", netfilterHelper.ErrTimeout), http.StatusServiceUnavailable},
+		{fmt.Errorf("other"), http.StatusInternalServerError},
+	} {
+		if status := statusOf(tc.err); status != tc.status {
+			t.Errorf("status of %v: %d, expected %d", tc.err, status, tc.status)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"magitrickle/models"
+)
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+	errForbidden    = errors.New("read-only token")
+)
+
+// publicPaths are probes served without a token
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// requestToken returns the bearer token of the request, the query parameter
+// is for clients which can't set headers (EventSource)
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// role returns the role of the request token, "" if the token is unknown
+func (s *Server) role(r *http.Request) string {
+	if len(s.tokens) == 0 {
+		return models.APIRoleAdmin
+	}
+	token := requestToken(r)
+	if token == "" {
+		return ""
+	}
+	var role string
+	// Every token is compared, so the time doesn't tell which one is close
+	for _, candidate := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate.Token), []byte(token)) == 1 {
+			role = candidate.Role
+		}
+	}
+	return role
+}
+
+// authorize checks the token of the request: viewers are limited to reading, admins have full access
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if publicPaths[r.URL.Path] {
+		return true
+	}
+	switch s.role(r) {
+	case models.APIRoleAdmin:
+		return true
+	case models.APIRoleViewer:
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return true
+		}
+		writeError(w, http.StatusForbidden, errForbidden)
+		return false
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="magitrickle"`)
+		writeError(w, http.StatusUnauthorized, errUnauthorized)
+		return false
+	}
+}
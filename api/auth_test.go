@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"magitrickle"
+	"magitrickle/log-buffer"
+	"magitrickle/models"
+)
+
+func TestAuthorize(t *testing.T) {
+	app := magitrickle.New()
+	cfg := models.Config{ConfigVersion: "0.1.0"}
+	cfg.App.API.Tokens = []models.APIToken{
+		{Token: "viewer-token", Role: models.APIRoleViewer},
+		{Token: "admin-token", Role: models.APIRoleAdmin},
+	}
+	err := app.ImportConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(app, logBuffer.New(10))
+
+	for _, tc := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodGet, "/healthz", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/api/groups", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/groups", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/api/groups", "viewer-token", http.StatusOK},
+		{http.MethodPost, "/api/groups/missing/disable", "viewer-token", http.StatusForbidden},
+		{http.MethodPost, "/api/groups/missing/disable", "admin-token", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("%s %s with %q: expected %d, got %d", tc.method, tc.path, tc.token, tc.status, rec.Code)
+		}
+	}
+
+	// EventSource can't set headers
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/records?token=viewer-token", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("token of the query is rejected: %d", rec.Code)
+	}
+}
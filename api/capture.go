@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"magitrickle"
+)
+
+// handleCapture downloads DNS transactions with rule matches as a pcap file
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if !s.app.CaptureEnabled() {
+		writeError(w, http.StatusNotFound, magitrickle.ErrCaptureDisabled)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", `attachment; filename="magitrickle-`+time.Now().Format("20060102-150405")+`.pcap"`)
+	// Headers are sent already, on a write error the client gets a truncated file
+	_ = s.app.WriteCapture(w)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"magitrickle/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maxConfigSize = 1 << 20
+
+// readConfig parses the config from the request body (YAML or JSON), unknown fields are errors
+// if the running config is strict
+func (s *Server) readConfig(r *http.Request) (models.Config, error) {
+	var cfg models.Config
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigSize))
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read body: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	decoder.KnownFields(s.app.ExportConfig().App.ConfigMode == models.ConfigModeStrict)
+	err = decoder.Decode(&cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return cfg, nil
+}
+
+// handleConfigSchema serves the JSON Schema of the config
+func (s *Server) handleConfigSchema(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	_ = json.NewEncoder(w).Encode(models.ConfigSchema())
+}
+
+func (s *Server) handleConfigSimulate(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	cfg, err := s.readConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	diff, err := s.app.SimulateConfig(cfg)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
@@ -0,0 +1,12 @@
+package api
+
+import (
+	"net/http"
+)
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"devices": s.app.ListDevices()})
+}
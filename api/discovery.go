@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultDiscoveryDuration is the window of a discovery session by default
+const defaultDiscoveryDuration = time.Minute
+
+// handleDiscovery serves GET /api/discovery (sessions) and POST /api/discovery?client=&duration=,
+// which starts recording domains the client resolves
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": s.app.DiscoverySessions()})
+		return
+	}
+	duration := defaultDiscoveryDuration
+	if value := r.URL.Query().Get("duration"); value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
+			return
+		}
+	}
+	session, err := s.app.StartDiscovery(r.URL.Query().Get("client"), duration)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// handleDiscoverySession serves GET /api/discovery/{client} (domains and candidate rules)
+// and DELETE /api/discovery/{client}
+func (s *Server) handleDiscoverySession(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	client := strings.TrimPrefix(r.URL.Path, "/api/discovery/")
+	if r.Method == http.MethodDelete {
+		err := s.app.StopDiscovery(client)
+		if err != nil {
+			writeAppError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"stopped": true})
+		return
+	}
+	result, err := s.app.DiscoveryResult(client)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
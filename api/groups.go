@@ -0,0 +1,254 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"magitrickle"
+	"magitrickle/app-errors"
+	"magitrickle/models"
+)
+
+var errRuleNotFound = appErrors.New(appErrors.ErrNotFound, "rule not found")
+
+type ruleView struct {
+	ID          string `json:"id"`
+	Slug        string `json:"slug,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Color       string `json:"color,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	Type        string `json:"type"`
+	Rule        string `json:"rule"`
+	Action      string `json:"action,omitempty"`
+	Enable      bool   `json:"enable"`
+	// Subscription is the ID of the subscription the rule comes from
+	Subscription string `json:"subscription,omitempty"`
+}
+
+type groupView struct {
+	ID          string     `json:"id"`
+	Slug        string     `json:"slug,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Color       string     `json:"color,omitempty"`
+	Icon        string     `json:"icon,omitempty"`
+	Interface   string     `json:"interface"`
+	Shadow      bool       `json:"shadow"`
+	RouteIPv4   bool       `json:"routeIPv4"`
+	RouteIPv6   bool       `json:"routeIPv6"`
+	Enabled     bool       `json:"enabled"`
+	Rules       []ruleView `json:"rules"`
+}
+
+func newRuleView(rule *models.Rule) ruleView {
+	view := ruleView{
+		ID:          rule.ID.String(),
+		Slug:        rule.Slug,
+		Name:        rule.Name,
+		Description: rule.Metadata.Description,
+		Color:       rule.Metadata.Color,
+		Icon:        rule.Metadata.Icon,
+		Type:        rule.Type,
+		Rule:        rule.Rule,
+		Action:      rule.Action,
+		Enable:      rule.Enable,
+	}
+	if rule.Subscription != nil {
+		view.Subscription = rule.Subscription.String()
+	}
+	return view
+}
+
+func newGroupView(group models.Group, enabled bool) groupView {
+	view := groupView{
+		ID:          group.ID.String(),
+		Slug:        group.Slug,
+		Name:        group.Name,
+		Description: group.Metadata.Description,
+		Color:       group.Metadata.Color,
+		Icon:        group.Metadata.Icon,
+		Interface:   group.Interface,
+		Shadow:      group.Shadow,
+		RouteIPv4:   group.RoutesIPv4(),
+		RouteIPv6:   group.RoutesIPv6(),
+		Enabled:     enabled,
+		Rules:       make([]ruleView, 0, len(group.Rules)),
+	}
+	for _, rule := range group.Rules {
+		view.Rules = append(view.Rules, newRuleView(rule))
+	}
+	return view
+}
+
+// handleGroups lists groups, filters: q (name, slug or ID), enabled, interface
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	enabled, err := parseBool(r, "enabled")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	q, iface := r.URL.Query().Get("q"), r.URL.Query().Get("interface")
+
+	groups := s.app.ExportConfig().Groups
+	views := make([]groupView, 0, len(groups))
+	for _, group := range groups {
+		view := newGroupView(group, s.app.GroupEnabled(group.ID))
+		if q != "" && !containsFold(q, view.Name, view.Slug, view.ID) {
+			continue
+		}
+		if enabled != nil && view.Enabled != *enabled {
+			continue
+		}
+		if iface != "" && view.Interface != iface {
+			continue
+		}
+		views = append(views, view)
+	}
+
+	key := func(view groupView) string { return view.ID }
+	start, err := cursorIndex(views, p, key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	views, next := paginate(views, start, p, key)
+	writePage(w, "groups", views, next, p)
+}
+
+// ruleAction returns the action of the rule, route if it's not set
+func ruleAction(rule *models.Rule) string {
+	if rule.Action == "" {
+		return models.RuleActionRoute
+	}
+	return rule.Action
+}
+
+// handleRules lists rules of the group, filters: q (name, slug or rule), type, action, enable
+func (s *Server) handleRules(w http.ResponseWriter, r *http.Request, group models.Group) {
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	enable, err := parseBool(r, "enable")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	query := r.URL.Query()
+	q, ruleType, action := query.Get("q"), query.Get("type"), query.Get("action")
+
+	views := make([]ruleView, 0, len(group.Rules))
+	for _, rule := range group.Rules {
+		if q != "" && !containsFold(q, rule.Name, rule.Slug, rule.Rule) {
+			continue
+		}
+		if ruleType != "" && rule.Type != ruleType {
+			continue
+		}
+		if action != "" && ruleAction(rule) != action {
+			continue
+		}
+		if enable != nil && rule.Enable != *enable {
+			continue
+		}
+		views = append(views, newRuleView(rule))
+	}
+
+	key := func(view ruleView) string { return view.ID }
+	start, err := cursorIndex(views, p, key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	views, next := paginate(views, start, p, key)
+	writePage(w, "rules", views, next, p)
+}
+
+// handleGroup serves /api/groups/{group}, /api/groups/{group}/addresses, /api/groups/{group}/rules,
+// /api/groups/{group}/rules/{rule}, /api/groups/{group}/subscriptions, POST /api/groups/{group}/enable (disable)
+// and POST /api/groups/{group}/subscriptions/{subscription}/approve (reject), where keys are slugs or hex IDs
+func (s *Server) handleGroup(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/groups/"), "/")
+	if len(parts) == 2 && (parts[1] == "enable" || parts[1] == "disable") {
+		s.handleGroupEnable(w, r, parts[0], parts[1] == "enable")
+		return
+	}
+	if len(parts) == 2 && parts[1] == "resync" {
+		s.handleGroupResync(w, r, parts[0])
+		return
+	}
+	if len(parts) == 4 && parts[1] == "subscriptions" && (parts[3] == "approve" || parts[3] == "reject") {
+		s.handleSubscriptionDecision(w, r, parts[0], parts[2], parts[3] == "approve")
+		return
+	}
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	group, ok := s.app.FindGroup(parts[0])
+	if !ok {
+		writeError(w, http.StatusNotFound, magitrickle.ErrGroupNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		writeJSON(w, http.StatusOK, newGroupView(group, s.app.GroupEnabled(group.ID)))
+	case len(parts) == 2 && parts[1] == "addresses":
+		addresses, err := s.app.GroupAddresses(parts[0])
+		if err != nil {
+			writeAppError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"addresses": addresses})
+	case len(parts) == 2 && parts[1] == "rules":
+		s.handleRules(w, r, group)
+	case len(parts) == 2 && parts[1] == "subscriptions":
+		s.handleSubscriptions(w, group)
+	case len(parts) == 3 && parts[1] == "rules":
+		rule := group.FindRule(parts[2])
+		if rule == nil {
+			writeError(w, http.StatusNotFound, errRuleNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, newRuleView(rule))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleGroupResync(w http.ResponseWriter, r *http.Request, key string) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	err := s.app.ResyncGroup(key)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	group, _ := s.app.FindGroup(key)
+	writeJSON(w, http.StatusOK, newGroupView(group, s.app.GroupEnabled(group.ID)))
+}
+
+func (s *Server) handleGroupEnable(w http.ResponseWriter, r *http.Request, key string, enabled bool) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	err := s.app.SetGroupEnabled(key, enabled)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	group, _ := s.app.FindGroup(key)
+	writeJSON(w, http.StatusOK, newGroupView(group, enabled))
+}
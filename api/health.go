@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+)
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	health := s.app.Health()
+	status := http.StatusOK
+	if !health.IsLive() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	health := s.app.Health()
+	status := http.StatusOK
+	if !health.IsReady() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
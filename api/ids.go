@@ -0,0 +1,34 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"magitrickle/models"
+)
+
+// maxIDs is the maximum number of IDs generated by one request
+const maxIDs = 100
+
+// handleIDs serves POST /api/ids, which generates IDs for new groups and rules, count is the number of IDs (1 by default)
+func (s *Server) handleIDs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	count := 1
+	if countStr := r.URL.Query().Get("count"); countStr != "" {
+		var err error
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count < 1 || count > maxIDs {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %s (1-%d)", countStr, maxIDs))
+			return
+		}
+	}
+	ids, err := s.app.NewIDs(count)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]models.ID{"ids": ids})
+}
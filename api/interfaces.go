@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"magitrickle"
+)
+
+func (s *Server) handleInterfaces(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	interfaces, err := s.app.ListInterfaces(magitrickle.InterfaceFilter{
+		Target:       query.Get("target") == "true",
+		Up:           query.Get("up") == "true",
+		DefaultRoute: query.Get("defaultRoute") == "true",
+	})
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"interfaces": interfaces})
+}
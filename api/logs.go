@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"magitrickle/log-buffer"
+
+	"github.com/rs/zerolog"
+)
+
+func parseLogFilter(r *http.Request) (logBuffer.Filter, error) {
+	filter := logBuffer.Filter{
+		Level:     zerolog.TraceLevel,
+		Component: r.URL.Query().Get("component"),
+	}
+	if levelStr := r.URL.Query().Get("level"); levelStr != "" {
+		level, err := zerolog.ParseLevel(levelStr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid level: %w", err)
+		}
+		filter.Level = level
+	}
+	return filter, nil
+}
+
+// handleLogs returns the last entries (the page of the limit), the cursor of the response leads to older entries.
+// Filters: level, component, q (substring of the entry)
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if r.URL.Query().Get("follow") == "true" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamLogs(w, r, filter, p.limit)
+		return
+	}
+
+	var before uint64
+	if p.after != "" {
+		before, err = strconv.ParseUint(p.after, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errInvalidCursor)
+			return
+		}
+	}
+	q := r.URL.Query().Get("q")
+	entries := make([]logBuffer.Entry, 0)
+	for _, entry := range s.logs.List(filter) {
+		if before != 0 && entry.Seq >= before {
+			break
+		}
+		if q != "" && !containsFold(q, string(entry.Raw)) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	var next string
+	if len(entries) > p.limit {
+		entries = lastEntries(entries, p.limit)
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(entries[0].Seq, 10)))
+	}
+	logs := make([]json.RawMessage, len(entries))
+	for idx, entry := range entries {
+		logs[idx] = entry.Raw
+	}
+	writePage(w, "logs", logs, next, p)
+}
+
+func lastEntries(entries []logBuffer.Entry, limit int) []logBuffer.Entry {
+	if limit > 0 && len(entries) > limit {
+		return entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+func (s *Server) streamLogs(w http.ResponseWriter, r *http.Request, filter logBuffer.Filter, limit int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	// Subscribe before sending the backlog, so nothing is lost in between
+	ch, unsubscribe := s.logs.Subscribe(256)
+	defer unsubscribe()
+	backlog := lastEntries(s.logs.List(filter), limit)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, entry := range backlog {
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", strings.TrimSpace(string(entry.Raw)))
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry := <-ch:
+			if !filter.Match(entry) {
+				continue
+			}
+			_, err := fmt.Fprintf(w, "data: %s\n\n", strings.TrimSpace(string(entry.Raw)))
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"magitrickle/app-errors"
+	"magitrickle/metrics"
+)
+
+var errMetricNotFound = appErrors.New(appErrors.ErrNotFound, "metric not found")
+
+// handleMetrics lists names of metric series
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"resolution": int(metrics.Resolution / time.Second),
+		"retention":  int(metrics.Retention / time.Second),
+		"names":      s.app.MetricNames(),
+	})
+}
+
+// handleMetric serves /api/metrics/{name}?since=6h with points of the series for charts
+func (s *Server) handleMetric(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/metrics/")
+	period := metrics.Retention
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		period, err = time.ParseDuration(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid since: %w", err))
+			return
+		}
+	}
+	points, ok := s.app.MetricSeries(name, time.Now().Add(-period))
+	if !ok {
+		writeError(w, http.StatusNotFound, errMetricNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"name": name, "points": points})
+}
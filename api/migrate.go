@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"magitrickle"
+	"magitrickle/kvas-migrate"
+
+	"gopkg.in/yaml.v3"
+)
+
+// handleMigrateKVAS converts posted KVAS files into a config, nothing is applied
+func (s *Server) handleMigrateKVAS(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var input kvasMigrate.Input
+	err := json.NewDecoder(io.LimitReader(r.Body, maxConfigSize)).Decode(&input)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to parse body: %w", err))
+		return
+	}
+
+	cfg, err := kvasMigrate.Convert(input)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	cfg.App = magitrickle.DefaultAppConfig
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
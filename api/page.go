@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// page are list parameters common to list endpoints: ?limit=100&cursor=...&fields=id,name
+type page struct {
+	limit int
+	// after is the key of the last item of the previous page ("" - the first page)
+	after  string
+	fields []string
+}
+
+func parsePage(r *http.Request) (page, error) {
+	query := r.URL.Query()
+	p := page{limit: defaultPageLimit}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return p, fmt.Errorf("invalid limit: %s (1-%d)", limitStr, maxPageLimit)
+		}
+		p.limit = limit
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			return p, fmt.Errorf("%w: %s", errInvalidCursor, cursor)
+		}
+		p.after = string(after)
+	}
+	if fields := query.Get("fields"); fields != "" {
+		p.fields = strings.Split(fields, ",")
+	}
+	return p, nil
+}
+
+// paginate returns the page of items starting at the index and the cursor of the next page ("" - the last page)
+func paginate[T any](items []T, start int, p page, key func(T) string) ([]T, string) {
+	if start >= len(items) {
+		return []T{}, ""
+	}
+	items = items[start:]
+	if len(items) <= p.limit {
+		return items, ""
+	}
+	items = items[:p.limit]
+	return items, base64.RawURLEncoding.EncodeToString([]byte(key(items[len(items)-1])))
+}
+
+// cursorIndex returns the index following the item of the cursor in the list of a stable order
+func cursorIndex[T any](items []T, p page, key func(T) string) (int, error) {
+	if p.after == "" {
+		return 0, nil
+	}
+	for idx, item := range items {
+		if key(item) == p.after {
+			return idx + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: item %s is gone", errInvalidCursor, p.after)
+}
+
+// selectFields keeps only the requested JSON fields of items (sparse fieldsets), unknown fields are ignored
+func selectFields[T any](items []T, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return items, nil
+	}
+	selected := make([]map[string]json.RawMessage, len(items))
+	for idx, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		err = json.Unmarshal(data, &all)
+		if err != nil {
+			return nil, err
+		}
+		selected[idx] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := all[field]; ok {
+				selected[idx][field] = value
+			}
+		}
+	}
+	return selected, nil
+}
+
+// writePage writes the page of items under the name with the cursor of the next page
+func writePage[T any](w http.ResponseWriter, name string, items []T, next string, p page) {
+	view, err := selectFields(items, p.fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := map[string]interface{}{name: view}
+	if next != "" {
+		resp["nextCursor"] = next
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseBool parses an optional boolean filter
+func parseBool(r *http.Request, name string) (*bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return &parsed, nil
+}
+
+// containsFold reports whether any of the values contains the substring ignoring case
+func containsFold(substr string, values ...string) bool {
+	substr = strings.ToLower(substr)
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), substr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+)
+
+// handlePause serves GET /api/pause (state) and POST /api/pause, which removes all marking rules
+// and the DNS remap at once
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		err := s.app.Pause()
+		if err != nil {
+			writeAppError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": s.app.Paused()})
+}
+
+// handleResume serves POST /api/resume, which installs rules removed by the pause again
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	err := s.app.Resume()
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": s.app.Paused()})
+}
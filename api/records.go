@@ -0,0 +1,177 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"magitrickle/decisions"
+	"magitrickle/records"
+)
+
+type recordAddressView struct {
+	Address string `json:"address"`
+	TTL     uint32 `json:"ttl"`
+}
+
+type recordView struct {
+	Domain    string              `json:"domain"`
+	Alias     string              `json:"alias,omitempty"`
+	TTL       uint32              `json:"ttl,omitempty"`
+	Addresses []recordAddressView `json:"addresses,omitempty"`
+}
+
+// remainingTTL returns seconds until the deadline, zero if it has passed
+func remainingTTL(deadline, now time.Time) uint32 {
+	if !deadline.After(now) {
+		return 0
+	}
+	return uint32(deadline.Sub(now).Seconds())
+}
+
+func newRecordView(entry records.Entry, now time.Time) recordView {
+	view := recordView{Domain: entry.Domain, Alias: entry.Alias}
+	if entry.Alias != "" {
+		view.TTL = remainingTTL(entry.Deadline, now)
+	}
+	for _, addr := range entry.Addresses {
+		view.Addresses = append(view.Addresses, recordAddressView{
+			Address: addr.Address.String(),
+			TTL:     remainingTTL(addr.Deadline, now),
+		})
+	}
+	return view
+}
+
+func hasAddress(entry records.Entry, address net.IP) bool {
+	for _, addr := range entry.Addresses {
+		if addr.Address.Equal(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRecords lists cached DNS records sorted by domain, filters: q (domain), address
+func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	q := r.URL.Query().Get("q")
+	var address net.IP
+	if addressStr := r.URL.Query().Get("address"); addressStr != "" {
+		address = net.ParseIP(addressStr)
+		if address == nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address: %s", addressStr))
+			return
+		}
+	}
+
+	entries := s.app.ListRecords()
+	// Records expire between pages, so the page starts at the next domain after the cursor
+	start := sort.Search(len(entries), func(idx int) bool { return entries[idx].Domain > p.after })
+	now := time.Now()
+	views := make([]recordView, 0, p.limit+1)
+	for _, entry := range entries[start:] {
+		if len(views) > p.limit {
+			break
+		}
+		if q != "" && !containsFold(q, entry.Domain) {
+			continue
+		}
+		if address != nil && !hasAddress(entry, address) {
+			continue
+		}
+		views = append(views, newRecordView(entry, now))
+	}
+	views, next := paginate(views, 0, p, func(view recordView) string { return view.Domain })
+	writePage(w, "records", views, next, p)
+}
+
+func (s *Server) handleRecordsStats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.app.RecordsStats())
+}
+
+type forgetView struct {
+	Domains   int `json:"domains"`
+	Addresses int `json:"addresses"`
+}
+
+// handleRecordsFlush serves POST /api/records/flush, which drops all cached records and their addresses from groups
+func (s *Server) handleRecordsFlush(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	domains, addresses, err := s.app.FlushRecords()
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, forgetView{Domains: domains, Addresses: addresses})
+}
+
+// handleRecord serves DELETE /api/records/{domain}, which drops the domain with its aliases and CNAME chain
+// and deletes their addresses from groups
+func (s *Server) handleRecord(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodDelete) {
+		return
+	}
+	domains, addresses, err := s.app.ForgetDomain(strings.TrimPrefix(r.URL.Path, "/api/records/"))
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, forgetView{Domains: domains, Addresses: addresses})
+}
+
+func (s *Server) handleMatcherStats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.app.MatcherStats())
+}
+
+// handleDecisions lists the last rule match decisions from the newest, filters: q (domain or alias), matched.
+// The list changes with every query, so only the first page is returned.
+func (s *Server) handleDecisions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	matched, err := parseBool(r, "matched")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	q := r.URL.Query().Get("q")
+
+	list := make([]decisions.Decision, 0, p.limit)
+	for _, decision := range s.app.Decisions() {
+		if len(list) == p.limit {
+			break
+		}
+		if q != "" && !containsFold(q, append([]string{decision.Domain}, decision.Names...)...) {
+			continue
+		}
+		if matched != nil && decision.Matched != *matched {
+			continue
+		}
+		list = append(list, decision)
+	}
+	writePage(w, "decisions", list, "", p)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"magitrickle"
+	"magitrickle/models"
+)
+
+// handleStatsExport downloads group address counts, rule hits and top domains, format: json (default) or csv
+func (s *Server) handleStatsExport(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = models.StatsFormatJSON
+	case models.StatsFormatJSON, models.StatsFormatCSV:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: %s", magitrickle.ErrUnknownStatsFormat, format))
+		return
+	}
+	export, err := s.app.ExportStats()
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	if format == models.StatsFormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="magitrickle-stats-`+time.Now().Format("20060102-150405")+`.`+format+`"`)
+	// Headers are sent already, on a write error the client gets a truncated file
+	_ = magitrickle.WriteStats(w, export, format)
+}
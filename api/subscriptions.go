@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+
+	"magitrickle"
+	"magitrickle/models"
+)
+
+type subscriptionView struct {
+	ID            string                  `json:"id"`
+	URL           string                  `json:"url"`
+	Interval      uint32                  `json:"interval"`
+	HoldThreshold int                     `json:"holdThreshold"`
+	Rules         int                     `json:"rules"`
+	Held          *magitrickle.HeldUpdate `json:"held,omitempty"`
+	// Source is the fetch status of the list, it's unknown until the first check
+	Source *magitrickle.SubscriptionSource `json:"source,omitempty"`
+}
+
+// handleSubscriptions lists subscriptions of the group with their held updates and fetch statuses
+func (s *Server) handleSubscriptions(w http.ResponseWriter, group models.Group) {
+	sources := make(map[string]magitrickle.SubscriptionSource)
+	for _, source := range s.app.SubscriptionSources() {
+		sources[source.URL] = source
+	}
+	held := make(map[models.ID]magitrickle.HeldUpdate)
+	for _, update := range s.app.HeldUpdates() {
+		if update.GroupID == group.ID {
+			held[update.SubscriptionID] = update
+		}
+	}
+	views := make([]subscriptionView, 0, len(group.Subscriptions))
+	for _, sub := range group.Subscriptions {
+		view := subscriptionView{
+			ID:            sub.ID.String(),
+			URL:           sub.URL,
+			Interval:      sub.IntervalOrDefault(),
+			HoldThreshold: sub.HoldThresholdOrDefault(),
+		}
+		for _, rule := range group.Rules {
+			if rule.FromSubscription(sub.ID) {
+				view.Rules++
+			}
+		}
+		if update, ok := held[sub.ID]; ok {
+			view.Held = &update
+		}
+		if source, ok := sources[sub.URL]; ok {
+			view.Source = &source
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"subscriptions": views})
+}
+
+// handleHeldUpdates lists updates of all subscriptions waiting for approval
+func (s *Server) handleHeldUpdates(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"held": s.app.HeldUpdates()})
+}
+
+// handleSubscriptionSources lists fetch statuses of lists of all subscriptions
+func (s *Server) handleSubscriptionSources(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sources": s.app.SubscriptionSources()})
+}
+
+// handleSubscriptionDecision serves POST /api/groups/{group}/subscriptions/{subscription}/approve (reject)
+func (s *Server) handleSubscriptionDecision(w http.ResponseWriter, r *http.Request, groupKey, subKey string, approve bool) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	var err error
+	if approve {
+		err = s.app.ApproveSubscription(groupKey, subKey)
+	} else {
+		err = s.app.RejectSubscription(groupKey, subKey)
+	}
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	group, _ := s.app.FindGroup(groupKey)
+	s.handleSubscriptions(w, group)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"magitrickle"
+)
+
+// defaultSuggestionQueries is how many queries of unmatched domains make a suggestion by default
+const defaultSuggestionQueries = 2
+
+// handleSuggestions lists suggested rules for unmatched domains, filters: client, min (queries)
+func (s *Server) handleSuggestions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if !s.app.LearningEnabled() {
+		writeError(w, http.StatusNotFound, magitrickle.ErrLearningDisabled)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	minQueries := defaultSuggestionQueries
+	if minStr := r.URL.Query().Get("min"); minStr != "" {
+		minQueries, err = strconv.Atoi(minStr)
+		if err != nil || minQueries < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid min: %s", minStr))
+			return
+		}
+	}
+
+	suggestions := s.app.Suggestions(r.URL.Query().Get("client"), minQueries)
+	if len(suggestions) > p.limit {
+		suggestions = suggestions[:p.limit]
+	}
+	writePage(w, "suggestions", suggestions, "", p)
+}
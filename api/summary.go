@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+)
+
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.app.Summary())
+}
+
+// handleFlows returns active connections per group, sampled with the summary
+func (s *Server) handleFlows(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.app.Summary().Groups)
+}
@@ -0,0 +1,96 @@
+// Package appErrors is the taxonomy of errors of the app: every error which callers may act on
+// has one of the kinds, so the API and the CLI report it without parsing messages.
+package appErrors
+
+import "errors"
+
+// Kinds of errors, match them with errors.Is
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrValidation  = errors.New("validation failed")
+	ErrConflict    = errors.New("conflict")
+	ErrNetfilter   = errors.New("netfilter failure")
+	ErrDNSUpstream = errors.New("dns upstream failure")
+)
+
+var kinds = []struct {
+	err  error
+	name string
+	exit int
+}{
+	{ErrNotFound, "notFound", 3},
+	{ErrValidation, "validation", 2},
+	{ErrConflict, "conflict", 4},
+	{ErrNetfilter, "netfilter", 5},
+	{ErrDNSUpstream, "dnsUpstream", 6},
+}
+
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// New returns a sentinel error of the kind
+func New(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+type wrappedError struct {
+	kind error
+	err  error
+}
+
+func (e *wrappedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *wrappedError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+// Wrap marks the error with the kind keeping its message, nil stays nil
+func Wrap(kind, err error) error {
+	if err == nil || errors.Is(err, kind) {
+		return err
+	}
+	return &wrappedError{kind: kind, err: err}
+}
+
+// Kind returns the name of the kind of the error ("" - unknown), it's the "kind" field of API errors
+func Kind(err error) string {
+	for _, kind := range kinds {
+		if errors.Is(err, kind.err) {
+			return kind.name
+		}
+	}
+	return ""
+}
+
+// Parse returns the kind by its name (see Kind), nil if it's unknown. Clients of the control socket
+// restore the kind of the reply with it.
+func Parse(name string) error {
+	for _, kind := range kinds {
+		if kind.name == name {
+			return kind.err
+		}
+	}
+	return nil
+}
+
+// ExitCode returns the exit code of CLI commands failed with the error, 1 for unknown kinds
+func ExitCode(err error) int {
+	for _, kind := range kinds {
+		if errors.Is(err, kind.err) {
+			return kind.exit
+		}
+	}
+	return 1
+}
@@ -0,0 +1,31 @@
+package appErrors
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+)
+
+func TestKinds(t *testing.T) {
+	errGroupNotFound := New(ErrNotFound, "group not found")
+	err := fmt.Errorf("failed to update group: %w", errGroupNotFound)
+	if !errors.Is(err, errGroupNotFound) || !errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+		t.Fatalf("unexpected kind of %v", err)
+	}
+	if Kind(err) != "notFound" || ExitCode(err) != 3 {
+		t.Fatalf("unexpected kind %q and exit code %d", Kind(err), ExitCode(err))
+	}
+
+	err = Wrap(ErrNetfilter, syscall.ENOENT)
+	if err.Error() != syscall.ENOENT.Error() || !errors.Is(err, ErrNetfilter) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("wrapped error lost its cause: %v", err)
+	}
+	if Parse(Kind(err)) != ErrNetfilter || Parse("other") != nil {
+		t.Fatal("kind is not parsed back")
+	}
+	if Wrap(ErrNetfilter, nil) != nil || Kind(errors.New("other")) != "" || ExitCode(errors.New("other")) != 1 {
+		t.Fatal("unexpected result for errors without a kind")
+	}
+}
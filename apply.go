@@ -0,0 +1,173 @@
+package magitrickle
+
+import (
+	"fmt"
+
+	"magitrickle/group"
+	"magitrickle/models"
+	"magitrickle/net-namespace"
+	"magitrickle/netfilter-helper"
+)
+
+// AppliedGroup is what is needed to remove a group installed by Apply
+type AppliedGroup struct {
+	ID         string   `json:"id"`
+	Interface  string   `json:"interface"`
+	FixProtect bool     `json:"fixProtect"`
+	RateLimit  bool     `json:"rateLimit"`
+	IPSets     []string `json:"ipsets"`
+	Mark       uint32   `json:"mark"`
+	Table      int      `json:"table"`
+	// ExternalTable is an existing table of the config, only the mark rule is removed
+	ExternalTable bool `json:"externalTable,omitempty"`
+}
+
+// ApplyState describes the netfilter plumbing left installed by Apply
+type ApplyState struct {
+	Netns       string         `json:"netns,omitempty"`
+	ChainPrefix string         `json:"chainPrefix"`
+	Groups      []AppliedGroup `json:"groups"`
+	// FixProtect are rules of groups with fixProtect, DefaultFixProtect if they're missing
+	FixProtect []models.IPTablesSnippet `json:"fixProtect,omitempty"`
+}
+
+// Apply installs ipsets, iptables and routing rules of the groups and leaves them installed.
+// Only static (subnet) rules are filled, there is no DNS proxy to resolve domain rules.
+func (a *App) Apply() (*ApplyState, error) {
+	if a.isRunning() {
+		return nil, ErrAlreadyRunning
+	}
+
+	err := a.requireCapabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.enterNetns()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = netfilterHelper.Detect(a.config.Netfilter.IPSet.TablePrefix + "probe")
+	if err != nil {
+		return nil, err
+	}
+
+	nh4, err := netfilterHelper.New(false)
+	if err != nil {
+		return nil, fmt.Errorf("netfilter helper init fail: %w", err)
+	}
+	err = nh4.CleanIPTables(a.config.Netfilter.IPTables.ChainPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear iptables: %w", err)
+	}
+	a.nfHelper4 = nh4
+
+	for _, group := range a.unprocessedGroups {
+		err = a.AddGroup(group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state := &ApplyState{
+		Netns:       a.config.Netns,
+		ChainPrefix: a.config.Netfilter.IPTables.ChainPrefix,
+		Groups:      make([]AppliedGroup, 0, len(a.groups)),
+		FixProtect:  a.config.Netfilter.FixProtect,
+	}
+	for _, grp := range a.groups {
+		err = a.enableGroup(grp)
+		if err != nil {
+			for _, grp := range a.groups {
+				_ = grp.Destroy()
+			}
+			return nil, fmt.Errorf("failed to enable group: %w", err)
+		}
+		if !grp.IsEnabled() {
+			// Only ipsets of disabled groups are installed
+			state.Groups = append(state.Groups, AppliedGroup{ID: grp.ID.String(), IPSets: grp.IPSetNames()})
+			continue
+		}
+		state.Groups = append(state.Groups, appliedGroup(grp))
+	}
+
+	return state, nil
+}
+
+func appliedGroup(grp *group.Group) AppliedGroup {
+	mark, table := grp.Routing()
+	return AppliedGroup{
+		ID:            grp.ID.String(),
+		Interface:     grp.Interface,
+		FixProtect:    grp.FixProtect,
+		RateLimit:     grp.RateLimit != "" && table != 0,
+		IPSets:        grp.IPSetNames(),
+		Mark:          mark,
+		Table:         table,
+		ExternalTable: grp.Table != 0,
+	}
+}
+
+// Teardown removes everything installed by Apply
+func Teardown(state ApplyState) []error {
+	var errs []error
+
+	if state.Netns != "" {
+		err := netNamespace.Set(state.Netns)
+		if err != nil {
+			return []error{err}
+		}
+	}
+
+	nh4, err := netfilterHelper.New(false)
+	if err != nil {
+		return []error{fmt.Errorf("netfilter helper init fail: %w", err)}
+	}
+	err = nh4.CleanIPTables(state.ChainPrefix)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to clear iptables: %w", err))
+	}
+
+	fixProtect := state.FixProtect
+	if len(fixProtect) == 0 {
+		fixProtect = DefaultFixProtect
+	}
+	for _, grp := range state.Groups {
+		if grp.FixProtect {
+			values := map[string]string{models.SnippetInterface: grp.Interface}
+			for _, rule := range fixProtect {
+				err = nh4.IPTables.DeleteIfExists(rule.Table, rule.Chain, rule.Args(values)...)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("group %s: failed to remove fix protect: %w", grp.ID, err))
+				}
+			}
+		}
+		if grp.RateLimit {
+			for _, err := range netfilterHelper.DeleteShaper(grp.Interface, grp.Mark) {
+				errs = append(errs, fmt.Errorf("group %s: %w", grp.ID, err))
+			}
+		}
+		// Routes of an existing table belong to its owner
+		if grp.ExternalTable {
+			err = netfilterHelper.DeleteMarkRule(grp.Mark, grp.Table)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("group %s: %w", grp.ID, err))
+			}
+		} else if grp.Table != 0 {
+			// Proxy groups have no routing
+			for _, err := range netfilterHelper.DeleteMarkRouting(grp.Mark, grp.Table) {
+				errs = append(errs, fmt.Errorf("group %s: %w", grp.ID, err))
+			}
+		}
+		for _, setName := range grp.IPSets {
+			ipset := &netfilterHelper.IPSet{SetName: setName}
+			err = ipset.Destroy()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("group %s: %w", grp.ID, err))
+			}
+		}
+	}
+
+	return errs
+}
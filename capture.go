@@ -0,0 +1,79 @@
+package magitrickle
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"magitrickle/app-errors"
+	"magitrickle/dns-capture"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrCaptureDisabled = appErrors.New(appErrors.ErrNotFound, "capture of matched transactions is disabled")
+
+// captureTransaction keeps the query and the response which matched a rule for the pcap export
+func (a *App) captureTransaction(clientAddr net.Addr, reqMsg, respMsg *dns.Msg) {
+	client := clientIP(clientAddr)
+	if client == nil {
+		return
+	}
+	var port uint16
+	switch v := clientAddr.(type) {
+	case *net.UDPAddr:
+		port = uint16(v.Port)
+	case *net.TCPAddr:
+		port = uint16(v.Port)
+	}
+	req, err := reqMsg.Pack()
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to pack captured request")
+		return
+	}
+	resp, err := respMsg.Pack()
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to pack captured response")
+		return
+	}
+	a.capture.Add(dnsCapture.Transaction{
+		Time:     time.Now(),
+		Client:   client,
+		Port:     port,
+		Server:   a.captureServer(client),
+		Request:  req,
+		Response: resp,
+	})
+}
+
+// captureServer returns the address of the router the client talks to: the first LAN address
+// of the client family or the loopback
+func (a *App) captureServer(client net.IP) net.IP {
+	isIPv4 := client.To4() != nil
+	if lan := a.lan.Load(); lan != nil {
+		for _, own := range lan.own {
+			if (own.To4() != nil) == isIPv4 {
+				return own
+			}
+		}
+	}
+	if isIPv4 {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	return net.IPv6loopback
+}
+
+// CaptureEnabled reports whether DNS transactions with rule matches are kept
+func (a *App) CaptureEnabled() bool {
+	return a.capture != nil
+}
+
+// WriteCapture writes kept DNS transactions with rule matches as a pcap file
+func (a *App) WriteCapture(w io.Writer) error {
+	if a.capture == nil {
+		return ErrCaptureDisabled
+	}
+	_, err := a.capture.WriteTo(w)
+	return err
+}
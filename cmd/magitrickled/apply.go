@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"magitrickle"
+
+	"github.com/rs/zerolog/log"
+)
+
+const applyStateLocation = "/opt/var/run/magitrickle.apply.json"
+
+// runApply installs netfilter plumbing of the config and exits, the state is saved for teardown
+func runApply(args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	cfgPath := flags.String("c", cfgFileLocation, "config file")
+	statePath := flags.String("s", applyStateLocation, "state file for teardown")
+	configMode := flags.String("config-mode", "", configModeUsage)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := checkPIDFile(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*statePath); err == nil {
+		return fmt.Errorf("already applied, run teardown first (%s exists)", *statePath)
+	}
+
+	if _, err := os.Stat(*cfgPath); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*cfgPath, filepath.Join(filepath.Dir(*cfgPath), "conf.d"), *configMode)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	app := magitrickle.New()
+	err = app.ImportConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to import config: %w", err)
+	}
+
+	state, err := app.Apply()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(*statePath, data, 0644)
+	if err != nil {
+		_ = magitrickle.Teardown(*state)
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	log.Info().Int("groups", len(state.Groups)).Msg("applied")
+	return nil
+}
+
+// runTeardown removes netfilter plumbing installed by apply
+func runTeardown(args []string) error {
+	flags := flag.NewFlagSet("teardown", flag.ContinueOnError)
+	statePath := flags.String("s", applyStateLocation, "state file of apply")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*statePath)
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	var state magitrickle.ApplyState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("failed to parse state: %w", err)
+	}
+
+	errs := magitrickle.Teardown(state)
+	for _, err := range errs {
+		log.Error().Err(err).Msg("teardown error")
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("teardown finished with %d errors", len(errs))
+	}
+
+	_ = os.Remove(*statePath)
+	log.Info().Int("groups", len(state.Groups)).Msg("teardown complete")
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"magitrickle"
+	"magitrickle/app-errors"
+	"magitrickle/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrConfigConflict     = errors.New("config conflict")
+	ErrUnknownConfigField = appErrors.New(appErrors.ErrValidation, "unknown config field")
+)
+
+// configFragment is a single file of the configuration, every section is optional
+type configFragment struct {
+	ConfigVersion string                `yaml:"configVersion"`
+	App           *models.App           `yaml:"app"`
+	Defaults      *models.GroupDefaults `yaml:"defaults"`
+	Groups        []models.Group        `yaml:"groups"`
+
+	// groupKeys are keys set by every group definition, other fields are inherited from defaults
+	groupKeys []map[string]bool
+	// data is the source of the fragment for the strict mode
+	data []byte
+}
+
+func readConfigFragment(path string) (*configFragment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigFragment(data)
+}
+
+// parseRawConfigFragment parses the fragment keeping encrypted values, it is not validated
+func parseRawConfigFragment(data []byte) (*configFragment, error) {
+	fragment := &configFragment{}
+	err := yaml.Unmarshal(data, fragment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+	return fragment, nil
+}
+
+func parseConfigFragment(data []byte) (*configFragment, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+	err = decryptSecrets(&document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	fragment := &configFragment{}
+	if document.Kind != 0 {
+		err = document.Decode(fragment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse: %w", err)
+		}
+	}
+
+	if fragment.ConfigVersion != "" && !strings.HasPrefix(fragment.ConfigVersion, "0.1.") {
+		return nil, magitrickle.ErrConfigUnsupportedVersion
+	}
+	fragment.groupKeys = groupKeys(&document, len(fragment.Groups))
+	fragment.data = data
+
+	return fragment, nil
+}
+
+// checkKnownFields returns an error if the fragment has fields no setting is known by (e.g. a typo like "intreface"),
+// encrypted values are strings, so the source is checked as is
+func (f *configFragment) checkKnownFields() error {
+	decoder := yaml.NewDecoder(bytes.NewReader(f.data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&configFragment{})
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %w", ErrUnknownConfigField, err)
+	}
+	return nil
+}
+
+// groupKeys returns keys of every group definition of the document
+func groupKeys(document *yaml.Node, count int) []map[string]bool {
+	keys := make([]map[string]bool, count)
+	for idx := range keys {
+		keys[idx] = make(map[string]bool)
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return keys
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return keys
+	}
+	for idx := 0; idx+1 < len(root.Content); idx += 2 {
+		if root.Content[idx].Value != "groups" || root.Content[idx+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for groupIdx, group := range root.Content[idx+1].Content {
+			if groupIdx >= count || group.Kind != yaml.MappingNode {
+				continue
+			}
+			for keyIdx := 0; keyIdx < len(group.Content); keyIdx += 2 {
+				keys[groupIdx][group.Content[keyIdx].Value] = true
+			}
+		}
+	}
+	return keys
+}
+
+// resolveGroups applies defaults to the groups of the fragment and validates them
+func (f *configFragment) resolveGroups(defaults *models.GroupDefaults) error {
+	for idx := range f.Groups {
+		if defaults != nil {
+			defaults.Apply(&f.Groups[idx], f.groupKeys[idx])
+		}
+		err := f.Groups[idx].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listConfigFragments returns *.yaml and *.yml files of the directory in lexical order
+func listConfigFragments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadConfig reads the main config (creating the default one if it doesn't exist)
+// and merges it with the fragments of the conf.d directory. App settings may be
+// defined only once, groups are appended in file order and must have unique IDs
+// across all files (as well as slugs). The mode overrides app.configMode unless it's empty.
+func loadConfig(cfgPath, dir, mode string) (models.Config, error) {
+	cfg := models.Config{
+		ConfigVersion: "0.1.0",
+		App:           magitrickle.DefaultAppConfig,
+	}
+
+	mainFragment, err := readConfigFragment(cfgPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return cfg, fmt.Errorf("%s: %w", cfgPath, err)
+		}
+		err = writeConfig(cfgPath, cfg)
+		if err != nil {
+			return cfg, err
+		}
+		mainFragment = &configFragment{ConfigVersion: cfg.ConfigVersion, App: &cfg.App}
+	}
+
+	cfg.ConfigVersion = mainFragment.ConfigVersion
+	var appSource, defaultsSource string
+	if mainFragment.App != nil {
+		cfg.App = *mainFragment.App
+		appSource = cfgPath
+	}
+	defaults := mainFragment.Defaults
+	if defaults != nil {
+		defaultsSource = cfgPath
+	}
+
+	groupSources := make(map[models.ID]string)
+	slugSources := make(map[string]string)
+	fragments := []*configFragment{mainFragment}
+	sources := []string{cfgPath}
+
+	files, err := listConfigFragments(dir)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to list config directory: %w", err)
+	}
+	for _, file := range files {
+		fragment, err := readConfigFragment(file)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", file, err)
+		}
+		if fragment.App != nil {
+			if appSource != "" {
+				return cfg, fmt.Errorf("%s: %w: app settings already defined in %s", file, ErrConfigConflict, appSource)
+			}
+			cfg.App = *fragment.App
+			appSource = file
+		}
+		if fragment.Defaults != nil {
+			if defaultsSource != "" {
+				return cfg, fmt.Errorf("%s: %w: group defaults already defined in %s", file, ErrConfigConflict, defaultsSource)
+			}
+			defaults = fragment.Defaults
+			defaultsSource = file
+		}
+		fragments = append(fragments, fragment)
+		sources = append(sources, file)
+	}
+
+	if mode == "" {
+		mode = cfg.App.ConfigMode
+	}
+	switch mode {
+	case "", models.ConfigModeLenient:
+	case models.ConfigModeStrict:
+		for idx, fragment := range fragments {
+			err = fragment.checkKnownFields()
+			if err != nil {
+				return cfg, fmt.Errorf("%s: %w", sources[idx], err)
+			}
+		}
+	default:
+		return cfg, fmt.Errorf("%w: %s", magitrickle.ErrUnknownConfigMode, mode)
+	}
+
+	for idx, fragment := range fragments {
+		err = fragment.resolveGroups(defaults)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", sources[idx], err)
+		}
+		for _, group := range fragment.Groups {
+			if source, exists := groupSources[group.ID]; exists {
+				return cfg, fmt.Errorf("%s: %w: group %s already defined in %s", sources[idx], ErrConfigConflict, group.ID.String(), source)
+			}
+			groupSources[group.ID] = sources[idx]
+			if group.Slug != "" {
+				if source, exists := slugSources[group.Slug]; exists {
+					return cfg, fmt.Errorf("%s: %w: group slug %s already defined in %s", sources[idx], ErrConfigConflict, group.Slug, source)
+				}
+				slugSources[group.Slug] = sources[idx]
+			}
+			cfg.Groups = append(cfg.Groups, group)
+		}
+	}
+
+	return cfg, nil
+}
+
+func writeConfig(cfgPath string, cfg models.Config) error {
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to serialize config: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(cfgPath), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	err = os.WriteFile(cfgPath, out, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"magitrickle/models"
+	"magitrickle/secrets"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigMerge(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, cfgPath, "configVersion: 0.1.0\ngroups:\n  - id: 00000001\n    name: main\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "20-group.yaml"), "groups:\n  - id: 00000003\n    name: second\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "10-app.yaml"), "app:\n  logLevel: debug\ngroups:\n  - id: 00000002\n    name: first\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "README"), "ignored")
+
+	cfg, err := loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.App.LogLevel != "debug" {
+		t.Fatalf("app settings not merged: %q", cfg.App.LogLevel)
+	}
+	if len(cfg.Groups) != 3 || cfg.Groups[0].Name != "main" || cfg.Groups[1].Name != "first" || cfg.Groups[2].Name != "second" {
+		t.Fatalf("unexpected groups order: %+v", cfg.Groups)
+	}
+}
+
+func TestLoadConfigConflict(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, cfgPath, "configVersion: 0.1.0\napp:\n  logLevel: info\ngroups:\n  - id: 00000001\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "app.yaml"), "app:\n  logLevel: debug\n")
+
+	_, err := loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if !errors.Is(err, ErrConfigConflict) {
+		t.Fatalf("expected app conflict, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "conf.d", "app.yaml"), "groups:\n  - id: 00000001\n")
+	_, err = loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if !errors.Is(err, ErrConfigConflict) {
+		t.Fatalf("expected group conflict, got %v", err)
+	}
+
+	writeFile(t, cfgPath, "configVersion: 0.1.0\ngroups:\n  - id: 00000001\n    slug: streaming\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "app.yaml"), "groups:\n  - id: 00000002\n    slug: streaming\n")
+	_, err = loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if !errors.Is(err, ErrConfigConflict) {
+		t.Fatalf("expected slug conflict, got %v", err)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, cfgPath, "configVersion: 0.1.0\ndefaults:\n  interface: nwg0\n  fixProtect: true\n  exclude: [10.0.0.0/8]\ngroups:\n  - id: 00000001\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "groups.yaml"), "groups:\n  - id: 00000002\n    interface: nwg1\n    fixProtect: false\n    exclude: []\n")
+
+	cfg, err := loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited, overridden := cfg.Groups[0], cfg.Groups[1]
+	if inherited.Interface != "nwg0" || !inherited.FixProtect || len(inherited.Exclude) != 1 {
+		t.Fatalf("defaults are not inherited: %+v", inherited)
+	}
+	if overridden.Interface != "nwg1" || overridden.FixProtect || len(overridden.Exclude) != 0 {
+		t.Fatalf("defaults override group settings: %+v", overridden)
+	}
+
+	writeFile(t, filepath.Join(dir, "conf.d", "defaults.yaml"), "defaults:\n  interface: nwg2\n")
+	_, err = loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if !errors.Is(err, ErrConfigConflict) {
+		t.Fatalf("expected defaults conflict, got %v", err)
+	}
+}
+
+func TestLoadConfigInvalidFragment(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, cfgPath, "configVersion: 0.1.0\n")
+	fragmentPath := filepath.Join(dir, "conf.d", "bad.yaml")
+	writeFile(t, fragmentPath, "groups:\n  - id: 00000001\n    rules:\n      - id: 00000001\n        type: unknown\n")
+
+	_, err := loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	if !strings.HasPrefix(err.Error(), fragmentPath+": ") {
+		t.Fatalf("error doesn't point to the file: %v", err)
+	}
+}
+
+func TestParseConfigFragmentSecrets(t *testing.T) {
+	t.Setenv(passphraseEnv, "passphrase")
+	keyring = nil
+	t.Cleanup(func() { keyring = nil })
+
+	token, err := secrets.FromPassphrase("passphrase").Encrypt("123456:ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err := parseConfigFragment([]byte("app:\n  notify:\n    - telegram:\n        token: " + token + "\n        chatID: '1'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fragment.App.Notify[0].Telegram.Token != "123456:ABC" {
+		t.Fatalf("secret is not decrypted: %s", fragment.App.Notify[0].Telegram.Token)
+	}
+
+	raw, err := parseRawConfigFragment([]byte("app:\n  logLevel: " + token + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.App.LogLevel != token {
+		t.Fatal("raw fragment must keep encrypted values")
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, cfgPath, "configVersion: 0.1.0\ngroups:\n  - id: 00000001\n    name: main\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "group.yaml"), "groups:\n  - id: 00000002\n    name: second\n    intreface: nwg0\n")
+
+	_, err := loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if err != nil {
+		t.Fatalf("lenient config failed: %v", err)
+	}
+	_, err = loadConfig(cfgPath, filepath.Join(dir, "conf.d"), models.ConfigModeStrict)
+	if !errors.Is(err, ErrUnknownConfigField) || !strings.Contains(err.Error(), "intreface") {
+		t.Fatalf("expected unknown field, got %v", err)
+	}
+
+	// The mode of the config is overridden by the option
+	writeFile(t, cfgPath, "configVersion: 0.1.0\napp:\n  configMode: strict\ngroups:\n  - id: 00000001\n    name: main\n")
+	_, err = loadConfig(cfgPath, filepath.Join(dir, "conf.d"), "")
+	if !errors.Is(err, ErrUnknownConfigField) {
+		t.Fatalf("expected unknown field, got %v", err)
+	}
+	_, err = loadConfig(cfgPath, filepath.Join(dir, "conf.d"), models.ConfigModeLenient)
+	if err != nil {
+		t.Fatalf("lenient option failed: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"magitrickle/fleet"
+	"magitrickle/log-buffer"
+	"magitrickle/models"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const fleetStateLocation = cfgFolderLocation + "/fleet.state"
+
+// fleetReadyTimeout is how long a new config has to become ready before it is rolled back
+const fleetReadyTimeout = 60 * time.Second
+
+// pollFleet sends verified bundles to the channel until the context is done
+func pollFleet(ctx context.Context, settings models.Fleet, bundles chan<- []byte) {
+	client, err := fleet.New(settings.URL, settings.PublicKey, fleetStateLocation)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start fleet agent")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(settings.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		bundle, err := client.Fetch(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("url", settings.URL).Msg("failed to fetch fleet bundle")
+		} else if bundle != nil {
+			select {
+			case bundles <- bundle:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// bundleFile is the main config file written from the bundle, groups are kept as defined
+// so that the fields they don't set are still inherited from defaults
+type bundleFile struct {
+	ConfigVersion string                `yaml:"configVersion"`
+	App           models.App            `yaml:"app"`
+	Defaults      *models.GroupDefaults `yaml:"defaults,omitempty"`
+	Groups        []yaml.Node           `yaml:"groups"`
+}
+
+// bundleConfig builds the main config file from the bundle, fleet settings can not be changed by the bundle.
+// Encrypted values are kept encrypted in the file.
+func bundleConfig(bundle []byte, current models.App) ([]byte, error) {
+	fragment, err := parseConfigFragment(bundle)
+	if err != nil {
+		return nil, err
+	}
+	err = fragment.resolveGroups(fragment.Defaults)
+	if err != nil {
+		return nil, err
+	}
+	fragment, err = parseRawConfigFragment(bundle)
+	if err != nil {
+		return nil, err
+	}
+	file := bundleFile{
+		ConfigVersion: fragment.ConfigVersion,
+		App:           current,
+		Defaults:      fragment.Defaults,
+	}
+	var groups struct {
+		Groups []yaml.Node `yaml:"groups"`
+	}
+	err = yaml.Unmarshal(bundle, &groups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+	file.Groups = groups.Groups
+	if fragment.App != nil {
+		file.App = *fragment.App
+	}
+	file.App.Fleet = current.Fleet
+	return yaml.Marshal(file)
+}
+
+func replaceFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// applyBundle replaces the main config and restarts the service, the previous config
+// is restored and started again if the new one fails to load or to become ready
+func applyBundle(ctx context.Context, current *service, cfg models.Config, bundle []byte, configMode string, logs *logBuffer.Buffer) (*service, models.Config, error) {
+	previous, err := os.ReadFile(cfgFileLocation)
+	if err != nil {
+		return current, cfg, fmt.Errorf("failed to read current config: %w", err)
+	}
+
+	currentApp := cfg.App
+	if previousFragment, err := parseRawConfigFragment(previous); err == nil && previousFragment.App != nil {
+		// Keep encrypted values of the current settings encrypted
+		currentApp = *previousFragment.App
+	}
+	data, err := bundleConfig(bundle, currentApp)
+	if err != nil {
+		return current, cfg, fmt.Errorf("invalid bundle: %w", err)
+	}
+	err = replaceFile(cfgFileLocation, data)
+	if err != nil {
+		return current, cfg, fmt.Errorf("failed to write config: %w", err)
+	}
+
+	newCfg, err := loadConfig(cfgFileLocation, cfgDirLocation, configMode)
+	if err != nil {
+		_ = replaceFile(cfgFileLocation, previous)
+		return current, cfg, fmt.Errorf("invalid bundle: %w", err)
+	}
+
+	_ = current.stop()
+	next, err := startService(ctx, newCfg, logs, nil)
+	if err == nil {
+		err = next.waitReady(fleetReadyTimeout)
+		if err != nil {
+			_ = next.stop()
+		}
+	}
+	if err == nil {
+		return next, newCfg, nil
+	}
+
+	log.Warn().Err(err).Msg("fleet config failed, rolling back")
+	rollbackErr := replaceFile(cfgFileLocation, previous)
+	if rollbackErr != nil {
+		log.Error().Err(rollbackErr).Msg("failed to restore config")
+	}
+	restored, startErr := startService(ctx, cfg, logs, nil)
+	if startErr != nil {
+		return nil, cfg, fmt.Errorf("failed to restore service: %w", startErr)
+	}
+	return restored, cfg, err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"magitrickle/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBundleConfig(t *testing.T) {
+	current := models.App{
+		LogLevel: "debug",
+		Fleet:    models.Fleet{URL: "https://example.com/home.yaml", PublicKey: "key", Interval: 300},
+	}
+
+	data, err := bundleConfig([]byte("configVersion: 0.1.0\napp:\n  logLevel: warn\n  fleet:\n    url: https://evil.example\ngroups:\n  - id: 00000001\n    name: vpn\n"), current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg models.Config
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.App.LogLevel != "warn" || cfg.App.Fleet != current.Fleet || len(cfg.Groups) != 1 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	// Without app settings the current ones are kept
+	data, err = bundleConfig([]byte("configVersion: 0.1.0\ngroups: []\n"), current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.App.LogLevel != "debug" {
+		t.Fatalf("current app settings are lost: %+v", cfg.App)
+	}
+}
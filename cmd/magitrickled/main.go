@@ -3,25 +3,30 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 
-	"magitrickle"
+	"magitrickle/app-errors"
 	"magitrickle/constant"
-	"magitrickle/models"
+	"magitrickle/log-buffer"
+	"magitrickle/notify"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/yaml.v3"
 )
 
 const cfgFolderLocation = "/opt/var/lib/magitrickle"
 const cfgFileLocation = cfgFolderLocation + "/config.yaml"
+const cfgDirLocation = cfgFolderLocation + "/conf.d"
 const pidFileLocation = "/opt/var/run/magitrickle.pid"
+const logBufferSize = 1000
+const configModeUsage = "strict (unknown config fields are errors) or lenient, overrides app.configMode"
 
 func checkPIDFile() error {
 	data, err := os.ReadFile(pidFileLocation)
@@ -55,12 +60,66 @@ func removePIDFile() {
 }
 
 func main() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+		var err error
+		switch os.Args[1] {
+		case "apply":
+			err = runApply(os.Args[2:])
+		case "teardown":
+			err = runTeardown(os.Args[2:])
+		case "migrate-kvas":
+			err = runMigrateKVAS(os.Args[2:])
+		case "encrypt":
+			err = runEncrypt(os.Args[2:])
+		case "schema":
+			err = runSchema()
+		case "upgrade":
+			err = runUpgrade()
+		case "pause", "resume", "toggle-pause":
+			err = runControl(os.Args[1])
+		case "flush-dns":
+			err = runControl("flush-dns")
+		case "forget":
+			if len(os.Args) != 3 {
+				err = appErrors.New(appErrors.ErrValidation, "usage: forget <domain>")
+				break
+			}
+			err = runControl("forget:" + os.Args[2])
+		case "export-stats":
+			if len(os.Args) != 3 {
+				err = appErrors.New(appErrors.ErrValidation, "usage: export-stats <file.json|file.csv>")
+				break
+			}
+			err = runExportStats(os.Args[2])
+		default:
+			err = appErrors.New(appErrors.ErrValidation, "unknown command: "+os.Args[1])
+		}
+		if err != nil {
+			// Scripts tell failures apart by the exit code of the kind of the error
+			log.Error().Err(err).Str("kind", appErrors.Kind(err)).Msgf("failed to %s", os.Args[1])
+			os.Exit(appErrors.ExitCode(err))
+		}
+		return
+	}
+
+	flags := flag.NewFlagSet("magitrickled", flag.ExitOnError)
+	configMode := flags.String("config-mode", "", configModeUsage)
+	_ = flags.Parse(os.Args[1:])
+
+	logs := logBuffer.New(logBufferSize)
+	log.Logger = log.Output(zerolog.MultiLevelWriter(zerolog.ConsoleWriter{Out: os.Stderr}, logs))
 	log.Info().
 		Str("version", constant.Version).
 		Str("commit", constant.Commit).
 		Msg("starting MagiTrickle daemon")
 
+	// The upgraded daemon waits until the previous one stops and removes its PID file
+	handoff, err := receiveHandoff()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to take over from previous daemon")
+	}
+
 	if err := checkPIDFile(); err != nil {
 		log.Fatal().Err(err).Msg("failed to start MagiTrickle daemon")
 	}
@@ -68,35 +127,17 @@ func main() {
 	if err := createPIDFile(); err != nil {
 		log.Fatal().Err(err).Msg("failed to create PID file")
 	}
-	defer removePIDFile()
+	// After the upgrade the PID file belongs to the new daemon
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			removePIDFile()
+		}
+	}()
 
-	cfg := models.Config{}
-	cfgFile, err := os.ReadFile(cfgFileLocation)
+	cfg, err := loadConfig(cfgFileLocation, cfgDirLocation, *configMode)
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			log.Fatal().Err(err).Msg("failed to read config.yaml")
-		}
-		cfg = models.Config{
-			ConfigVersion: "0.1.0",
-			App:           magitrickle.DefaultAppConfig,
-		}
-		out, err := yaml.Marshal(cfg)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to serialize config.yaml")
-		}
-		err = os.MkdirAll(cfgFolderLocation, os.ModePerm)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to create config directory")
-		}
-		err = os.WriteFile(cfgFileLocation, out, 0600)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to save config.yaml")
-		}
-	} else {
-		err = yaml.Unmarshal(cfgFile, &cfg)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to parse config.yaml")
-		}
+		log.Fatal().Err(err).Msg("failed to load config")
 	}
 
 	switch cfg.App.LogLevel {
@@ -122,25 +163,24 @@ func main() {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
 
-	app := magitrickle.New()
-	err = app.ImportConfig(cfg)
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to import config")
-	}
-
-	log.Info().Msg("starting service")
-
 	/*
 		Starting app with graceful shutdown
 	*/
 	ctx, cancel := context.WithCancel(context.Background())
-	appResult := make(chan error)
-	go func() {
-		appResult <- app.Start(ctx)
-	}()
+	current, err := startService(ctx, cfg, logs, handoff)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to start service")
+	}
+
+	bundles := make(chan []byte)
+	if cfg.App.Fleet.URL != "" {
+		go pollFleet(ctx, cfg.App.Fleet, bundles)
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	upgrades := make(chan os.Signal, 1)
+	signal.Notify(upgrades, syscall.SIGUSR2)
 
 	var once sync.Once
 	closeEvent := func() {
@@ -150,12 +190,39 @@ func main() {
 
 	for {
 		select {
-		case err, _ := <-appResult:
+		case err, _ := <-current.result:
 			if err != nil {
 				log.Error().Err(err).Msg("failed to start application")
 			}
 			log.Info().Msg("exiting application")
 			return
+		case bundle := <-bundles:
+			if ctx.Err() != nil {
+				continue
+			}
+			log.Info().Msg("applying fleet config")
+			current, cfg, err = applyBundle(ctx, current, cfg, bundle, *configMode, logs)
+			if current == nil {
+				log.Error().Err(err).Msg("failed to apply fleet config")
+				return
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("failed to apply fleet config")
+				current.app.Notify(notify.EventConfigApplyFailed, fmt.Sprintf("failed to apply fleet config: %v", err))
+			}
+		case <-upgrades:
+			if ctx.Err() != nil {
+				continue
+			}
+			log.Info().Msg("upgrading daemon")
+			err = upgrade(current)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to upgrade daemon")
+				continue
+			}
+			handedOff = true
+			log.Info().Msg("handed off to new daemon")
+			return
 		case <-c:
 			once.Do(closeEvent)
 		}
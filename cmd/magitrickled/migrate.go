@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"magitrickle"
+	"magitrickle/kvas-migrate"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// readOptional returns an empty content if the file does not exist
+func readOptional(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+// runMigrateKVAS converts KVAS lists and settings into a config
+func runMigrateKVAS(args []string) error {
+	flags := flag.NewFlagSet("migrate-kvas", flag.ContinueOnError)
+	hostsPath := flags.String("hosts", kvasMigrate.DefaultHostsPath, "KVAS routed list")
+	excludePath := flags.String("exclude", kvasMigrate.DefaultExcludePath, "KVAS exclusion list")
+	settingsPath := flags.String("conf", kvasMigrate.DefaultSettingsPath, "KVAS settings")
+	iface := flags.String("interface", "", "tunnel interface, overrides KVAS settings")
+	outPath := flags.String("o", "", "output config file, stdout if empty")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var input kvasMigrate.Input
+	input.Interface = *iface
+	for _, file := range []struct {
+		path string
+		dst  *string
+	}{
+		{*hostsPath, &input.Hosts},
+		{*excludePath, &input.Exclude},
+		{*settingsPath, &input.Settings},
+	} {
+		*file.dst, err = readOptional(file.path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.path, err)
+		}
+	}
+
+	cfg, err := kvasMigrate.Convert(input)
+	if err != nil {
+		return err
+	}
+	cfg.App = magitrickle.DefaultAppConfig
+
+	if *outPath == "" {
+		return yaml.NewEncoder(os.Stdout).Encode(cfg)
+	}
+	if _, err := os.Stat(*outPath); err == nil {
+		return fmt.Errorf("%s already exists", *outPath)
+	}
+	err = writeConfig(*outPath, cfg)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("rules", len(cfg.Groups[0].Rules)).Str("path", *outPath).Msg("KVAS config migrated")
+	return nil
+}
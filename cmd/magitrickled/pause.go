@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"magitrickle"
+	"magitrickle/app-errors"
+
+	"github.com/rs/zerolog/log"
+)
+
+// controlTimeout limits the exchange with the daemon, removing rules of many groups takes a while
+const controlTimeout = 30 * time.Second
+
+// runExportStats asks the running daemon to write statistics to the file, the path is resolved here
+// since the daemon has another working directory
+func runExportStats(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return runControl("export-stats:" + path)
+}
+
+// runControl sends the command (pause, resume, toggle-pause, flush-dns, forget:<domain> or
+// export-stats:<path>) to the running daemon
+// over its UNIX socket
+func runControl(command string) error {
+	conn, err := net.DialTimeout("unix", magitrickle.ControlSocketPath, controlTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	err = conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write([]byte(command))
+	if err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+
+	reply := strings.TrimSpace(string(data))
+	if reply == "ok" {
+		log.Info().Str("command", command).Msg("done")
+		return nil
+	}
+	msg, found := strings.CutPrefix(reply, "fail: ")
+	if !found {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	// The reply is "fail: <kind>: <error>", the kind keeps the exit code
+	if kind, rest, found := strings.Cut(msg, ": "); found && appErrors.Parse(kind) != nil {
+		return appErrors.Wrap(appErrors.Parse(kind), errors.New(rest))
+	}
+	return errors.New(msg)
+}
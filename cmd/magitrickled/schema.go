@@ -0,0 +1,16 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+
+	"magitrickle/models"
+)
+
+// runSchema prints the JSON Schema of config files (the main config and fragments of conf.d)
+func runSchema() error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(models.Schema(reflect.TypeOf(configFragment{})))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"magitrickle/secrets"
+
+	"gopkg.in/yaml.v3"
+)
+
+const secretKeyLocation = cfgFolderLocation + "/secret.key"
+
+// passphraseEnv overrides the key file, the same passphrase must be set for the daemon
+const passphraseEnv = "MAGITRICKLE_PASSPHRASE"
+
+var (
+	keyringMux sync.Mutex
+	keyring    *secrets.Keyring
+)
+
+// configKeyring returns the key of encrypted config values, the key file is created if asked
+func configKeyring(create bool) (*secrets.Keyring, error) {
+	keyringMux.Lock()
+	defer keyringMux.Unlock()
+	if keyring != nil {
+		return keyring, nil
+	}
+
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		keyring = secrets.FromPassphrase(passphrase)
+		return keyring, nil
+	}
+	k, err := secrets.FromFile(secretKeyLocation, create)
+	if err != nil {
+		return nil, err
+	}
+	keyring = k
+	return keyring, nil
+}
+
+// decryptSecrets replaces encrypted scalars of the YAML document with their plaintext,
+// the key is only needed if there are encrypted values
+func decryptSecrets(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && secrets.IsEncrypted(node.Value) {
+		k, err := configKeyring(false)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value, err = k.Decrypt(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		return nil
+	}
+	for _, child := range node.Content {
+		err := decryptSecrets(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runEncrypt prints the encrypted value to paste into the config, the value is read from stdin if not given
+func runEncrypt(args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	value := flags.Arg(0)
+	if value == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read value: %w", err)
+		}
+		value = strings.TrimRight(line, "\r\n")
+	}
+
+	k, err := configKeyring(true)
+	if err != nil {
+		return err
+	}
+	encrypted, err := k.Encrypt(value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(encrypted)
+	return nil
+}
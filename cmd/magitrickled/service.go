@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"magitrickle"
+	"magitrickle/api"
+	"magitrickle/log-buffer"
+	"magitrickle/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrNotReady = errors.New("service is not ready")
+
+const readyPollInterval = 500 * time.Millisecond
+
+// service is a running app with its API server, it is replaced as a whole on config changes
+type service struct {
+	app    *magitrickle.App
+	cancel context.CancelFunc
+	result chan error
+}
+
+// startService starts the app of the config, the handoff is the state of the previous daemon (nil if none)
+func startService(ctx context.Context, cfg models.Config, logs *logBuffer.Buffer, handoff *magitrickle.Handoff) (*service, error) {
+	app := magitrickle.New()
+	err := app.ImportConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import config: %w", err)
+	}
+	if handoff != nil {
+		app.ImportHandoff(*handoff)
+	}
+
+	log.Info().Msg("starting service")
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &service{
+		app:    app,
+		cancel: cancel,
+		result: make(chan error, 1),
+	}
+	go func() {
+		s.result <- app.Start(ctx)
+	}()
+
+	apiConfig := app.ExportConfig().App.API
+	if !apiConfig.Disable {
+		go func() {
+			err := api.New(app, logs).ListenAndServe(ctx, apiConfig.Host.Address, apiConfig.Host.Port)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to serve api")
+			}
+		}()
+	}
+	return s, nil
+}
+
+// stop cancels the app and waits for it to exit
+func (s *service) stop() error {
+	s.cancel()
+	return <-s.result
+}
+
+// waitReady waits until the app serves DNS and routes traffic
+func (s *service) waitReady(timeout time.Duration) error {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case err := <-s.result:
+			// Keep the result for stop
+			s.result <- err
+			if err == nil {
+				return ErrNotReady
+			}
+			return err
+		case <-ticker.C:
+			if s.app.Health().IsReady() {
+				return nil
+			}
+		case <-deadline:
+			return ErrNotReady
+		}
+	}
+}
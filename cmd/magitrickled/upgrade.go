@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"magitrickle"
+	"magitrickle/socket-activation"
+
+	"github.com/rs/zerolog/log"
+)
+
+// handoffFDEnv is the descriptor of the connection the upgraded daemon receives the state from
+const handoffFDEnv = "MAGITRICKLE_HANDOFF_FD"
+
+// runUpgrade asks the running daemon to hand off to the binary at its path (SIGUSR2)
+func runUpgrade() error {
+	data, err := os.ReadFile(pidFileLocation)
+	if err != nil {
+		return fmt.Errorf("failed to read PID file: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.New("invalid PID file content")
+	}
+	err = syscall.Kill(pid, syscall.SIGUSR2)
+	if err != nil {
+		return fmt.Errorf("failed to signal daemon: %w", err)
+	}
+	log.Info().Int("pid", pid).Msg("upgrade requested, see the log of the daemon")
+	return nil
+}
+
+// upgrade starts the binary at the executable path (a replaced binary is the new one) with listening
+// sockets of the service and sends it the live state. The handoff:
+//  1. the new daemon starts with duplicates of sockets, it waits for the state;
+//  2. the state is sent, then the service is stopped and its netfilter state is removed;
+//  3. the connection is closed, so the new daemon installs netfilter state and serves the sockets.
+//
+// Queries arriving meanwhile wait in sockets. If the new daemon can't be started, the service keeps running.
+func upgrade(current *service) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create handoff connection: %w", err)
+	}
+	conn := os.NewFile(uintptr(fds[0]), "handoff")
+	defer func() { _ = conn.Close() }()
+	childConn := os.NewFile(uintptr(fds[1]), "handoff")
+
+	files := socketActivation.Files()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, childConn)
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		handoffFDEnv+"="+strconv.Itoa(3+len(files)),
+	)
+	err = cmd.Start()
+	_ = childConn.Close()
+	for _, file := range files {
+		_ = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start new daemon: %w", err)
+	}
+	log.Info().Int("pid", cmd.Process.Pid).Int("sockets", len(files)).Msg("started new daemon")
+
+	err = json.NewEncoder(conn).Encode(current.app.ExportHandoff())
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("failed to send state: %w", err)
+	}
+
+	err = current.stop()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to stop service")
+	}
+	removePIDFile()
+	return cmd.Process.Release()
+}
+
+// receiveHandoff returns the state of the previous daemon if it started this one (see upgrade),
+// it waits until the previous daemon has stopped
+func receiveHandoff() (*magitrickle.Handoff, error) {
+	fdStr := os.Getenv(handoffFDEnv)
+	if fdStr == "" {
+		return nil, nil
+	}
+	_ = os.Unsetenv(handoffFDEnv)
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", handoffFDEnv, fdStr)
+	}
+	syscall.CloseOnExec(fd)
+	conn := os.NewFile(uintptr(fd), "handoff")
+	defer func() { _ = conn.Close() }()
+
+	var handoff magitrickle.Handoff
+	decoder := json.NewDecoder(conn)
+	err = decoder.Decode(&handoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive state: %w", err)
+	}
+	// The connection is closed once the previous daemon released netfilter state
+	_, err = io.Copy(io.Discard, decoder.Buffered())
+	if err == nil {
+		_, err = io.Copy(io.Discard, conn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for previous daemon: %w", err)
+	}
+	return &handoff, nil
+}
@@ -0,0 +1,188 @@
+package magitrickle
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"magitrickle/app-errors"
+	"magitrickle/netfilter-helper"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ControlSocketPath is the UNIX socket of hooks and commands of the running daemon
+const ControlSocketPath = "/opt/var/run/magitrickle.sock"
+
+const (
+	// controlSocketWorkers is the maximum number of connections handled at once, extra ones are closed
+	controlSocketWorkers = 8
+	// controlSocketTimeout limits the whole exchange, a hook script that never writes cannot hold a worker
+	controlSocketTimeout = 5 * time.Second
+	// controlSocketMaxMessage is the maximum size of a command
+	controlSocketMaxMessage = 1024
+)
+
+type ControlSocketStats struct {
+	Active   int64  `json:"active"`
+	Served   uint64 `json:"served"`
+	Rejected uint64 `json:"rejected"`
+}
+
+type controlSocketCounters struct {
+	active   atomic.Int64
+	served   atomic.Uint64
+	rejected atomic.Uint64
+}
+
+func (c *controlSocketCounters) stats() ControlSocketStats {
+	return ControlSocketStats{
+		Active:   c.active.Load(),
+		Served:   c.served.Load(),
+		Rejected: c.rejected.Load(),
+	}
+}
+
+// serveControlSocket accepts commands of netfilter.d hooks and health checks until the listener is closed
+func (a *App) serveControlSocket(ctx context.Context, socket net.Listener) {
+	workers := make(chan struct{}, controlSocketWorkers)
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		conn, err := socket.Accept()
+		if err != nil {
+			if !strings.Contains(err.Error(), "use of closed network connection") {
+				log.Error().Err(err).Msg("error while listening unix socket")
+			}
+			return
+		}
+
+		select {
+		case workers <- struct{}{}:
+		default:
+			a.controlSocket.rejected.Add(1)
+			log.Warn().Int("workers", controlSocketWorkers).Msg("control socket is busy, connection rejected")
+			_ = conn.Close()
+			continue
+		}
+
+		a.controlSocket.active.Add(1)
+		go func(conn net.Conn) {
+			defer func() {
+				_ = conn.Close()
+				a.controlSocket.active.Add(-1)
+				a.controlSocket.served.Add(1)
+				<-workers
+			}()
+			a.handleControlConn(conn)
+		}(conn)
+	}
+}
+
+// writeControlResult replies "ok" or "fail: <kind>: <error>", the kind is omitted if it's unknown
+func writeControlResult(conn net.Conn, err error) {
+	if err == nil {
+		_, _ = conn.Write([]byte("ok\n"))
+		return
+	}
+	msg := err.Error()
+	if kind := appErrors.Kind(err); kind != "" {
+		msg = kind + ": " + msg
+	}
+	_, _ = conn.Write([]byte("fail: " + msg + "\n"))
+}
+
+func (a *App) handleControlConn(conn net.Conn) {
+	err := conn.SetDeadline(time.Now().Add(controlSocketTimeout))
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, controlSocketMaxMessage+1)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return
+	}
+	if n > controlSocketMaxMessage {
+		log.Warn().Int("limit", controlSocketMaxMessage).Msg("control socket message is too long")
+		return
+	}
+
+	args := strings.Split(strings.TrimSpace(string(buf[:n])), ":")
+	switch {
+	case len(args) == 1 && (args[0] == "healthz" || args[0] == "readyz"):
+		health := a.Health()
+		ok := health.IsLive()
+		if args[0] == "readyz" {
+			ok = health.IsReady()
+		}
+		if ok {
+			_, _ = conn.Write([]byte("ok\n"))
+		} else {
+			_, _ = conn.Write([]byte("fail\n"))
+		}
+	case len(args) == 4 && args[0] == "subscription":
+		writeControlResult(conn, a.handleSubscriptionCommand(args[1], args[2], args[3]))
+	case len(args) == 2 && args[0] == "resync":
+		writeControlResult(conn, a.ResyncGroup(args[1]))
+	case len(args) == 1 && args[0] == "pause":
+		writeControlResult(conn, a.Pause())
+	case len(args) == 1 && args[0] == "resume":
+		writeControlResult(conn, a.Resume())
+	case len(args) == 1 && args[0] == "toggle-pause":
+		_, err = a.TogglePause()
+		writeControlResult(conn, err)
+	case len(args) == 1 && args[0] == "flush-dns":
+		_, _, err = a.FlushRecords()
+		writeControlResult(conn, err)
+	case len(args) == 2 && args[0] == "export-stats":
+		writeControlResult(conn, a.ExportStatsFile(args[1]))
+	case len(args) == 2 && args[0] == "forget":
+		_, _, err = a.ForgetDomain(args[1])
+		writeControlResult(conn, err)
+	case len(args) == 4 && args[0] == "ifstatechanged":
+		a.handleHotplug(args[1], args[2], args[3])
+	case len(args) == 3 && args[0] == "netfilter.d":
+		log.Debug().Str("table", args[2]).Msg("netfilter.d event")
+		for _, dnsOverrider := range []*netfilterHelper.PortRemap{a.dnsOverrider4, a.dnsOverrider6} {
+			if dnsOverrider == nil {
+				continue
+			}
+			err = dnsOverrider.NetfilterDHook(args[2])
+			if err != nil {
+				log.Error().Err(err).Msg("error while fixing iptables after netfilter.d")
+			}
+		}
+		if a.doh.block != nil {
+			err = a.doh.block.NetfilterDHook(args[2])
+			if err != nil {
+				log.Error().Err(err).Msg("error while fixing iptables after netfilter.d")
+			}
+		}
+		for _, group := range a.groups {
+			err := group.NetfilterDHook(args[2])
+			if err != nil {
+				log.Error().Err(err).Msg("error while fixing iptables after netfilter.d")
+			}
+		}
+	}
+}
+
+// handleHotplug handles ndm ifstatechanged.d events. Keenetic re-creates tunnel interfaces on reconnect,
+// so routes are re-installed when the interface gets running instead of relying on the netlink event only.
+func (a *App) handleHotplug(ifaceName, layer, level string) {
+	log.Debug().Str("interface", ifaceName).Str("layer", layer).Str("level", level).Msg("ifstatechanged.d event")
+	if level != "running" || (layer != "link" && layer != "ipv4") {
+		return
+	}
+	for _, group := range a.groups {
+		err := group.InterfaceHook(ifaceName)
+		if err != nil {
+			log.Error().Str("group", group.ID.String()).Err(err).Msg("error while handling interface hotplug")
+		}
+	}
+}
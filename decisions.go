@@ -0,0 +1,69 @@
+package magitrickle
+
+import (
+	"time"
+
+	"magitrickle/decisions"
+	"magitrickle/group"
+	"magitrickle/models"
+)
+
+type groupMatch struct {
+	group *group.Group
+	rule  *models.Rule
+	name  string
+}
+
+// matchGroups matches names of the record against enabled groups, the decision is kept
+// for /api/debug/decisions
+func (a *App) matchGroups(record, domain string, names []string, ctx models.MatchContext) []groupMatch {
+	var matches []groupMatch
+	if a.decisions == nil {
+		for _, group := range a.groups {
+			if !group.IsEnabled() {
+				continue
+			}
+			rule, name := group.Match(names, ctx)
+			if rule != nil {
+				matches = append(matches, groupMatch{group: group, rule: rule, name: name})
+			}
+		}
+		return matches
+	}
+
+	decision := decisions.Decision{
+		Time:   ctx.Time,
+		Domain: domain,
+		Record: record,
+		Names:  names,
+	}
+	if ctx.Client != nil {
+		decision.Client = ctx.Client.String()
+	}
+	start := time.Now()
+	for _, group := range a.groups {
+		if !group.IsEnabled() {
+			continue
+		}
+		rule, name, evaluated := group.MatchCounted(names, ctx)
+		result := decisions.GroupResult{Group: group.ID.String(), Evaluated: evaluated}
+		decision.Evaluated += evaluated
+		if rule != nil {
+			matches = append(matches, groupMatch{group: group, rule: rule, name: name})
+			result.Rule, result.Name, result.Exclude, result.Log = rule.ID.String(), name, rule.IsExclude(), rule.IsLog()
+		}
+		decision.Groups = append(decision.Groups, result)
+	}
+	decision.DurationUs = time.Since(start).Microseconds()
+	decision.Matched = len(matches) != 0
+	a.decisions.Add(decision)
+	return matches
+}
+
+// Decisions returns the last rule match decisions from the newest, nil if they're not kept
+func (a *App) Decisions() []decisions.Decision {
+	if a.decisions == nil {
+		return nil
+	}
+	return a.decisions.List()
+}
@@ -0,0 +1,74 @@
+// Package decisions keeps the last rule match decisions of the DNS pipeline in memory,
+// so "why didn't this domain match" can be answered without trace logging.
+package decisions
+
+import (
+	"sync"
+	"time"
+)
+
+// GroupResult is the outcome of matching the names against one group
+type GroupResult struct {
+	Group string `json:"group"`
+	// Rule is the ID of the matched rule, empty if nothing matched
+	Rule string `json:"rule,omitempty"`
+	// Name is the name of the chain the rule matched
+	Name    string `json:"name,omitempty"`
+	Exclude bool   `json:"exclude,omitempty"`
+	// Log is set if the matched rule only records matches
+	Log bool `json:"log,omitempty"`
+	// Evaluated is the number of rules checked, indexed rules which can't match are not checked
+	Evaluated int `json:"evaluated"`
+}
+
+type Decision struct {
+	Time time.Time `json:"time"`
+	// Domain is the owner name of the record, Names are it and its known aliases
+	Domain    string        `json:"domain"`
+	Record    string        `json:"record"`
+	Names     []string      `json:"names"`
+	Client    string        `json:"client,omitempty"`
+	Matched   bool          `json:"matched"`
+	Evaluated int           `json:"evaluated"`
+	Groups    []GroupResult `json:"groups"`
+	// DurationUs is the time spent matching in microseconds
+	DurationUs int64 `json:"durationUs"`
+}
+
+// Ring keeps up to the limit of the last decisions
+type Ring struct {
+	mux       sync.Mutex
+	decisions []Decision
+	next      int
+	full      bool
+}
+
+func New(limit int) *Ring {
+	return &Ring{decisions: make([]Decision, limit)}
+}
+
+// Add keeps the decision, the oldest one is dropped when the ring is full
+func (r *Ring) Add(decision Decision) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.decisions[r.next] = decision
+	r.next = (r.next + 1) % len(r.decisions)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns kept decisions from the newest
+func (r *Ring) List() []Decision {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.decisions)
+	}
+	list := make([]Decision, 0, count)
+	for idx := 1; idx <= count; idx++ {
+		list = append(list, r.decisions[(r.next-idx+len(r.decisions))%len(r.decisions)])
+	}
+	return list
+}
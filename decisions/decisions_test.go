@@ -0,0 +1,22 @@
+package decisions
+
+import "testing"
+
+func TestRing(t *testing.T) {
+	ring := New(3)
+	if len(ring.List()) != 0 {
+		t.Fatal("new ring must be empty")
+	}
+	for _, domain := range []string{"a", "b", "c", "d"} {
+		ring.Add(Decision{Domain: domain})
+	}
+	list := ring.List()
+	if len(list) != 3 {
+		t.Fatalf("unexpected length: %d", len(list))
+	}
+	for idx, domain := range []string{"d", "c", "b"} {
+		if list[idx].Domain != domain {
+			t.Fatalf("unexpected order: %v", list)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package dedup drops DNS answers identical to one seen shortly before, so a burst of clients
+// resolving the same name passes the group matching and ipset pipeline only once.
+package dedup
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type Cache struct {
+	mux       sync.Mutex
+	window    time.Duration
+	seen      map[uint64]time.Time
+	lastSweep time.Time
+}
+
+// Key hashes the question and the answer set of the message. TTLs and the order of records
+// are ignored, they differ between cached and fresh answers of the same set.
+func Key(msg dns.Msg) uint64 {
+	h := fnv.New64a()
+	for _, q := range msg.Question {
+		_, _ = h.Write([]byte(strings.ToLower(q.Name)))
+		_ = binary.Write(h, binary.BigEndian, q.Qtype)
+	}
+	answers := make([]string, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		answers = append(answers, strings.ToLower(rr.String()))
+	}
+	sort.Strings(answers)
+	for _, answer := range answers {
+		_, _ = h.Write([]byte(answer))
+		_, _ = h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// Seen reports whether the key was seen within the window, otherwise it is remembered
+func (c *Cache) Seen(key uint64, now time.Time) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if now.Sub(c.lastSweep) > c.window {
+		for k, deadline := range c.seen {
+			if now.After(deadline) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if deadline, ok := c.seen[key]; ok && !now.After(deadline) {
+		return true
+	}
+	c.seen[key] = now.Add(c.window)
+	return false
+}
+
+func New(window time.Duration) *Cache {
+	return &Cache{
+		window: window,
+		seen:   make(map[uint64]time.Time),
+	}
+}
@@ -0,0 +1,58 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func message(t *testing.T, records ...string) dns.Msg {
+	var msg dns.Msg
+	msg.SetQuestion("example.com.", dns.TypeA)
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Answer = append(msg.Answer, rr)
+	}
+	return msg
+}
+
+func TestKey(t *testing.T) {
+	a := message(t, "example.com. 60 IN A 1.2.3.4", "example.com. 60 IN A 1.2.3.5")
+	b := message(t, "example.com. 30 IN A 1.2.3.5", "example.com. 30 IN A 1.2.3.4")
+	if Key(a) != Key(b) {
+		t.Fatal("TTL and order must not change the key")
+	}
+	c := message(t, "example.com. 60 IN A 1.2.3.4")
+	if Key(a) == Key(c) {
+		t.Fatal("different answers must have different keys")
+	}
+	d := c.Copy()
+	d.Question[0].Qtype = dns.TypeAAAA
+	if Key(c) == Key(*d) {
+		t.Fatal("different qtypes must have different keys")
+	}
+}
+
+func TestSeen(t *testing.T) {
+	c := New(2 * time.Second)
+	now := time.Now()
+	if c.Seen(1, now) {
+		t.Fatal("first answer must pass")
+	}
+	if !c.Seen(1, now.Add(time.Second)) {
+		t.Fatal("repeated answer must be dropped")
+	}
+	if c.Seen(2, now.Add(time.Second)) {
+		t.Fatal("other answer must pass")
+	}
+	if c.Seen(1, now.Add(3500*time.Millisecond)) {
+		t.Fatal("answer must pass after the window")
+	}
+	if len(c.seen) != 1 {
+		t.Fatalf("expired keys must be swept, got %d", len(c.seen))
+	}
+}
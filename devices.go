@@ -0,0 +1,53 @@
+package magitrickle
+
+import (
+	"net"
+	"time"
+
+	"magitrickle/devices"
+	"magitrickle/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+const neighborsPollInterval = 30 * time.Second
+
+func (a *App) updateNeighbors() {
+	err := a.devices.UpdateNeighbors(a.config.Link)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to update neighbors")
+	}
+	a.updateLeases()
+}
+
+// updateLeases re-reads the DHCP leases file if it has changed, so MAC and hostname conditions
+// of expression rules follow devices getting new addresses
+func (a *App) updateLeases() {
+	if a.config.DHCPLeases.Path == "" {
+		return
+	}
+	if a.leases == nil || a.leases.Path != a.config.DHCPLeases.Path {
+		a.leases = &devices.LeaseFile{Path: a.config.DHCPLeases.Path, Format: a.config.DHCPLeases.Format}
+	}
+	leases, changed, err := a.leases.Load()
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to load DHCP leases")
+		return
+	}
+	if changed {
+		a.devices.SetLeases(leases)
+		log.Debug().Int("leases", len(leases)).Msg("loaded DHCP leases")
+	}
+}
+
+// matchContext describes the client of the answer for expression rules
+func (a *App) matchContext(clientAddr net.Addr, now time.Time, qtype uint16) models.MatchContext {
+	ctx := models.MatchContext{Client: clientIP(clientAddr), Time: now, QType: qtype}
+	ctx.ClientMAC, ctx.ClientHostname = a.devices.Client(ctx.Client)
+	return ctx
+}
+
+// ListDevices returns LAN devices known from the neighbor table and DNS activity
+func (a *App) ListDevices() []devices.Device {
+	return a.devices.List()
+}
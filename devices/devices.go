@@ -0,0 +1,164 @@
+package devices
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"magitrickle/net-namespace"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// forgetTimeout is how long a device is kept after it was seen for the last time
+const forgetTimeout = 24 * time.Hour
+
+type Device struct {
+	IP        net.IP    `json:"ip"`
+	MAC       string    `json:"mac"`
+	Hostname  string    `json:"hostname"`
+	Interface string    `json:"interface"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+type Inventory struct {
+	mux     sync.RWMutex
+	devices map[string]*Device
+	// leases are DHCP leases by the address
+	leases map[string]Lease
+}
+
+func (i *Inventory) device(ip net.IP) *Device {
+	key := ip.String()
+	device, ok := i.devices[key]
+	if !ok {
+		device = &Device{IP: ip}
+		i.devices[key] = device
+	}
+	return device
+}
+
+// UpdateNeighbors refreshes devices from the neighbor table of the interfaces
+func (i *Inventory) UpdateNeighbors(interfaces []string) error {
+	now := time.Now()
+
+	type neighbor struct {
+		netlink.Neigh
+		iface string
+	}
+	var neighbors []neighbor
+	for _, ifaceName := range interfaces {
+		link, err := netNamespace.Netlink.LinkByName(ifaceName)
+		if err != nil {
+			return fmt.Errorf("failed to find link %s: %w", ifaceName, err)
+		}
+		list, err := netNamespace.Netlink.NeighList(link.Attrs().Index, nl.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("failed to list neighbors of %s: %w", ifaceName, err)
+		}
+		for _, neigh := range list {
+			neighbors = append(neighbors, neighbor{Neigh: neigh, iface: ifaceName})
+		}
+	}
+
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	for _, neigh := range neighbors {
+		if neigh.State&(netlink.NUD_REACHABLE|netlink.NUD_STALE|netlink.NUD_DELAY|netlink.NUD_PROBE|netlink.NUD_PERMANENT) == 0 {
+			continue
+		}
+		if neigh.IP == nil || neigh.IP.IsLinkLocalUnicast() || len(neigh.HardwareAddr) == 0 {
+			continue
+		}
+		device := i.device(neigh.IP)
+		device.MAC = neigh.HardwareAddr.String()
+		device.Interface = neigh.iface
+		if neigh.State&(netlink.NUD_REACHABLE|netlink.NUD_PERMANENT) != 0 {
+			device.LastSeen = now
+		}
+	}
+
+	for key, device := range i.devices {
+		if !device.LastSeen.IsZero() && now.Sub(device.LastSeen) > forgetTimeout {
+			delete(i.devices, key)
+		}
+	}
+
+	return nil
+}
+
+// Seen marks the device as active (e.g. on DNS activity)
+func (i *Inventory) Seen(ip net.IP) {
+	if ip == nil || ip.IsLoopback() {
+		return
+	}
+	i.mux.Lock()
+	i.device(ip).LastSeen = time.Now()
+	i.mux.Unlock()
+}
+
+// SetLeases replaces DHCP leases, devices of active leases get their MAC and hostname
+func (i *Inventory) SetLeases(leases []Lease) {
+	now := time.Now()
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	// Addresses of gone leases may be given to other devices
+	for key, lease := range i.leases {
+		if device, ok := i.devices[key]; ok && device.MAC == lease.MAC {
+			device.MAC, device.Hostname = "", ""
+		}
+	}
+	i.leases = make(map[string]Lease, len(leases))
+	for _, lease := range leases {
+		if !lease.Expiry.IsZero() && lease.Expiry.Before(now) {
+			continue
+		}
+		i.leases[lease.IP.String()] = lease
+		device := i.device(lease.IP)
+		device.MAC = lease.MAC
+		device.Hostname = lease.Hostname
+	}
+}
+
+// Client returns the MAC and the hostname of the address, the lease takes precedence over the neighbor table
+func (i *Inventory) Client(ip net.IP) (mac, hostname string) {
+	if ip == nil {
+		return "", ""
+	}
+	key := ip.String()
+	i.mux.RLock()
+	defer i.mux.RUnlock()
+	if lease, ok := i.leases[key]; ok && (lease.Expiry.IsZero() || lease.Expiry.After(time.Now())) {
+		return lease.MAC, lease.Hostname
+	}
+	if device, ok := i.devices[key]; ok {
+		return device.MAC, device.Hostname
+	}
+	return "", ""
+}
+
+func (i *Inventory) List() []Device {
+	i.mux.RLock()
+	devices := make([]Device, 0, len(i.devices))
+	for _, device := range i.devices {
+		devices = append(devices, *device)
+	}
+	i.mux.RUnlock()
+
+	sort.Slice(devices, func(a, b int) bool {
+		return bytes.Compare(devices[a].IP.To16(), devices[b].IP.To16()) < 0
+	})
+	return devices
+}
+
+func New() *Inventory {
+	return &Inventory{
+		devices: make(map[string]*Device),
+	}
+}
@@ -0,0 +1,127 @@
+package devices
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownLeasesFormat = errors.New("unknown leases format")
+
+// Lease is an address given to a device by the DHCP server
+type Lease struct {
+	IP       net.IP
+	MAC      string
+	Hostname string
+	// Expiry is zero for infinite leases
+	Expiry time.Time
+}
+
+// ParseDnsmasqLeases parses "<expiry> <mac> <ip> <hostname> <client-id>" lines, "*" is an unknown hostname
+func ParseDnsmasqLeases(data []byte) []Lease {
+	var leases []Lease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		ip := net.ParseIP(fields[2])
+		mac, err := net.ParseMAC(fields[1])
+		if ip == nil || err != nil {
+			continue
+		}
+		lease := Lease{IP: ip, MAC: mac.String()}
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+		if expiry, err := strconv.ParseInt(fields[0], 10, 64); err == nil && expiry != 0 {
+			lease.Expiry = time.Unix(expiry, 0)
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+// ParseISCLeases parses "lease <ip> { ... }" blocks of dhcpd.leases, later blocks of an address replace earlier ones
+func ParseISCLeases(data []byte) []Lease {
+	var leases []Lease
+	index := make(map[string]int)
+	var current *Lease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		switch {
+		case current == nil && len(fields) >= 2 && fields[0] == "lease":
+			current = &Lease{IP: net.ParseIP(fields[1])}
+		case current == nil:
+		case fields[0] == "}":
+			if current.IP != nil && current.MAC != "" {
+				if idx, ok := index[current.IP.String()]; ok {
+					leases[idx] = *current
+				} else {
+					index[current.IP.String()] = len(leases)
+					leases = append(leases, *current)
+				}
+			}
+			current = nil
+		case len(fields) >= 3 && fields[0] == "hardware" && fields[1] == "ethernet":
+			if mac, err := net.ParseMAC(fields[2]); err == nil {
+				current.MAC = mac.String()
+			}
+		case len(fields) >= 2 && fields[0] == "client-hostname":
+			current.Hostname = strings.Trim(fields[1], `"`)
+		case len(fields) >= 4 && fields[0] == "ends" && fields[1] != "never":
+			// ends <weekday> <yyyy/mm/dd> <hh:mm:ss> in UTC
+			if expiry, err := time.Parse("2006/01/02 15:04:05", fields[2]+" "+fields[3]); err == nil {
+				current.Expiry = expiry
+			}
+		}
+	}
+	return leases
+}
+
+// LeaseFile reads the leases file when it changes
+type LeaseFile struct {
+	Path    string
+	Format  string
+	modTime time.Time
+	size    int64
+}
+
+// Load returns leases of the file, changed is false (and leases are nil) if the file is the same as on the last call
+func (f *LeaseFile) Load() (leases []Lease, changed bool, err error) {
+	var parse func([]byte) []Lease
+	switch f.Format {
+	case "", "dnsmasq":
+		parse = ParseDnsmasqLeases
+	case "isc":
+		parse = ParseISCLeases
+	default:
+		return nil, false, fmt.Errorf("%w: %s", ErrUnknownLeasesFormat, f.Format)
+	}
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to stat leases file: %w", err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read leases file: %w", err)
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return parse(data), true, nil
+}
@@ -0,0 +1,62 @@
+package devices
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseDnsmasqLeases(t *testing.T) {
+	leases := ParseDnsmasqLeases([]byte("1700000000 AA:BB:CC:DD:EE:01 192.168.1.10 kids-tablet 01:aa:bb:cc:dd:ee:01\n" +
+		"0 aa:bb:cc:dd:ee:02 192.168.1.11 * *\n" +
+		"broken line\n"))
+	if len(leases) != 2 {
+		t.Fatalf("unexpected leases: %+v", leases)
+	}
+	if leases[0].MAC != "aa:bb:cc:dd:ee:01" || leases[0].Hostname != "kids-tablet" || !leases[0].Expiry.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected lease: %+v", leases[0])
+	}
+	if leases[1].Hostname != "" || !leases[1].Expiry.IsZero() {
+		t.Fatalf("unexpected infinite lease: %+v", leases[1])
+	}
+}
+
+func TestParseISCLeases(t *testing.T) {
+	leases := ParseISCLeases([]byte(`# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.10 {
+  starts 1 2024/01/01 10:00:00;
+  ends 1 2024/01/01 22:00:00;
+  hardware ethernet aa:bb:cc:dd:ee:01;
+  client-hostname "old-name";
+}
+lease 192.168.1.10 {
+  ends never;
+  hardware ethernet aa:bb:cc:dd:ee:01;
+  client-hostname "kids-tablet";
+}
+`))
+	if len(leases) != 1 {
+		t.Fatalf("unexpected leases: %+v", leases)
+	}
+	if leases[0].Hostname != "kids-tablet" || !leases[0].Expiry.IsZero() || !leases[0].IP.Equal(net.ParseIP("192.168.1.10")) {
+		t.Fatalf("later lease doesn't replace the earlier one: %+v", leases[0])
+	}
+}
+
+func TestInventoryLeases(t *testing.T) {
+	inventory := New()
+	ip := net.ParseIP("192.168.1.10")
+	inventory.SetLeases([]Lease{{IP: ip, MAC: "aa:bb:cc:dd:ee:01", Hostname: "kids-tablet"}})
+	if mac, hostname := inventory.Client(ip); mac != "aa:bb:cc:dd:ee:01" || hostname != "kids-tablet" {
+		t.Fatalf("unexpected client: %s %s", mac, hostname)
+	}
+
+	// The device got another address
+	inventory.SetLeases([]Lease{{IP: net.ParseIP("192.168.1.20"), MAC: "aa:bb:cc:dd:ee:01", Hostname: "kids-tablet"}})
+	if _, hostname := inventory.Client(net.ParseIP("192.168.1.20")); hostname != "kids-tablet" {
+		t.Fatal("new address is not resolved")
+	}
+	if mac, hostname := inventory.Client(ip); mac != "" || hostname != "" {
+		t.Fatalf("old address keeps the device: %s %s", mac, hostname)
+	}
+}
@@ -0,0 +1,124 @@
+package magitrickle
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"time"
+
+	"magitrickle/app-errors"
+	"magitrickle/discovery"
+	"magitrickle/learning"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	ErrInvalidDiscovery  = appErrors.New(appErrors.ErrValidation, "invalid discovery session")
+	ErrDiscoveryNotFound = appErrors.New(appErrors.ErrNotFound, "discovery session not found")
+)
+
+// DiscoveryCandidate is a namespace rule covering domains of the session with the same parent
+type DiscoveryCandidate struct {
+	Type    string   `json:"type"`
+	Rule    string   `json:"rule"`
+	Domains []string `json:"domains"`
+	Queries int      `json:"queries"`
+	// Groups already route some of the domains
+	Groups []string `json:"groups,omitempty"`
+}
+
+// DiscoveryResult is the session with candidate rules for its domains, the most queried first
+type DiscoveryResult struct {
+	discovery.Session
+	Candidates []DiscoveryCandidate `json:"candidates"`
+}
+
+// parseDiscoveryClient returns the canonical form of the client address
+func parseDiscoveryClient(client string) (net.IP, error) {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: invalid client %q", ErrInvalidDiscovery, client)
+	}
+	return ip, nil
+}
+
+// StartDiscovery records domains the client resolves for the duration, a previous session of the client is replaced
+func (a *App) StartDiscovery(client string, duration time.Duration) (discovery.Session, error) {
+	ip, err := parseDiscoveryClient(client)
+	if err != nil {
+		return discovery.Session{}, err
+	}
+	if duration <= 0 || duration > discovery.MaxDuration {
+		return discovery.Session{}, fmt.Errorf("%w: duration must be up to %s", ErrInvalidDiscovery, discovery.MaxDuration)
+	}
+	return a.discovery.Start(ip.String(), duration, time.Now()), nil
+}
+
+// StopDiscovery forgets the session of the client
+func (a *App) StopDiscovery(client string) error {
+	ip, err := parseDiscoveryClient(client)
+	if err != nil {
+		return err
+	}
+	if !a.discovery.Stop(ip.String()) {
+		return ErrDiscoveryNotFound
+	}
+	return nil
+}
+
+// DiscoverySessions returns active and finished sessions without their domains
+func (a *App) DiscoverySessions() []discovery.Session {
+	return a.discovery.List(time.Now())
+}
+
+// DiscoveryResult returns domains the client resolved during its session as candidate rules,
+// groups already routing them are listed, so the user sees what's left to add
+func (a *App) DiscoveryResult(client string) (DiscoveryResult, error) {
+	ip, err := parseDiscoveryClient(client)
+	if err != nil {
+		return DiscoveryResult{}, err
+	}
+	session, ok := a.discovery.Get(ip.String(), time.Now())
+	if !ok {
+		return DiscoveryResult{}, ErrDiscoveryNotFound
+	}
+
+	result := DiscoveryResult{Session: session, Candidates: []DiscoveryCandidate{}}
+	byParent := make(map[string]int)
+	clientAddr := &net.UDPAddr{IP: ip}
+	for _, domain := range session.Domains {
+		parent := learning.Parent(domain.Domain)
+		idx, ok := byParent[parent]
+		if !ok {
+			idx = len(result.Candidates)
+			byParent[parent] = idx
+			result.Candidates = append(result.Candidates, DiscoveryCandidate{Type: "namespace", Rule: parent})
+		}
+		candidate := &result.Candidates[idx]
+		candidate.Domains = append(candidate.Domains, domain.Domain)
+		candidate.Queries += domain.Queries
+		for _, grp := range a.matchQuery(clientAddr, []string{domain.Domain}, dns.TypeA) {
+			if id := grp.ID.String(); !slices.Contains(candidate.Groups, id) {
+				candidate.Groups = append(candidate.Groups, id)
+			}
+		}
+	}
+	sort.SliceStable(result.Candidates, func(i, j int) bool {
+		return result.Candidates[i].Queries > result.Candidates[j].Queries
+	})
+	return result, nil
+}
+
+// observeDiscovery records the query of the client for its discovery session
+func (a *App) observeDiscovery(clientAddr net.Addr, reqMsg *dns.Msg) {
+	if len(reqMsg.Question) == 0 {
+		return
+	}
+	client := clientIP(clientAddr)
+	if client == nil {
+		return
+	}
+	a.discovery.Observe(client.String(), reqMsg.Question[0].Name, time.Now())
+}
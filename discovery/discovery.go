@@ -0,0 +1,160 @@
+// Package discovery records domains a client device resolves during a short session, so the user can
+// use an application for a minute and get the domains behind it as candidate rules.
+package discovery
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxDuration bounds the window of a session
+	MaxDuration = 10 * time.Minute
+	// Retention is how long a finished session is kept for its result
+	Retention = time.Hour
+	// maxSessions are kept at once, the oldest finished session is dropped
+	maxSessions = 16
+	// maxDomains are recorded per session
+	maxDomains = 1024
+)
+
+// Domain is a name the client resolved during the session
+type Domain struct {
+	Domain    string    `json:"domain"`
+	Queries   int       `json:"queries"`
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+// Session is a copy of the session state
+type Session struct {
+	Client  string    `json:"client"`
+	Started time.Time `json:"started"`
+	Until   time.Time `json:"until"`
+	Active  bool      `json:"active"`
+	// Domains are sorted by the first query
+	Domains []Domain `json:"domains"`
+}
+
+type session struct {
+	started time.Time
+	until   time.Time
+	domains map[string]*Domain
+}
+
+type Sessions struct {
+	mux      sync.Mutex
+	sessions map[string]*session
+}
+
+func New() *Sessions {
+	return &Sessions{sessions: make(map[string]*session)}
+}
+
+// Start begins a new session of the client, a previous session of the client is replaced
+func (s *Sessions) Start(client string, duration time.Duration, now time.Time) Session {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.sessions[client]; !ok && len(s.sessions) >= maxSessions {
+		s.evict(now)
+	}
+	sess := &session{started: now, until: now.Add(duration), domains: make(map[string]*Domain)}
+	s.sessions[client] = sess
+	return sess.snapshot(client, now)
+}
+
+// evict drops expired sessions, or the session which finished first if none expired
+func (s *Sessions) evict(now time.Time) {
+	var oldest string
+	for client, sess := range s.sessions {
+		if now.Sub(sess.until) > Retention {
+			delete(s.sessions, client)
+			continue
+		}
+		if oldest == "" || sess.until.Before(s.sessions[oldest].until) {
+			oldest = client
+		}
+	}
+	if len(s.sessions) >= maxSessions {
+		delete(s.sessions, oldest)
+	}
+}
+
+// Stop forgets the session of the client, it reports whether there was one
+func (s *Sessions) Stop(client string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, ok := s.sessions[client]
+	delete(s.sessions, client)
+	return ok
+}
+
+// Observe records the query of the client if it has an active session
+func (s *Sessions) Observe(client, domain string, now time.Time) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".arpa") {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	sess, ok := s.sessions[client]
+	if !ok || !now.Before(sess.until) {
+		return
+	}
+	entry, ok := sess.domains[domain]
+	if !ok {
+		if len(sess.domains) >= maxDomains {
+			return
+		}
+		entry = &Domain{Domain: domain, FirstSeen: now}
+		sess.domains[domain] = entry
+	}
+	entry.Queries++
+}
+
+// Get returns the session of the client
+func (s *Sessions) Get(client string, now time.Time) (Session, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	sess, ok := s.sessions[client]
+	if !ok || now.Sub(sess.until) > Retention {
+		return Session{}, false
+	}
+	return sess.snapshot(client, now), true
+}
+
+// List returns sessions without their domains, the latest first
+func (s *Sessions) List(now time.Time) []Session {
+	s.mux.Lock()
+	list := make([]Session, 0, len(s.sessions))
+	for client, sess := range s.sessions {
+		if now.Sub(sess.until) > Retention {
+			continue
+		}
+		list = append(list, Session{Client: client, Started: sess.started, Until: sess.until, Active: now.Before(sess.until)})
+	}
+	s.mux.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.After(list[j].Started) })
+	return list
+}
+
+func (sess *session) snapshot(client string, now time.Time) Session {
+	snapshot := Session{
+		Client:  client,
+		Started: sess.started,
+		Until:   sess.until,
+		Active:  now.Before(sess.until),
+		Domains: make([]Domain, 0, len(sess.domains)),
+	}
+	for _, entry := range sess.domains {
+		snapshot.Domains = append(snapshot.Domains, *entry)
+	}
+	sort.Slice(snapshot.Domains, func(i, j int) bool {
+		if !snapshot.Domains[i].FirstSeen.Equal(snapshot.Domains[j].FirstSeen) {
+			return snapshot.Domains[i].FirstSeen.Before(snapshot.Domains[j].FirstSeen)
+		}
+		return snapshot.Domains[i].Domain < snapshot.Domains[j].Domain
+	})
+	return snapshot
+}
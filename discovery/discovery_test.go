@@ -0,0 +1,39 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	now := time.Now()
+	s := New()
+	s.Observe("192.168.1.10", "before.example.", now)
+	s.Start("192.168.1.10", time.Minute, now)
+	s.Observe("192.168.1.10", "api.app.example.", now.Add(time.Second))
+	s.Observe("192.168.1.10", "API.App.Example.", now.Add(2*time.Second))
+	s.Observe("192.168.1.10", "cdn.app.example.", now.Add(3*time.Second))
+	s.Observe("192.168.1.10", "1.1.168.192.in-addr.arpa.", now.Add(3*time.Second))
+	s.Observe("192.168.1.11", "other.org.", now.Add(3*time.Second))
+	s.Observe("192.168.1.10", "after.example.", now.Add(time.Minute))
+
+	session, ok := s.Get("192.168.1.10", now.Add(2*time.Minute))
+	if !ok || session.Active {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+	if len(session.Domains) != 2 || session.Domains[0].Domain != "api.app.example" || session.Domains[0].Queries != 2 || session.Domains[1].Domain != "cdn.app.example" {
+		t.Fatalf("unexpected domains: %+v", session.Domains)
+	}
+	if _, ok = s.Get("192.168.1.11", now); ok {
+		t.Fatal("client without a session has a session")
+	}
+	if list := s.List(now); len(list) != 1 || !list[0].Active || list[0].Domains != nil {
+		t.Fatalf("unexpected sessions: %+v", list)
+	}
+	if _, ok = s.Get("192.168.1.10", now.Add(time.Minute+Retention+time.Second)); ok {
+		t.Fatal("expired session is kept")
+	}
+	if !s.Stop("192.168.1.10") || s.Stop("192.168.1.10") {
+		t.Fatal("unexpected result of stop")
+	}
+}
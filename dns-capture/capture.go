@@ -0,0 +1,173 @@
+// Package dnsCapture keeps the last DNS transactions (query and response) in memory
+// and writes them as a pcap file, so they can be attached to bug reports and opened in Wireshark.
+//
+// Packets are synthesized: every transaction becomes two UDP datagrams between the client
+// and port 53 of the server, transactions served over TCP are written as UDP as well.
+package dnsCapture
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// linkTypeRaw is LINKTYPE_RAW, packets start with the IPv4 or IPv6 header
+	linkTypeRaw = 101
+	// maxPayload is the largest DNS message fitting an IPv4 UDP datagram
+	maxPayload = 65507
+)
+
+type Transaction struct {
+	Time     time.Time
+	Client   net.IP
+	Port     uint16
+	Server   net.IP
+	Request  []byte
+	Response []byte
+}
+
+// Ring keeps up to the limit of the last transactions
+type Ring struct {
+	mux          sync.Mutex
+	transactions []Transaction
+	next         int
+	full         bool
+}
+
+func New(limit int) *Ring {
+	return &Ring{transactions: make([]Transaction, limit)}
+}
+
+// Add keeps the transaction, the oldest one is dropped when the ring is full
+func (r *Ring) Add(transaction Transaction) {
+	if len(transaction.Request) > maxPayload || len(transaction.Response) > maxPayload {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.transactions[r.next] = transaction
+	r.next = (r.next + 1) % len(r.transactions)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Len returns the number of kept transactions
+func (r *Ring) Len() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.full {
+		return len(r.transactions)
+	}
+	return r.next
+}
+
+// list returns kept transactions from the oldest
+func (r *Ring) list() []Transaction {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.full {
+		return append([]Transaction(nil), r.transactions[:r.next]...)
+	}
+	return append(append([]Transaction(nil), r.transactions[r.next:]...), r.transactions[:r.next]...)
+}
+
+// WriteTo writes kept transactions as a pcap file
+func (r *Ring) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeRaw)
+	written, err := w.Write(header)
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	for _, transaction := range r.list() {
+		for idx, packet := range [][]byte{
+			datagram(transaction.Client, transaction.Server, transaction.Port, 53, transaction.Request),
+			datagram(transaction.Server, transaction.Client, 53, transaction.Port, transaction.Response),
+		} {
+			// The response follows the query by a microsecond to keep the order in viewers
+			ts := transaction.Time.Add(time.Duration(idx) * time.Microsecond)
+			record := make([]byte, 16, 16+len(packet))
+			binary.LittleEndian.PutUint32(record[0:], uint32(ts.Unix()))
+			binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+			binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+			binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+			written, err = w.Write(append(record, packet...))
+			total += int64(written)
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// datagram builds the IP packet with the UDP datagram, both addresses must be of the same family
+func datagram(src, dst net.IP, srcPort, dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[8:], payload)
+
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(src4, dst4, udp))
+		ip := make([]byte, 20, 20+len(udp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+		ip[8] = 64
+		ip[9] = 17
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], ^sum(0, ip))
+		return append(ip, udp...)
+	}
+
+	src16, dst16 := src.To16(), dst.To16()
+	binary.BigEndian.PutUint16(udp[6:], udpChecksum(src16, dst16, udp))
+	ip := make([]byte, 40, 40+len(udp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+	ip[6] = 17
+	ip[7] = 64
+	copy(ip[8:], src16)
+	copy(ip[24:], dst16)
+	return append(ip, udp...)
+}
+
+func udpChecksum(src, dst net.IP, udp []byte) uint16 {
+	pseudo := make([]byte, 0, 2*len(src)+4)
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	pseudo = append(pseudo, 0, 17)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(udp)))
+	checksum := ^sum(uint32(sum(0, pseudo)), udp)
+	if checksum == 0 {
+		return 0xffff
+	}
+	return checksum
+}
+
+// sum is the ones' complement sum of 16-bit words
+func sum(initial uint32, data []byte) uint16 {
+	total := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		total += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		total += uint32(data[len(data)-1]) << 8
+	}
+	for total > 0xffff {
+		total = total&0xffff + total>>16
+	}
+	return uint16(total)
+}
@@ -0,0 +1,56 @@
+package dnsCapture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := New(2)
+	for i := 0; i < 3; i++ {
+		r.Add(Transaction{
+			Time:     time.Unix(int64(1700000000+i), 0),
+			Client:   net.IPv4(192, 168, 1, 10),
+			Port:     40000,
+			Server:   net.IPv4(192, 168, 1, 1),
+			Request:  []byte{byte(i), 1, 2},
+			Response: []byte{byte(i), 3, 4, 5},
+		})
+	}
+	if r.Len() != 2 {
+		t.Fatalf("expected 2 transactions, got %d", r.Len())
+	}
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if binary.LittleEndian.Uint32(data) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(data[20:]) != linkTypeRaw {
+		t.Fatal("invalid pcap header")
+	}
+
+	var packets [][]byte
+	for offset := 24; offset < len(data); {
+		length := int(binary.LittleEndian.Uint32(data[offset+8:]))
+		packets = append(packets, data[offset+16:offset+16+length])
+		offset += 16 + length
+	}
+	if len(packets) != 4 {
+		t.Fatalf("expected 4 packets, got %d", len(packets))
+	}
+	// The oldest transaction is dropped, the first packet is the query of the second one
+	query := packets[0]
+	if sum(0, query[:20]) != 0xffff {
+		t.Fatal("invalid IPv4 header checksum")
+	}
+	if binary.BigEndian.Uint16(query[22:]) != 53 || query[28] != 1 {
+		t.Fatalf("unexpected query packet: %v", query)
+	}
+	if binary.BigEndian.Uint16(packets[1][20:]) != 53 || len(packets[1]) != 28+4 {
+		t.Fatalf("unexpected response packet: %v", packets[1])
+	}
+}
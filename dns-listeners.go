@@ -0,0 +1,98 @@
+package magitrickle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"magitrickle/models"
+	"magitrickle/net-namespace"
+	"magitrickle/netfilter-helper"
+	"magitrickle/socket-activation"
+)
+
+// validateDNSPorts checks extra remapped ports and additional listeners of the proxy
+func validateDNSPorts(cfg models.DNSProxy) error {
+	seen := make(map[string]struct{})
+	for idx, port := range cfg.ExtraPorts {
+		if port.Port == 0 || port.Port == 53 {
+			return fmt.Errorf("%w: extra port %d: port %d", ErrInvalidDNSPort, idx, port.Port)
+		}
+		var networks []string
+		switch port.Network {
+		case "":
+			networks = []string{"udp", "tcp"}
+		case "udp", "tcp":
+			networks = []string{port.Network}
+		default:
+			return fmt.Errorf("%w: extra port %d: unknown network %q", ErrInvalidDNSPort, idx, port.Network)
+		}
+		for _, network := range networks {
+			key := network + "/" + strconv.Itoa(int(port.Port))
+			if _, exists := seen[key]; exists {
+				return fmt.Errorf("%w: extra port %d: %s is duplicated", ErrInvalidDNSPort, idx, key)
+			}
+			seen[key] = struct{}{}
+		}
+	}
+	for idx, server := range cfg.Listen {
+		if server.Address == "" || server.Port == 0 {
+			return fmt.Errorf("%w: listener %d: address and port are required", ErrInvalidDNSPort, idx)
+		}
+	}
+	return nil
+}
+
+// dnsPortMappings returns mappings of extra ports to the proxy or to their local ports
+func (a *App) dnsPortMappings() []netfilterHelper.PortMapping {
+	mappings := make([]netfilterHelper.PortMapping, 0, len(a.config.DNSProxy.ExtraPorts))
+	for _, port := range a.config.DNSProxy.ExtraPorts {
+		to := port.To
+		if to == 0 {
+			to = a.config.DNSProxy.Host.Port
+		}
+		mappings = append(mappings, netfilterHelper.PortMapping{Proto: port.Network, From: port.Port, To: to})
+	}
+	return mappings
+}
+
+// serveDNSListeners serves the proxy on additional addresses until the context is done
+func (a *App) serveDNSListeners(ctx context.Context, errChan chan error) error {
+	for _, server := range a.config.DNSProxy.Listen {
+		addr := net.JoinHostPort(server.Address, strconv.Itoa(int(server.Port)))
+
+		udpConn, err := listenPacket(addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen udp port of %s: %w", addr, err)
+		}
+		go func() {
+			err := a.dnsMITM.ServeUDP(ctx, udpConn)
+			if err != nil {
+				errChan <- fmt.Errorf("failed to serve DNS UDP proxy on %s: %v", addr, err)
+			}
+		}()
+
+		tcpListener, err := listenStream(addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen tcp port of %s: %w", addr, err)
+		}
+		go func() {
+			err := a.dnsMITM.ServeTCP(ctx, tcpListener)
+			if err != nil {
+				errChan <- fmt.Errorf("failed to serve DNS TCP proxy on %s: %v", addr, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// listenPacket takes the passed UDP socket of the address (see socketActivation) or binds a new one
+func listenPacket(addr string) (net.PacketConn, error) {
+	return socketActivation.ListenPacket("udp", addr, netNamespace.ListenPacket)
+}
+
+// listenStream takes the passed TCP socket of the address (see socketActivation) or binds a new one
+func listenStream(addr string) (net.Listener, error) {
+	return socketActivation.Listen("tcp", addr, netNamespace.Listen)
+}
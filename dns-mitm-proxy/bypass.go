@@ -0,0 +1,45 @@
+package dnsMitmProxy
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// BypassAction is what Bypass decides for the request of the peer
+type BypassAction int
+
+const (
+	// BypassNone processes the request as usual
+	BypassNone BypassAction = iota
+	// BypassForward sends the request to the default upstream as is, without middlewares, routes and OnResponse
+	BypassForward
+	// BypassRefuse answers REFUSED without asking the upstream
+	BypassRefuse
+)
+
+// bypass handles the request by the action of Bypass other than BypassNone
+func (p *DNSMITMProxy) bypass(req []byte, network string, action BypassAction) ([]byte, error) {
+	switch action {
+	case BypassForward:
+		resp, err := p.requestShared(req, network, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		return resp, nil
+	case BypassRefuse:
+		var reqMsg dns.Msg
+		err := reqMsg.Unpack(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse request: %w", err)
+		}
+		respMsg := new(dns.Msg)
+		respMsg.SetRcode(&reqMsg, dns.RcodeRefused)
+		resp, err := respMsg.Pack()
+		if err != nil {
+			return nil, fmt.Errorf("failed to send refused response: %w", err)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("unknown bypass action %d", action)
+}
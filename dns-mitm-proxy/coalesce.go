@@ -0,0 +1,93 @@
+package dnsMitmProxy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// inflight is an upstream request shared by identical client requests
+type inflight struct {
+	done chan struct{}
+	resp []byte
+	err  error
+}
+
+type coalescer struct {
+	mux   sync.Mutex
+	calls map[string]*inflight
+}
+
+// do runs fn once for concurrent calls with the same key, every caller gets the same result
+func (c *coalescer) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	c.mux.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]*inflight)
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mux.Unlock()
+		<-call.done
+		return call.resp, call.err
+	}
+	call := &inflight{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mux.Unlock()
+
+	call.resp, call.err = fn()
+
+	c.mux.Lock()
+	delete(c.calls, key)
+	c.mux.Unlock()
+	close(call.done)
+	return call.resp, call.err
+}
+
+// coalesceKey returns the key of requests the upstream answers identically, empty if the request
+// must not be shared. The name is case-sensitive as clients using 0x20 encoding check it.
+func coalesceKey(req []byte, network string) string {
+	var reqMsg dns.Msg
+	if reqMsg.Unpack(req) != nil || len(reqMsg.Question) != 1 || reqMsg.Opcode != dns.OpcodeQuery {
+		return ""
+	}
+	q := reqMsg.Question[0]
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%s|%s|%d|%d|%t|%t", network, q.Name, q.Qtype, q.Qclass, reqMsg.RecursionDesired, reqMsg.CheckingDisabled)
+	if opt := reqMsg.IsEdns0(); opt != nil {
+		_, _ = fmt.Fprintf(&b, "|%t|%d", opt.Do(), opt.UDPSize())
+		for _, option := range opt.Option {
+			// Cookies differ per client and don't change the answer
+			if option.Option() == dns.EDNS0COOKIE {
+				continue
+			}
+			_, _ = fmt.Fprintf(&b, "|%d:%s", option.Option(), option.String())
+		}
+	}
+	return b.String()
+}
+
+// requestShared sends the request to the upstreams, identical in-flight requests to the same upstreams
+// wait for the first one and receive its answer with their own ID
+func (p *DNSMITMProxy) requestShared(req []byte, network string, upstreams []string) ([]byte, error) {
+	if !p.Coalesce || len(req) < 2 {
+		return p.requestDNS(req, network, upstreams)
+	}
+	key := coalesceKey(req, network)
+	if key == "" {
+		return p.requestDNS(req, network, upstreams)
+	}
+	key += "|" + strings.Join(upstreams, ",")
+
+	resp, err := p.coalescer.do(key, func() ([]byte, error) {
+		return p.requestDNS(req, network, upstreams)
+	})
+	if err != nil || len(resp) < 2 {
+		return resp, err
+	}
+	own := make([]byte, len(resp))
+	copy(own, resp)
+	copy(own[:2], req[:2])
+	return own, nil
+}
@@ -0,0 +1,66 @@
+package dnsMitmProxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCoalesce(t *testing.T) {
+	var requests atomic.Int32
+	proxy := &DNSMITMProxy{
+		Coalesce: true,
+		Dial: MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+			requests.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			return resp
+		}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(id uint16) {
+			defer wg.Done()
+			reqMsg := new(dns.Msg)
+			reqMsg.SetQuestion("example.com.", dns.TypeA)
+			reqMsg.Id = id
+			req, _ := reqMsg.Pack()
+			resp, err := proxy.processReq(nil, req, "udp")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var respMsg dns.Msg
+			if err = respMsg.Unpack(resp); err != nil || respMsg.Id != id {
+				t.Errorf("unexpected response for %d: %v %v", id, respMsg.Id, err)
+			}
+		}(uint16(i + 1))
+	}
+	wg.Wait()
+
+	if requests.Load() != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", requests.Load())
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	a := new(dns.Msg)
+	a.SetQuestion("example.com.", dns.TypeA)
+	b := a.Copy()
+	b.Id++
+	b.SetEdns0(1232, true)
+	packedA, _ := a.Pack()
+	packedB, _ := b.Pack()
+	if coalesceKey(packedA, "udp") == coalesceKey(packedB, "udp") {
+		t.Fatal("DO requests must not share answers with plain ones")
+	}
+	if coalesceKey(packedA, "udp") == coalesceKey(packedA, "tcp") {
+		t.Fatal("networks must not share answers")
+	}
+}
@@ -0,0 +1,77 @@
+package dnsMitmProxy
+
+import (
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+// DNSSECMode defines how response middlewares treat DNSSEC signed answers
+type DNSSECMode int
+
+const (
+	// DNSSECIgnore applies response middlewares to every answer
+	DNSSECIgnore DNSSECMode = iota
+	// DNSSECPreserve passes signed answers to the client unchanged
+	DNSSECPreserve
+	// DNSSECStrip removes DNSSEC data from signed answers before response middlewares
+	DNSSECStrip
+)
+
+// isSigned reports whether the client asked for DNSSEC data and the answer carries it,
+// a validating client would reject such an answer once it is modified
+func isSigned(reqMsg, respMsg *dns.Msg) bool {
+	opt := reqMsg.IsEdns0()
+	if opt == nil || !opt.Do() {
+		return false
+	}
+	if respMsg.AuthenticatedData {
+		return true
+	}
+	for _, section := range [][]dns.RR{respMsg.Answer, respMsg.Ns} {
+		for _, record := range section {
+			if record.Header().Rrtype == dns.TypeRRSIG {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stripDNSSECRecords(records []dns.RR) []dns.RR {
+	idx := 0
+	for _, record := range records {
+		switch record.Header().Rrtype {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			continue
+		}
+		records[idx] = record
+		idx++
+	}
+	return records[:idx]
+}
+
+// stripDNSSEC turns the answer into an unsigned one
+func stripDNSSEC(respMsg *dns.Msg) {
+	respMsg.AuthenticatedData = false
+	respMsg.Answer = stripDNSSECRecords(respMsg.Answer)
+	respMsg.Ns = stripDNSSECRecords(respMsg.Ns)
+}
+
+// prepareSigned applies the DNSSEC mode, it returns false if response middlewares must be skipped
+func (p *DNSMITMProxy) prepareSigned(reqMsg, respMsg *dns.Msg) bool {
+	if p.DNSSEC == DNSSECIgnore || !isSigned(reqMsg, respMsg) {
+		return true
+	}
+
+	var name string
+	if len(reqMsg.Question) != 0 {
+		name = reqMsg.Question[0].Name
+	}
+	if p.DNSSEC == DNSSECStrip {
+		log.Debug().Str("name", name).Msg("stripping DNSSEC data from signed answer")
+		stripDNSSEC(respMsg)
+		return true
+	}
+	log.Debug().Str("name", name).Msg("signed answer is passed unchanged")
+	return false
+}
@@ -0,0 +1,50 @@
+package dnsMitmProxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func signedExchange() (*dns.Msg, *dns.Msg) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.SetEdns0(1232, true)
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.AuthenticatedData = true
+	resp.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(1, 2, 3, 4)},
+		&dns.RRSIG{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60}, TypeCovered: dns.TypeA},
+	}
+	return req, resp
+}
+
+func TestPrepareSigned(t *testing.T) {
+	req, resp := signedExchange()
+	if !(&DNSMITMProxy{}).prepareSigned(req, resp) || len(resp.Answer) != 2 {
+		t.Fatal("ignore mode changed the answer")
+	}
+
+	req, resp = signedExchange()
+	if (&DNSMITMProxy{DNSSEC: DNSSECPreserve}).prepareSigned(req, resp) {
+		t.Fatal("preserve mode allows middlewares for signed answer")
+	}
+
+	req, resp = signedExchange()
+	if !(&DNSMITMProxy{DNSSEC: DNSSECStrip}).prepareSigned(req, resp) {
+		t.Fatal("strip mode skips middlewares")
+	}
+	if len(resp.Answer) != 1 || resp.AuthenticatedData {
+		t.Fatalf("DNSSEC data is not stripped: %v", resp)
+	}
+
+	// Without DO the client does not validate
+	req, resp = signedExchange()
+	req.IsEdns0().SetDo(false)
+	if !(&DNSMITMProxy{DNSSEC: DNSSECPreserve}).prepareSigned(req, resp) {
+		t.Fatal("preserve mode skips middlewares for non-validating client")
+	}
+}
@@ -0,0 +1,99 @@
+package dnsMitmProxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dohContentType is the media type of DNS messages of DNS-over-HTTPS (RFC 8484)
+const dohContentType = "application/dns-message"
+
+// dohMaxResponse bounds the read response body, it's the largest DNS message
+const dohMaxResponse = 65535
+
+var ErrInvalidDoH = errors.New("invalid DNS-over-HTTPS upstream")
+
+var dohClient = &http.Client{
+	Timeout: upstreamTimeout,
+	Transport: &http.Transport{
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: upstreamTimeout,
+	},
+}
+
+// IsDoH reports whether the upstream is a DNS-over-HTTPS URL rather than host:port
+func IsDoH(upstream string) bool {
+	return strings.HasPrefix(upstream, "https://")
+}
+
+// ParseDoH checks the DNS-over-HTTPS URL
+func ParseDoH(upstream string) error {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || u.Fragment != "" {
+		return fmt.Errorf("%w: %q", ErrInvalidDoH, upstream)
+	}
+	return nil
+}
+
+// Upstream returns the upstream of the server (host:port), a DNS-over-HTTPS URL is kept as is
+func Upstream(address string, port uint16) string {
+	if IsDoH(address) {
+		return address
+	}
+	return net.JoinHostPort(address, strconv.Itoa(int(port)))
+}
+
+// requestDoH sends the request to the DNS-over-HTTPS upstream by POST. The ID is zeroed as RFC 8484
+// recommends for caches and restored in the response, the response is never truncated.
+func (p *DNSMITMProxy) requestDoH(upstream string, req []byte) ([]byte, error) {
+	if len(req) < 2 {
+		return nil, errors.New("request is too short")
+	}
+	id := binary.BigEndian.Uint16(req)
+	body := bytes.Clone(req)
+	binary.BigEndian.PutUint16(body, 0)
+
+	httpReq, err := http.NewRequest(http.MethodPost, upstream, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", dohContentType)
+	httpReq.Header.Set("Accept", dohContentType)
+
+	client := p.DoHClient
+	if client == nil {
+		client = dohClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", httpResp.Status)
+	}
+	if contentType := httpResp.Header.Get("Content-Type"); contentType != dohContentType {
+		return nil, fmt.Errorf("unexpected content type %q", contentType)
+	}
+
+	resp, err := io.ReadAll(io.LimitReader(httpResp.Body, dohMaxResponse+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(resp) < 12 || len(resp) > dohMaxResponse {
+		return nil, fmt.Errorf("invalid response size %d", len(resp))
+	}
+	binary.BigEndian.PutUint16(resp, id)
+	return resp, nil
+}
@@ -0,0 +1,57 @@
+package dnsMitmProxy
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultUDPSize is the EDNS0 payload size avoiding IP fragmentation (DNS flag day 2020)
+const DefaultUDPSize = 1232
+
+// isTruncated reports whether the TC flag of the packed message is set
+func isTruncated(msg []byte) bool {
+	return len(msg) > 2 && msg[2]&0x02 != 0
+}
+
+// clientUDPSize returns the largest UDP response the client accepts
+func clientUDPSize(req *dns.Msg) int {
+	if opt := req.IsEdns0(); opt != nil && opt.UDPSize() > dns.MinMsgSize {
+		return int(opt.UDPSize())
+	}
+	return dns.MinMsgSize
+}
+
+// upstreamBufferSize is the UDP response buffer, it fits the advertised size and sizes of clients
+func (p *DNSMITMProxy) upstreamBufferSize() int {
+	if int(p.UDPSize) > dns.DefaultMsgSize {
+		return int(p.UDPSize)
+	}
+	return dns.DefaultMsgSize
+}
+
+// advertiseUDPSize replaces the payload size of the EDNS0 request forwarded over UDP,
+// it reports whether the request is changed
+func (p *DNSMITMProxy) advertiseUDPSize(req *dns.Msg) bool {
+	opt := req.IsEdns0()
+	if p.UDPSize == 0 || opt == nil || opt.UDPSize() == p.UDPSize {
+		return false
+	}
+	opt.SetUDPSize(p.UDPSize)
+	return true
+}
+
+// truncateResponse fits the response into the size the client accepts over UDP (0 - any size), records
+// which don't fit are removed and the TC flag is set, so the client retries over TCP
+func truncateResponse(resp []byte, size int) ([]byte, error) {
+	if size == 0 || len(resp) <= size {
+		return resp, nil
+	}
+	var msg dns.Msg
+	err := msg.Unpack(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	msg.Truncate(size)
+	return msg.Pack()
+}
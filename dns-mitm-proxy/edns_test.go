@@ -0,0 +1,108 @@
+package dnsMitmProxy
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// largeAnswer answers with enough records to exceed the minimal UDP size
+func largeAnswer(req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	for i := 1; i <= 60; i++ {
+		rr, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A 10.0.0.%d", req.Question[0].Name, i))
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
+
+func packRequest(t *testing.T, udpSize uint16) []byte {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	if udpSize != 0 {
+		req.SetEdns0(udpSize, false)
+	}
+	packed, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func TestTruncateForClient(t *testing.T) {
+	var advertised uint16
+	proxy := &DNSMITMProxy{
+		UDPSize: DefaultUDPSize,
+		Dial: MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+			if opt := req.IsEdns0(); opt != nil {
+				advertised = opt.UDPSize()
+			}
+			return largeAnswer(req)
+		}),
+	}
+
+	resp, err := proxy.processReq(nil, packRequest(t, 0), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var respMsg dns.Msg
+	if err = respMsg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) > dns.MinMsgSize || !respMsg.Truncated {
+		t.Fatalf("expected truncated response, got %d bytes, tc %v", len(resp), respMsg.Truncated)
+	}
+
+	resp, err = proxy.processReq(nil, packRequest(t, 4096), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = respMsg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if respMsg.Truncated || len(respMsg.Answer) != 60 {
+		t.Fatalf("expected full response, got %d records, tc %v", len(respMsg.Answer), respMsg.Truncated)
+	}
+	if advertised != DefaultUDPSize {
+		t.Fatalf("expected advertised size %d, got %d", DefaultUDPSize, advertised)
+	}
+}
+
+func TestRetryTruncatedOverTCP(t *testing.T) {
+	var networks []string
+	udp := MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Truncated = true
+		return resp
+	})
+	tcp := MemoryUpstream(largeAnswer)
+	proxy := &DNSMITMProxy{
+		Dial: func(network, address string) (net.Conn, error) {
+			networks = append(networks, network)
+			if network == "tcp" {
+				return tcp(network, address)
+			}
+			return udp(network, address)
+		},
+	}
+
+	resp, err := proxy.processReq(nil, packRequest(t, 4096), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var respMsg dns.Msg
+	if err = respMsg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if respMsg.Truncated || len(respMsg.Answer) != 60 {
+		t.Fatalf("expected answer fetched over tcp, got %d records, tc %v", len(respMsg.Answer), respMsg.Truncated)
+	}
+	if len(networks) != 2 || networks[1] != "tcp" {
+		t.Fatalf("unexpected upstream requests: %v", networks)
+	}
+}
@@ -0,0 +1,64 @@
+package dnsMitmProxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// MemoryUpstream returns a Dial function answering requests by the handler over in-memory pipes,
+// so the proxy can be tested without sockets. A nil answer drops the request.
+func MemoryUpstream(handler func(req *dns.Msg) *dns.Msg) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveMemoryUpstream(server, network, handler)
+		return client, nil
+	}
+}
+
+func serveMemoryUpstream(conn net.Conn, network string, handler func(req *dns.Msg) *dns.Msg) {
+	defer func() { _ = conn.Close() }()
+	for {
+		var req []byte
+		if network == "tcp" {
+			var reqLen uint16
+			if binary.Read(conn, binary.BigEndian, &reqLen) != nil {
+				return
+			}
+			req = make([]byte, reqLen)
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+		} else {
+			buf := make([]byte, dns.MaxMsgSize)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			req = buf[:n]
+		}
+
+		var reqMsg dns.Msg
+		if reqMsg.Unpack(req) != nil {
+			return
+		}
+		respMsg := handler(&reqMsg)
+		if respMsg == nil {
+			return
+		}
+		resp, err := respMsg.Pack()
+		if err != nil {
+			return
+		}
+		if network == "tcp" {
+			if binary.Write(conn, binary.BigEndian, uint16(len(resp))) != nil {
+				return
+			}
+		}
+		if _, err = conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
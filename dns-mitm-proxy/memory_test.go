@@ -0,0 +1,149 @@
+package dnsMitmProxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMemoryUpstream(t *testing.T) {
+	proxy := &DNSMITMProxy{
+		Dial: MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			rr, _ := dns.NewRR(req.Question[0].Name + " 60 IN A 1.2.3.4")
+			resp.Answer = append(resp.Answer, rr)
+			return resp
+		}),
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err := proxy.Exchange(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "1.2.3.4" {
+		t.Fatalf("unexpected answer: %v", resp.Answer)
+	}
+}
+
+func TestResolveClient(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 40000}
+	client := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 50000}
+	var seen net.Addr
+	proxy := &DNSMITMProxy{
+		Dial: MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			return resp
+		}),
+		ResolveClient: func(clientAddr net.Addr, network string) net.Addr {
+			if clientAddr == peer {
+				return client
+			}
+			return clientAddr
+		},
+		OnResponse: func(clientAddr net.Addr, _ dns.Msg, _ dns.Msg, _ string) {
+			seen = clientAddr
+		},
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	packed, _ := req.Pack()
+	_, err := proxy.processReq(peer, packed, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != client {
+		t.Fatalf("response is attributed to %v", seen)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	upstream := MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp
+	})
+	var dialed []string
+	proxy := &DNSMITMProxy{
+		UpstreamDNSAddress: "192.168.1.1",
+		UpstreamDNSPort:    53,
+		Dial: func(network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			return upstream(network, address)
+		},
+		Route: func(clientAddr net.Addr, reqMsg *dns.Msg) []string {
+			if reqMsg.Question[0].Name == "vpn.example.com." {
+				return []string{"10.8.0.1:53"}
+			}
+			return nil
+		},
+	}
+
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 50000}
+	for _, name := range []string{"vpn.example.com.", "example.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		packed, _ := req.Pack()
+		_, err := proxy.processReq(peer, packed, "udp")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dialed) != 2 || dialed[0] != "10.8.0.1:53" || dialed[1] != "192.168.1.1:53" {
+		t.Fatalf("unexpected upstreams: %v", dialed)
+	}
+}
+
+func TestBypass(t *testing.T) {
+	router := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	client := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 50000}
+	action := BypassForward
+	responses := 0
+	proxy := &DNSMITMProxy{
+		Dial: MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			return resp
+		}),
+		Bypass: func(peerAddr net.Addr, network string) BypassAction {
+			if peerAddr == router {
+				return action
+			}
+			return BypassNone
+		},
+		OnResponse: func(net.Addr, dns.Msg, dns.Msg, string) {
+			responses++
+		},
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	packed, _ := req.Pack()
+	for _, peer := range []net.Addr{router, client} {
+		_, err := proxy.processReq(peer, packed, "udp")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if responses != 1 {
+		t.Fatalf("only the response of the client must be processed, got %d", responses)
+	}
+
+	action = BypassRefuse
+	resp, err := proxy.processReq(router, packed, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var respMsg dns.Msg
+	if err := respMsg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if respMsg.Rcode != dns.RcodeRefused || respMsg.Id != req.Id {
+		t.Fatalf("unexpected response: %v", respMsg)
+	}
+}
@@ -0,0 +1,173 @@
+package dnsMitmProxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Middleware is a step of the processing chain.
+// Request may modify the request in place or return a response to answer the client
+// without asking the upstream. Response may modify the upstream response in place.
+type Middleware struct {
+	Name     string
+	Request  func(clientAddr net.Addr, reqMsg *dns.Msg, network string) (*dns.Msg, error)
+	Response func(clientAddr net.Addr, reqMsg *dns.Msg, respMsg *dns.Msg, network string) error
+}
+
+// FakePTR answers PTR requests with NXDOMAIN, only for clients accepted by the filter (all if nil)
+func FakePTR(filter func(clientAddr net.Addr) bool) Middleware {
+	return Middleware{
+		Name: "fakePTR",
+		Request: func(clientAddr net.Addr, reqMsg *dns.Msg, network string) (*dns.Msg, error) {
+			if len(reqMsg.Question) != 1 || reqMsg.Question[0].Qtype != dns.TypePTR {
+				return nil, nil
+			}
+			if filter != nil && !filter(clientAddr) {
+				return nil, nil
+			}
+			return &dns.Msg{
+				MsgHdr: dns.MsgHdr{
+					Id:                 reqMsg.Id,
+					Response:           true,
+					RecursionAvailable: true,
+					Rcode:              dns.RcodeNameError,
+				},
+				Question: reqMsg.Question,
+			}, nil
+		},
+	}
+}
+
+// StripECS removes the EDNS Client Subnet option from requests
+func StripECS() Middleware {
+	return Middleware{
+		Name: "stripECS",
+		Request: func(clientAddr net.Addr, reqMsg *dns.Msg, network string) (*dns.Msg, error) {
+			opt := reqMsg.IsEdns0()
+			if opt == nil {
+				return nil, nil
+			}
+			idx := 0
+			for _, option := range opt.Option {
+				if option.Option() == dns.EDNS0SUBNET {
+					continue
+				}
+				opt.Option[idx] = option
+				idx++
+			}
+			opt.Option = opt.Option[:idx]
+			return nil, nil
+		},
+	}
+}
+
+// ClampTTL keeps TTL of the response records in [min, max] range, zero disables the bound
+func ClampTTL(minTTL, maxTTL uint32) Middleware {
+	clamp := func(records []dns.RR) {
+		for _, record := range records {
+			hdr := record.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if minTTL != 0 && hdr.Ttl < minTTL {
+				hdr.Ttl = minTTL
+			}
+			if maxTTL != 0 && hdr.Ttl > maxTTL {
+				hdr.Ttl = maxTTL
+			}
+		}
+	}
+	return Middleware{
+		Name: "clampTTL",
+		Response: func(clientAddr net.Addr, reqMsg *dns.Msg, respMsg *dns.Msg, network string) error {
+			clamp(respMsg.Answer)
+			clamp(respMsg.Ns)
+			clamp(respMsg.Extra)
+			return nil
+		},
+	}
+}
+
+// FilterAAAA removes AAAA records from answers accepted by the filter (all if nil)
+func FilterAAAA(filter func(clientAddr net.Addr, respMsg *dns.Msg) bool) Middleware {
+	return Middleware{
+		Name: "filterAAAA",
+		Response: func(clientAddr net.Addr, reqMsg *dns.Msg, respMsg *dns.Msg, network string) error {
+			if filter != nil && !filter(clientAddr, respMsg) {
+				return nil
+			}
+			idx := 0
+			for _, answer := range respMsg.Answer {
+				if answer.Header().Rrtype == dns.TypeAAAA {
+					continue
+				}
+				respMsg.Answer[idx] = answer
+				idx++
+			}
+			respMsg.Answer = respMsg.Answer[:idx]
+			return nil
+		},
+	}
+}
+
+// LocalZone answers A, AAAA and PTR requests for the local names without asking the upstream.
+// Keys of records are lowercase FQDNs, a known name without addresses of the requested family gets an empty answer
+func LocalZone(records map[string][]net.IP, ttl uint32) Middleware {
+	reverse := make(map[string]string)
+	for name, addresses := range records {
+		for _, address := range addresses {
+			arpa, err := dns.ReverseAddr(address.String())
+			if err == nil {
+				reverse[arpa] = name
+			}
+		}
+	}
+
+	return Middleware{
+		Name: "localZone",
+		Request: func(clientAddr net.Addr, reqMsg *dns.Msg, network string) (*dns.Msg, error) {
+			if len(reqMsg.Question) != 1 {
+				return nil, nil
+			}
+			question := reqMsg.Question[0]
+			name := strings.ToLower(question.Name)
+			hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: ttl}
+
+			var answer []dns.RR
+			switch question.Qtype {
+			case dns.TypePTR:
+				target, ok := reverse[name]
+				if !ok {
+					return nil, nil
+				}
+				answer = append(answer, &dns.PTR{Hdr: hdr, Ptr: target})
+			default:
+				addresses, ok := records[name]
+				if !ok {
+					return nil, nil
+				}
+				for _, address := range addresses {
+					if ip4 := address.To4(); ip4 != nil && question.Qtype == dns.TypeA {
+						answer = append(answer, &dns.A{Hdr: hdr, A: ip4})
+					} else if ip4 == nil && question.Qtype == dns.TypeAAAA {
+						answer = append(answer, &dns.AAAA{Hdr: hdr, AAAA: address})
+					}
+				}
+			}
+
+			return &dns.Msg{
+				MsgHdr: dns.MsgHdr{
+					Id:                 reqMsg.Id,
+					Response:           true,
+					Authoritative:      true,
+					RecursionDesired:   reqMsg.RecursionDesired,
+					RecursionAvailable: true,
+				},
+				Question: reqMsg.Question,
+				Answer:   answer,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,114 @@
+package dnsMitmProxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestFakePTR(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("1.1.168.192.in-addr.arpa.", dns.TypePTR)
+	resp, err := FakePTR(nil).Request(nil, req, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Rcode != dns.RcodeNameError || resp.Id != req.Id {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err = FakePTR(nil).Request(nil, req, "udp")
+	if err != nil || resp != nil {
+		t.Fatalf("non-PTR request answered: %v, %v", resp, err)
+	}
+
+	req.SetQuestion("1.1.168.192.in-addr.arpa.", dns.TypePTR)
+	resp, err = FakePTR(func(net.Addr) bool { return false }).Request(nil, req, "udp")
+	if err != nil || resp != nil {
+		t.Fatalf("filtered client answered: %v, %v", resp, err)
+	}
+}
+
+func TestStripECS(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.SetEdns0(1232, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.IPv4(192, 168, 1, 0)})
+	_, err := StripECS().Request(nil, req, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.IsEdns0().Option) != 0 {
+		t.Fatal("ECS option not removed")
+	}
+}
+
+func TestClampTTL(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.", Rrtype: dns.TypeA, Ttl: 5}},
+		&dns.A{Hdr: dns.RR_Header{Name: "b.", Rrtype: dns.TypeA, Ttl: 100000}},
+	}
+	err := ClampTTL(60, 3600).Response(nil, nil, resp, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Answer[0].Header().Ttl != 60 || resp.Answer[1].Header().Ttl != 3600 {
+		t.Fatalf("unexpected TTLs: %d, %d", resp.Answer[0].Header().Ttl, resp.Answer[1].Header().Ttl)
+	}
+}
+
+func TestFilterAAAA(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.Answer = []dns.RR{
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "a.", Rrtype: dns.TypeAAAA}},
+		&dns.A{Hdr: dns.RR_Header{Name: "a.", Rrtype: dns.TypeA}},
+	}
+	err := FilterAAAA(nil).Response(nil, nil, resp, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != dns.TypeA {
+		t.Fatalf("unexpected answer: %v", resp.Answer)
+	}
+}
+
+func TestLocalZone(t *testing.T) {
+	middleware := LocalZone(map[string][]net.IP{
+		"router.lan.": {net.ParseIP("192.168.1.1"), net.ParseIP("fd00::1")},
+	}, 60)
+
+	req := new(dns.Msg)
+	req.SetQuestion("Router.LAN.", dns.TypeA)
+	resp, err := middleware.Request(nil, req, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || len(resp.Answer) != 1 || !resp.Authoritative || resp.Id != req.Id {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || !a.A.Equal(net.IPv4(192, 168, 1, 1)) || a.Hdr.Ttl != 60 {
+		t.Fatalf("unexpected answer: %v", resp.Answer[0])
+	}
+
+	req.SetQuestion("router.lan.", dns.TypeMX)
+	resp, err = middleware.Request(nil, req, "udp")
+	if err != nil || resp == nil || len(resp.Answer) != 0 || resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("unexpected response for other type: %v, %v", resp, err)
+	}
+
+	req.SetQuestion("1.1.168.192.in-addr.arpa.", dns.TypePTR)
+	resp, err = middleware.Request(nil, req, "udp")
+	if err != nil || resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.PTR).Ptr != "router.lan." {
+		t.Fatalf("unexpected PTR response: %v, %v", resp, err)
+	}
+
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err = middleware.Request(nil, req, "udp")
+	if err != nil || resp != nil {
+		t.Fatalf("foreign name answered: %v, %v", resp, err)
+	}
+}
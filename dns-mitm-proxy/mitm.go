@@ -4,23 +4,111 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"magitrickle/app-errors"
+
 	"github.com/miekg/dns"
 	"github.com/rs/zerolog/log"
 )
 
 type DNSMITMProxy struct {
+	// UpstreamDNSAddress is an IP address or a DNS-over-HTTPS URL (the port is ignored then)
 	UpstreamDNSAddress string
 	UpstreamDNSPort    uint16
+	// RaceDNSAddress is optional, requests are sent to both upstreams and the first valid answer wins
+	RaceDNSAddress string
+	RaceDNSPort    uint16
+
+	// DNSSEC defines how signed answers are treated by response middlewares
+	DNSSEC DNSSECMode
+
+	// Coalesce shares one upstream request between identical requests in flight
+	Coalesce bool
+
+	// UDPSize is the EDNS0 payload size advertised to upstreams over UDP (0 - size of the client)
+	UDPSize uint16
+
+	// Dial is optional, it connects to upstreams instead of net.Dial (e.g. MemoryUpstream in tests)
+	Dial func(network, address string) (net.Conn, error)
+	// DoHClient is optional, DNS-over-HTTPS upstreams are requested by it instead of the default client
+	DoHClient *http.Client
+
+	// OnUpstream receives the result of every upstream request, nil on success
+	OnUpstream func(error)
+	// OnResponse receives every response sent to the client after the middleware chain
+	OnResponse func(net.Addr, dns.Msg, dns.Msg, string)
+	// ResolveClient is optional, it replaces the address of the peer seen by middlewares and OnResponse
+	// (e.g. by the original client of a query translated by NAT)
+	ResolveClient func(clientAddr net.Addr, network string) net.Addr
+	// Route is optional, it returns upstreams (host:port or DNS-over-HTTPS URLs) of the request, several ones race.
+	// Nil sends the request to the default upstream.
+	Route func(clientAddr net.Addr, reqMsg *dns.Msg) []string
+	// Bypass is optional, it's asked with the peer address before anything else (e.g. to leave queries
+	// of the router's own resolver alone)
+	Bypass func(peerAddr net.Addr, network string) BypassAction
+
+	middlewares []Middleware
+	coalescer   coalescer
+	// upstream overrides UpstreamDNSAddress and UpstreamDNSPort, it's set by SetUpstream
+	upstream atomic.Pointer[string]
+}
+
+// Use appends middlewares to the end of the processing chain
+func (p *DNSMITMProxy) Use(middlewares ...Middleware) {
+	p.middlewares = append(p.middlewares, middlewares...)
+}
+
+// SetUpstream replaces the upstream of the running proxy (e.g. resolvers of the WAN changed)
+func (p *DNSMITMProxy) SetUpstream(address string, port uint16) {
+	upstream := net.JoinHostPort(address, strconv.Itoa(int(port)))
+	p.upstream.Store(&upstream)
+}
+
+func (p *DNSMITMProxy) upstreamAddress() string {
+	if upstream := p.upstream.Load(); upstream != nil {
+		return *upstream
+	}
+	return Upstream(p.UpstreamDNSAddress, p.UpstreamDNSPort)
+}
 
-	RequestHook  func(net.Addr, dns.Msg, string) (*dns.Msg, *dns.Msg, error)
-	ResponseHook func(net.Addr, dns.Msg, dns.Msg, string) (*dns.Msg, error)
+// requestDNS sends the request to the upstreams given by Route (nil - the default upstream, raced with
+// the race upstream if any), errors are of the dnsUpstream kind
+func (p *DNSMITMProxy) requestDNS(req []byte, network string, upstreams []string) (resp []byte, err error) {
+	defer func() {
+		err = appErrors.Wrap(appErrors.ErrDNSUpstream, err)
+		if p.OnUpstream != nil {
+			p.OnUpstream(err)
+		}
+	}()
+	switch {
+	case len(upstreams) == 1:
+		return p.requestUpstream(upstreams[0], req, network)
+	case len(upstreams) > 1:
+		return p.raceDNS(upstreams, req, network)
+	case p.RaceDNSAddress != "":
+		return p.raceDNS([]string{p.upstreamAddress(), p.raceAddress()}, req, network)
+	}
+	return p.requestUpstream(p.upstreamAddress(), req, network)
+}
+
+func (p *DNSMITMProxy) dial(network, address string) (net.Conn, error) {
+	if p.Dial != nil {
+		return p.Dial(network, address)
+	}
+	return net.DialTimeout(network, address, upstreamTimeout)
 }
 
-func (p DNSMITMProxy) requestDNS(req []byte, network string) ([]byte, error) {
-	upstreamConn, err := net.Dial(network, fmt.Sprintf("%s:%d", p.UpstreamDNSAddress, p.UpstreamDNSPort))
+func (p *DNSMITMProxy) requestUpstream(address string, req []byte, network string) ([]byte, error) {
+	if IsDoH(address) {
+		return p.requestDoH(address, req)
+	}
+	upstreamConn, err := p.dial(network, address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial DNS upstream: %w", err)
 	}
@@ -51,10 +139,14 @@ func (p DNSMITMProxy) requestDNS(req []byte, network string) ([]byte, error) {
 			return nil, fmt.Errorf("failed to read length: %w", err)
 		}
 		resp = make([]byte, respLen)
-	} else {
-		resp = make([]byte, 512)
+		_, err = io.ReadFull(upstreamConn, resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return resp, nil
 	}
 
+	resp = make([]byte, p.upstreamBufferSize())
 	n, err = upstreamConn.Read(resp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
@@ -63,70 +155,164 @@ func (p DNSMITMProxy) requestDNS(req []byte, network string) ([]byte, error) {
 	return resp[:n], nil
 }
 
-func (p DNSMITMProxy) processReq(clientAddr net.Addr, req []byte, network string) ([]byte, error) {
+// Exchange sends the request to the upstream bypassing the middleware chain
+func (p *DNSMITMProxy) Exchange(reqMsg *dns.Msg) (*dns.Msg, error) {
+	return p.ExchangeWith(reqMsg, nil)
+}
+
+// ExchangeWith is Exchange with the upstreams (host:port or DNS-over-HTTPS URLs), nil - the default upstream
+func (p *DNSMITMProxy) ExchangeWith(reqMsg *dns.Msg, upstreams []string) (*dns.Msg, error) {
+	req, err := reqMsg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack request: %w", err)
+	}
+
+	var respMsg dns.Msg
+	for _, network := range []string{"udp", "tcp"} {
+		resp, err := p.requestDNS(req, network, upstreams)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		err = respMsg.Unpack(resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		if !respMsg.Truncated {
+			break
+		}
+	}
+	return &respMsg, nil
+}
+
+// client returns the address of the client which sent the request from the peer address
+func (p *DNSMITMProxy) client(peerAddr net.Addr, network string) net.Addr {
+	if p.ResolveClient == nil {
+		return peerAddr
+	}
+	return p.ResolveClient(peerAddr, network)
+}
+
+func (p *DNSMITMProxy) processReq(clientAddr net.Addr, req []byte, network string) ([]byte, error) {
+	if p.Bypass != nil {
+		if action := p.Bypass(clientAddr, network); action != BypassNone {
+			return p.bypass(req, network, action)
+		}
+	}
+	clientAddr = p.client(clientAddr, network)
+
+	var hasRequestMiddlewares, hasResponseMiddlewares bool
+	for _, middleware := range p.middlewares {
+		hasRequestMiddlewares = hasRequestMiddlewares || middleware.Request != nil
+		hasResponseMiddlewares = hasResponseMiddlewares || middleware.Response != nil
+	}
+
 	var reqMsg dns.Msg
-	if p.RequestHook != nil || p.ResponseHook != nil {
+	if hasRequestMiddlewares || hasResponseMiddlewares || p.OnResponse != nil || p.Route != nil || network == "udp" {
 		err := reqMsg.Unpack(req)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse request: %w", err)
 		}
 	}
 
-	if p.RequestHook != nil {
-		modifiedReq, modifiedResp, err := p.RequestHook(clientAddr, reqMsg, network)
-		if err != nil {
-			return nil, fmt.Errorf("request hook error: %w", err)
-		}
-		if modifiedResp != nil {
-			resp, err := modifiedResp.Pack()
-			if err != nil {
-				return nil, fmt.Errorf("failed to send modified response: %w", err)
+	if hasRequestMiddlewares {
+		for _, middleware := range p.middlewares {
+			if middleware.Request == nil {
+				continue
 			}
-			return resp, nil
-		}
-		if modifiedReq != nil {
-			reqMsg = *modifiedReq
-			req, err = reqMsg.Pack()
+			modifiedResp, err := middleware.Request(clientAddr, &reqMsg, network)
 			if err != nil {
-				return nil, fmt.Errorf("failed to pack modified request: %w", err)
+				return nil, fmt.Errorf("%s request middleware error: %w", middleware.Name, err)
+			}
+			if modifiedResp != nil {
+				resp, err := modifiedResp.Pack()
+				if err != nil {
+					return nil, fmt.Errorf("failed to send modified response: %w", err)
+				}
+				return resp, nil
 			}
 		}
 	}
 
-	resp, err := p.requestDNS(req, network)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	// The size the client accepts is taken before it's replaced by the advertised one
+	var maxSize int
+	if network == "udp" {
+		maxSize = clientUDPSize(&reqMsg)
 	}
-
-	if p.ResponseHook != nil {
-		var respMsg dns.Msg
-		err = respMsg.Unpack(resp)
+	if (network == "udp" && p.advertiseUDPSize(&reqMsg)) || hasRequestMiddlewares {
+		var err error
+		req, err = reqMsg.Pack()
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse response: %w", err)
+			return nil, fmt.Errorf("failed to pack modified request: %w", err)
 		}
+	}
 
-		modifiedResp, err := p.ResponseHook(clientAddr, reqMsg, respMsg, network)
+	var upstreams []string
+	if p.Route != nil {
+		upstreams = p.Route(clientAddr, &reqMsg)
+	}
+
+	resp, err := p.requestShared(req, network, upstreams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if network == "udp" && isTruncated(resp) {
+		// The answer doesn't fit the UDP upstream leg, it's fetched over TCP and truncated for the client if needed
+		tcpResp, err := p.requestShared(req, "tcp", upstreams)
 		if err != nil {
-			return nil, fmt.Errorf("response hook error: %w", err)
+			log.Debug().Err(err).Msg("failed to retry truncated response over tcp")
+		} else {
+			resp = tcpResp
 		}
-		if modifiedResp != nil {
-			resp, err = modifiedResp.Pack()
+	}
+
+	if !hasResponseMiddlewares && p.OnResponse == nil {
+		return truncateResponse(resp, maxSize)
+	}
+
+	var respMsg dns.Msg
+	err = respMsg.Unpack(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if hasResponseMiddlewares && p.prepareSigned(&reqMsg, &respMsg) {
+		for _, middleware := range p.middlewares {
+			if middleware.Response == nil {
+				continue
+			}
+			err = middleware.Response(clientAddr, &reqMsg, &respMsg, network)
 			if err != nil {
-				return nil, fmt.Errorf("failed to send modified response: %w", err)
+				return nil, fmt.Errorf("%s response middleware error: %w", middleware.Name, err)
 			}
-			return resp, nil
 		}
+
+		resp, err = respMsg.Pack()
+		if err != nil {
+			return nil, fmt.Errorf("failed to send modified response: %w", err)
+		}
+	}
+
+	if p.OnResponse != nil {
+		p.OnResponse(clientAddr, reqMsg, respMsg, network)
 	}
 
-	return resp, nil
+	return truncateResponse(resp, maxSize)
 }
 
-func (p DNSMITMProxy) ListenTCP(ctx context.Context, addr *net.TCPAddr) error {
+func (p *DNSMITMProxy) ListenTCP(ctx context.Context, addr *net.TCPAddr) error {
 	listener, err := net.ListenTCP("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen tcp port: %v", err)
 	}
+	return p.ServeTCP(ctx, listener)
+}
+
+func (p *DNSMITMProxy) ServeTCP(ctx context.Context, listener net.Listener) error {
 	defer func() { _ = listener.Close() }()
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
 
 	for {
 		// Exit if context is done
@@ -136,53 +322,31 @@ func (p DNSMITMProxy) ListenTCP(ctx context.Context, addr *net.TCPAddr) error {
 
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			log.Error().Err(err).Msg("tcp connection error")
 			continue
 		}
 
-		go func(clientConn net.Conn) {
-			defer func() { _ = clientConn.Close() }()
-
-			var respLen uint16
-			err = binary.Read(clientConn, binary.BigEndian, &respLen)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to read length")
-				return
-			}
-
-			req := make([]byte, int(respLen))
-			_, err = clientConn.Read(req)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to read tcp request")
-				return
-			}
-
-			resp, err := p.processReq(clientConn.RemoteAddr(), req, "tcp")
-			if err != nil {
-				log.Error().Err(err).Msg("failed to process request")
-				return
-			}
-
-			err = binary.Write(clientConn, binary.BigEndian, uint16(len(resp)))
-			if err != nil {
-				log.Error().Err(err).Msg("failed to send length")
-				return
-			}
-			_, err = clientConn.Write(resp)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to send response")
-				return
-			}
-		}(conn)
+		go p.serveTCPConn(conn)
 	}
 }
 
-func (p DNSMITMProxy) ListenUDP(ctx context.Context, addr *net.UDPAddr) error {
+func (p *DNSMITMProxy) ListenUDP(ctx context.Context, addr *net.UDPAddr) error {
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen udp port: %v", err)
 	}
+	return p.ServeUDP(ctx, conn)
+}
+
+func (p *DNSMITMProxy) ServeUDP(ctx context.Context, conn net.PacketConn) error {
 	defer func() { _ = conn.Close() }()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
 
 	for {
 		// Exit if context is done
@@ -191,21 +355,24 @@ func (p DNSMITMProxy) ListenUDP(ctx context.Context, addr *net.UDPAddr) error {
 		}
 
 		req := make([]byte, 512)
-		n, clientAddr, err := conn.ReadFromUDP(req)
+		n, clientAddr, err := conn.ReadFrom(req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			log.Error().Err(err).Msg("failed to read udp request")
 			continue
 		}
 		req = req[:n]
 
-		go func(clientConn *net.UDPConn, clientAddr *net.UDPAddr) {
+		go func(clientConn net.PacketConn, clientAddr net.Addr) {
 			resp, err := p.processReq(clientAddr, req, "udp")
 			if err != nil {
 				log.Error().Err(err).Msg("failed to process request")
 				return
 			}
 
-			_, err = clientConn.WriteToUDP(resp, clientAddr)
+			_, err = clientConn.WriteTo(resp, clientAddr)
 			if err != nil {
 				log.Error().Err(err).Msg("failed to send response")
 				return
@@ -0,0 +1,64 @@
+package dnsMitmProxy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+func (p *DNSMITMProxy) raceAddress() string {
+	return Upstream(p.RaceDNSAddress, p.RaceDNSPort)
+}
+
+// isValidAnswer reports whether the response may win the race,
+// server failures and refusals are hoped to be answered by the other upstream
+func isValidAnswer(req, resp []byte) bool {
+	var msg dns.Msg
+	err := msg.Unpack(resp)
+	if err != nil || len(req) < 2 {
+		return false
+	}
+	if msg.Id != uint16(req[0])<<8|uint16(req[1]) {
+		return false
+	}
+	return msg.Rcode != dns.RcodeServerFailure && msg.Rcode != dns.RcodeRefused
+}
+
+// raceDNS sends the request to all upstreams and returns the first valid answer,
+// if there is none the last received answer is returned
+func (p *DNSMITMProxy) raceDNS(addresses []string, req []byte, network string) ([]byte, error) {
+	type result struct {
+		address string
+		resp    []byte
+		err     error
+	}
+
+	results := make(chan result, len(addresses))
+	for _, address := range addresses {
+		go func(address string) {
+			resp, err := p.requestUpstream(address, req, network)
+			results <- result{address: address, resp: resp, err: err}
+		}(address)
+	}
+
+	var fallback []byte
+	var errs []error
+	for range addresses {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", res.address, res.err))
+			continue
+		}
+		if isValidAnswer(req, res.resp) {
+			log.Trace().Str("upstream", res.address).Msg("race won")
+			return res.resp, nil
+		}
+		fallback = res.resp
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, errors.Join(errs...)
+}
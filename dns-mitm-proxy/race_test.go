@@ -0,0 +1,44 @@
+package dnsMitmProxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func startUDPUpstream(t *testing.T, delay time.Duration, rcode int) uint16 {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		time.Sleep(delay)
+		resp := new(dns.Msg)
+		resp.SetRcode(req, rcode)
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestRaceDNS(t *testing.T) {
+	proxy := &DNSMITMProxy{
+		UpstreamDNSAddress: "127.0.0.1",
+		UpstreamDNSPort:    startUDPUpstream(t, 0, dns.RcodeServerFailure),
+		RaceDNSAddress:     "127.0.0.1",
+		RaceDNSPort:        startUDPUpstream(t, 50*time.Millisecond, dns.RcodeSuccess),
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err := proxy.Exchange(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("failed upstream won the race: %s", dns.RcodeToString[resp.Rcode])
+	}
+}
@@ -0,0 +1,145 @@
+package dnsMitmProxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+// tcpIdleTimeout closes client connections without new requests
+const tcpIdleTimeout = 10 * time.Second
+
+const upstreamTimeout = 5 * time.Second
+
+// canSplice reports whether TCP messages may be passed through without decoding,
+// it's possible when nothing in the chain needs to mutate them and there is a single upstream over TCP
+func (p *DNSMITMProxy) canSplice() bool {
+	return len(p.middlewares) == 0 && p.RaceDNSAddress == "" && p.Route == nil && !IsDoH(p.upstreamAddress())
+}
+
+func (p *DNSMITMProxy) serveTCPConn(clientConn net.Conn) {
+	var upstreamConn net.Conn
+	defer func() {
+		_ = clientConn.Close()
+		if upstreamConn != nil {
+			_ = upstreamConn.Close()
+		}
+	}()
+
+	// Clients may pipeline several requests over the same connection
+	for {
+		err := clientConn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to set deadline")
+			return
+		}
+
+		var reqLen uint16
+		err = binary.Read(clientConn, binary.BigEndian, &reqLen)
+		if err != nil {
+			var netErr net.Error
+			if !errors.Is(err, io.EOF) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				log.Error().Err(err).Msg("failed to read length")
+			}
+			return
+		}
+
+		req := make([]byte, int(reqLen))
+		_, err = io.ReadFull(clientConn, req)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to read tcp request")
+			return
+		}
+
+		if p.canSplice() {
+			err = p.spliceTCP(clientConn, &upstreamConn, req)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to splice request")
+				return
+			}
+			continue
+		}
+
+		resp, err := p.processReq(clientConn.RemoteAddr(), req, "tcp")
+		if err != nil {
+			log.Error().Err(err).Msg("failed to process request")
+			return
+		}
+
+		buffers := net.Buffers{binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp}
+		_, err = buffers.WriteTo(clientConn)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to send response")
+			return
+		}
+	}
+}
+
+// spliceTCP passes the length-prefixed request to the upstream connection (dialing it if needed)
+// and copies the response back to the client without decoding; the response is decoded
+// afterward only if somebody observes responses.
+func (p *DNSMITMProxy) spliceTCP(clientConn net.Conn, upstreamConn *net.Conn, req []byte) (err error) {
+	if *upstreamConn == nil {
+		*upstreamConn, err = p.dial("tcp", p.upstreamAddress())
+		if err != nil {
+			return fmt.Errorf("failed to dial DNS upstream: %w", err)
+		}
+	}
+	upstream := *upstreamConn
+	defer func() {
+		if err != nil {
+			_ = upstream.Close()
+			*upstreamConn = nil
+		}
+	}()
+
+	err = upstream.SetDeadline(time.Now().Add(upstreamTimeout))
+	if err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	buffers := net.Buffers{binary.BigEndian.AppendUint16(nil, uint16(len(req))), req}
+	_, err = buffers.WriteTo(upstream)
+	if err != nil {
+		return fmt.Errorf("failed to write request: %w", err)
+	}
+
+	var respLen uint16
+	err = binary.Read(upstream, binary.BigEndian, &respLen)
+	if err != nil {
+		return fmt.Errorf("failed to read length: %w", err)
+	}
+	err = binary.Write(clientConn, binary.BigEndian, respLen)
+	if err != nil {
+		return fmt.Errorf("failed to send length: %w", err)
+	}
+
+	var src io.Reader = upstream
+	var captured bytes.Buffer
+	if p.OnResponse != nil {
+		captured.Grow(int(respLen))
+		src = io.TeeReader(upstream, &captured)
+	}
+	_, err = io.CopyN(clientConn, src, int64(respLen))
+	if err != nil {
+		return fmt.Errorf("failed to copy response: %w", err)
+	}
+
+	if p.OnResponse != nil {
+		var reqMsg, respMsg dns.Msg
+		if reqMsg.Unpack(req) != nil || respMsg.Unpack(captured.Bytes()) != nil {
+			log.Debug().Msg("failed to parse spliced message")
+			return nil
+		}
+		p.OnResponse(p.client(clientConn.RemoteAddr(), "tcp"), reqMsg, respMsg, "tcp")
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package dnsMitmProxy
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeTCPSplice(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	server := &dns.Server{Listener: upstream, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		for i := 0; i < 64; i++ {
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{"0123456789012345678901234567890123456789012345678901234567890123"},
+			})
+		}
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	defer server.Shutdown()
+
+	responses := make(chan struct{}, 3)
+	proxy := &DNSMITMProxy{
+		UpstreamDNSAddress: "127.0.0.1",
+		UpstreamDNSPort:    uint16(upstream.Addr().(*net.TCPAddr).Port),
+		OnResponse: func(net.Addr, dns.Msg, dns.Msg, string) {
+			responses <- struct{}{}
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = proxy.ServeTCP(ctx, listener) }()
+
+	conn, err := dns.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Several requests over the same connection
+	for i := 0; i < 3; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", dns.TypeTXT)
+		err = conn.WriteMsg(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := conn.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id != req.Id || len(resp.Answer) != 64 {
+			t.Fatalf("unexpected response: %v", resp)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		<-responses
+	}
+}
@@ -0,0 +1,206 @@
+package magitrickle
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"magitrickle/dns-mitm-proxy"
+	"magitrickle/group"
+	"magitrickle/models"
+
+	"github.com/miekg/dns"
+)
+
+type dnsRoute struct {
+	group     string
+	unmatched bool
+	qtypes    []uint16
+	clients   []*net.IPNet
+	upstreams []string
+	// iface is the interface the upstreams are reached through, see RuleActionResolver
+	iface string
+}
+
+func (r dnsRoute) hasQType(qtype uint16) bool {
+	if len(r.qtypes) == 0 {
+		return true
+	}
+	for _, candidate := range r.qtypes {
+		if candidate == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClient reports whether the route applies to queries of the client, a route limited to clients
+// doesn't apply to queries without a client (e.g. of the app itself)
+func (r dnsRoute) hasClient(client net.IP) bool {
+	if len(r.clients) == 0 {
+		return true
+	}
+	if client == nil {
+		return false
+	}
+	for _, network := range r.clients {
+		if network.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDNSRoutes checks that routes refer to known groups, query types, clients and upstream sets
+func validateDNSRoutes(dnsProxy models.DNSProxy, groups []models.Group) error {
+	sets := make(map[string]struct{}, len(dnsProxy.Upstreams))
+	for idx, set := range dnsProxy.Upstreams {
+		if set.Name == "" || len(set.Servers) == 0 {
+			return fmt.Errorf("%w: upstream set %d needs a name and servers", ErrInvalidDNSRoute, idx)
+		}
+		if _, ok := sets[set.Name]; ok {
+			return fmt.Errorf("%w: upstream set %q is duplicated", ErrInvalidDNSRoute, set.Name)
+		}
+		for _, server := range set.Servers {
+			if dnsMitmProxy.IsDoH(server.Address) {
+				if err := dnsMitmProxy.ParseDoH(server.Address); err != nil {
+					return fmt.Errorf("%w: upstream set %q: %w", ErrInvalidDNSRoute, set.Name, err)
+				}
+			} else if net.ParseIP(server.Address) == nil {
+				return fmt.Errorf("%w: upstream set %q: invalid address %q", ErrInvalidDNSRoute, set.Name, server.Address)
+			}
+		}
+		sets[set.Name] = struct{}{}
+	}
+
+	for idx, route := range dnsProxy.Routes {
+		if _, ok := sets[route.Upstream]; !ok {
+			return fmt.Errorf("%w: route %d: unknown upstream set %q", ErrInvalidDNSRoute, idx, route.Upstream)
+		}
+		if route.Group != "" && route.Unmatched {
+			return fmt.Errorf("%w: route %d: group and unmatched are exclusive", ErrInvalidDNSRoute, idx)
+		}
+		if route.Group != "" {
+			found := false
+			for _, group := range groups {
+				if group.HasKey(route.Group) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%w: route %d: unknown group %q", ErrInvalidDNSRoute, idx, route.Group)
+			}
+		}
+		for _, qtype := range route.QTypes {
+			if _, ok := dns.StringToType[strings.ToUpper(qtype)]; !ok {
+				return fmt.Errorf("%w: route %d: %w: %s", ErrInvalidDNSRoute, idx, models.ErrUnknownQType, qtype)
+			}
+		}
+		for _, client := range route.Clients {
+			_, err := models.ParseCIDR(client)
+			if err != nil {
+				return fmt.Errorf("%w: route %d: invalid client: %w", ErrInvalidDNSRoute, idx, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compileDNSRoutes resolves upstream sets of routes, the routes must be validated
+func compileDNSRoutes(dnsProxy models.DNSProxy) []dnsRoute {
+	sets := make(map[string]models.UpstreamSet, len(dnsProxy.Upstreams))
+	for _, set := range dnsProxy.Upstreams {
+		sets[set.Name] = set
+	}
+	routes := make([]dnsRoute, 0, len(dnsProxy.Routes))
+	for _, route := range dnsProxy.Routes {
+		set := sets[route.Upstream]
+		compiled := dnsRoute{group: route.Group, unmatched: route.Unmatched, iface: set.Interface}
+		for _, server := range set.Servers {
+			port := server.Port
+			if port == 0 {
+				port = 53
+			}
+			compiled.upstreams = append(compiled.upstreams, dnsMitmProxy.Upstream(server.Address, port))
+		}
+		for _, qtype := range route.QTypes {
+			compiled.qtypes = append(compiled.qtypes, dns.StringToType[strings.ToUpper(qtype)])
+		}
+		for _, client := range route.Clients {
+			network, _ := models.ParseCIDR(client)
+			compiled.clients = append(compiled.clients, network)
+		}
+		routes = append(routes, compiled)
+	}
+	return routes
+}
+
+// routeUpstreams is the Route function of the DNS proxy
+func (a *App) routeUpstreams(clientAddr net.Addr, reqMsg *dns.Msg) []string {
+	route := a.routeQuery(clientAddr, reqMsg)
+	if route == nil {
+		return nil
+	}
+	return route.upstreams
+}
+
+// routeQuery returns the first route matching the client, the group of the queried domain and the query type,
+// nil (the default upstream) if no route matches
+func (a *App) routeQuery(clientAddr net.Addr, reqMsg *dns.Msg) *dnsRoute {
+	if len(reqMsg.Question) != 1 {
+		return nil
+	}
+	question := reqMsg.Question[0]
+	names := []string{strings.ToLower(strings.TrimSuffix(question.Name, "."))}
+	client := clientIP(clientAddr)
+
+	// Groups are matched once and only if some route needs them
+	var matched []*group.Group
+	matchedDone := false
+	for idx := range a.dnsRoutes {
+		route := &a.dnsRoutes[idx]
+		if !route.hasQType(question.Qtype) || !route.hasClient(client) {
+			continue
+		}
+		if route.group == "" && !route.unmatched {
+			return route
+		}
+		if !matchedDone {
+			matched = a.matchQuery(clientAddr, names, question.Qtype)
+			matchedDone = true
+		}
+		if route.unmatched {
+			if len(matched) == 0 {
+				return route
+			}
+			continue
+		}
+		for _, grp := range matched {
+			if grp.HasKey(route.group) {
+				return route
+			}
+		}
+	}
+	return nil
+}
+
+// matchQuery returns enabled groups routing the queried names, exclusions don't count as matches
+func (a *App) matchQuery(clientAddr net.Addr, names []string, qtype uint16) []*group.Group {
+	if clientAddr == nil {
+		clientAddr = SystemClient
+	}
+	ctx := a.matchContext(clientAddr, time.Now(), qtype)
+	var matched []*group.Group
+	for _, grp := range a.groups {
+		if !grp.IsEnabled() {
+			continue
+		}
+		rule, _ := grp.Match(names, ctx)
+		if rule != nil && !rule.IsExclude() && !rule.IsLog() {
+			matched = append(matched, grp)
+		}
+	}
+	return matched
+}
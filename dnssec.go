@@ -0,0 +1,16 @@
+package magitrickle
+
+import (
+	"magitrickle/dns-mitm-proxy"
+	"magitrickle/models"
+)
+
+func dnssecMode(mode string) dnsMitmProxy.DNSSECMode {
+	switch mode {
+	case models.DNSSECPreserve:
+		return dnsMitmProxy.DNSSECPreserve
+	case models.DNSSECStrip:
+		return dnsMitmProxy.DNSSECStrip
+	}
+	return dnsMitmProxy.DNSSECIgnore
+}
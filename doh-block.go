@@ -0,0 +1,146 @@
+package magitrickle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"magitrickle/doh-block"
+	"magitrickle/models"
+	"magitrickle/netfilter-helper"
+	"magitrickle/subscription"
+
+	"github.com/rs/zerolog/log"
+)
+
+type dohListResult struct {
+	entries []string
+	err     error
+}
+
+// dohBlocker blocks HTTPS to addresses of public DoH servers. Addresses of the built-in and configured lists
+// are kept while running, addresses resolved for DoH domains live as long as records of the answer.
+type dohBlocker struct {
+	list     *dohBlock.List
+	ipset    *netfilterHelper.IPSet
+	block    *netfilterHelper.IPSetBlock
+	fetched  time.Time
+	fetching bool
+	results  chan dohListResult
+}
+
+// startDoHBlock creates the ipset of DoH servers and blocks HTTPS to it
+func (a *App) startDoHBlock() error {
+	cfg := a.config.DoHBlock
+	if !cfg.Enable {
+		return nil
+	}
+	ipset, err := a.nfHelper4.IPSet(a.config.Netfilter.IPSet.TablePrefix + "doh")
+	if err != nil {
+		return fmt.Errorf("failed to create DoH ipset: %w", err)
+	}
+	a.doh.ipset = ipset
+	a.addDoHNetworks(append(append([]string(nil), dohBlock.DefaultAddresses...), cfg.Addresses...))
+
+	block := a.nfHelper4.IPSetBlock(a.config.Netfilter.IPTables.ChainPrefix+"DOH", ipset.SetName, cfg.Action == models.DoHBlockDrop)
+	err = block.Enable()
+	if err != nil {
+		_ = ipset.Destroy()
+		a.doh.ipset = nil
+		return fmt.Errorf("failed to block DoH: %w", err)
+	}
+	a.doh.block = block
+	a.doh.list = dohBlock.New(a.dohDomains(nil))
+	a.doh.fetched = time.Time{}
+	a.doh.fetching = false
+	a.doh.results = make(chan dohListResult)
+	return nil
+}
+
+func (a *App) stopDoHBlock() {
+	if a.doh.block != nil {
+		_ = a.doh.block.Disable()
+	}
+	if a.doh.ipset != nil {
+		_ = a.doh.ipset.Destroy()
+	}
+	a.doh.list, a.doh.ipset, a.doh.block = nil, nil, nil
+}
+
+// dohDomains returns built-in, configured and fetched DoH domains
+func (a *App) dohDomains(fetched []string) []string {
+	domains := append([]string(nil), dohBlock.DefaultDomains...)
+	domains = append(domains, a.config.DoHBlock.Domains...)
+	return append(domains, fetched...)
+}
+
+// addDoHNetworks adds addresses and networks to the DoH ipset without a timeout
+func (a *App) addDoHNetworks(entries []string) {
+	var timeout uint32
+	for _, entry := range entries {
+		network, err := models.ParseCIDR(entry)
+		if err != nil || network.IP.To4() == nil {
+			continue
+		}
+		err = a.doh.ipset.AddNet(network, &timeout)
+		if err != nil {
+			log.Error().Str("network", network.String()).Err(err).Msg("failed to add DoH network")
+		}
+	}
+}
+
+// blockDoH adds the address to the DoH ipset if one of the names is a DoH domain
+func (a *App) blockDoH(names []string, address net.IP, ttl uint32) {
+	if a.doh.list == nil || !a.doh.list.Match(names) {
+		return
+	}
+	err := a.doh.ipset.AddIP(address, &ttl)
+	if err != nil {
+		log.Error().Str("address", address.String()).Err(err).Msg("failed to add DoH address")
+		return
+	}
+	log.Debug().Str("address", address.String()).Strs("names", names).Msg("block DoH address")
+}
+
+// checkDoHList starts the fetch of the DoH list subscription if it's due
+func (a *App) checkDoHList(ctx context.Context) {
+	cfg := a.config.DoHBlock
+	s := &a.doh
+	if s.list == nil || cfg.URL == "" || s.fetching {
+		return
+	}
+	now := time.Now()
+	if now.Sub(s.fetched) < time.Duration(cfg.IntervalOrDefault())*time.Second {
+		return
+	}
+	s.fetching = true
+	s.fetched = now
+	go func(url string, results chan dohListResult) {
+		entries, err := subscription.Fetch(ctx, url)
+		select {
+		case results <- dohListResult{entries: entries, err: err}:
+		case <-ctx.Done():
+		}
+	}(cfg.URL, s.results)
+}
+
+// handleDoHListResult applies the fetched DoH list, networks of the previous list are kept until restart
+func (a *App) handleDoHListResult(result dohListResult) {
+	a.doh.fetching = false
+	if a.doh.list == nil {
+		return
+	}
+	if result.err != nil {
+		log.Error().Err(result.err).Msg("failed to update DoH list")
+		return
+	}
+	domains, networks := dohBlock.Split(result.entries)
+	a.doh.list.Set(a.dohDomains(domains))
+	entries := make([]string, len(networks))
+	for idx, network := range networks {
+		entries[idx] = network.String()
+	}
+	a.addDoHNetworks(entries)
+	log.Info().Int("domains", len(domains)).Int("networks", len(networks)).Msg("DoH list updated")
+}
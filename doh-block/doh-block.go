@@ -0,0 +1,122 @@
+// Package dohBlock keeps the list of public DNS-over-HTTPS servers. Addresses they resolve to are blocked,
+// so clients can't silently switch to encrypted DNS and bypass routing by the DNS proxy.
+package dohBlock
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"magitrickle/models"
+)
+
+// DefaultDomains are hostnames of well-known public DoH servers
+var DefaultDomains = []string{
+	"dns.google",
+	"dns.google.com",
+	"cloudflare-dns.com",
+	"one.one.one.one",
+	"dns.quad9.net",
+	"doh.opendns.com",
+	"doh.familyshield.opendns.com",
+	"adguard-dns.com",
+	"dns.adguard.com",
+	"doh.cleanbrowsing.org",
+	"dns.nextdns.io",
+	"doh.mullvad.net",
+	"dns.mullvad.net",
+	"dns.controld.com",
+	"doh.dns.sb",
+	"dns.alidns.com",
+	"doh.pub",
+	"doh.libredns.gr",
+	"dns0.eu",
+}
+
+// DefaultAddresses are anycast addresses of well-known public DoH servers, they're known before any answer.
+// Only HTTPS is blocked, plain DNS to them keeps working.
+var DefaultAddresses = []string{
+	"1.1.1.1",
+	"1.0.0.1",
+	"8.8.8.8",
+	"8.8.4.4",
+	"9.9.9.9",
+	"149.112.112.112",
+	"208.67.222.222",
+	"208.67.220.220",
+	"94.140.14.14",
+	"94.140.15.15",
+	"185.228.168.168",
+	"185.228.169.168",
+	"76.76.2.0",
+	"194.242.2.2",
+}
+
+// List matches names against DoH domains, a domain matches its subdomains too
+type List struct {
+	mux     sync.RWMutex
+	domains map[string]struct{}
+}
+
+func New(domains []string) *List {
+	l := &List{}
+	l.Set(domains)
+	return l
+}
+
+// Set replaces domains of the list
+func (l *List) Set(domains []string) {
+	set := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		if domain != "" {
+			set[domain] = struct{}{}
+		}
+	}
+	l.mux.Lock()
+	l.domains = set
+	l.mux.Unlock()
+}
+
+// Len returns the number of domains
+func (l *List) Len() int {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	return len(l.domains)
+}
+
+// Match reports whether one of the names is a DoH domain or its subdomain
+func (l *List) Match(names []string) bool {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		for name != "" {
+			if _, ok := l.domains[name]; ok {
+				return true
+			}
+			idx := strings.IndexByte(name, '.')
+			if idx == -1 {
+				break
+			}
+			name = name[idx+1:]
+		}
+	}
+	return false
+}
+
+// Split separates entries of a subscription list into domains and networks, IPv6 networks are skipped
+func Split(entries []string) ([]string, []*net.IPNet) {
+	var domains []string
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if network, err := models.ParseCIDR(entry); err == nil {
+			if network.IP.To4() != nil {
+				networks = append(networks, network)
+			}
+			continue
+		}
+		domains = append(domains, strings.TrimPrefix(entry, "*."))
+	}
+	return domains, networks
+}
@@ -0,0 +1,39 @@
+package dohBlock
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	l := New([]string{"dns.google", "Cloudflare-DNS.com."})
+	for names, expected := range map[string]bool{
+		"dns.google":                 true,
+		"dns.google.":                true,
+		"mozilla.cloudflare-dns.com": true,
+		"google":                     false,
+		"notdns.google":              false,
+		"example.com":                false,
+	} {
+		if got := l.Match([]string{names}); got != expected {
+			t.Errorf("Match(%q) = %v, expected %v", names, got, expected)
+		}
+	}
+	if !l.Match([]string{"cdn.example.net", "dns.google"}) {
+		t.Error("Match of an alias expected")
+	}
+
+	l.Set([]string{"dns.quad9.net"})
+	if l.Match([]string{"dns.google"}) || !l.Match([]string{"dns.quad9.net"}) {
+		t.Error("Set doesn't replace domains")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	domains, networks := Split([]string{"*.doh.example", "dns.example", "10.0.0.1", "10.1.0.0/16", "2001:db8::/32"})
+	if len(domains) != 2 || domains[0] != "doh.example" || domains[1] != "dns.example" {
+		t.Fatalf("unexpected domains: %v", domains)
+	}
+	if len(networks) != 2 || networks[0].String() != "10.0.0.1/32" || networks[1].String() != "10.1.0.0/16" {
+		t.Fatalf("unexpected networks: %v", networks)
+	}
+}
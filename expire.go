@@ -0,0 +1,58 @@
+package magitrickle
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"magitrickle/app-errors"
+
+	"github.com/rs/zerolog/log"
+)
+
+// recordsExpireInterval is how often expired records are removed and their addresses are deleted from groups
+const recordsExpireInterval = 10 * time.Second
+
+// expireRecords keeps ipsets in sync with the records store: addresses of expired records are deleted
+// from groups which added them, unless another record still holds the address
+func (a *App) expireRecords() {
+	if a.labels != nil {
+		a.labels.expire(time.Now())
+	}
+	count := a.records.Expire()
+	if count != 0 {
+		log.Debug().Int("addresses", count).Msg("deleted expired addresses")
+	}
+}
+
+// ForgetDomain drops the domain, its aliases and its CNAME chain from the records store, so a stale answer
+// doesn't keep routing a domain removed from rules. Addresses no other record holds are deleted from groups
+// at once. It returns the numbers of dropped domains and deleted addresses.
+func (a *App) ForgetDomain(name string) (int, int, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return 0, 0, appErrors.New(appErrors.ErrValidation, "empty domain")
+	}
+	if a.records == nil {
+		return 0, 0, ErrNotRunning
+	}
+	domains := a.records.Forget(name)
+	if domains == 0 {
+		return 0, 0, fmt.Errorf("%w: %s", ErrDomainNotCached, name)
+	}
+	addresses := a.records.Expire()
+	log.Info().Str("domain", name).Int("domains", domains).Int("addresses", addresses).Msg("forgot domain")
+	return domains, addresses, nil
+}
+
+// FlushRecords drops the whole records store and deletes addresses of records from groups,
+// static addresses stay. It returns the numbers of dropped domains and deleted addresses.
+func (a *App) FlushRecords() (int, int, error) {
+	if a.records == nil {
+		return 0, 0, ErrNotRunning
+	}
+	domains := a.records.Flush()
+	addresses := a.records.Expire()
+	log.Info().Int("domains", domains).Int("addresses", addresses).Msg("flushed records")
+	return domains, addresses, nil
+}
@@ -0,0 +1,64 @@
+package magitrickle
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dropsAAAA reports whether AAAA answers of the response must be dropped: a domain of the response
+// is matched by a group which doesn't route IPv6, so the client would bypass its tunnel over IPv6
+func (a *App) dropsAAAA(clientAddr net.Addr, respMsg *dns.Msg) bool {
+	names := responseNames(respMsg, dns.TypeAAAA)
+	if len(names) == 0 {
+		return false
+	}
+	if clientAddr == nil {
+		clientAddr = SystemClient
+	}
+	ctx := a.matchContext(clientAddr, time.Now(), dns.TypeAAAA)
+	for _, group := range a.groups {
+		if !group.IsEnabled() || group.RoutesIPv6() {
+			continue
+		}
+		rule, _ := group.Match(names, ctx)
+		if rule != nil && !rule.IsExclude() && !rule.IsLog() {
+			return true
+		}
+	}
+	return false
+}
+
+// responseNames returns the question name and owner names of the answer (the CNAME chain),
+// nil if the answer has no records of the type
+func responseNames(msg *dns.Msg, rrtype uint16) []string {
+	found := false
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == rrtype {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	unique := make(map[string]struct{})
+	names := make([]string, 0, len(msg.Answer)+1)
+	add := func(name string) {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if _, ok := unique[name]; ok || name == "" {
+			return
+		}
+		unique[name] = struct{}{}
+		names = append(names, name)
+	}
+	for _, question := range msg.Question {
+		add(question.Name)
+	}
+	for _, rr := range msg.Answer {
+		add(rr.Header().Name)
+	}
+	return names
+}
@@ -0,0 +1,125 @@
+// Package fleet pulls config bundles published for several routers from a central URL.
+//
+// The bundle is a config file, its ed25519 signature is published next to it
+// at the same URL with the ".sig" suffix (base64). Only bundles signed by the
+// configured key are returned, each bundle is returned once.
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	maxBundleSize  = 1 << 20
+	requestTimeout = 30 * time.Second
+)
+
+var (
+	ErrInvalidPublicKey = errors.New("invalid fleet public key")
+	ErrInvalidSignature = errors.New("invalid bundle signature")
+)
+
+type Client struct {
+	URL       string
+	PublicKey ed25519.PublicKey
+	// StatePath is optional, the digest of the last bundle is kept there across restarts
+	StatePath string
+
+	httpClient *http.Client
+	lastDigest []byte
+}
+
+// ParsePublicKey decodes the base64 ed25519 public key
+func ParsePublicKey(value string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return key, nil
+}
+
+func (c *Client) get(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response is larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// Fetch returns the verified bundle, nil if it is the same as the last returned one
+func (c *Client) Fetch(ctx context.Context) ([]byte, error) {
+	bundle, err := c.get(ctx, c.URL, maxBundleSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bundle: %w", err)
+	}
+
+	digest := sha256.Sum256(bundle)
+	if bytes.Equal(digest[:], c.lastDigest) {
+		return nil, nil
+	}
+
+	sigData, err := c.get(ctx, c.URL+".sig", 1024)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil || !ed25519.Verify(c.PublicKey, bundle, signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	// A failed bundle is not retried until a new one is published
+	c.lastDigest = digest[:]
+	if c.StatePath != "" {
+		err = os.WriteFile(c.StatePath, []byte(base64.StdEncoding.EncodeToString(c.lastDigest)), 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save state: %w", err)
+		}
+	}
+	return bundle, nil
+}
+
+func New(url, publicKey, statePath string) (*Client, error) {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		URL:        url,
+		PublicKey:  key,
+		StatePath:  statePath,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		if err == nil {
+			c.lastDigest, _ = base64.StdEncoding.DecodeString(string(data))
+		}
+	}
+	return c, nil
+}
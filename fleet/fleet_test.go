@@ -0,0 +1,72 @@
+package fleet
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := []byte("configVersion: 0.1.0\n")
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, bundle))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle.yaml":
+			_, _ = w.Write(bundle)
+		case "/bundle.yaml.sig":
+			_, _ = w.Write([]byte(signature))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	statePath := filepath.Join(t.TempDir(), "fleet.state")
+	client, err := New(srv.URL+"/bundle.yaml", base64.StdEncoding.EncodeToString(publicKey), statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := client.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(bundle) {
+		t.Fatalf("unexpected bundle: %q", data)
+	}
+
+	data, err = client.Fetch(context.Background())
+	if err != nil || data != nil {
+		t.Fatalf("unchanged bundle returned: %q, %v", data, err)
+	}
+
+	// The state survives restarts
+	client, err = New(srv.URL+"/bundle.yaml", base64.StdEncoding.EncodeToString(publicKey), statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = client.Fetch(context.Background())
+	if err != nil || data != nil {
+		t.Fatalf("unchanged bundle returned after restart: %q, %v", data, err)
+	}
+
+	bundle = []byte("configVersion: 0.1.1\n")
+	_, err = client.Fetch(context.Background())
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+
+	if _, err := New(srv.URL, "short", ""); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Fatalf("expected invalid key, got %v", err)
+	}
+}
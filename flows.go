@@ -0,0 +1,63 @@
+package magitrickle
+
+import (
+	"magitrickle/net-namespace"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+)
+
+type flowCounter struct {
+	flows int
+	bytes uint64
+}
+
+// sampleFlows counts conntrack entries per group (in the order of groups): routed groups are matched
+// by the connection mark, proxy and shadow groups by the original destination in their ipsets
+func (a *App) sampleFlows() []flowCounter {
+	counters := make([]flowCounter, len(a.groups))
+	if len(a.groups) == 0 || a.memoryBackend {
+		return counters
+	}
+
+	flows, err := netNamespace.Netlink.ConntrackTableList(netlink.ConntrackTable, netlink.FAMILY_V4)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to list conntrack entries")
+		return counters
+	}
+
+	marks := make(map[uint32]int)
+	var byAddress []int
+	addresses := make([]map[string]*uint32, len(a.groups))
+	for idx, group := range a.groups {
+		if mark, _ := group.Routing(); mark != 0 {
+			marks[mark] = idx
+			continue
+		}
+		addresses[idx], err = group.ListIP()
+		if err != nil {
+			continue
+		}
+		byAddress = append(byAddress, idx)
+	}
+
+	for _, flow := range flows {
+		bytes := flow.Forward.Bytes + flow.Reverse.Bytes
+		if idx, ok := marks[flow.Mark]; ok && flow.Mark != 0 {
+			counters[idx].flows++
+			counters[idx].bytes += bytes
+			continue
+		}
+		dst := flow.Forward.DstIP.To4()
+		if dst == nil {
+			continue
+		}
+		for _, idx := range byAddress {
+			if _, ok := addresses[idx][string(dst)]; ok {
+				counters[idx].flows++
+				counters[idx].bytes += bytes
+			}
+		}
+	}
+	return counters
+}
@@ -0,0 +1,176 @@
+package magitrickle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"magitrickle/group"
+	"magitrickle/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// groupState keeps groups disabled through the API and the pause across restarts
+type groupState struct {
+	path     string
+	disabled map[models.ID]struct{}
+	paused   bool
+}
+
+type groupStateFile struct {
+	Disabled []models.ID `json:"disabled"`
+	Paused   bool        `json:"paused,omitempty"`
+}
+
+// loadGroupState reads the state file, a missing file is an empty state. An empty path disables persistence.
+func loadGroupState(path string) (*groupState, error) {
+	state := &groupState{path: path, disabled: make(map[models.ID]struct{})}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("failed to read group state: %w", err)
+	}
+	var file groupStateFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse group state: %w", err)
+	}
+	for _, id := range file.Disabled {
+		state.disabled[id] = struct{}{}
+	}
+	state.paused = file.Paused
+	return state, nil
+}
+
+func (s *groupState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	file := groupStateFile{Disabled: make([]models.ID, 0, len(s.disabled)), Paused: s.paused}
+	for id := range s.disabled {
+		file.Disabled = append(file.Disabled, id)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create group state directory: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write group state: %w", err)
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// groupStates returns the state of groups, it's loaded on first use
+func (a *App) groupStates() *groupState {
+	if a.groupState == nil {
+		path := a.config.GroupStatePath
+		if a.memoryBackend {
+			path = ""
+		}
+		state, err := loadGroupState(path)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to load group state, all groups are enabled")
+			state, _ = loadGroupState("")
+		}
+		a.groupState = state
+	}
+	return a.groupState
+}
+
+// isGroupDisabled reports whether the group was disabled through the API
+func (a *App) isGroupDisabled(id models.ID) bool {
+	_, disabled := a.groupStates().disabled[id]
+	return disabled
+}
+
+// GroupEnabled reports whether the group is not disabled through the API
+func (a *App) GroupEnabled(id models.ID) bool {
+	return !a.isGroupDisabled(id)
+}
+
+// enableGroup enables the group unless it was disabled through the API or the app is paused
+func (a *App) enableGroup(grp *group.Group) error {
+	if a.isGroupDisabled(grp.ID) {
+		log.Debug().Str("id", grp.ID.String()).Msg("group is disabled")
+		return nil
+	}
+	if a.Paused() {
+		log.Debug().Str("id", grp.ID.String()).Msg("group is not enabled while paused")
+		return nil
+	}
+	return grp.Enable()
+}
+
+// SetGroupEnabled enables or disables the group and persists the state across restarts.
+// A disabled group keeps its ipset, but its netfilter rules, routes and fix protect are removed,
+// new addresses are not added and its counters are frozen. Enabling re-fills the ipset from known records.
+func (a *App) SetGroupEnabled(key string, enabled bool) error {
+	var grp *group.Group
+	for _, candidate := range a.groups {
+		if candidate.HasKey(key) {
+			grp = candidate
+			break
+		}
+	}
+	var id models.ID
+	if grp != nil {
+		id = grp.ID
+	} else {
+		found := false
+		for _, candidate := range a.unprocessedGroups {
+			if candidate.HasKey(key) {
+				id, found = candidate.ID, true
+				break
+			}
+		}
+		if !found {
+			return ErrGroupNotFound
+		}
+	}
+
+	state := a.groupStates()
+	if _, disabled := state.disabled[id]; disabled != enabled {
+		return nil
+	}
+	if enabled {
+		delete(state.disabled, id)
+	} else {
+		state.disabled[id] = struct{}{}
+	}
+
+	if a.isRunning() && grp != nil && !a.Paused() {
+		if enabled {
+			err := grp.Enable()
+			if err == nil {
+				err = grp.Sync(a.records)
+			}
+			if err != nil {
+				state.disabled[id] = struct{}{}
+				return err
+			}
+		} else {
+			// The group is disabled even if some rules failed to be removed
+			err := errors.Join(grp.Disable()...)
+			if err != nil {
+				return errors.Join(err, state.save())
+			}
+		}
+	}
+
+	log.Info().Str("id", id.String()).Bool("enabled", enabled).Msg("group state changed")
+	return state.save()
+}
@@ -0,0 +1,109 @@
+package group
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// addressSet keeps addresses routed by the group, it's an ipset or
+// an in-memory set for shadow groups
+type addressSet interface {
+	AddIP(addr net.IP, timeout *uint32) error
+	AddNet(network *net.IPNet, timeout *uint32) error
+	DelIP(addr net.IP) error
+	ListIPs() (map[string]*uint32, error)
+	// Ensure re-creates the set if it was destroyed externally, it reports whether the set was created
+	Ensure() (bool, error)
+	Destroy() error
+}
+
+// memorySet mimics an ipset with timeouts, entries with zero timeout are permanent
+type memorySet struct {
+	mux sync.Mutex
+	// zero deadline means permanent
+	entries map[string]time.Time
+}
+
+func (s *memorySet) add(key string, timeout *uint32) {
+	var deadline time.Time
+	if timeout == nil {
+		deadline = time.Now().Add(defaultMemorySetTimeout)
+	} else if *timeout != 0 {
+		deadline = time.Now().Add(time.Duration(*timeout) * time.Second)
+	}
+
+	s.mux.Lock()
+	s.entries[key] = deadline
+	s.mux.Unlock()
+}
+
+func (s *memorySet) AddIP(addr net.IP, timeout *uint32) error {
+	if ip4 := addr.To4(); ip4 != nil {
+		addr = ip4
+	}
+	s.add(string(addr), timeout)
+	return nil
+}
+
+func (s *memorySet) AddNet(network *net.IPNet, timeout *uint32) error {
+	// Host networks are addresses as in ipset
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return s.AddIP(network.IP, timeout)
+	}
+	s.add(network.String(), timeout)
+	return nil
+}
+
+func (s *memorySet) DelIP(addr net.IP) error {
+	if ip4 := addr.To4(); ip4 != nil {
+		addr = ip4
+	}
+	s.mux.Lock()
+	delete(s.entries, string(addr))
+	s.mux.Unlock()
+	return nil
+}
+
+// ListIPs returns addresses with remaining timeouts, networks are skipped as ipset does
+func (s *memorySet) ListIPs() (map[string]*uint32, error) {
+	now := time.Now()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	addresses := make(map[string]*uint32)
+	for key, deadline := range s.entries {
+		if !deadline.IsZero() && !deadline.After(now) {
+			delete(s.entries, key)
+			continue
+		}
+		if len(key) != net.IPv4len && len(key) != net.IPv6len {
+			continue
+		}
+		timeout := uint32(0)
+		if !deadline.IsZero() {
+			timeout = uint32(deadline.Sub(now).Seconds())
+		}
+		addresses[key] = &timeout
+	}
+	return addresses, nil
+}
+
+func (s *memorySet) Ensure() (bool, error) {
+	return false, nil
+}
+
+func (s *memorySet) Destroy() error {
+	s.mux.Lock()
+	s.entries = make(map[string]time.Time)
+	s.mux.Unlock()
+	return nil
+}
+
+// defaultMemorySetTimeout is the default timeout of ipsets created by the helper
+const defaultMemorySetTimeout = 300 * time.Second
+
+func newMemorySet() *memorySet {
+	return &memorySet{entries: make(map[string]time.Time)}
+}
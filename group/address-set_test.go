@@ -0,0 +1,73 @@
+package group
+
+import (
+	"net"
+	"testing"
+
+	"magitrickle/models"
+)
+
+func TestMemorySet(t *testing.T) {
+	set := newMemorySet()
+	ttl, permanent := uint32(60), uint32(0)
+	_ = set.AddIP(net.ParseIP("192.0.2.1"), &ttl)
+	_ = set.AddIP(net.ParseIP("192.0.2.2"), &permanent)
+	_, network, _ := net.ParseCIDR("198.51.100.0/24")
+	_ = set.AddNet(network, &ttl)
+
+	addresses, _ := set.ListIPs()
+	if len(addresses) != 2 {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+	if timeout := addresses[string(net.IPv4(192, 0, 2, 2).To4())]; timeout == nil || *timeout != 0 {
+		t.Fatal("permanent entry has a timeout")
+	}
+
+	_ = set.DelIP(net.ParseIP("192.0.2.1"))
+	addresses, _ = set.ListIPs()
+	if len(addresses) != 1 {
+		t.Fatalf("address not deleted: %v", addresses)
+	}
+}
+
+func TestShadowGroup(t *testing.T) {
+	grp, err := NewGroup(models.Group{
+		Shadow: true,
+		Rules:  []*models.Rule{{Type: "subnet", Rule: "203.0.113.1", Enable: true}},
+	}, nil, "MT_", "mt_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = grp.Enable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = grp.AddIP(net.ParseIP("192.0.2.1"), 60)
+
+	addresses, _ := grp.ListIP()
+	if len(addresses) != 2 {
+		t.Fatalf("unexpected would-be addresses: %v", addresses)
+	}
+	if len(grp.IPSetNames()) != 0 {
+		t.Fatal("shadow group owns ipsets")
+	}
+	if errs := grp.Destroy(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestMirroredSet(t *testing.T) {
+	primary, mirror := newMemorySet(), newMemorySet()
+	set := &mirroredSet{addressSet: primary, mirrors: []addressSet{mirror}}
+	ttl := uint32(60)
+	_ = set.AddIP(net.ParseIP("192.0.2.1"), &ttl)
+	_ = set.AddIP(net.ParseIP("192.0.2.2"), &ttl)
+	_ = set.DelIP(net.ParseIP("192.0.2.1"))
+
+	for _, s := range []*memorySet{primary, mirror} {
+		addresses, _ := s.ListIPs()
+		if len(addresses) != 1 || addresses[string(net.IPv4(192, 0, 2, 2).To4())] == nil {
+			t.Fatalf("unexpected addresses: %v", addresses)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package group
+
+import (
+	"net"
+
+	"magitrickle/records"
+
+	"github.com/rs/zerolog/log"
+)
+
+// subscription connects ipsets of the group to the records store, addresses are deleted
+// as soon as records holding them expire instead of waiting for the ipset timeout
+type subscription struct {
+	records  *records.Records
+	route    uint32
+	excluded uint32
+}
+
+// Subscribe makes the store notify the group about expired addresses it has added
+func (g *Group) Subscribe(store *records.Records) {
+	if store == nil {
+		return
+	}
+	g.subscription = subscription{
+		records:  store,
+		route:    store.Subscribe(g.expireIP),
+		excluded: store.Subscribe(g.expireExcludedIP),
+	}
+}
+
+func (g *Group) unsubscribe() {
+	if g.subscription.records == nil {
+		return
+	}
+	g.subscription.records.Unsubscribe(g.subscription.route)
+	g.subscription.records.Unsubscribe(g.subscription.excluded)
+	g.subscription = subscription{}
+}
+
+func (g *Group) contribute(address net.IP, excluded bool) {
+	if g.subscription.records == nil {
+		return
+	}
+	id := g.subscription.route
+	if excluded {
+		id = g.subscription.excluded
+	}
+	g.subscription.records.Contribute(address, id)
+}
+
+func (g *Group) expireIP(address net.IP) {
+	// The address may be gone already by the ipset timeout
+	err := g.ipset.DelIP(address)
+	if err != nil {
+		log.Trace().Str("group", g.ID.String()).Str("address", address.String()).Err(err).Msg("failed to delete expired address")
+		return
+	}
+	log.Debug().Str("group", g.ID.String()).Str("address", address.String()).Msg("expired address")
+}
+
+func (g *Group) expireExcludedIP(address net.IP) {
+	if g.excludeIPSet == nil {
+		return
+	}
+	err := g.excludeIPSet.DelIP(address)
+	if err != nil {
+		log.Trace().Str("group", g.ID.String()).Str("address", address.String()).Err(err).Msg("failed to delete expired excluded address")
+	}
+}
@@ -0,0 +1,43 @@
+package group
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"magitrickle/models"
+	"magitrickle/records"
+)
+
+func TestExpireSubscription(t *testing.T) {
+	store := records.New()
+	grp := NewMemoryGroup(models.Group{})
+	grp.Subscribe(store)
+
+	shared, expiring := net.IPv4(192, 0, 2, 1).To4(), net.IPv4(192, 0, 2, 2).To4()
+	store.AddARecord("short.example.com", shared, 0)
+	store.AddARecord("long.example.com", shared, 60)
+	store.AddARecord("short.example.com", expiring, 0)
+	_ = grp.AddIP(shared, 60)
+	_ = grp.AddIP(expiring, 60)
+
+	time.Sleep(time.Millisecond)
+	if count := store.Expire(); count != 1 {
+		t.Fatalf("expected one expired address, got %d", count)
+	}
+	addresses, _ := grp.ListIP()
+	if _, ok := addresses[string(expiring)]; ok {
+		t.Fatal("expired address is kept")
+	}
+	if _, ok := addresses[string(shared)]; !ok {
+		t.Fatal("address held by another record is deleted")
+	}
+
+	// Destroyed groups are not notified
+	_ = grp.Destroy()
+	store.AddARecord("short.example.com", expiring, 0)
+	time.Sleep(time.Millisecond)
+	if count := store.Expire(); count != 0 {
+		t.Fatalf("unsubscribed group is notified %d times", count)
+	}
+}
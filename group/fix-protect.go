@@ -0,0 +1,45 @@
+package group
+
+import (
+	"fmt"
+
+	"magitrickle/models"
+)
+
+// SetFixProtect sets rules installed while the group with fixProtect routes through its interface
+func (g *Group) SetFixProtect(rules []models.IPTablesSnippet) {
+	g.fixProtect = rules
+}
+
+// insertFixProtect appends fixProtect rules of the table ("" - all tables) unless they exist
+func (g *Group) insertFixProtect(table string) error {
+	if !g.FixProtect || g.ipsetToLink == nil {
+		return nil
+	}
+	values := map[string]string{models.SnippetInterface: g.Interface}
+	for _, rule := range g.fixProtect {
+		if table != "" && rule.Table != table {
+			continue
+		}
+		err := g.iptables.AppendUnique(rule.Table, rule.Chain, rule.Args(values)...)
+		if err != nil {
+			return fmt.Errorf("failed to fix protect: %w", err)
+		}
+	}
+	return nil
+}
+
+func (g *Group) deleteFixProtect() []error {
+	if !g.FixProtect || g.ipsetToLink == nil {
+		return nil
+	}
+	var errs []error
+	values := map[string]string{models.SnippetInterface: g.Interface}
+	for _, rule := range g.fixProtect {
+		err := g.iptables.DeleteIfExists(rule.Table, rule.Chain, rule.Args(values)...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove fix protect: %w", err))
+		}
+	}
+	return errs
+}
@@ -3,8 +3,11 @@ package group
 import (
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"magitrickle/app-errors"
 	"magitrickle/models"
 	"magitrickle/netfilter-helper"
 	"magitrickle/records"
@@ -17,14 +20,140 @@ import (
 type Group struct {
 	models.Group
 
-	enabled     bool
-	iptables    *iptables.IPTables
-	ipset       *netfilterHelper.IPSet
-	ipsetToLink *netfilterHelper.IPSetToLink
+	enabled      bool
+	iptables     *iptables.IPTables
+	ipset        addressSet
+	excludeIPSet addressSet
+	ipsetToLink  *netfilterHelper.IPSetToLink
+	ipsetToProxy *netfilterHelper.IPSetToProxy
+	shaper       *netfilterHelper.Shaper
+	fixProtect   []models.IPTablesSnippet
+
+	promotionMux sync.Mutex
+	promotion    prefixPromotion
+
+	retry        retryQueue
+	matcher      atomic.Pointer[matcher]
+	subscription subscription
 }
 
+// AddIP adds the address to the ipset, on failure the address is queued to be added on the next Heal
 func (g *Group) AddIP(address net.IP, ttl uint32) error {
-	return g.ipset.AddIP(address, &ttl)
+	if !g.routes(address) {
+		return nil
+	}
+	g.contribute(address, false)
+	err := g.addIP(address, ttl)
+	if err != nil && !g.retry.push(address, ttl, false, time.Now()) {
+		log.Warn().Str("group", g.ID.String()).Str("address", address.String()).Msg("retry queue is full, address is dropped")
+	}
+	return err
+}
+
+// routes reports whether the address family is routed by the group
+func (g *Group) routes(address net.IP) bool {
+	if address.To4() != nil {
+		return g.RoutesIPv4()
+	}
+	return g.RoutesIPv6()
+}
+
+func (g *Group) addIP(address net.IP, ttl uint32) error {
+	if !g.PrefixPromotion.IsEnabled() {
+		return g.ipset.AddIP(address, &ttl)
+	}
+
+	g.promotionMux.Lock()
+	defer g.promotionMux.Unlock()
+
+	now := time.Now()
+	if g.isPromoted(address, now) {
+		return g.extendPromoted(address, ttl, now)
+	}
+
+	err := g.ipset.AddIP(address, &ttl)
+	if err != nil {
+		return err
+	}
+	g.promote(address, ttl, now)
+	return nil
+}
+
+// AddExcludedIP adds the address to the exclusion ipset, so it bypasses the group
+func (g *Group) AddExcludedIP(address net.IP, ttl uint32) error {
+	if g.excludeIPSet == nil {
+		return nil
+	}
+	g.contribute(address, true)
+	err := g.excludeIPSet.AddIP(address, &ttl)
+	if err != nil && !g.retry.push(address, ttl, true, time.Now()) {
+		log.Warn().Str("group", g.ID.String()).Str("address", address.String()).Msg("retry queue is full, address is dropped")
+	}
+	return err
+}
+
+// Match returns the enabled rule matching any of the names, exclude rules take precedence.
+// The domain of the context is replaced by each of the names.
+func (g *Group) Match(names []string, ctx models.MatchContext) (*models.Rule, string) {
+	rule, name, _ := g.MatchCounted(names, ctx)
+	return rule, name
+}
+
+// MatchCounted is Match which also returns the number of evaluated rules
+func (g *Group) MatchCounted(names []string, ctx models.MatchContext) (*models.Rule, string, int) {
+	m := g.matcher.Load()
+	if m == nil {
+		m = g.resetMatcher()
+	}
+	m.lookups.Add(1)
+
+	var matchedRule *models.Rule
+	var matchedName string
+	var evaluated uint64
+	forEach(m.candidates(names), func(idx int) bool {
+		rule := g.Rules[idx]
+		// Log rules don't shadow routing rules, a later routing rule wins over them
+		if matchedRule != nil && !rule.IsExclude() && (!matchedRule.IsLog() || rule.IsLog()) {
+			return true
+		}
+		evaluated++
+		for _, name := range names {
+			ctx.Domain = name
+			if !rule.IsMatchContext(ctx) {
+				continue
+			}
+			if rule.IsExclude() {
+				matchedRule, matchedName = rule, name
+				return false
+			}
+			matchedRule, matchedName = rule, name
+			break
+		}
+		return true
+	})
+	m.evaluated.Add(evaluated)
+	return matchedRule, matchedName, int(evaluated)
+}
+
+func (g *Group) resetMatcher() *matcher {
+	m := newMatcher(g.Rules)
+	g.matcher.Store(m)
+	return m
+}
+
+// Update replaces settings of the group which don't need netfilter rules to be re-created (names and domain rules)
+func (g *Group) Update(group models.Group) {
+	g.Group = group
+	g.resetMatcher()
+}
+
+// MatcherStats returns counters of the rule index
+func (g *Group) MatcherStats() MatcherStats {
+	m := g.matcher.Load()
+	if m == nil {
+		m = g.resetMatcher()
+	}
+	return m.stats()
 }
 
 func (g *Group) DelIP(address net.IP) error {
@@ -35,7 +164,13 @@ func (g *Group) ListIP() (map[string]*uint32, error) {
 	return g.ipset.ListIPs()
 }
 
-func (g *Group) Enable() error {
+// IsEnabled reports whether netfilter rules and routes of the group are installed
+func (g *Group) IsEnabled() bool {
+	return g.enabled
+}
+
+// Enable adds static subnets and the rules of the group, errors are of the netfilter kind
+func (g *Group) Enable() (err error) {
 	if g.enabled {
 		return nil
 	}
@@ -43,17 +178,48 @@ func (g *Group) Enable() error {
 		if !g.enabled {
 			_ = g.Disable()
 		}
+		err = appErrors.Wrap(appErrors.ErrNetfilter, err)
 	}()
 
-	if g.FixProtect {
-		err := g.iptables.AppendUnique("filter", "_NDM_SL_FORWARD", "-o", g.Interface, "-m", "state", "--state", "NEW", "-j", "_NDM_SL_PROTECT")
+	err = g.insertFixProtect("")
+	if err != nil {
+		return err
+	}
+
+	err = g.addStatic()
+	if err != nil {
+		return err
+	}
+
+	if g.ipsetToProxy != nil {
+		err = g.ipsetToProxy.Enable()
+	} else if g.ipsetToLink != nil {
+		err = g.ipsetToLink.Enable()
+	}
+	if err != nil {
+		return err
+	}
+
+	if g.shaper != nil {
+		g.shaper.Mark = g.ipsetToLink.Mark()
+		err = g.shaper.Enable()
 		if err != nil {
-			return fmt.Errorf("failed to fix protect: %w", err)
+			_ = g.ipsetToLink.Disable()
+			return fmt.Errorf("failed to limit bandwidth: %w", err)
 		}
 	}
 
-	err := g.ipsetToLink.Enable()
+	err = g.insertSnippets("")
 	if err != nil {
+		_ = g.deleteSnippets()
+		if g.shaper != nil {
+			_ = g.shaper.Disable()
+		}
+		if g.ipsetToProxy != nil {
+			_ = g.ipsetToProxy.Disable()
+		} else if g.ipsetToLink != nil {
+			_ = g.ipsetToLink.Disable()
+		}
 		return err
 	}
 
@@ -62,6 +228,50 @@ func (g *Group) Enable() error {
 	return nil
 }
 
+// addStatic adds permanent exclusions and subnets of static rules
+func (g *Group) addStatic() error {
+	return g.addStaticTo(g.ipset, g.excludeIPSet)
+}
+
+// addStaticTo adds subnets of static rules to the set and exclusions to the exclusion set, nil sets are skipped
+func (g *Group) addStaticTo(set, excludeSet addressSet) error {
+	permanent := uint32(0)
+	if excludeSet != nil {
+		for _, exclude := range g.Exclude {
+			network, err := models.ParseCIDR(exclude)
+			if err != nil {
+				return err
+			}
+			err = excludeSet.AddNet(network, &permanent)
+			if err != nil {
+				return fmt.Errorf("failed to add exclusion: %w", err)
+			}
+		}
+	}
+
+	for _, rule := range g.Rules {
+		if !rule.IsEnabled() || !rule.IsStatic() {
+			continue
+		}
+		target := set
+		if rule.IsExclude() {
+			target = excludeSet
+		}
+		if target == nil {
+			continue
+		}
+		network, err := models.ParseCIDR(rule.Rule)
+		if err != nil {
+			return err
+		}
+		err = target.AddNet(network, &permanent)
+		if err != nil {
+			return fmt.Errorf("failed to add subnet: %w", err)
+		}
+	}
+	return nil
+}
+
 func (g *Group) Disable() []error {
 	var errs []error
 
@@ -69,16 +279,19 @@ func (g *Group) Disable() []error {
 		return nil
 	}
 
-	if g.FixProtect {
-		err := g.iptables.Delete("filter", "_NDM_SL_FORWARD", "-o", g.Interface, "-m", "state", "--state", "NEW", "-j", "_NDM_SL_PROTECT")
-		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to remove fix protect: %w", err))
-		}
+	errs = append(errs, g.deleteFixProtect()...)
+
+	// Snippets use the mark, so they're removed before routing releases it
+	errs = append(errs, g.deleteSnippets()...)
+
+	if g.shaper != nil {
+		errs = append(errs, g.shaper.Disable()...)
 	}
 
-	err := g.ipsetToLink.Disable()
-	if err != nil {
-		errs = append(errs, err...)
+	if g.ipsetToProxy != nil {
+		errs = append(errs, g.ipsetToProxy.Disable()...)
+	} else if g.ipsetToLink != nil {
+		errs = append(errs, g.ipsetToLink.Disable()...)
 	}
 
 	g.enabled = false
@@ -87,20 +300,28 @@ func (g *Group) Disable() []error {
 }
 
 func (g *Group) Destroy() []error {
+	g.unsubscribe()
 	errs := g.Disable()
 	err := g.ipset.Destroy()
 	if err != nil {
 		errs = append(errs, err)
 	}
+	if g.excludeIPSet != nil {
+		err = g.excludeIPSet.Destroy()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
 	return errs
 }
 
-func (g *Group) Sync(records *records.Records) error {
+// MatchedAddresses returns known addresses of domains matched by the rules with their TTL
+func MatchedAddresses(rules []*models.Rule, records *records.Records) map[string]uint32 {
 	now := time.Now()
 
 	addresses := make(map[string]uint32)
 	knownDomains := records.ListKnownDomains()
-	for _, domain := range g.Rules {
+	for _, domain := range rules {
 		if !domain.IsEnabled() {
 			continue
 		}
@@ -112,7 +333,10 @@ func (g *Group) Sync(records *records.Records) error {
 
 			domainAddresses := records.GetARecords(domainName)
 			for _, address := range domainAddresses {
-				ttl := uint32(now.Sub(address.Deadline).Seconds())
+				if !address.Deadline.After(now) {
+					continue
+				}
+				ttl := uint32(address.Deadline.Sub(now).Seconds())
 				if oldTTL, ok := addresses[string(address.Address)]; !ok || ttl > oldTTL {
 					addresses[string(address.Address)] = ttl
 				}
@@ -120,11 +344,113 @@ func (g *Group) Sync(records *records.Records) error {
 		}
 	}
 
+	return addresses
+}
+
+func (g *Group) routeRules() []*models.Rule {
+	rules := make([]*models.Rule, 0, len(g.Rules))
+	for _, rule := range g.Rules {
+		// Addresses of resolver rules belong to the group of the resolving interface, log rules route nothing
+		if !rule.IsExclude() && !rule.IsResolver() && !rule.IsLog() {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (g *Group) excludeRules() []*models.Rule {
+	var rules []*models.Rule
+	for _, rule := range g.Rules {
+		if rule.IsExclude() {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (g *Group) Sync(records *records.Records) error {
 	currentAddresses, err := g.ListIP()
 	if err != nil {
 		return fmt.Errorf("failed to get old ipset list: %w", err)
 	}
+	addresses := MatchedAddresses(g.routeRules(), records)
+	if !g.RoutesIPv4() {
+		addresses = nil
+	}
+	syncAddresses(addresses, currentAddresses, g.AddIP, g.DelIP)
+
+	if g.excludeIPSet != nil {
+		currentAddresses, err = g.excludeIPSet.ListIPs()
+		if err != nil {
+			return fmt.Errorf("failed to get old exclusion ipset list: %w", err)
+		}
+		add := func(address net.IP, ttl uint32) error {
+			return g.excludeIPSet.AddIP(address, &ttl)
+		}
+		syncAddresses(MatchedAddresses(g.excludeRules(), records), currentAddresses, add, g.excludeIPSet.DelIP)
+	}
+
+	return nil
+}
+
+// Heal re-creates ipsets of the group destroyed externally (with their static and known addresses),
+// then adds addresses that failed to be added before. It returns the number of added queued addresses.
+func (g *Group) Heal(records *records.Records) (int, error) {
+	recreated := false
+	for _, set := range []addressSet{g.ipset, g.excludeIPSet} {
+		if set == nil {
+			continue
+		}
+		created, err := set.Ensure()
+		if err != nil {
+			return 0, err
+		}
+		recreated = recreated || created
+	}
+	if recreated {
+		log.Warn().Str("group", g.ID.String()).Msg("ipset was destroyed externally, re-created")
+		if g.enabled {
+			err := g.addStatic()
+			if err != nil {
+				return 0, err
+			}
+		}
+		err := g.Sync(records)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	now := time.Now()
+	entries := g.retry.take(now)
+	for idx, entry := range entries {
+		ttl := uint32(entry.deadline.Sub(now).Seconds())
+		var err error
+		if entry.excluded {
+			if g.excludeIPSet != nil {
+				err = g.excludeIPSet.AddIP(entry.address, &ttl)
+			}
+		} else {
+			err = g.addIP(entry.address, ttl)
+		}
+		if err != nil {
+			// The ipset is still unavailable, keep the rest for the next attempt
+			for _, rest := range entries[idx:] {
+				g.retry.push(rest.address, uint32(rest.deadline.Sub(now).Seconds()), rest.excluded, now)
+			}
+			return idx, fmt.Errorf("failed to add queued address: %w", err)
+		}
+	}
+	return len(entries), nil
+}
 
+// PendingRetries returns the number of addresses waiting for the next Heal
+func (g *Group) PendingRetries() int {
+	return g.retry.len()
+}
+
+// syncAddresses brings the ipset content to the addresses, permanent entries are kept
+func syncAddresses(addresses map[string]uint32, currentAddresses map[string]*uint32, add func(net.IP, uint32) error, del func(net.IP) error) {
 	for addr, ttl := range addresses {
 		if _, exists := currentAddresses[addr]; exists {
 			if currentAddresses[addr] == nil {
@@ -136,7 +462,7 @@ func (g *Group) Sync(records *records.Records) error {
 			}
 		}
 		ip := net.IP(addr)
-		err = g.AddIP(ip, ttl)
+		err := add(ip, ttl)
 		if err != nil {
 			log.Error().
 				Str("address", ip.String()).
@@ -150,12 +476,15 @@ func (g *Group) Sync(records *records.Records) error {
 		}
 	}
 
-	for addr := range currentAddresses {
+	for addr, timeout := range currentAddresses {
 		if _, ok := addresses[addr]; ok {
 			continue
 		}
+		if timeout != nil && *timeout == 0 {
+			continue
+		}
 		ip := net.IP(addr)
-		err = g.DelIP(ip)
+		err := del(ip)
 		if err != nil {
 			log.Error().
 				Str("address", ip.String()).
@@ -168,37 +497,191 @@ func (g *Group) Sync(records *records.Records) error {
 				Msg("del address")
 		}
 	}
+}
 
-	return nil
+// IPSetNames returns names of the ipsets owned by the group
+func (g *Group) IPSetNames() []string {
+	var names []string
+	for _, set := range []addressSet{g.ipset, g.excludeIPSet} {
+		if mirrored, ok := set.(*mirroredSet); ok {
+			set = mirrored.addressSet
+		}
+		if ipset, ok := set.(*netfilterHelper.IPSet); ok {
+			names = append(names, ipset.SetName)
+		}
+	}
+	return names
+}
+
+// PreferRouting sets the mark and the table used on enable unless they are taken by other rules
+func (g *Group) PreferRouting(mark uint32, table int) {
+	if g.ipsetToLink == nil {
+		return
+	}
+	if g.ipsetToLink.ExistingTable != 0 {
+		table = g.ipsetToLink.ExistingTable
+	}
+	g.ipsetToLink.PreferredMark, g.ipsetToLink.PreferredTable = mark, table
+}
+
+// Routing returns the firewall mark and the routing table used by the enabled group,
+// zeros for proxy groups
+func (g *Group) Routing() (mark uint32, table int) {
+	if g.ipsetToLink == nil {
+		return 0, 0
+	}
+	return g.ipsetToLink.Mark(), g.ipsetToLink.Table()
 }
 
 func (g *Group) NetfilterDHook(table string) error {
-	if g.enabled && g.FixProtect && table == "filter" {
-		err := g.iptables.AppendUnique("filter", "_NDM_SL_FORWARD", "-o", g.Interface, "-m", "state", "--state", "NEW", "-j", "_NDM_SL_PROTECT")
+	if g.ipsetToProxy != nil {
+		err := g.ipsetToProxy.NetfilterDHook(table)
+		if err != nil || !g.enabled {
+			return err
+		}
+		return g.insertSnippets(table)
+	}
+	if g.ipsetToLink == nil {
+		return nil
+	}
+
+	if g.enabled {
+		err := g.insertFixProtect(table)
 		if err != nil {
-			return fmt.Errorf("failed to fix protect: %w", err)
+			return err
 		}
 	}
 
-	return g.ipsetToLink.NetfilterDHook(table)
+	err := g.ipsetToLink.NetfilterDHook(table)
+	if err != nil || !g.enabled {
+		return err
+	}
+	return g.insertSnippets(table)
 }
 
 func (g *Group) LinkUpdateHook(event netlink.LinkUpdate) error {
-	return g.ipsetToLink.LinkUpdateHook(event)
+	if g.ipsetToLink == nil {
+		return nil
+	}
+	err := g.ipsetToLink.LinkUpdateHook(event)
+	if err != nil {
+		return err
+	}
+	if g.shaper != nil {
+		return g.shaper.LinkUpdateHook(event)
+	}
+	return nil
+}
+
+// InterfaceHook re-installs routing of the group after the interface came up
+func (g *Group) InterfaceHook(ifaceName string) error {
+	if g.ipsetToLink == nil {
+		return nil
+	}
+	err := g.ipsetToLink.InterfaceHook(ifaceName)
+	if err != nil {
+		return err
+	}
+	if g.shaper != nil {
+		return g.shaper.InterfaceHook(ifaceName)
+	}
+	return nil
+}
+
+// RepairRouting re-installs the ip rule and the route of the group removed by other daemons,
+// it reports whether anything was re-installed
+func (g *Group) RepairRouting() (bool, error) {
+	if g.ipsetToLink == nil {
+		return false, nil
+	}
+	rule, route, err := g.ipsetToLink.Repair()
+	if rule || route {
+		log.Warn().Str("group", g.ID.String()).Bool("rule", rule).Bool("route", route).Msg("routing was removed externally, re-installed")
+	}
+	return rule || route, err
+}
+
+// NewMemoryGroup creates the group keeping ipset contents in memory without any netfilter rules,
+// it backs shadow groups and runs the pipeline in tests without root
+func NewMemoryGroup(group models.Group) *Group {
+	grp := &Group{
+		Group: group,
+		ipset: newMemorySet(),
+		promotion: prefixPromotion{
+			candidates: make(map[string]*prefixCandidate),
+			promoted:   make(map[string]time.Time),
+		},
+	}
+	if group.HasExclusions() {
+		grp.excludeIPSet = newMemorySet()
+	}
+	return grp
 }
 
 func NewGroup(group models.Group, nh4 *netfilterHelper.NetfilterHelper, chainPrefix, ipsetNamePrefix string) (*Group, error) {
+	if group.Shadow {
+		return NewMemoryGroup(group), nil
+	}
+
 	ipsetName := fmt.Sprintf("%s%8x", ipsetNamePrefix, group.ID)
+	var ipset addressSet
 	ipset, err := nh4.IPSet(ipsetName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize ipset: %w", err)
 	}
+	if len(group.Mirrors) != 0 {
+		mirrored := &mirroredSet{addressSet: ipset}
+		for _, name := range group.Mirrors {
+			mirror, err := nh4.SharedIPSet(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize mirror ipset: %w", err)
+			}
+			mirrored.mirrors = append(mirrored.mirrors, mirror)
+		}
+		ipset = mirrored
+	}
+
+	var excludeIPSet addressSet
+	var excludeIPSetName string
+	if group.HasExclusions() {
+		excludeIPSetName = ipsetName + "_ex"
+		excludeIPSet, err = nh4.IPSet(excludeIPSetName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize exclusion ipset: %w", err)
+		}
+	}
 
-	ipsetToLink := nh4.IPSetToLink(fmt.Sprintf("%s%8x", chainPrefix, group.ID), group.Interface, ipsetName)
+	var ipsetToLink *netfilterHelper.IPSetToLink
+	var ipsetToProxy *netfilterHelper.IPSetToProxy
+	if group.Proxy.IsEnabled() {
+		ipsetToProxy = nh4.IPSetToProxy(fmt.Sprintf("%s%8x", chainPrefix, group.ID), ipsetName, group.Proxy.Port)
+		ipsetToProxy.ExcludeIPSetName = excludeIPSetName
+	} else {
+		ipsetToLink = nh4.IPSetToLink(fmt.Sprintf("%s%8x", chainPrefix, group.ID), group.Interface, ipsetName)
+		ipsetToLink.ExcludeIPSetName = excludeIPSetName
+		ipsetToLink.Gateway = net.ParseIP(group.Gateway)
+		ipsetToLink.ExistingTable = group.Table
+	}
+
+	var shaper *netfilterHelper.Shaper
+	if group.RateLimit != "" && ipsetToLink != nil && group.Interface != "" {
+		rate, err := models.ParseRate(group.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		shaper = nh4.Shaper(group.Interface, 0, rate)
+	}
 	return &Group{
-		Group:       group,
-		iptables:    nh4.IPTables,
-		ipset:       ipset,
-		ipsetToLink: ipsetToLink,
+		Group:        group,
+		iptables:     nh4.IPTables,
+		ipset:        ipset,
+		excludeIPSet: excludeIPSet,
+		ipsetToLink:  ipsetToLink,
+		ipsetToProxy: ipsetToProxy,
+		shaper:       shaper,
+		promotion: prefixPromotion{
+			candidates: make(map[string]*prefixCandidate),
+			promoted:   make(map[string]time.Time),
+		},
 	}, nil
 }
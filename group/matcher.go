@@ -0,0 +1,124 @@
+package group
+
+import (
+	"math/bits"
+	"strings"
+	"sync/atomic"
+
+	"magitrickle/models"
+)
+
+// MatcherStats are counters of the rule index of the group
+type MatcherStats struct {
+	// Indexed are domain, namespace and "*.suffix" wildcard rules looked up by name
+	Indexed int `json:"indexed"`
+	// Linear are rules evaluated one by one (regex, expression and other wildcards)
+	Linear int `json:"linear"`
+	// Lookups is the number of Match calls, Candidates is the number of rules evaluated by them
+	Lookups    uint64 `json:"lookups"`
+	Candidates uint64 `json:"candidates"`
+}
+
+// matcher indexes enabled rules by the name or the suffix they match, so that a record is
+// checked against the few rules that may match it instead of every rule of the group.
+// Suffixes are looked up label by label: "a.b.example.com" checks "b.example.com", "example.com" and "com".
+type matcher struct {
+	// exact are domain and namespace rules by their name
+	exact map[string][]int
+	// suffix are namespace rules and "*.suffix" wildcard rules by the suffix
+	suffix map[string][]int
+	// linear are rules without a name to be looked up
+	linear  []int
+	indexed int
+	size    int
+
+	lookups   atomic.Uint64
+	evaluated atomic.Uint64
+}
+
+// indexSuffix returns the suffix of "*.suffix" wildcards without other wildcard characters
+func indexSuffix(pattern string) (string, bool) {
+	suffix, ok := strings.CutPrefix(pattern, "*.")
+	if !ok || suffix == "" || strings.ContainsAny(suffix, "*?") {
+		return "", false
+	}
+	return suffix, true
+}
+
+func newMatcher(rules []*models.Rule) *matcher {
+	m := &matcher{
+		exact:  make(map[string][]int),
+		suffix: make(map[string][]int),
+		size:   len(rules),
+	}
+	for idx, rule := range rules {
+		if !rule.IsEnabled() || rule.IsStatic() {
+			continue
+		}
+		switch rule.Type {
+		case "domain":
+			m.exact[rule.Rule] = append(m.exact[rule.Rule], idx)
+		case "namespace":
+			m.exact[rule.Rule] = append(m.exact[rule.Rule], idx)
+			m.suffix[rule.Rule] = append(m.suffix[rule.Rule], idx)
+		case "wildcard":
+			if suffix, ok := indexSuffix(rule.Rule); ok {
+				m.suffix[suffix] = append(m.suffix[suffix], idx)
+				m.indexed++
+				continue
+			}
+			m.linear = append(m.linear, idx)
+			continue
+		default:
+			m.linear = append(m.linear, idx)
+			continue
+		}
+		m.indexed++
+	}
+	return m
+}
+
+// candidates returns a bitmap of rules that may match any of the names
+func (m *matcher) candidates(names []string) []uint64 {
+	set := make([]uint64, (m.size+63)/64)
+	add := func(indexes []int) {
+		for _, idx := range indexes {
+			set[idx/64] |= 1 << (idx % 64)
+		}
+	}
+	add(m.linear)
+	for _, name := range names {
+		add(m.exact[name])
+		for rest := name; ; {
+			dot := strings.IndexByte(rest, '.')
+			if dot == -1 {
+				break
+			}
+			rest = rest[dot+1:]
+			add(m.suffix[rest])
+		}
+	}
+	return set
+}
+
+func (m *matcher) stats() MatcherStats {
+	return MatcherStats{
+		Indexed:    m.indexed,
+		Linear:     len(m.linear),
+		Lookups:    m.lookups.Load(),
+		Candidates: m.evaluated.Load(),
+	}
+}
+
+// forEach calls fn for rules of the bitmap in the order of rules until fn returns false
+func forEach(set []uint64, fn func(idx int) bool) {
+	for word, bitmap := range set {
+		for bitmap != 0 {
+			bit := bits.TrailingZeros64(bitmap)
+			if !fn(word*64 + bit) {
+				return
+			}
+			bitmap &= bitmap - 1
+		}
+	}
+}
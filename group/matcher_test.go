@@ -0,0 +1,79 @@
+package group
+
+import (
+	"fmt"
+	"testing"
+
+	"magitrickle/models"
+)
+
+func TestMatcher(t *testing.T) {
+	grp := NewMemoryGroup(models.Group{Rules: []*models.Rule{
+		{ID: models.ID{0, 0, 0, 1}, Type: "domain", Rule: "example.com", Enable: true},
+		{ID: models.ID{0, 0, 0, 2}, Type: "namespace", Rule: "example.org", Enable: true},
+		{ID: models.ID{0, 0, 0, 3}, Type: "wildcard", Rule: "*.example.net", Enable: true},
+		{ID: models.ID{0, 0, 0, 4}, Type: "wildcard", Rule: "cdn*.example.io", Enable: true},
+		{ID: models.ID{0, 0, 0, 5}, Type: "regex", Rule: `^video\.`, Enable: true},
+		{ID: models.ID{0, 0, 0, 6}, Type: "namespace", Rule: "ads.example.org", Action: models.RuleActionExclude, Enable: true},
+		{ID: models.ID{0, 0, 0, 7}, Type: "domain", Rule: "disabled.com"},
+	}})
+
+	for name, expected := range map[string]string{
+		"example.com":         "00000001",
+		"www.example.com":     "",
+		"example.org":         "00000002",
+		"a.b.example.org":     "00000002",
+		"ads.example.org":     "00000006",
+		"x.ads.example.org":   "00000006",
+		"www.example.net":     "00000003",
+		"example.net":         "",
+		"cdn1.example.io":     "00000004",
+		"video.example.local": "00000005",
+		"disabled.com":        "",
+	} {
+		rule, _ := grp.Match([]string{name}, models.MatchContext{})
+		var id string
+		if rule != nil {
+			id = rule.ID.String()
+		}
+		if id != expected {
+			t.Fatalf("Match(%q) = %q, expected %q", name, id, expected)
+		}
+	}
+
+	// Rules are evaluated in order, an alias may match an earlier rule
+	rule, name := grp.Match([]string{"www.example.net", "example.com"}, models.MatchContext{})
+	if rule == nil || rule.ID.String() != "00000001" || name != "example.com" {
+		t.Fatalf("unexpected match: %v %s", rule, name)
+	}
+
+	stats := grp.MatcherStats()
+	if stats.Indexed != 4 || stats.Linear != 2 || stats.Lookups == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	grp.Update(models.Group{Rules: []*models.Rule{
+		{ID: models.ID{0, 0, 0, 1}, Type: "domain", Rule: "example.com"},
+	}})
+	if rule, _ := grp.Match([]string{"example.com"}, models.MatchContext{}); rule != nil {
+		t.Fatal("matcher is not rebuilt on update")
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	rules := make([]*models.Rule, 0, 5000)
+	for idx := 0; idx < 5000; idx++ {
+		rule := &models.Rule{Type: "namespace", Rule: fmt.Sprintf("domain%d.com", idx), Enable: true}
+		if idx%2 == 0 {
+			rule.Type, rule.Rule = "wildcard", "*."+rule.Rule
+		}
+		rules = append(rules, rule)
+	}
+	grp := NewMemoryGroup(models.Group{Rules: rules})
+	names := []string{"www.cdn.domain4999.com", "edge.cdn.example.net"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		grp.Match(names, models.MatchContext{})
+	}
+}
@@ -0,0 +1,65 @@
+package group
+
+import (
+	"net"
+
+	"github.com/rs/zerolog/log"
+)
+
+// mirroredSet copies additions and deletions of the set to secondary ipsets (e.g. used by
+// an external firewall script). Mirrors are not listed, healed or destroyed with the set,
+// their failures don't fail the set.
+type mirroredSet struct {
+	addressSet
+	mirrors []addressSet
+}
+
+func (s *mirroredSet) AddIP(addr net.IP, timeout *uint32) error {
+	err := s.addressSet.AddIP(addr, timeout)
+	if err != nil {
+		return err
+	}
+	for _, mirror := range s.mirrors {
+		err = mirror.AddIP(addr, timeout)
+		if err != nil {
+			log.Debug().Str("address", addr.String()).Err(err).Msg("failed to add address to mirror ipset")
+		}
+	}
+	return nil
+}
+
+func (s *mirroredSet) AddNet(network *net.IPNet, timeout *uint32) error {
+	err := s.addressSet.AddNet(network, timeout)
+	if err != nil {
+		return err
+	}
+	for _, mirror := range s.mirrors {
+		err = mirror.AddNet(network, timeout)
+		if err != nil {
+			log.Debug().Str("network", network.String()).Err(err).Msg("failed to add network to mirror ipset")
+		}
+	}
+	return nil
+}
+
+func (s *mirroredSet) DelIP(addr net.IP) error {
+	err := s.addressSet.DelIP(addr)
+	for _, mirror := range s.mirrors {
+		mirrorErr := mirror.DelIP(addr)
+		if mirrorErr != nil {
+			log.Debug().Str("address", addr.String()).Err(mirrorErr).Msg("failed to delete address from mirror ipset")
+		}
+	}
+	return err
+}
+
+// Ensure re-creates mirrors destroyed externally as well, only the set itself is reported
+func (s *mirroredSet) Ensure() (bool, error) {
+	for _, mirror := range s.mirrors {
+		_, err := mirror.Ensure()
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to check mirror ipset")
+		}
+	}
+	return s.addressSet.Ensure()
+}
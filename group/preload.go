@@ -0,0 +1,85 @@
+package group
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"magitrickle/models"
+)
+
+// LoadPreload fills the ipset from the preload file, so routing works before the first DNS answer.
+// The file has an address or a network per line, "#" starts a comment. A missing file is not an error
+func (g *Group) LoadPreload(ttl uint32) (int, error) {
+	if g.Preload == "" {
+		return 0, nil
+	}
+	file, err := os.Open(g.Preload)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open preload file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		network, err := models.ParseCIDR(line)
+		if err != nil {
+			return count, fmt.Errorf("invalid preload entry: %w", err)
+		}
+		err = g.ipset.AddNet(network, &ttl)
+		if err != nil {
+			return count, fmt.Errorf("failed to preload address: %w", err)
+		}
+		count++
+	}
+	err = scanner.Err()
+	if err != nil {
+		return count, fmt.Errorf("failed to read preload file: %w", err)
+	}
+	return count, nil
+}
+
+// SavePreload writes addresses of the ipset to the preload file for the next start, networks are not listed by ipsets
+func (g *Group) SavePreload() error {
+	if g.Preload == "" {
+		return nil
+	}
+	addresses, err := g.ListIP()
+	if err != nil {
+		return fmt.Errorf("failed to list addresses: %w", err)
+	}
+	lines := make([]string, 0, len(addresses))
+	for address := range addresses {
+		lines = append(lines, net.IP(address).String())
+	}
+	sort.Strings(lines)
+
+	err = os.MkdirAll(filepath.Dir(g.Preload), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create preload directory: %w", err)
+	}
+	tmpPath := g.Preload + ".tmp"
+	err = os.WriteFile(tmpPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write preload file: %w", err)
+	}
+	return os.Rename(tmpPath, g.Preload)
+}
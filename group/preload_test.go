@@ -0,0 +1,50 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"magitrickle/models"
+)
+
+func TestPreload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preload.list")
+	err := os.WriteFile(path, []byte("# previous run\n1.1.1.1\n10.0.0.0/8 # static\n\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grp := NewMemoryGroup(models.Group{Preload: path})
+	count, err := grp.LoadPreload(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("unexpected count: %d", count)
+	}
+
+	err = grp.AddIP([]byte{8, 8, 8, 8}, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = grp.SavePreload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewMemoryGroup(models.Group{Preload: path})
+	count, err = reloaded.LoadPreload(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10.0.0.0/8 is not an address, it is not saved
+	if count != 2 {
+		t.Fatalf("unexpected count after save: %d", count)
+	}
+
+	missing := NewMemoryGroup(models.Group{Preload: filepath.Join(t.TempDir(), "missing")})
+	if _, err := missing.LoadPreload(300); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package group
+
+import (
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultPromotionWindow     = 60
+	defaultPromotionIPv4Prefix = 24
+	defaultPromotionIPv6Prefix = 48
+	maxPromotionCandidates     = 1024
+)
+
+type prefixCandidate struct {
+	addresses map[string]time.Time
+}
+
+type prefixPromotion struct {
+	candidates map[string]*prefixCandidate
+	promoted   map[string]time.Time
+}
+
+func (g *Group) promotionPrefix(address net.IP) *net.IPNet {
+	if ip4 := address.To4(); ip4 != nil {
+		bits := g.PrefixPromotion.IPv4Prefix
+		if bits <= 0 || bits > 32 {
+			bits = defaultPromotionIPv4Prefix
+		}
+		mask := net.CIDRMask(bits, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	bits := g.PrefixPromotion.IPv6Prefix
+	if bits <= 0 || bits > 128 {
+		bits = defaultPromotionIPv6Prefix
+	}
+	mask := net.CIDRMask(bits, 128)
+	return &net.IPNet{IP: address.Mask(mask), Mask: mask}
+}
+
+// isPromoted reports whether the address is already covered by a promoted prefix
+func (g *Group) isPromoted(address net.IP, now time.Time) bool {
+	prefix := g.promotionPrefix(address).String()
+	deadline, ok := g.promotion.promoted[prefix]
+	if !ok {
+		return false
+	}
+	if now.After(deadline) {
+		delete(g.promotion.promoted, prefix)
+		return false
+	}
+	return true
+}
+
+// extendPromoted refreshes the TTL of the promoted prefix covering the address
+func (g *Group) extendPromoted(address net.IP, ttl uint32, now time.Time) error {
+	prefix := g.promotionPrefix(address)
+	deadline := now.Add(time.Duration(ttl) * time.Second)
+	if !deadline.After(g.promotion.promoted[prefix.String()]) {
+		return nil
+	}
+	err := g.ipset.AddNet(prefix, &ttl)
+	if err != nil {
+		return err
+	}
+	g.promotion.promoted[prefix.String()] = deadline
+	return nil
+}
+
+func (g *Group) cleanupCandidates(now time.Time, window time.Duration) {
+	for prefix, candidate := range g.promotion.candidates {
+		for addr, seen := range candidate.addresses {
+			if now.Sub(seen) > window {
+				delete(candidate.addresses, addr)
+			}
+		}
+		if len(candidate.addresses) == 0 {
+			delete(g.promotion.candidates, prefix)
+		}
+	}
+}
+
+// promote tracks the address and replaces its neighbours with the covering
+// prefix when the configured threshold is exceeded
+func (g *Group) promote(address net.IP, ttl uint32, now time.Time) {
+	window := time.Duration(g.PrefixPromotion.Window) * time.Second
+	if window == 0 {
+		window = defaultPromotionWindow * time.Second
+	}
+
+	prefix := g.promotionPrefix(address)
+	prefixStr := prefix.String()
+
+	if len(g.promotion.candidates) > maxPromotionCandidates {
+		g.cleanupCandidates(now, window)
+	}
+
+	candidate, ok := g.promotion.candidates[prefixStr]
+	if !ok {
+		candidate = &prefixCandidate{addresses: make(map[string]time.Time)}
+		g.promotion.candidates[prefixStr] = candidate
+	}
+	for addr, seen := range candidate.addresses {
+		if now.Sub(seen) > window {
+			delete(candidate.addresses, addr)
+		}
+	}
+	candidate.addresses[address.String()] = now
+
+	if len(candidate.addresses) <= g.PrefixPromotion.Threshold {
+		return
+	}
+
+	err := g.ipset.AddNet(prefix, &ttl)
+	if err != nil {
+		log.Error().
+			Str("group", g.ID.String()).
+			Str("prefix", prefixStr).
+			Err(err).
+			Msg("failed to promote prefix")
+		return
+	}
+	for addr := range candidate.addresses {
+		err = g.ipset.DelIP(net.ParseIP(addr))
+		if err != nil {
+			log.Trace().
+				Str("group", g.ID.String()).
+				Str("address", addr).
+				Err(err).
+				Msg("failed to delete promoted address")
+		}
+	}
+	delete(g.promotion.candidates, prefixStr)
+	g.promotion.promoted[prefixStr] = now.Add(time.Duration(ttl) * time.Second)
+
+	log.Debug().
+		Str("group", g.ID.String()).
+		Str("prefix", prefixStr).
+		Msg("promoted prefix")
+}
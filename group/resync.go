@@ -0,0 +1,96 @@
+package group
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"magitrickle/netfilter-helper"
+	"magitrickle/records"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Resync rebuilds ipsets of the group from static rules, promoted prefixes and known addresses.
+// Ipsets are filled aside and swapped in at once, so traffic doesn't escape the group while a large
+// set is rebuilt. Mirrors are not rebuilt, in-memory sets of shadow groups are refilled in place.
+func (g *Group) Resync(records *records.Records) error {
+	err := rebuild(g.ipset, func(set addressSet) error {
+		err := g.addStaticTo(set, nil)
+		if err != nil {
+			return err
+		}
+		g.addPromotedTo(set)
+		if g.RoutesIPv4() {
+			addAddresses(set, MatchedAddresses(g.routeRules(), records))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resync ipset: %w", err)
+	}
+
+	if g.excludeIPSet != nil {
+		err = rebuild(g.excludeIPSet, func(set addressSet) error {
+			err := g.addStaticTo(nil, set)
+			if err != nil {
+				return err
+			}
+			addAddresses(set, MatchedAddresses(g.excludeRules(), records))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to resync exclusion ipset: %w", err)
+		}
+	}
+
+	// Addresses added to the old sets while the new ones were filled are restored from the records
+	return g.Sync(records)
+}
+
+// rebuild refills the set, ipsets are filled aside and swapped in
+func rebuild(set addressSet, fill func(set addressSet) error) error {
+	if mirrored, ok := set.(*mirroredSet); ok {
+		set = mirrored.addressSet
+	}
+	if ipset, ok := set.(*netfilterHelper.IPSet); ok {
+		return ipset.Rebuild(func(temp *netfilterHelper.IPSet) error {
+			return fill(temp)
+		})
+	}
+	err := set.Destroy()
+	if err != nil {
+		return err
+	}
+	return fill(set)
+}
+
+// addPromotedTo adds prefixes promoted by the group which haven't expired yet
+func (g *Group) addPromotedTo(set addressSet) {
+	now := time.Now()
+	g.promotionMux.Lock()
+	defer g.promotionMux.Unlock()
+	for prefix, deadline := range g.promotion.promoted {
+		if !deadline.After(now) {
+			continue
+		}
+		_, network, err := net.ParseCIDR(prefix)
+		if err != nil {
+			continue
+		}
+		ttl := uint32(deadline.Sub(now).Seconds())
+		err = set.AddNet(network, &ttl)
+		if err != nil {
+			log.Error().Str("group", g.ID.String()).Str("prefix", prefix).Err(err).Msg("failed to add promoted prefix")
+		}
+	}
+}
+
+func addAddresses(set addressSet, addresses map[string]uint32) {
+	for addr, ttl := range addresses {
+		err := set.AddIP(net.IP(addr), &ttl)
+		if err != nil {
+			log.Error().Str("address", net.IP(addr).String()).Err(err).Msg("failed to add address")
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package group
+
+import (
+	"net"
+	"testing"
+
+	"magitrickle/models"
+	"magitrickle/records"
+)
+
+func TestResync(t *testing.T) {
+	store := records.New()
+	grp := NewMemoryGroup(models.Group{
+		Rules: []*models.Rule{
+			{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true},
+			{ID: models.ID{2}, Type: "subnet", Rule: "198.51.100.0/24", Enable: true},
+		},
+	})
+
+	known, stale := net.IPv4(192, 0, 2, 1).To4(), net.IPv4(192, 0, 2, 2).To4()
+	store.AddARecord("example.com", known, 60)
+	_ = grp.AddIP(stale, 60)
+
+	err := grp.Resync(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, _ := grp.ListIP()
+	if _, ok := addresses[string(known)]; !ok {
+		t.Fatalf("known address is not restored: %v", addresses)
+	}
+	if timeout := addresses[string(known)]; timeout == nil || *timeout > 60 {
+		t.Fatal("timeout of the known address exceeds the TTL of its record")
+	}
+	if _, ok := addresses[string(stale)]; ok {
+		t.Fatal("address without a record is kept")
+	}
+}
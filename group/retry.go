@@ -0,0 +1,75 @@
+package group
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// retryQueueLimit bounds the queue, addresses are dropped when it's full
+const retryQueueLimit = 4096
+
+type retryKey struct {
+	address  string
+	excluded bool
+}
+
+// retryQueue keeps addresses that failed to be added to ipsets with their deadlines
+// until the next successful self-heal
+type retryQueue struct {
+	mux     sync.Mutex
+	entries map[retryKey]time.Time
+}
+
+type retryEntry struct {
+	address  net.IP
+	excluded bool
+	deadline time.Time
+}
+
+// push queues the address, it reports whether the address is queued
+func (q *retryQueue) push(address net.IP, ttl uint32, excluded bool, now time.Time) bool {
+	if ip4 := address.To4(); ip4 != nil {
+		address = ip4
+	}
+	key := retryKey{address: string(address), excluded: excluded}
+	deadline := now.Add(time.Duration(ttl) * time.Second)
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.entries == nil {
+		q.entries = make(map[retryKey]time.Time)
+	}
+	if current, ok := q.entries[key]; ok {
+		if deadline.After(current) {
+			q.entries[key] = deadline
+		}
+		return true
+	}
+	if len(q.entries) >= retryQueueLimit {
+		return false
+	}
+	q.entries[key] = deadline
+	return true
+}
+
+// take empties the queue and returns entries that are not expired yet
+func (q *retryQueue) take(now time.Time) []retryEntry {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	entries := make([]retryEntry, 0, len(q.entries))
+	for key, deadline := range q.entries {
+		if !deadline.After(now) {
+			continue
+		}
+		entries = append(entries, retryEntry{address: net.IP(key.address), excluded: key.excluded, deadline: deadline})
+	}
+	q.entries = nil
+	return entries
+}
+
+func (q *retryQueue) len() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return len(q.entries)
+}
@@ -0,0 +1,42 @@
+package group
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryQueue(t *testing.T) {
+	var q retryQueue
+	now := time.Now()
+	q.push(net.ParseIP("10.0.0.1"), 60, false, now)
+	q.push(net.IPv4(10, 0, 0, 1), 120, false, now)
+	q.push(net.ParseIP("10.0.0.1"), 30, true, now)
+	q.push(net.ParseIP("10.0.0.2"), 5, false, now)
+	if q.len() != 3 {
+		t.Fatalf("expected 3 queued addresses, got %d", q.len())
+	}
+
+	entries := q.take(now.Add(10 * time.Second))
+	if len(entries) != 2 {
+		t.Fatalf("expired address is not dropped: %+v", entries)
+	}
+	for _, entry := range entries {
+		if !entry.address.Equal(net.ParseIP("10.0.0.1")) {
+			t.Fatalf("unexpected address: %s", entry.address)
+		}
+		if !entry.excluded && !entry.deadline.Equal(now.Add(120*time.Second)) {
+			t.Fatalf("latest deadline is not kept: %s", entry.deadline)
+		}
+	}
+	if q.len() != 0 {
+		t.Fatal("queue is not emptied")
+	}
+
+	for idx := 0; idx < retryQueueLimit; idx++ {
+		q.push(net.IPv4(10, 1, byte(idx>>8), byte(idx)), 60, false, now)
+	}
+	if q.push(net.ParseIP("10.2.0.1"), 60, false, now) {
+		t.Fatal("address is queued over the limit")
+	}
+}
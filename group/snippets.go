@@ -0,0 +1,58 @@
+package group
+
+import (
+	"fmt"
+	"strconv"
+
+	"magitrickle/models"
+)
+
+// snippetValues returns values of placeholders of iptables snippets, the mark is known once routing is enabled
+func (g *Group) snippetValues() map[string]string {
+	values := map[string]string{models.SnippetInterface: g.Interface}
+	if g.ipsetToProxy != nil {
+		values[models.SnippetIPSet] = g.ipsetToProxy.IPSetName
+		values[models.SnippetExcludeIPSet] = g.ipsetToProxy.ExcludeIPSetName
+		values[models.SnippetChain] = g.ipsetToProxy.ChainName
+	} else if g.ipsetToLink != nil {
+		values[models.SnippetIPSet] = g.ipsetToLink.IPSetName
+		values[models.SnippetExcludeIPSet] = g.ipsetToLink.ExcludeIPSetName
+		values[models.SnippetChain] = g.ipsetToLink.ChainName
+		values[models.SnippetMark] = strconv.Itoa(int(g.ipsetToLink.Mark()))
+		values[models.SnippetRouteTable] = strconv.Itoa(g.ipsetToLink.Table())
+	}
+	return values
+}
+
+// insertSnippets appends iptables snippets of the table ("" - all tables) unless they exist
+func (g *Group) insertSnippets(table string) error {
+	if g.iptables == nil {
+		return nil
+	}
+	values := g.snippetValues()
+	for idx, snippet := range g.IPTables {
+		if table != "" && snippet.Table != table {
+			continue
+		}
+		err := g.iptables.AppendUnique(snippet.Table, snippet.Chain, snippet.Args(values)...)
+		if err != nil {
+			return fmt.Errorf("failed to append iptables snippet %d: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+func (g *Group) deleteSnippets() []error {
+	if g.iptables == nil {
+		return nil
+	}
+	var errs []error
+	values := g.snippetValues()
+	for idx, snippet := range g.IPTables {
+		err := g.iptables.DeleteIfExists(snippet.Table, snippet.Chain, snippet.Args(values)...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete iptables snippet %d: %w", idx, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,205 @@
+package magitrickle
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"magitrickle/group"
+	"magitrickle/mark-allocator"
+	"magitrickle/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RemoveGroup disables the group and destroys its ipsets, the mark and the table are released
+func (a *App) RemoveGroup(id models.ID) error {
+	if !a.isRunning() {
+		for idx, grp := range a.unprocessedGroups {
+			if grp.ID == id {
+				a.unprocessedGroups = append(a.unprocessedGroups[:idx], a.unprocessedGroups[idx+1:]...)
+				return nil
+			}
+		}
+		return ErrGroupNotFound
+	}
+
+	for idx, grp := range a.groups {
+		if grp.ID != id {
+			continue
+		}
+		a.groups = append(a.groups[:idx], a.groups[idx+1:]...)
+		log.Debug().Str("id", grp.ID.String()).Str("name", grp.Name).Msg("removed group")
+		return errors.Join(grp.Destroy()...)
+	}
+	return ErrGroupNotFound
+}
+
+// netfilterSettings returns the part of the group defining its netfilter plumbing,
+// dynamic (domain) rules and names are applied without re-creating the group
+func netfilterSettings(grp models.Group) models.Group {
+	grp.Name, grp.Slug, grp.Metadata = "", "", models.Metadata{}
+	rules := make([]*models.Rule, 0)
+	for _, rule := range grp.Rules {
+		if rule.IsStatic() {
+			static := *rule
+			static.Metadata = models.Metadata{}
+			rules = append(rules, &static)
+		}
+	}
+	grp.Rules = rules
+	return grp
+}
+
+// checkGroupConflicts checks the updated group against the other groups and its own rules
+func (a *App) checkGroupConflicts(groupModel models.Group, groups []models.Group) error {
+	dup := make(map[models.ID]struct{})
+	for _, rule := range groupModel.Rules {
+		if _, exists := dup[rule.ID]; exists {
+			return ErrRuleIDConflict
+		}
+		dup[rule.ID] = struct{}{}
+	}
+	for _, grp := range groups {
+		if grp.ID == groupModel.ID {
+			continue
+		}
+		if groupModel.Slug != "" && groupModel.Slug == grp.Slug {
+			return ErrGroupSlugConflict
+		}
+		if groupModel.Proxy.IsEnabled() && grp.Proxy.IsEnabled() && groupModel.Proxy.Port == grp.Proxy.Port {
+			return ErrProxyPortConflict
+		}
+	}
+	return nil
+}
+
+// newGroup creates the group on netfilter, or in memory for the harness
+func (a *App) newGroup(groupModel models.Group) (*group.Group, error) {
+	var grp *group.Group
+	if a.memoryBackend {
+		grp = group.NewMemoryGroup(groupModel)
+	} else {
+		var err error
+		grp, err = group.NewGroup(groupModel, a.nfHelper4, a.config.Netfilter.IPTables.ChainPrefix, a.config.Netfilter.IPSet.TablePrefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+	grp.SetFixProtect(a.config.Netfilter.FixProtect)
+	grp.Subscribe(a.records)
+	return grp, nil
+}
+
+// createGroup creates, enables and fills the group of the running app
+func (a *App) createGroup(groupModel models.Group) (*group.Group, error) {
+	grp, err := a.newGroup(groupModel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create group: %w", err)
+	}
+	a.assignRouting(grp)
+	err = a.enableGroup(grp)
+	if err != nil {
+		_ = grp.Destroy()
+		return nil, fmt.Errorf("failed to enable group: %w", err)
+	}
+	err = grp.Sync(a.records)
+	if err != nil {
+		_ = grp.Destroy()
+		return nil, err
+	}
+	return grp, nil
+}
+
+// UpdateGroup replaces the group with the same ID. Changes of domain rules are applied in place,
+// other changes re-create the group (ipsets, iptables, mark and table), the old group is restored on failure
+func (a *App) UpdateGroup(groupModel models.Group) error {
+	err := groupModel.Validate()
+	if err != nil {
+		return err
+	}
+
+	if !a.isRunning() {
+		for idx, grp := range a.unprocessedGroups {
+			if grp.ID != groupModel.ID {
+				continue
+			}
+			err = a.checkGroupConflicts(groupModel, a.unprocessedGroups)
+			if err != nil {
+				return err
+			}
+			a.unprocessedGroups[idx] = groupModel
+			return nil
+		}
+		return ErrGroupNotFound
+	}
+
+	current := make([]models.Group, len(a.groups))
+	idx := -1
+	for i, grp := range a.groups {
+		current[i] = grp.Group
+		if grp.ID == groupModel.ID {
+			idx = i
+		}
+	}
+	if idx == -1 {
+		return ErrGroupNotFound
+	}
+	err = a.checkGroupConflicts(groupModel, current)
+	if err != nil {
+		return err
+	}
+
+	old := a.groups[idx]
+	if reflect.DeepEqual(netfilterSettings(old.Group), netfilterSettings(groupModel)) {
+		old.Update(groupModel)
+		log.Debug().Str("id", old.ID.String()).Msg("updated group rules")
+		return old.Sync(a.records)
+	}
+
+	for _, err := range old.Destroy() {
+		log.Warn().Str("id", old.ID.String()).Err(err).Msg("failed to destroy group")
+	}
+	grp, err := a.createGroup(groupModel)
+	if err != nil {
+		restored, restoreErr := a.createGroup(old.Group)
+		if restoreErr != nil {
+			a.groups = append(a.groups[:idx], a.groups[idx+1:]...)
+			return errors.Join(err, fmt.Errorf("failed to restore group: %w", restoreErr))
+		}
+		a.groups[idx] = restored
+		return err
+	}
+	a.groups[idx] = grp
+	log.Debug().Str("id", grp.ID.String()).Msg("re-created group")
+	return nil
+}
+
+// assignRouting makes the group prefer the mark and the table derived from its ID
+func (a *App) assignRouting(grp *group.Group) {
+	if a.memoryBackend {
+		return
+	}
+	if a.marks == nil {
+		marks, err := markAllocator.Load(a.config.Netfilter.MarksPath)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to load marks, they are not persisted")
+			marks, _ = markAllocator.Load("")
+		}
+		if a.handoff != nil {
+			err = marks.Restore(a.handoff.Marks)
+			if err != nil {
+				log.Warn().Err(err).Msg("failed to restore marks of the previous daemon")
+			}
+		}
+		a.marks = marks
+	}
+	assignment, err := a.marks.Assign(grp.ID)
+	if err != nil {
+		log.Warn().Str("id", grp.ID.String()).Err(err).Msg("failed to assign mark")
+		if assignment.Mark == 0 {
+			return
+		}
+	}
+	grp.PreferRouting(assignment.Mark, assignment.Table)
+}
@@ -0,0 +1,130 @@
+package magitrickle
+
+import (
+	"math"
+	"net"
+	"time"
+
+	"magitrickle/mark-allocator"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Handoff is the live state passed to the upgraded daemon, so it answers from known records and
+// routes known addresses right away instead of waiting for clients to resolve them again
+type Handoff struct {
+	Records []HandoffRecord `json:"records"`
+	// Addresses of ipsets (in-memory sets of shadow groups) by the group ID
+	Addresses map[string][]HandoffAddress `json:"addresses"`
+	// Marks are assignments of marks and tables, they are persisted anyway unless marksPath is empty
+	Marks map[string]markAllocator.Assignment `json:"marks,omitempty"`
+}
+
+type HandoffRecord struct {
+	Domain    string           `json:"domain"`
+	Alias     string           `json:"alias,omitempty"`
+	TTL       uint32           `json:"ttl,omitempty"`
+	Addresses []HandoffAddress `json:"addresses,omitempty"`
+}
+
+// HandoffAddress is an address with its remaining TTL
+type HandoffAddress struct {
+	Address string `json:"address"`
+	TTL     uint32 `json:"ttl"`
+}
+
+// remainingTTL returns seconds until the deadline rounded up, zero if it has passed
+func remainingTTL(deadline, now time.Time) uint32 {
+	remaining := deadline.Sub(now)
+	if remaining <= 0 {
+		return 0
+	}
+	return uint32(math.Min(math.Ceil(remaining.Seconds()), math.MaxUint32))
+}
+
+// ExportHandoff returns the live state of the running app for the upgraded daemon
+func (a *App) ExportHandoff() Handoff {
+	now := time.Now()
+	handoff := Handoff{Records: []HandoffRecord{}, Addresses: make(map[string][]HandoffAddress)}
+	if a.records != nil {
+		for _, entry := range a.records.List() {
+			record := HandoffRecord{Domain: entry.Domain}
+			if entry.Alias != "" {
+				record.Alias = entry.Alias
+				record.TTL = remainingTTL(entry.Deadline, now)
+			}
+			for _, aRecord := range entry.Addresses {
+				if ttl := remainingTTL(aRecord.Deadline, now); ttl != 0 {
+					record.Addresses = append(record.Addresses, HandoffAddress{Address: aRecord.Address.String(), TTL: ttl})
+				}
+			}
+			if record.TTL != 0 || len(record.Addresses) != 0 {
+				handoff.Records = append(handoff.Records, record)
+			}
+		}
+	}
+	for _, grp := range a.groups {
+		addresses, err := grp.ListIP()
+		if err != nil {
+			log.Warn().Str("group", grp.ID.String()).Err(err).Msg("failed to list addresses for handoff")
+			continue
+		}
+		list := make([]HandoffAddress, 0, len(addresses))
+		for addr, ttl := range addresses {
+			// Permanent entries are static subnets and exclusions, they are added by the group itself
+			if ttl == nil || *ttl == 0 {
+				continue
+			}
+			list = append(list, HandoffAddress{Address: net.IP(addr).String(), TTL: *ttl})
+		}
+		handoff.Addresses[grp.ID.String()] = list
+	}
+	if a.marks != nil {
+		handoff.Marks = a.marks.Assignments()
+	}
+	return handoff
+}
+
+// ImportHandoff keeps the state of the previous daemon for the next start: marks are restored before
+// groups are added, records before the DNS proxy serves and addresses once groups are enabled
+func (a *App) ImportHandoff(handoff Handoff) {
+	a.handoff = &handoff
+}
+
+// restoreHandoff restores the imported state into records and ipsets of groups, the state is used once
+func (a *App) restoreHandoff() {
+	handoff := a.handoff
+	if handoff == nil {
+		return
+	}
+	a.handoff = nil
+
+	for _, record := range handoff.Records {
+		if record.Alias != "" && record.TTL != 0 {
+			a.records.AddCNameRecord(record.Domain, record.Alias, record.TTL)
+		}
+		for _, address := range record.Addresses {
+			ip := net.ParseIP(address.Address).To4()
+			if ip != nil && address.TTL != 0 {
+				a.records.AddARecord(record.Domain, ip, address.TTL)
+			}
+		}
+	}
+
+	count := 0
+	for _, grp := range a.groups {
+		for _, address := range handoff.Addresses[grp.ID.String()] {
+			ip := net.ParseIP(address.Address)
+			if ip == nil {
+				continue
+			}
+			err := grp.AddIP(ip, address.TTL)
+			if err != nil {
+				log.Warn().Str("group", grp.ID.String()).Str("address", address.Address).Err(err).Msg("failed to restore address")
+				continue
+			}
+			count++
+		}
+	}
+	log.Info().Int("records", len(handoff.Records)).Int("addresses", count).Msg("restored state of the previous daemon")
+}
@@ -0,0 +1,48 @@
+package magitrickle
+
+import (
+	"context"
+	"net"
+)
+
+// ServeHarness runs the DNS proxy and the record to rule to ipset pipeline without root:
+// groups keep addresses in memory (no ipset, iptables or routes), the proxy serves the given
+// connection and reaches upstreams by dial (see dnsMitmProxy.MemoryUpstream, nil is net.Dial).
+// It blocks until the context is done, addresses are available by GroupAddresses.
+func (a *App) ServeHarness(ctx context.Context, conn net.PacketConn, dial func(network, address string) (net.Conn, error)) error {
+	ctx, err := a.begin(ctx)
+	if err != nil {
+		return err
+	}
+	a.memoryBackend = true
+	defer func() {
+		a.memoryBackend = false
+		a.end()
+	}()
+
+	a.dnsMITM = a.newDNSProxy()
+	a.dnsMITM.Dial = dial
+	a.initPipeline()
+
+	groupsAdded := false
+	defer func() {
+		for _, group := range a.groups {
+			_ = group.Destroy()
+		}
+		if groupsAdded {
+			a.unprocessedGroups = a.ExportConfig().Groups
+		}
+		a.groups = nil
+	}()
+	for _, group := range a.unprocessedGroups {
+		err := a.AddGroup(group)
+		if err != nil {
+			return err
+		}
+	}
+	groupsAdded = true
+	a.restoreHandoff()
+
+	a.started()
+	return a.dnsMITM.ServeUDP(ctx, conn)
+}
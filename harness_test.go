@@ -0,0 +1,1025 @@
+package magitrickle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"magitrickle/dns-mitm-proxy"
+	"magitrickle/models"
+	"magitrickle/subscription"
+	"magitrickle/summary"
+
+	"github.com/miekg/dns"
+)
+
+var harnessZone = map[string][]string{
+	"example.com.":           {"example.com. 60 IN A 10.0.0.1"},
+	"www.example.com.":       {"www.example.com. 60 IN CNAME cdn.example.net.", "cdn.example.net. 60 IN A 10.0.0.2"},
+	"direct.example.com.":    {"direct.example.com. 60 IN A 10.0.0.3"},
+	"other.org.":             {"other.org. 60 IN A 10.0.0.4"},
+	"dual.example.org.":      {"dual.example.org. 60 IN A 10.0.0.6", "dual.example.org. 60 IN AAAA fd00::6"},
+	"_sip._tcp.example.com.": {"_sip._tcp.example.com. 60 IN SRV 10 0 5060 sip.example.net."},
+}
+
+// harnessExtra are records of the additional section
+var harnessExtra = map[string][]string{
+	"_sip._tcp.example.com.": {"sip.example.net. 60 IN A 10.0.0.5"},
+}
+
+func harnessUpstream(req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	for _, record := range harnessZone[req.Question[0].Name] {
+		rr, _ := dns.NewRR(record)
+		resp.Answer = append(resp.Answer, rr)
+	}
+	for _, record := range harnessExtra[req.Question[0].Name] {
+		rr, _ := dns.NewRR(record)
+		resp.Extra = append(resp.Extra, rr)
+	}
+	return resp
+}
+
+// startHarness serves the app on a local UDP port and returns its address
+func startHarness(t *testing.T, groups []models.Group) (*App, string) {
+	return startHarnessConfig(t, models.Config{ConfigVersion: "0.1.2", Groups: groups})
+}
+
+func startHarnessConfig(t *testing.T, cfg models.Config) (*App, string) {
+	return startHarnessDial(t, cfg, dnsMitmProxy.MemoryUpstream(harnessUpstream))
+}
+
+func startHarnessDial(t *testing.T, cfg models.Config, dial func(network, address string) (net.Conn, error)) (*App, string) {
+	app := New()
+	err := app.ImportConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- app.ServeHarness(ctx, conn, dial) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return app, conn.LocalAddr().String()
+}
+
+func query(t *testing.T, address, name string) {
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	_, err := dns.Exchange(req, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHarnessPipeline(t *testing.T) {
+	app, address := startHarness(t, []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Slug:      "example",
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules: []*models.Rule{
+			{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true},
+			{ID: models.ID{2}, Type: "domain", Rule: "direct.example.com", Action: models.RuleActionExclude, Enable: true},
+			{ID: models.ID{3}, Type: "subnet", Rule: "192.168.100.0/24", Enable: true},
+		},
+	}})
+
+	for _, name := range []string{"example.com.", "www.example.com.", "direct.example.com.", "other.org."} {
+		query(t, address, name)
+	}
+
+	addresses, err := app.GroupAddresses("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"10.0.0.1", "10.0.0.2"} {
+		if !slices.Contains(addresses, expected) {
+			t.Fatalf("%s is not routed: %v", expected, addresses)
+		}
+	}
+	for _, unexpected := range []string{"10.0.0.3", "10.0.0.4"} {
+		if slices.Contains(addresses, unexpected) {
+			t.Fatalf("%s must not be routed: %v", unexpected, addresses)
+		}
+	}
+	// The exclusion is a match, only other.org is dropped
+	if dropped := app.Summary().DroppedRecords; dropped[summary.DropUnmatched] != 1 {
+		t.Fatalf("unexpected dropped records: %v", dropped)
+	}
+}
+
+func TestHarnessUpdateGroup(t *testing.T) {
+	groupModel := models.Group{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "domain", Rule: "example.com", Enable: true}},
+	}
+	app, address := startHarness(t, []models.Group{groupModel})
+
+	query(t, address, "other.org.")
+	groupModel.Rules = append(groupModel.Rules, &models.Rule{ID: models.ID{2}, Type: "domain", Rule: "other.org", Enable: true})
+	err := app.UpdateGroup(groupModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Known records are synced into the updated group
+	addresses, err := app.GroupAddresses(groupModel.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(addresses, "10.0.0.4") {
+		t.Fatalf("known record is not synced: %v", addresses)
+	}
+
+	err = app.RemoveGroup(groupModel.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = app.GroupAddresses(groupModel.ID.String()); err != ErrGroupNotFound {
+		t.Fatalf("removed group is found: %v", err)
+	}
+}
+
+func TestHarnessGroupEnable(t *testing.T) {
+	groupModel := models.Group{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "domain", Rule: "example.com", Enable: true}},
+	}
+	app, address := startHarness(t, []models.Group{groupModel})
+
+	err := app.SetGroupEnabled(groupModel.ID.String(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query(t, address, "example.com.")
+	addresses, err := app.GroupAddresses(groupModel.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 0 || app.GroupEnabled(groupModel.ID) {
+		t.Fatalf("disabled group routes addresses: %v", addresses)
+	}
+
+	// Records resolved while the group was disabled are synced on enable
+	err = app.SetGroupEnabled(groupModel.ID.String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, err = app.GroupAddresses(groupModel.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(addresses, "10.0.0.1") {
+		t.Fatalf("known record is not synced: %v", addresses)
+	}
+}
+
+func TestHarnessLifecycle(t *testing.T) {
+	groupModel := models.Group{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "domain", Rule: "example.com", Enable: true}},
+	}
+	app, _ := startHarness(t, []models.Group{groupModel})
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	err = app.ServeHarness(context.Background(), conn, nil)
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("expected %v on double start, got %v", ErrAlreadyRunning, err)
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = app.Stop(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("expected %v on double stop, got %v", ErrNotRunning, err)
+	}
+	if groups := app.ExportConfig().Groups; len(groups) != 1 || groups[0].ID != groupModel.ID {
+		t.Fatalf("groups are not kept for the next start: %v", groups)
+	}
+
+	// The stopped app starts again with the same groups
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- app.ServeHarness(ctx, conn, dnsMitmProxy.MemoryUpstream(harnessUpstream)) }()
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	query(t, conn.LocalAddr().String(), "example.com.")
+	addresses, err := app.GroupAddresses(groupModel.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(addresses, "10.0.0.1") {
+		t.Fatalf("restarted app doesn't route addresses: %v", addresses)
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHarnessProcessExtra(t *testing.T) {
+	groupModel := models.Group{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.net", Enable: true}},
+	}
+	for _, processExtra := range []bool{false, true} {
+		cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{groupModel}}
+		cfg.App.DNSProxy.ProcessExtra = processExtra
+		app, address := startHarnessConfig(t, cfg)
+
+		req := new(dns.Msg)
+		req.SetQuestion("_sip._tcp.example.com.", dns.TypeSRV)
+		if _, err := dns.Exchange(req, address); err != nil {
+			t.Fatal(err)
+		}
+		addresses, err := app.GroupAddresses(groupModel.ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Contains(addresses, "10.0.0.5") != processExtra {
+			t.Fatalf("processExtra %v: unexpected addresses %v", processExtra, addresses)
+		}
+	}
+}
+
+func TestHarnessSubscriptionHold(t *testing.T) {
+	groupModel := models.Group{
+		ID:            models.ID{1, 2, 3, 4},
+		Name:          "Example",
+		Interface:     "nwg0",
+		Rules:         []*models.Rule{{ID: models.ID{1}, Type: "domain", Rule: "other.org", Enable: true}},
+		Subscriptions: []models.Subscription{{ID: models.ID{9}, URL: "http://lists.example/list.txt"}},
+	}
+	app, address := startHarness(t, []models.Group{groupModel})
+	key := subscriptionKeyOf(groupModel.ID, models.ID{9})
+	subKey := models.ID{9}.String()
+
+	if err := app.updateSubscription(key, []string{"a.example", "b.example", "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	query(t, address, "example.com.")
+	addresses, _ := app.GroupAddresses(groupModel.ID.String())
+	if !slices.Contains(addresses, "10.0.0.1") {
+		t.Fatalf("subscription rules are not applied: %v", addresses)
+	}
+
+	// Two of three rules are removed, the update waits for approval
+	if err := app.updateSubscription(key, []string{"example.com", "c.example"}); err != nil {
+		t.Fatal(err)
+	}
+	held := app.HeldUpdates()
+	if len(held) != 1 || held[0].Removed != 2 || held[0].Added != 1 {
+		t.Fatalf("expected held update, got %+v", held)
+	}
+	grp, _ := app.FindGroup(groupModel.ID.String())
+	if grp.FindRule(subscriptionRuleID(models.ID{9}, "a.example")) == nil {
+		t.Fatal("held update must not change rules")
+	}
+
+	if err := app.RejectSubscription(groupModel.ID.String(), subKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.updateSubscription(key, []string{"example.com", "c.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.HeldUpdates()) != 0 {
+		t.Fatal("rejected update is held again")
+	}
+
+	if err := app.updateSubscription(key, []string{"example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ApproveSubscription(groupModel.ID.String(), subKey); err != nil {
+		t.Fatal(err)
+	}
+	grp, _ = app.FindGroup(groupModel.ID.String())
+	if len(grp.Rules) != 2 || grp.FindRule(subscriptionRuleID(models.ID{9}, "a.example")) != nil {
+		t.Fatalf("unexpected rules after approval: %d", len(grp.Rules))
+	}
+	if err := app.ApproveSubscription(groupModel.ID.String(), subKey); !errors.Is(err, ErrSubscriptionNotHeld) {
+		t.Fatalf("expected ErrSubscriptionNotHeld, got %v", err)
+	}
+}
+
+func subscriptionRuleID(id models.ID, entry string) string {
+	return subscription.Rules(id, []string{entry})[0].ID.String()
+}
+
+func TestHarnessCaptureMatched(t *testing.T) {
+	cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}}}
+	cfg.App.DNSProxy.CaptureMatched = 10
+	app, address := startHarnessConfig(t, cfg)
+
+	query(t, address, "example.com.")
+	query(t, address, "other.org.")
+	if app.capture.Len() != 1 {
+		t.Fatalf("expected only the matched transaction, got %d", app.capture.Len())
+	}
+}
+
+func TestHarnessAddressFamilies(t *testing.T) {
+	disabled, enabled := false, true
+	for _, tc := range []struct {
+		routeIPv4, routeIPv6 *bool
+		routed, aaaa         bool
+	}{
+		{routed: true},
+		{routeIPv6: &enabled, routed: true, aaaa: true},
+		{routeIPv4: &disabled, routeIPv6: &enabled, aaaa: true},
+	} {
+		groupModel := models.Group{
+			ID:        models.ID{1, 2, 3, 4},
+			Name:      "Example",
+			Interface: "nwg0",
+			RouteIPv4: tc.routeIPv4,
+			RouteIPv6: tc.routeIPv6,
+			Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.org", Enable: true}},
+		}
+		cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{groupModel}}
+		cfg.App.DNSProxy.DisableDropAAAA = true
+		app, address := startHarnessConfig(t, cfg)
+
+		req := new(dns.Msg)
+		req.SetQuestion("dual.example.org.", dns.TypeAAAA)
+		resp, err := dns.Exchange(req, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aaaa := slices.ContainsFunc(resp.Answer, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeAAAA })
+		if aaaa != tc.aaaa {
+			t.Fatalf("routeIPv6 %v: unexpected answer %v", groupModel.RoutesIPv6(), resp.Answer)
+		}
+		addresses, err := app.GroupAddresses(groupModel.ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Contains(addresses, "10.0.0.6") != tc.routed {
+			t.Fatalf("routeIPv4 %v: unexpected addresses %v", groupModel.RoutesIPv4(), addresses)
+		}
+	}
+}
+
+func TestHarnessDecisions(t *testing.T) {
+	app, address := startHarness(t, []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}})
+	query(t, address, "example.com.")
+	query(t, address, "other.org.")
+
+	list := app.Decisions()
+	if len(list) != 2 {
+		t.Fatalf("unexpected decisions: %+v", list)
+	}
+	if list[0].Domain != "other.org" || list[0].Matched || len(list[0].Groups) != 1 || list[0].Groups[0].Rule != "" {
+		t.Fatalf("unexpected decision of the unmatched domain: %+v", list[0])
+	}
+	if list[1].Domain != "example.com" || !list[1].Matched || list[1].Groups[0].Rule != (models.ID{1}).String() || list[1].Evaluated == 0 {
+		t.Fatalf("unexpected decision of the matched domain: %+v", list[1])
+	}
+}
+
+func TestHarnessSuggestions(t *testing.T) {
+	cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}}}
+	cfg.App.DNSProxy.LearnUnmatched = true
+	app, address := startHarnessConfig(t, cfg)
+	for _, name := range []string{"example.com.", "example.com.", "other.org.", "other.org."} {
+		query(t, address, name)
+	}
+
+	suggestions := app.Suggestions("127.0.0.1", 2)
+	if len(suggestions) != 1 || suggestions[0].Rule != "other.org" || suggestions[0].Queries != 2 {
+		t.Fatalf("unexpected suggestions: %+v", suggestions)
+	}
+}
+
+func TestHarnessPause(t *testing.T) {
+	groupModel := models.Group{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "domain", Rule: "example.com", Enable: true}},
+	}
+	app, address := startHarness(t, []models.Group{groupModel})
+
+	paused, err := app.TogglePause()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query(t, address, "example.com.")
+	addresses, err := app.GroupAddresses(groupModel.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !paused || !app.Health().Paused || len(addresses) != 0 {
+		t.Fatalf("paused app routes addresses: %v", addresses)
+	}
+
+	paused, err = app.TogglePause()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, err = app.GroupAddresses(groupModel.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused || !app.GroupEnabled(groupModel.ID) || !slices.Contains(addresses, "10.0.0.1") {
+		t.Fatalf("known record is not synced on resume: %v", addresses)
+	}
+}
+
+func TestHarnessDNSRoutes(t *testing.T) {
+	cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Slug:      "vpn",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}}}
+	cfg.App.DNSProxy.Upstreams = []models.UpstreamSet{
+		{Name: "vpn", Servers: []models.DNSProxyServer{{Address: "10.8.0.1"}}},
+		{Name: "isp", Servers: []models.DNSProxyServer{{Address: "192.0.2.53", Port: 5353}}},
+	}
+	cfg.App.DNSProxy.Routes = []models.DNSRoute{
+		{Group: "vpn", Upstream: "vpn"},
+		{Unmatched: true, QTypes: []string{"A"}, Upstream: "isp"},
+	}
+
+	upstream := dnsMitmProxy.MemoryUpstream(harnessUpstream)
+	dialed := make(chan string, 4)
+	_, address := startHarnessDial(t, cfg, func(network, address string) (net.Conn, error) {
+		dialed <- address
+		return upstream(network, address)
+	})
+
+	for _, tc := range []struct{ name, upstream string }{
+		{"www.example.com.", "10.8.0.1:53"},
+		{"other.org.", "192.0.2.53:5353"},
+	} {
+		query(t, address, tc.name)
+		if got := <-dialed; got != tc.upstream {
+			t.Fatalf("%s is resolved by %s, expected %s", tc.name, got, tc.upstream)
+		}
+	}
+
+	cfg.App.DNSProxy.Routes = append(cfg.App.DNSProxy.Routes, models.DNSRoute{Upstream: "missing"})
+	if err := New().ImportConfig(cfg); !errors.Is(err, ErrInvalidDNSRoute) {
+		t.Fatalf("expected invalid route, got %v", err)
+	}
+}
+
+func TestHarnessResolverRule(t *testing.T) {
+	cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{
+		{
+			ID:        models.ID{1},
+			Name:      "Lists",
+			Slug:      "lists",
+			Interface: "nwg0",
+			Rules: []*models.Rule{
+				{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Action: models.RuleActionResolver, Enable: true},
+			},
+		},
+		{ID: models.ID{2}, Name: "Tunnel", Slug: "tunnel", Interface: "nwg1"},
+	}}
+	cfg.App.DNSProxy.Upstreams = []models.UpstreamSet{
+		{Name: "tunnel", Interface: "nwg1", Servers: []models.DNSProxyServer{{Address: "10.8.0.1"}}},
+	}
+	cfg.App.DNSProxy.Routes = []models.DNSRoute{{Group: "lists", Upstream: "tunnel"}}
+	app, address := startHarnessConfig(t, cfg)
+
+	for _, name := range []string{"example.com.", "www.example.com.", "other.org."} {
+		query(t, address, name)
+	}
+
+	addresses, err := app.GroupAddresses("tunnel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"10.0.0.1", "10.0.0.2"} {
+		if !slices.Contains(addresses, expected) {
+			t.Fatalf("%s is not routed via the resolving interface: %v", expected, addresses)
+		}
+	}
+	addresses, err = app.GroupAddresses("lists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 0 {
+		t.Fatalf("the group of the resolver rule must route nothing: %v", addresses)
+	}
+}
+
+func TestHarnessForget(t *testing.T) {
+	app, address := startHarness(t, []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Slug:      "example",
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}})
+	for _, name := range []string{"example.com.", "www.example.com."} {
+		query(t, address, name)
+	}
+
+	domains, _, err := app.ForgetDomain("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domains != 2 {
+		t.Fatalf("unexpected forgotten domains %d", domains)
+	}
+	addresses, err := app.GroupAddresses("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(addresses, "10.0.0.2") || !slices.Contains(addresses, "10.0.0.1") {
+		t.Fatalf("unexpected addresses after forget: %v", addresses)
+	}
+	if _, _, err := app.ForgetDomain("www.example.com"); !errors.Is(err, ErrDomainNotCached) {
+		t.Fatalf("expected not cached domain, got %v", err)
+	}
+
+	_, _, err = app.FlushRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, err = app.GroupAddresses("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 0 {
+		t.Fatalf("unexpected addresses after flush: %v", addresses)
+	}
+}
+
+func TestHarnessLogRule(t *testing.T) {
+	app, address := startHarness(t, []models.Group{{
+		ID:        models.ID{1},
+		Name:      "Candidate",
+		Slug:      "candidate",
+		Interface: "nwg0",
+		Rules: []*models.Rule{
+			{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Action: models.RuleActionLog, Enable: true},
+			{ID: models.ID{2}, Type: "domain", Rule: "example.com", Enable: true},
+			{ID: models.ID{3}, Type: "domain", Rule: "direct.example.com", Enable: true},
+		},
+	}})
+
+	for _, name := range []string{"example.com.", "www.example.com.", "direct.example.com."} {
+		query(t, address, name)
+	}
+
+	addresses, err := app.GroupAddresses("candidate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(addresses)
+	if !slices.Equal(addresses, []string{"10.0.0.1", "10.0.0.3"}) {
+		t.Fatalf("only addresses of routing rules must be routed: %v", addresses)
+	}
+	logged := false
+	for _, recent := range app.Summary().RecentDomains {
+		if recent.Domain == "www.example.com" {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Fatalf("match of the log rule is not recorded: %v", app.Summary().RecentDomains)
+	}
+}
+
+func TestHarnessOwnQueries(t *testing.T) {
+	groups := []models.Group{{
+		ID:        models.ID{1},
+		Name:      "Example",
+		Slug:      "example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "domain", Rule: "example.com", Enable: true}},
+	}}
+	cfg := models.Config{ConfigVersion: "0.1.2", Groups: groups}
+	cfg.App.DNSProxy.OwnQueries.Action = models.OwnQueriesForward
+	app, address := startHarnessConfig(t, cfg)
+
+	// The harness listens on the loopback, so its queries are queries of the router
+	query(t, address, "example.com.")
+	addresses, err := app.GroupAddresses("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 0 {
+		t.Fatalf("forwarded own query must not be processed: %v", addresses)
+	}
+
+	cfg.App.DNSProxy.OwnQueries.Action = models.OwnQueriesRefuse
+	_, address = startHarnessConfig(t, cfg)
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err := dns.Exchange(req, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeRefused {
+		t.Fatalf("own query must be refused: %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	cfg.App.DNSProxy.OwnQueries.Action = "drop"
+	if err := New().ImportConfig(cfg); !errors.Is(err, ErrUnknownOwnQueriesAction) {
+		t.Fatalf("expected unknown action, got %v", err)
+	}
+}
+
+func TestValidateDNSPorts(t *testing.T) {
+	valid := models.DNSProxy{
+		ExtraPorts: []models.DNSPort{{Port: 5353, Network: "udp"}, {Port: 853, Network: "tcp", To: 8853}, {Port: 5353, Network: "tcp"}},
+		Listen:     []models.DNSProxyServer{{Address: "127.0.0.1", Port: 5353}},
+	}
+	if err := validateDNSPorts(valid); err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []models.DNSProxy{
+		{ExtraPorts: []models.DNSPort{{Port: 53}}},
+		{ExtraPorts: []models.DNSPort{{Port: 5353, Network: "sctp"}}},
+		{ExtraPorts: []models.DNSPort{{Port: 5353}, {Port: 5353, Network: "udp"}}},
+		{Listen: []models.DNSProxyServer{{Address: "127.0.0.1"}}},
+	} {
+		if err := validateDNSPorts(invalid); !errors.Is(err, ErrInvalidDNSPort) {
+			t.Errorf("%+v: expected invalid DNS port, got %v", invalid, err)
+		}
+	}
+}
+
+func TestHarnessExportStats(t *testing.T) {
+	app, address := startHarness(t, []models.Group{{
+		ID:        models.ID{1},
+		Name:      "Example",
+		Slug:      "example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{2}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}})
+	query(t, address, "example.com.")
+	query(t, address, "direct.example.com.")
+
+	export, err := app.ExportStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Rules) != 1 || export.Rules[0].Rule != "02000000" || export.Rules[0].Hits != 2 {
+		t.Fatalf("unexpected rule hits: %+v", export.Rules)
+	}
+
+	path := filepath.Join(t.TempDir(), "stats.csv")
+	err = app.ExportStatsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "type,group,rule,domain,count\n") || !strings.Contains(string(data), "rule,01000000,02000000,,2\n") {
+		t.Fatalf("unexpected csv:\n%s", data)
+	}
+	if err := app.ExportStatsFile("stats.json"); !errors.Is(err, ErrInvalidStatsPath) {
+		t.Fatalf("expected invalid path, got %v", err)
+	}
+}
+
+func TestHarnessVerify(t *testing.T) {
+	cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{{
+		ID:        models.ID{1},
+		Name:      "Example",
+		Slug:      "example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}}}
+	cfg.App.DNSProxy.Verify = models.Verify{
+		Upstream: models.DNSProxyServer{Address: "10.8.0.1"},
+		Never:    []string{"direct.example.com"},
+	}
+
+	// The ISP answers filtered domains by 0.0.0.0, the trusted upstream knows real addresses
+	trusted := dnsMitmProxy.MemoryUpstream(harnessUpstream)
+	isp := dnsMitmProxy.MemoryUpstream(func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		rr, _ := dns.NewRR(req.Question[0].Name + " 60 IN A 0.0.0.0")
+		resp.Answer = append(resp.Answer, rr)
+		return resp
+	})
+	app, address := startHarnessDial(t, cfg, func(network, address string) (net.Conn, error) {
+		if address == "10.8.0.1:53" {
+			return trusted(network, address)
+		}
+		return isp(network, address)
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err := dns.Exchange(req, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "0.0.0.0" {
+		t.Fatalf("the client must get the original answer: %v", resp.Answer)
+	}
+	query(t, address, "direct.example.com.")
+
+	addresses, err := app.GroupAddresses("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(addresses)
+	if !slices.Equal(addresses, []string{"0.0.0.0", "10.0.0.1"}) {
+		t.Fatalf("unexpected routed addresses: %v", addresses)
+	}
+	if verification := app.Summary().Verification; verification.Replaced != 1 {
+		t.Fatalf("unexpected verification counters: %+v", verification)
+	}
+
+	cfg.App.DNSProxy.Verify.Bogus = []string{"0.0.0.0/33"}
+	if err := New().ImportConfig(cfg); !errors.Is(err, ErrInvalidVerify) {
+		t.Fatalf("expected invalid verification, got %v", err)
+	}
+}
+
+func TestHarnessDiscovery(t *testing.T) {
+	app, address := startHarness(t, []models.Group{{
+		ID:        models.ID{1},
+		Name:      "Example",
+		Slug:      "example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "domain", Rule: "example.com", Enable: true}},
+	}})
+
+	query(t, address, "other.org.")
+	_, err := app.StartDiscovery("127.0.0.1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"example.com.", "www.example.com.", "www.example.com.", "dual.example.org."} {
+		query(t, address, name)
+	}
+
+	result, err := app.DiscoveryResult("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Domains) != 3 || !result.Active {
+		t.Fatalf("unexpected session: %+v", result.Session)
+	}
+	if len(result.Candidates) != 2 {
+		t.Fatalf("unexpected candidates: %+v", result.Candidates)
+	}
+	candidate := result.Candidates[0]
+	if candidate.Rule != "example.com" || candidate.Queries != 3 || !slices.Equal(candidate.Groups, []string{"01000000"}) {
+		t.Fatalf("unexpected candidate: %+v", candidate)
+	}
+	if result.Candidates[1].Rule != "example.org" || len(result.Candidates[1].Groups) != 0 {
+		t.Fatalf("unexpected candidate: %+v", result.Candidates[1])
+	}
+
+	if _, err := app.StartDiscovery("127.0.0.1", time.Hour); !errors.Is(err, ErrInvalidDiscovery) {
+		t.Fatalf("expected invalid discovery, got %v", err)
+	}
+	if err := app.StopDiscovery("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.DiscoveryResult("127.0.0.1"); !errors.Is(err, ErrDiscoveryNotFound) {
+		t.Fatalf("expected missing session, got %v", err)
+	}
+}
+
+func TestHarnessHandoff(t *testing.T) {
+	groups := []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Slug:      "example",
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}}
+	previous, address := startHarness(t, groups)
+	query(t, address, "www.example.com.")
+	handoff := previous.ExportHandoff()
+	if len(handoff.Records) != 2 || len(handoff.Addresses["01020304"]) != 1 {
+		t.Fatalf("unexpected handoff: %+v", handoff)
+	}
+
+	app := New()
+	err := app.ImportConfig(models.Config{ConfigVersion: "0.1.2", Groups: groups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ImportHandoff(handoff)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- app.ServeHarness(ctx, conn, dnsMitmProxy.MemoryUpstream(harnessUpstream)) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Addresses are routed without a query to the new daemon
+	for start := time.Now(); app.State() != StateRunning && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	addresses, err := app.GroupAddresses("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(addresses, "10.0.0.2") {
+		t.Fatalf("address is not restored: %v", addresses)
+	}
+	if aRecords := app.records.GetARecords("www.example.com"); len(aRecords) != 1 || !aRecords[0].Address.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("records are not restored: %v", aRecords)
+	}
+}
+
+func TestHarnessRuleTTL(t *testing.T) {
+	long, short := uint32(86400), uint32(0)
+	app, address := startHarness(t, []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Interface: "nwg0",
+		Rules: []*models.Rule{
+			{ID: models.ID{1}, Type: "domain", Rule: "example.com", Enable: true, AdditionalTTL: &long},
+			{ID: models.ID{2}, Type: "domain", Rule: "other.org", Enable: true, AdditionalTTL: &short},
+			{ID: models.ID{3}, Type: "domain", Rule: "direct.example.com", Enable: true},
+		},
+	}})
+	for _, name := range []string{"example.com.", "other.org.", "direct.example.com."} {
+		query(t, address, name)
+	}
+
+	addresses, err := app.groups[0].ListIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, expected := range map[string]uint32{"10.0.0.1": 60 + long, "10.0.0.4": 60, "10.0.0.3": 60 + app.config.Netfilter.IPSet.AdditionalTTL} {
+		ttl := addresses[string(net.ParseIP(addr).To4())]
+		if ttl == nil || *ttl > expected || *ttl < expected-5 {
+			t.Fatalf("unexpected TTL of %s: %v", addr, addresses)
+		}
+	}
+	// The record outlives the default TTL, so the address isn't expired early
+	aRecords := app.records.GetARecords("example.com")
+	if len(aRecords) != 1 || time.Until(aRecords[0].Deadline) < time.Duration(long)*time.Second {
+		t.Fatalf("unexpected records: %v", aRecords)
+	}
+}
+
+func TestHarnessClientRoutes(t *testing.T) {
+	cfg := models.Config{ConfigVersion: "0.1.2", Groups: []models.Group{{
+		ID:        models.ID{1, 2, 3, 4},
+		Name:      "Example",
+		Slug:      "example",
+		Interface: "nwg0",
+		Rules:     []*models.Rule{{ID: models.ID{1}, Type: "namespace", Rule: "example.com", Enable: true}},
+	}}}
+	cfg.App.DNSProxy.Upstreams = []models.UpstreamSet{
+		{Name: "kids", Servers: []models.DNSProxyServer{{Address: "192.0.2.1"}}},
+		{Name: "family", Servers: []models.DNSProxyServer{{Address: "192.0.2.2"}}},
+	}
+	cfg.App.DNSProxy.Routes = []models.DNSRoute{
+		{Clients: []string{"192.168.1.0/24"}, Upstream: "kids"},
+		{Clients: []string{"10.0.0.1", "127.0.0.0/8"}, Upstream: "family"},
+	}
+
+	upstream := dnsMitmProxy.MemoryUpstream(harnessUpstream)
+	dialed := make(chan string, 4)
+	app, address := startHarnessDial(t, cfg, func(network, address string) (net.Conn, error) {
+		dialed <- address
+		return upstream(network, address)
+	})
+
+	query(t, address, "example.com.")
+	if got := <-dialed; got != "192.0.2.2:53" {
+		t.Fatalf("query of the client is resolved by %s", got)
+	}
+	// Answers of the client's upstream are routed as usual
+	addresses, err := app.GroupAddresses("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(addresses, "10.0.0.1") {
+		t.Fatalf("address is not routed: %v", addresses)
+	}
+
+	cfg.App.DNSProxy.Routes = append(cfg.App.DNSProxy.Routes, models.DNSRoute{Clients: []string{"kids-tablet"}, Upstream: "kids"})
+	if err := New().ImportConfig(cfg); !errors.Is(err, ErrInvalidDNSRoute) {
+		t.Fatalf("expected invalid route, got %v", err)
+	}
+}
+
+func TestHarnessSubscriptionSources(t *testing.T) {
+	const url = "http://lists.example/list.txt"
+	groups := []models.Group{
+		{ID: models.ID{1}, Name: "First", Interface: "nwg0", Subscriptions: []models.Subscription{{ID: models.ID{9}, URL: url, Interval: 3600}}},
+		{ID: models.ID{2}, Name: "Second", Interface: "nwg1", Subscriptions: []models.Subscription{{ID: models.ID{9}, URL: url}}},
+	}
+	app, address := startHarness(t, groups)
+	query(t, address, "other.org.")
+	if interval := app.subscriptionSources()[url]; interval != time.Hour {
+		t.Fatalf("expected the shortest interval, got %s", interval)
+	}
+	app.subscriptions.sources = map[string]*SubscriptionSource{url: {URL: url, Fetching: true}}
+
+	start := time.Now()
+	app.handleSubscriptionResult(subscriptionResult{url: url, err: errors.New("timeout")})
+	sources := app.SubscriptionSources()
+	if len(sources) != 1 || sources[0].Failures != 1 || sources[0].LastError != "timeout" || sources[0].Fetching {
+		t.Fatalf("unexpected status after failure: %+v", sources)
+	}
+	if retry := sources[0].NextFetch.Sub(start); retry < subscriptionRetryDelay || retry > subscriptionRetryDelay+time.Second {
+		t.Fatalf("unexpected retry delay %s", retry)
+	}
+
+	app.handleSubscriptionResult(subscriptionResult{url: url, entries: []string{"example.com"}})
+	sources = app.SubscriptionSources()
+	if sources[0].Failures != 0 || sources[0].LastError != "" || sources[0].LastSuccess == nil {
+		t.Fatalf("unexpected status after success: %+v", sources[0])
+	}
+	if next := sources[0].NextFetch.Sub(start); next < time.Hour || next > time.Hour+6*time.Minute+time.Second {
+		t.Fatalf("unexpected next fetch in %s", next)
+	}
+	// One fetch updates subscriptions of both groups
+	for _, groupModel := range groups {
+		grp, _ := app.FindGroup(groupModel.ID.String())
+		if grp.FindRule(subscriptionRuleID(models.ID{9}, "example.com")) == nil {
+			t.Fatalf("subscription of group %s is not updated", groupModel.Name)
+		}
+	}
+}
+
+func TestSubscriptionRetry(t *testing.T) {
+	for _, tc := range []struct {
+		failures int
+		interval time.Duration
+		expected time.Duration
+	}{
+		{1, time.Hour, time.Minute},
+		{2, time.Hour, 2 * time.Minute},
+		{5, time.Hour, 16 * time.Minute},
+		{10, time.Hour, time.Hour},
+		{3, 30 * time.Second, time.Minute},
+	} {
+		if delay := subscriptionRetry(tc.failures, tc.interval); delay != tc.expected {
+			t.Errorf("%d failures of %s: expected %s, got %s", tc.failures, tc.interval, tc.expected, delay)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if jitter := subscriptionJitter(24 * time.Hour); jitter < 0 || jitter >= subscriptionMaxJitter {
+			t.Fatalf("jitter out of bounds: %s", jitter)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package magitrickle
+
+import (
+	"fmt"
+	"time"
+
+	"magitrickle/notify"
+
+	"github.com/rs/zerolog/log"
+)
+
+// healInterval is how often ipsets and routing of groups are checked and failed insertions are retried
+const healInterval = 15 * time.Second
+
+// healGroups re-creates ipsets destroyed externally, re-installs ip rules and routes removed by other daemons
+// and retries addresses that failed to be added, so routing converges without waiting for clients
+// to resolve the domains again
+func (a *App) healGroups() {
+	for _, group := range a.groups {
+		repaired, err := group.RepairRouting()
+		if err != nil {
+			log.Warn().Str("group", group.ID.String()).Err(err).Msg("failed to repair routing of group")
+		}
+		if repaired {
+			a.Notify(notify.EventRoutingRepaired, fmt.Sprintf("routing of group %s was removed externally and re-installed", group.Name))
+		}
+
+		retried, err := group.Heal(a.records)
+		if err != nil {
+			log.Warn().
+				Str("group", group.ID.String()).
+				Int("pending", group.PendingRetries()).
+				Err(err).
+				Msg("failed to heal group")
+			continue
+		}
+		if retried != 0 {
+			log.Info().Str("group", group.ID.String()).Int("addresses", retried).Msg("added queued addresses")
+		}
+	}
+}
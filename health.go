@@ -0,0 +1,71 @@
+package magitrickle
+
+import (
+	"time"
+
+	"magitrickle/netfilter-helper"
+)
+
+// loopStallTimeout is how long the main loop may stay silent before the app is considered hung
+const loopStallTimeout = 30 * time.Second
+
+const loopHeartbeatInterval = 5 * time.Second
+
+type Health struct {
+	State              string `json:"state"`
+	Running            bool   `json:"running"`
+	DNSUDPListening    bool   `json:"dnsUdpListening"`
+	DNSTCPListening    bool   `json:"dnsTcpListening"`
+	NetfilterInstalled bool   `json:"netfilterInstalled"`
+	// NetfilterStalled is set while ipset, route or iptables calls time out (e.g. a wedged xtables lock)
+	NetfilterStalled  bool               `json:"netfilterStalled"`
+	NetfilterTimeouts uint64             `json:"netfilterTimeouts"`
+	LastLoopHeartbeat time.Time          `json:"lastLoopHeartbeat"`
+	ControlSocket     ControlSocketStats `json:"controlSocket"`
+	// Paused is set while marking rules and the DNS remap are removed by Pause
+	Paused bool `json:"paused"`
+	// Capabilities are kernel features detected at the last start
+	Capabilities *netfilterHelper.Capabilities `json:"capabilities,omitempty"`
+}
+
+// IsLive reports whether the main loop is responsive
+func (h Health) IsLive() bool {
+	return h.Running && time.Since(h.LastLoopHeartbeat) < loopStallTimeout
+}
+
+// IsReady reports whether the app serves DNS and routes traffic
+func (h Health) IsReady() bool {
+	return h.IsLive() && h.DNSUDPListening && h.DNSTCPListening && h.NetfilterInstalled && !h.NetfilterStalled
+}
+
+func (a *App) Health() Health {
+	health := Health{
+		State:              a.State().String(),
+		Running:            a.State() == StateRunning,
+		DNSUDPListening:    a.health.dnsUDPListening.Load(),
+		DNSTCPListening:    a.health.dnsTCPListening.Load(),
+		NetfilterInstalled: a.health.netfilterInstalled.Load(),
+		NetfilterStalled:   netfilterHelper.Stalled(),
+		NetfilterTimeouts:  netfilterHelper.Timeouts(),
+		ControlSocket:      a.controlSocket.stats(),
+		Paused:             a.Paused(),
+		Capabilities:       netfilterHelper.Detected(),
+	}
+	// The time keeps its monotonic reading, so a jump of the wall clock doesn't look like a stall
+	if heartbeat := a.health.loopHeartbeat.Load(); heartbeat != nil {
+		health.LastLoopHeartbeat = *heartbeat
+	}
+	return health
+}
+
+func (a *App) heartbeat() {
+	now := time.Now()
+	a.health.loopHeartbeat.Store(&now)
+}
+
+func (a *App) resetHealth() {
+	a.health.dnsUDPListening.Store(false)
+	a.health.dnsTCPListening.Store(false)
+	a.health.netfilterInstalled.Store(false)
+	a.health.loopHeartbeat.Store(nil)
+}
@@ -0,0 +1,22 @@
+package magitrickle
+
+import (
+	"time"
+
+	"magitrickle/history"
+)
+
+const historyCompactInterval = time.Hour
+
+// TopDomains returns the most often routed domains of the group (all groups if empty) since the time
+func (a *App) TopDomains(groupKey string, since time.Time, limit int) ([]history.DomainCount, error) {
+	var groupID string
+	if groupKey != "" {
+		group, ok := a.FindGroup(groupKey)
+		if !ok {
+			return nil, ErrGroupNotFound
+		}
+		groupID = group.ID.String()
+	}
+	return a.history.TopDomains(groupID, since, limit)
+}
@@ -0,0 +1,196 @@
+// Package history keeps routed domains on disk for offline analysis.
+//
+// There is no embedded database on the router, so entries are appended to a
+// JSON lines file, one object per line, and entries older than the retention
+// are dropped by Compact.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Group   string    `json:"group"`
+	Rule    string    `json:"rule"`
+	Domain  string    `json:"domain"`
+	Address net.IP    `json:"address"`
+}
+
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+type Store struct {
+	Path      string
+	Retention time.Duration
+
+	mux  sync.Mutex
+	file *os.File
+}
+
+// Record appends the entry, the store may be nil
+func (s *Store) Record(entry Entry) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	_, err = s.file.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// scan calls fn for every entry since the time
+func (s *Store) scan(since time.Time, fn func(Entry)) error {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if entry.Time.Before(since) {
+			continue
+		}
+		fn(entry)
+	}
+	return scanner.Err()
+}
+
+// TopDomains returns the most often routed domains of the group (all groups if empty) since the time
+func (s *Store) TopDomains(group string, since time.Time, limit int) ([]DomainCount, error) {
+	if s == nil {
+		return []DomainCount{}, nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	counts := make(map[string]int)
+	err := s.scan(since, func(entry Entry) {
+		if group != "" && entry.Group != group {
+			return
+		}
+		counts[entry.Domain]++
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	top := make([]DomainCount, 0, len(counts))
+	for domain, count := range counts {
+		top = append(top, DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Domain < top[j].Domain
+	})
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
+
+// Compact drops entries older than the retention
+func (s *Store) Compact() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	tmpPath := s.Path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	err = s.scan(time.Now().Add(-s.Retention), func(entry Entry) {
+		_ = encoder.Encode(entry)
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	_ = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+
+	err = os.Rename(tmpPath, s.Path)
+	if err != nil {
+		return fmt.Errorf("failed to replace history: %w", err)
+	}
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return err
+}
+
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Open opens (creating if needed) the history file and drops outdated entries
+func Open(path string, retention time.Duration) (*Store, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	s := &Store{
+		Path:      path,
+		Retention: retention,
+		file:      file,
+	}
+	err = s.Compact()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
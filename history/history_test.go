@@ -0,0 +1,66 @@
+package history
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	for _, entry := range []Entry{
+		{Time: now.Add(-2 * time.Hour), Group: "a", Domain: "old.example.com"},
+		{Time: now, Group: "a", Domain: "example.com", Address: net.IPv4(192, 0, 2, 1)},
+		{Time: now, Group: "a", Domain: "example.com", Address: net.IPv4(192, 0, 2, 2)},
+		{Time: now, Group: "a", Domain: "example.org"},
+		{Time: now, Group: "b", Domain: "example.net"},
+	} {
+		err = store.Record(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	top, err := store.TopDomains("a", now.Add(-24*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 3 || top[0].Domain != "example.com" || top[0].Count != 2 {
+		t.Fatalf("unexpected top: %+v", top)
+	}
+
+	err = store.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err = store.TopDomains("", time.Time{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0].Domain != "example.com" {
+		t.Fatalf("unexpected top after compaction: %+v", top)
+	}
+	top, _ = store.TopDomains("a", time.Time{}, 0)
+	for _, domain := range top {
+		if domain.Domain == "old.example.com" {
+			t.Fatal("outdated entry not compacted")
+		}
+	}
+
+	err = store.Record(Entry{Time: now, Group: "b", Domain: "after.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, _ = store.TopDomains("b", time.Time{}, 0)
+	if len(top) != 2 {
+		t.Fatalf("record after compaction lost: %+v", top)
+	}
+}
@@ -0,0 +1,31 @@
+package magitrickle
+
+import (
+	"magitrickle/models"
+)
+
+// NewIDs returns random IDs for new groups and rules, they differ from IDs of all groups and rules
+// of the config and from each other. IDs aren't reserved, so clients should use them right away.
+func (a *App) NewIDs(count int) ([]models.ID, error) {
+	taken := make(map[models.ID]struct{})
+	for _, group := range a.ExportConfig().Groups {
+		taken[group.ID] = struct{}{}
+		for _, rule := range group.Rules {
+			taken[rule.ID] = struct{}{}
+		}
+	}
+
+	ids := make([]models.ID, 0, count)
+	for len(ids) < count {
+		id, err := models.NewID(func(id models.ID) bool {
+			_, ok := taken[id]
+			return ok
+		})
+		if err != nil {
+			return nil, err
+		}
+		taken[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,133 @@
+package magitrickle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"magitrickle/keenetic-rci"
+	"magitrickle/net-namespace"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const rciTimeout = 2 * time.Second
+
+// mainTable is the routing table used when no rule matches (RT_TABLE_MAIN)
+const mainTable = 254
+
+// nonTargetLinkTypes are service devices traffic can not be routed to
+var nonTargetLinkTypes = map[string]struct{}{
+	"ifb":    {},
+	"dummy":  {},
+	"bridge": {},
+	"veth":   {},
+}
+
+type Interface struct {
+	Name            string   `json:"name"`
+	Label           string   `json:"label,omitempty"`
+	Description     string   `json:"description,omitempty"`
+	Type            string   `json:"type"`
+	Up              bool     `json:"up"`
+	PointToPoint    bool     `json:"pointToPoint"`
+	HasDefaultRoute bool     `json:"hasDefaultRoute"`
+	CanBeTarget     bool     `json:"canBeTarget"`
+	Addresses       []string `json:"addresses"`
+}
+
+// InterfaceFilter keeps only interfaces having all of the enabled capabilities
+type InterfaceFilter struct {
+	Target       bool
+	Up           bool
+	DefaultRoute bool
+}
+
+func (f InterfaceFilter) match(iface Interface) bool {
+	return (!f.Target || iface.CanBeTarget) && (!f.Up || iface.Up) && (!f.DefaultRoute || iface.HasDefaultRoute)
+}
+
+// defaultRouteLinks returns indexes of links having a default route in the main table
+func defaultRouteLinks() (map[int]struct{}, error) {
+	routes, err := netNamespace.Netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{Table: mainTable}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routes: %w", err)
+	}
+	links := make(map[int]struct{})
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		links[route.LinkIndex] = struct{}{}
+		for _, nextHop := range route.MultiPath {
+			links[nextHop.LinkIndex] = struct{}{}
+		}
+	}
+	return links, nil
+}
+
+// ListInterfaces returns system interfaces with firmware labels, if the RCI is available
+func (a *App) ListInterfaces(filter InterfaceFilter) ([]Interface, error) {
+	links, err := netNamespace.Netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interfaces: %w", err)
+	}
+	defaultRoutes, err := defaultRouteLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rciTimeout)
+	defer cancel()
+	firmware, err := keeneticRCI.Interfaces(ctx, keeneticRCI.DefaultURL)
+	if err != nil {
+		log.Debug().Err(err).Msg("interface labels are not available")
+	}
+
+	lanLinks := make(map[string]struct{}, len(a.config.Link))
+	for _, name := range a.config.Link {
+		lanLinks[name] = struct{}{}
+	}
+
+	interfaces := make([]Interface, 0, len(links))
+	for _, link := range links {
+		attrs := link.Attrs()
+		iface := Interface{
+			Name:         attrs.Name,
+			Description:  attrs.Alias,
+			Type:         link.Type(),
+			Up:           attrs.Flags&net.FlagUp != 0,
+			PointToPoint: attrs.Flags&net.FlagPointToPoint != 0,
+			Addresses:    []string{},
+		}
+		if _, ok := defaultRoutes[attrs.Index]; ok {
+			iface.HasDefaultRoute = true
+		}
+		if fw, ok := firmware[attrs.Name]; ok {
+			iface.Label = fw.ID
+			if fw.Description != "" {
+				iface.Description = fw.Description
+			}
+		}
+		_, isLAN := lanLinks[attrs.Name]
+		_, isService := nonTargetLinkTypes[iface.Type]
+		iface.CanBeTarget = attrs.Flags&net.FlagLoopback == 0 && !isLAN && !isService
+
+		addrList, err := netNamespace.Netlink.AddrList(link, nl.FAMILY_ALL)
+		if err == nil {
+			for _, addr := range addrList {
+				iface.Addresses = append(iface.Addresses, addr.IPNet.String())
+			}
+		}
+
+		if filter.match(iface) {
+			interfaces = append(interfaces, iface)
+		}
+	}
+	return interfaces, nil
+}
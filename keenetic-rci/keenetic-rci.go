@@ -0,0 +1,118 @@
+// Package keeneticRCI reads data of the Keenetic firmware through its local RCI (REST Core Interface).
+package keeneticRCI
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultURL is the RCI endpoint available from the router itself
+const DefaultURL = "http://127.0.0.1:79/rci"
+
+const maxResponseSize = 4 << 20
+
+type rciInterface struct {
+	ID            string `json:"id"`
+	InterfaceName string `json:"interface-name"`
+	Type          string `json:"type"`
+	Description   string `json:"description"`
+}
+
+// Interface is a firmware interface mapped to the system one
+type Interface struct {
+	// ID is the firmware name (Wireguard0, Bridge0)
+	ID          string
+	Type        string
+	Description string
+}
+
+func request(ctx context.Context, baseURL, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request RCI: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to request RCI: %s", resp.Status)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to parse RCI response: %w", err)
+	}
+	return nil
+}
+
+// Interfaces returns firmware interfaces by system name (nwg0, br0)
+func Interfaces(ctx context.Context, baseURL string) (map[string]Interface, error) {
+	var list map[string]rciInterface
+	err := request(ctx, baseURL, "/show/interface", &list)
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make(map[string]Interface, len(list))
+	for id, iface := range list {
+		if iface.InterfaceName == "" {
+			continue
+		}
+		if iface.ID != "" {
+			id = iface.ID
+		}
+		interfaces[iface.InterfaceName] = Interface{
+			ID:          id,
+			Type:        iface.Type,
+			Description: iface.Description,
+		}
+	}
+	return interfaces, nil
+}
+
+type rciNameServers struct {
+	Server []struct {
+		Address string `json:"address"`
+		Port    string `json:"port"`
+		Domain  string `json:"domain"`
+	} `json:"server"`
+}
+
+// NameServer is a resolver the firmware got from the provider (DHCP, PPP) or from its settings
+type NameServer struct {
+	Address string
+	Port    uint16
+}
+
+// NameServers returns global resolvers of the firmware, resolvers of specific domains are skipped
+func NameServers(ctx context.Context, baseURL string) ([]NameServer, error) {
+	var list rciNameServers
+	err := request(ctx, baseURL, "/show/ip/name-server", &list)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []NameServer
+	for _, server := range list.Server {
+		if server.Domain != "" || server.Address == "" {
+			continue
+		}
+		port := uint16(53)
+		if server.Port != "" {
+			value, err := strconv.ParseUint(server.Port, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse RCI response: invalid port %q", server.Port)
+			}
+			port = uint16(value)
+		}
+		servers = append(servers, NameServer{Address: server.Address, Port: port})
+	}
+	return servers, nil
+}
@@ -0,0 +1,57 @@
+package keeneticRCI
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInterfaces(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rci/show/interface" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+			"Wireguard0": {"id": "Wireguard0", "interface-name": "nwg0", "type": "Wireguard", "description": "VPN"},
+			"Bridge0": {"id": "Bridge0", "interface-name": "br0", "type": "Bridge", "description": "Home network"},
+			"Dot11Radio0": {"id": "Dot11Radio0", "type": "Dot11Radio"}
+		}`))
+	}))
+	defer srv.Close()
+
+	interfaces, err := Interfaces(context.Background(), srv.URL+"/rci/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interfaces) != 2 {
+		t.Fatalf("unexpected interfaces: %+v", interfaces)
+	}
+	if iface := interfaces["nwg0"]; iface.ID != "Wireguard0" || iface.Description != "VPN" {
+		t.Fatalf("unexpected interface: %+v", iface)
+	}
+}
+
+func TestNameServers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rci/show/ip/name-server" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"server": [
+			{"address": "10.1.0.1", "port": "53", "domain": null, "global": false},
+			{"address": "192.168.10.1", "port": "53", "domain": "corp.example"},
+			{"address": "10.1.0.2", "port": "5353"}
+		]}`))
+	}))
+	defer srv.Close()
+
+	servers, err := NameServers(context.Background(), srv.URL+"/rci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || servers[0] != (NameServer{Address: "10.1.0.1", Port: 53}) || servers[1].Port != 5353 {
+		t.Fatalf("unexpected name servers: %+v", servers)
+	}
+}
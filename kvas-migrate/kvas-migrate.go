@@ -0,0 +1,148 @@
+// Package kvasMigrate converts lists and settings of a KVAS installation
+// into a magitrickle config.
+//
+// KVAS keeps the routed list as one entry per line, "*example.com" (the domain
+// with subdomains), "example.com" or an address/network. Exclusions use the same
+// format. Settings are shell-like KEY=value lines, the tunnel interface is taken
+// from INFACE_ENT.
+package kvasMigrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"magitrickle/models"
+)
+
+const (
+	DefaultHostsPath    = "/opt/etc/hosts.list"
+	DefaultExcludePath  = "/opt/etc/kvas/exclude.list"
+	DefaultSettingsPath = "/opt/etc/kvas.conf"
+)
+
+const interfaceSetting = "INFACE_ENT"
+
+var ErrNoInterface = errors.New("tunnel interface is unknown")
+
+// Input is the content of KVAS files, Interface overrides the one from settings
+type Input struct {
+	Hosts     string `json:"hosts"`
+	Exclude   string `json:"exclude"`
+	Settings  string `json:"settings"`
+	Interface string `json:"interface"`
+}
+
+// parseSettings reads KEY=value lines, quotes are removed
+func parseSettings(data string) map[string]string {
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		settings[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return settings
+}
+
+// parseList returns entries of a KVAS list without comments and duplicates
+func parseList(data string) []string {
+	var entries []string
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" {
+			continue
+		}
+		if _, ok := seen[line]; ok {
+			continue
+		}
+		seen[line] = struct{}{}
+		entries = append(entries, line)
+	}
+	return entries
+}
+
+// ruleID derives a stable ID from the entry, so repeated migrations produce the same config
+func ruleID(entry string, used map[models.ID]struct{}) models.ID {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(entry))
+	sum := hash.Sum32()
+	for {
+		id := models.ID{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+		if _, exists := used[id]; !exists {
+			used[id] = struct{}{}
+			return id
+		}
+		sum++
+	}
+}
+
+func convertEntry(entry string) *models.Rule {
+	if _, err := models.ParseCIDR(entry); err == nil {
+		return &models.Rule{Name: entry, Type: "subnet", Rule: entry, Enable: true}
+	}
+	if strings.HasPrefix(entry, "*") {
+		domain := strings.TrimLeft(entry, "*.")
+		return &models.Rule{Name: entry, Type: "namespace", Rule: domain, Enable: true}
+	}
+	return &models.Rule{Name: entry, Type: "domain", Rule: entry, Enable: true}
+}
+
+// Convert produces the config with a single group routing the KVAS list
+func Convert(input Input) (models.Config, error) {
+	cfg := models.Config{ConfigVersion: "0.1.0"}
+
+	iface := input.Interface
+	if iface == "" {
+		iface = parseSettings(input.Settings)[interfaceSetting]
+	}
+	if iface == "" {
+		return cfg, ErrNoInterface
+	}
+
+	group := models.Group{
+		ID:        models.ID{0x6b, 0x76, 0x61, 0x73},
+		Slug:      "kvas",
+		Name:      "KVAS",
+		Interface: iface,
+	}
+
+	usedIDs := make(map[models.ID]struct{})
+	for _, entry := range parseList(input.Hosts) {
+		rule := convertEntry(entry)
+		rule.ID = ruleID(entry, usedIDs)
+		group.Rules = append(group.Rules, rule)
+	}
+	for _, entry := range parseList(input.Exclude) {
+		// Networks are excluded statically, domains by exclude rules
+		if _, err := models.ParseCIDR(entry); err == nil {
+			group.Exclude = append(group.Exclude, entry)
+			continue
+		}
+		rule := convertEntry(entry)
+		rule.ID = ruleID("exclude:"+entry, usedIDs)
+		rule.Action = models.RuleActionExclude
+		group.Rules = append(group.Rules, rule)
+	}
+
+	err := group.Validate()
+	if err != nil {
+		return cfg, fmt.Errorf("converted group is invalid: %w", err)
+	}
+	cfg.Groups = []models.Group{group}
+	return cfg, nil
+}
@@ -0,0 +1,54 @@
+package kvasMigrate
+
+import (
+	"errors"
+	"testing"
+
+	"magitrickle/models"
+)
+
+func TestConvert(t *testing.T) {
+	cfg, err := Convert(Input{
+		Hosts:    "# comment\n*example.com\nexact.example.org\n10.0.0.0/8\nEXACT.example.org\n\n",
+		Exclude:  "direct.example.com\n192.168.0.0/16\n",
+		Settings: "INFACE_ENT='nwg0'\nDNS_CRYPT=on\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Groups) != 1 {
+		t.Fatalf("unexpected groups: %+v", cfg.Groups)
+	}
+	group := cfg.Groups[0]
+	if group.Interface != "nwg0" {
+		t.Fatalf("unexpected interface: %q", group.Interface)
+	}
+	if len(group.Exclude) != 1 || group.Exclude[0] != "192.168.0.0/16" {
+		t.Fatalf("unexpected exclusions: %v", group.Exclude)
+	}
+
+	expected := []struct{ typ, rule, action string }{
+		{"namespace", "example.com", ""},
+		{"domain", "exact.example.org", ""},
+		{"subnet", "10.0.0.0/8", ""},
+		{"domain", "direct.example.com", models.RuleActionExclude},
+	}
+	if len(group.Rules) != len(expected) {
+		t.Fatalf("unexpected rules: %d", len(group.Rules))
+	}
+	for idx, rule := range group.Rules {
+		if rule.Type != expected[idx].typ || rule.Rule != expected[idx].rule || rule.Action != expected[idx].action {
+			t.Fatalf("unexpected rule %d: %+v", idx, rule)
+		}
+	}
+
+	again, _ := Convert(Input{Hosts: "*example.com\n", Interface: "nwg0"})
+	if again.Groups[0].Rules[0].ID != group.Rules[0].ID {
+		t.Fatal("rule IDs are not stable")
+	}
+
+	_, err = Convert(Input{Hosts: "example.com"})
+	if !errors.Is(err, ErrNoInterface) {
+		t.Fatalf("expected no interface error, got %v", err)
+	}
+}
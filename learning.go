@@ -0,0 +1,61 @@
+package magitrickle
+
+import (
+	"net"
+	"time"
+
+	"magitrickle/app-errors"
+	"magitrickle/learning"
+
+	"github.com/miekg/dns"
+)
+
+var ErrLearningDisabled = appErrors.New(appErrors.ErrNotFound, "learning of unmatched domains is disabled")
+
+// learn records the query of the client if no rule matches names of the response, answers skipped
+// by deduplication are matched again here
+func (a *App) learn(clientAddr net.Addr, respMsg *dns.Msg) {
+	if clientAddr == nil || clientAddr == SystemClient || len(respMsg.Question) == 0 {
+		return
+	}
+	client := clientIP(clientAddr)
+	if client == nil {
+		return
+	}
+	question := respMsg.Question[0]
+	if question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA {
+		return
+	}
+
+	names := []string{question.Name}
+	for _, rr := range respMsg.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			names = append(names, cname.Target)
+		}
+	}
+	for idx, name := range names {
+		names[idx] = dns.CanonicalName(name)
+		names[idx] = names[idx][:len(names[idx])-1]
+	}
+	ctx := a.matchContext(clientAddr, time.Now(), question.Qtype)
+	for _, group := range a.groups {
+		if rule, _ := group.Match(names, ctx); rule != nil {
+			return
+		}
+	}
+	a.learner.Observe(client.String(), question.Name, respMsg.Rcode == dns.RcodeNameError, ctx.Time)
+}
+
+// LearningEnabled reports whether unmatched domains are recorded
+func (a *App) LearningEnabled() bool {
+	return a.learner != nil
+}
+
+// Suggestions returns suggested namespace rules for domains queried by the client ("" - any client)
+// at least minQueries times, which no rule matches
+func (a *App) Suggestions(client string, minQueries int) []learning.Suggestion {
+	if a.learner == nil {
+		return nil
+	}
+	return a.learner.Suggestions(client, minQueries, time.Now())
+}
@@ -0,0 +1,159 @@
+// Package learning records domains which clients query but no rule matches, and groups them into
+// suggested rules, so it's easier to find out which domains an application really uses.
+// Suggestions are only shown, they're never applied.
+package learning
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDomains bounds the memory, the least recently seen domain is dropped
+	maxDomains = 4096
+	// maxClients are kept per domain
+	maxClients = 16
+	// Retention is how long a domain is kept since it was queried last
+	Retention = 24 * time.Hour
+	// maxSuggestionDomains are listed per suggestion
+	maxSuggestionDomains = 20
+)
+
+type entry struct {
+	queries  int
+	nxdomain int
+	clients  map[string]struct{}
+	lastSeen time.Time
+}
+
+// Suggestion is a namespace rule covering unmatched domains with the same parent
+type Suggestion struct {
+	Type     string    `json:"type"`
+	Rule     string    `json:"rule"`
+	Domains  []string  `json:"domains"`
+	Clients  []string  `json:"clients"`
+	Queries  int       `json:"queries"`
+	NXDomain int       `json:"nxdomain"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type Learner struct {
+	mux     sync.Mutex
+	domains map[string]*entry
+}
+
+func New() *Learner {
+	return &Learner{domains: make(map[string]*entry)}
+}
+
+// Observe counts the unmatched query of the client, nxdomain is set when the name doesn't exist
+func (l *Learner) Observe(client, domain string, nxdomain bool, now time.Time) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".arpa") {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	e, ok := l.domains[domain]
+	if !ok {
+		if len(l.domains) >= maxDomains {
+			l.evict(now)
+		}
+		e = &entry{clients: make(map[string]struct{})}
+		l.domains[domain] = e
+	}
+	e.queries++
+	if nxdomain {
+		e.nxdomain++
+	}
+	if _, ok := e.clients[client]; ok || len(e.clients) < maxClients {
+		e.clients[client] = struct{}{}
+	}
+	e.lastSeen = now
+}
+
+// evict drops expired domains, or the least recently seen one if none expired
+func (l *Learner) evict(now time.Time) {
+	var oldest string
+	for domain, e := range l.domains {
+		if now.Sub(e.lastSeen) > Retention {
+			delete(l.domains, domain)
+			continue
+		}
+		if oldest == "" || e.lastSeen.Before(l.domains[oldest].lastSeen) {
+			oldest = domain
+		}
+	}
+	if len(l.domains) >= maxDomains {
+		delete(l.domains, oldest)
+	}
+}
+
+// Parent returns the registrable-like parent of the domain: its last two labels
+func Parent(domain string) string {
+	labels := strings.Split(domain, ".")
+	if len(labels) <= 2 {
+		return domain
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// Suggestions returns namespace rules of parents queried at least minQueries times by the client
+// ("" - any client), the most queried first
+func (l *Learner) Suggestions(client string, minQueries int, now time.Time) []Suggestion {
+	byParent := make(map[string]*Suggestion)
+	clients := make(map[string]map[string]struct{})
+
+	l.mux.Lock()
+	for domain, e := range l.domains {
+		if now.Sub(e.lastSeen) > Retention {
+			delete(l.domains, domain)
+			continue
+		}
+		if _, ok := e.clients[client]; client != "" && !ok {
+			continue
+		}
+		parent := Parent(domain)
+		suggestion, ok := byParent[parent]
+		if !ok {
+			suggestion = &Suggestion{Type: "namespace", Rule: parent}
+			byParent[parent] = suggestion
+			clients[parent] = make(map[string]struct{})
+		}
+		suggestion.Domains = append(suggestion.Domains, domain)
+		suggestion.Queries += e.queries
+		suggestion.NXDomain += e.nxdomain
+		if e.lastSeen.After(suggestion.LastSeen) {
+			suggestion.LastSeen = e.lastSeen
+		}
+		for c := range e.clients {
+			clients[parent][c] = struct{}{}
+		}
+	}
+	l.mux.Unlock()
+
+	suggestions := make([]Suggestion, 0, len(byParent))
+	for parent, suggestion := range byParent {
+		if suggestion.Queries < minQueries {
+			continue
+		}
+		sort.Strings(suggestion.Domains)
+		if len(suggestion.Domains) > maxSuggestionDomains {
+			suggestion.Domains = suggestion.Domains[:maxSuggestionDomains]
+		}
+		for c := range clients[parent] {
+			suggestion.Clients = append(suggestion.Clients, c)
+		}
+		sort.Strings(suggestion.Clients)
+		suggestions = append(suggestions, *suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Queries != suggestions[j].Queries {
+			return suggestions[i].Queries > suggestions[j].Queries
+		}
+		return suggestions[i].Rule < suggestions[j].Rule
+	})
+	return suggestions
+}
@@ -0,0 +1,34 @@
+package learning
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuggestions(t *testing.T) {
+	now := time.Now()
+	l := New()
+	for i := 0; i < 3; i++ {
+		l.Observe("192.168.1.10", "api.app.example.", false, now)
+	}
+	l.Observe("192.168.1.10", "Telemetry.App.Example.", true, now)
+	l.Observe("192.168.1.11", "other.org.", false, now)
+	l.Observe("192.168.1.11", "localhost.", false, now)
+	l.Observe("192.168.1.11", "1.1.168.192.in-addr.arpa.", false, now)
+
+	suggestions := l.Suggestions("", 2, now)
+	if len(suggestions) != 1 {
+		t.Fatalf("unexpected suggestions: %+v", suggestions)
+	}
+	suggestion := suggestions[0]
+	if suggestion.Rule != "app.example" || suggestion.Queries != 4 || suggestion.NXDomain != 1 || len(suggestion.Domains) != 2 {
+		t.Fatalf("unexpected suggestion: %+v", suggestion)
+	}
+
+	if suggestions = l.Suggestions("192.168.1.11", 1, now); len(suggestions) != 1 || suggestions[0].Rule != "other.org" {
+		t.Fatalf("unexpected suggestions of the client: %+v", suggestions)
+	}
+	if suggestions = l.Suggestions("", 1, now.Add(Retention+time.Minute)); len(suggestions) != 0 {
+		t.Fatalf("expired domains are suggested: %+v", suggestions)
+	}
+}
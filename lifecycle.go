@@ -0,0 +1,108 @@
+package magitrickle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// State is the stage of the app lifecycle: stopped -> starting -> running -> stopping -> stopped
+type State int32
+
+const (
+	StateStopped State = iota
+	StateStarting
+	StateRunning
+	StateStopping
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	default:
+		return "stopped"
+	}
+}
+
+// lifecycle guards transitions between Start (ServeHarness) and Stop,
+// the state is atomic so it can be read without the lock
+type lifecycle struct {
+	mux    sync.Mutex
+	state  atomic.Int32
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// State returns the current stage of the app lifecycle
+func (a *App) State() State {
+	return State(a.lifecycle.state.Load())
+}
+
+// isRunning reports whether the app owns netfilter state and groups (starting, running or stopping)
+func (a *App) isRunning() bool {
+	return a.State() != StateStopped
+}
+
+// begin moves the stopped app to starting, the returned context is cancelled by Stop
+func (a *App) begin(ctx context.Context) (context.Context, error) {
+	l := &a.lifecycle
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	switch State(l.state.Load()) {
+	case StateStopped:
+	case StateStopping:
+		return nil, ErrStopping
+	default:
+		return nil, ErrAlreadyRunning
+	}
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	l.state.Store(int32(StateStarting))
+	return ctx, nil
+}
+
+// started moves the app from starting to running, a stop requested during the start is kept
+func (a *App) started() {
+	a.lifecycle.state.CompareAndSwap(int32(StateStarting), int32(StateRunning))
+}
+
+// end moves the app to stopped and releases waiters of Stop
+func (a *App) end() {
+	l := &a.lifecycle
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.cancel()
+	close(l.done)
+	l.state.Store(int32(StateStopped))
+}
+
+// Stop cancels the starting or running app and waits until it releases sockets and netfilter state
+// or the context is done
+func (a *App) Stop(ctx context.Context) error {
+	l := &a.lifecycle
+	l.mux.Lock()
+	switch State(l.state.Load()) {
+	case StateStopped:
+		l.mux.Unlock()
+		return ErrNotRunning
+	case StateStopping:
+		l.mux.Unlock()
+		return ErrStopping
+	}
+	l.state.Store(int32(StateStopping))
+	l.cancel()
+	done := l.done
+	l.mux.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,24 @@
+package magitrickle
+
+import (
+	"net"
+	"strings"
+
+	"magitrickle/models"
+
+	"github.com/miekg/dns"
+)
+
+// localZoneRecords groups addresses of the local zone by FQDN
+func localZoneRecords(records []models.LocalRecord) map[string][]net.IP {
+	zone := make(map[string][]net.IP)
+	for _, record := range records {
+		name := dns.Fqdn(strings.ToLower(record.Name))
+		ip := net.ParseIP(record.Address)
+		if ip == nil {
+			continue
+		}
+		zone[name] = append(zone[name], ip)
+	}
+	return zone
+}
@@ -0,0 +1,131 @@
+package logBuffer
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+type Entry struct {
+	// Seq is the number of the entry since the start, it identifies the entry in the buffer
+	Seq       uint64
+	Level     zerolog.Level
+	Component string
+	Raw       json.RawMessage
+}
+
+type Filter struct {
+	Level     zerolog.Level
+	Component string
+}
+
+func (f Filter) Match(entry Entry) bool {
+	if entry.Level < f.Level {
+		return false
+	}
+	if f.Component != "" && entry.Component != f.Component {
+		return false
+	}
+	return true
+}
+
+// Buffer keeps the last written zerolog events and fans them out to subscribers
+type Buffer struct {
+	mux         sync.RWMutex
+	entries     []Entry
+	next        int
+	full        bool
+	seq         uint64
+	subscribers map[chan Entry]struct{}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	var fields struct {
+		Level     string `json:"level"`
+		Component string `json:"component"`
+	}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		// Not a JSON event, nothing to keep
+		return len(p), nil
+	}
+	level, err := zerolog.ParseLevel(fields.Level)
+	if err != nil {
+		level = zerolog.NoLevel
+	}
+
+	raw := make(json.RawMessage, len(p))
+	copy(raw, p)
+	entry := Entry{
+		Level:     level,
+		Component: fields.Component,
+		Raw:       raw,
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.seq++
+	entry.Seq = b.seq
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- entry:
+		default:
+			// Slow subscriber, drop the entry instead of blocking logging
+		}
+	}
+
+	return len(p), nil
+}
+
+func (b *Buffer) List(filter Filter) []Entry {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	var ordered []Entry
+	if b.full {
+		ordered = append(ordered, b.entries[b.next:]...)
+	}
+	ordered = append(ordered, b.entries[:b.next]...)
+
+	entries := make([]Entry, 0, len(ordered))
+	for _, entry := range ordered {
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (b *Buffer) Subscribe(size int) (<-chan Entry, func()) {
+	ch := make(chan Entry, size)
+
+	b.mux.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mux.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mux.Lock()
+			delete(b.subscribers, ch)
+			b.mux.Unlock()
+		})
+	}
+}
+
+func New(capacity int) *Buffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Buffer{
+		entries:     make([]Entry, capacity),
+		subscribers: make(map[chan Entry]struct{}),
+	}
+}
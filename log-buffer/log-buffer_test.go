@@ -0,0 +1,51 @@
+package logBuffer
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRing(t *testing.T) {
+	b := New(2)
+	_, _ = b.Write([]byte(`{"level":"info","message":"1"}`))
+	_, _ = b.Write([]byte(`{"level":"info","message":"2"}`))
+	_, _ = b.Write([]byte(`{"level":"info","message":"3"}`))
+	entries := b.List(Filter{})
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if string(entries[0].Raw) != `{"level":"info","message":"2"}` {
+		t.Fatalf("unexpected first entry: %s", entries[0].Raw)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	b := New(10)
+	_, _ = b.Write([]byte(`{"level":"debug","component":"dns","message":"1"}`))
+	_, _ = b.Write([]byte(`{"level":"error","component":"dns","message":"2"}`))
+	_, _ = b.Write([]byte(`{"level":"error","message":"3"}`))
+	if entries := b.List(Filter{Level: zerolog.WarnLevel}); len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries := b.List(Filter{Level: zerolog.WarnLevel, Component: "dns"}); len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := New(10)
+	ch, unsubscribe := b.Subscribe(1)
+	_, _ = b.Write([]byte(`{"level":"info","message":"1"}`))
+	entry := <-ch
+	if entry.Level != zerolog.InfoLevel {
+		t.Fatalf("unexpected level: %s", entry.Level)
+	}
+	unsubscribe()
+	_, _ = b.Write([]byte(`{"level":"info","message":"2"}`))
+	select {
+	case <-ch:
+		t.Fatal("received entry after unsubscribe")
+	default:
+	}
+}
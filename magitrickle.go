@@ -6,35 +6,86 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"magitrickle/app-errors"
+	"magitrickle/decisions"
+	"magitrickle/dedup"
+	"magitrickle/devices"
+	"magitrickle/discovery"
+	"magitrickle/dns-capture"
 	"magitrickle/dns-mitm-proxy"
+	"magitrickle/fleet"
 	"magitrickle/group"
+	"magitrickle/history"
+	"magitrickle/learning"
+	"magitrickle/mark-allocator"
+	"magitrickle/match-events"
+	"magitrickle/metrics"
 	"magitrickle/models"
+	"magitrickle/net-namespace"
 	"magitrickle/netfilter-helper"
+	"magitrickle/notify"
 	"magitrickle/records"
+	"magitrickle/summary"
 
 	"github.com/miekg/dns"
 	"github.com/rs/zerolog/log"
 	"github.com/vishvananda/netlink"
-	"github.com/vishvananda/netlink/nl"
 )
 
 var (
-	ErrAlreadyRunning           = errors.New("already running")
-	ErrGroupIDConflict          = errors.New("group id conflict")
-	ErrRuleIDConflict           = errors.New("rule id conflict")
-	ErrConfigUnsupportedVersion = errors.New("config unsupported version")
+	ErrAlreadyRunning           = appErrors.New(appErrors.ErrConflict, "already running")
+	ErrNotRunning               = appErrors.New(appErrors.ErrConflict, "not running")
+	ErrStopping                 = appErrors.New(appErrors.ErrConflict, "stopping")
+	ErrGroupIDConflict          = appErrors.New(appErrors.ErrConflict, "group id conflict")
+	ErrGroupSlugConflict        = appErrors.New(appErrors.ErrConflict, "group slug conflict")
+	ErrGroupNotFound            = appErrors.New(appErrors.ErrNotFound, "group not found")
+	ErrProxyPortConflict        = appErrors.New(appErrors.ErrConflict, "proxy port conflict")
+	ErrRuleIDConflict           = appErrors.New(appErrors.ErrConflict, "rule id conflict")
+	ErrConfigUnsupportedVersion = appErrors.New(appErrors.ErrValidation, "config unsupported version")
+	ErrInvalidLocalRecord       = appErrors.New(appErrors.ErrValidation, "invalid local zone record")
+	ErrUnknownDNSSECMode        = appErrors.New(appErrors.ErrValidation, "unknown DNSSEC mode")
+	ErrUnknownUpstreamSource    = appErrors.New(appErrors.ErrValidation, "unknown upstream source")
+	ErrInvalidUpstream          = appErrors.New(appErrors.ErrValidation, "invalid upstream")
+	ErrInvalidDNSRoute          = appErrors.New(appErrors.ErrValidation, "invalid DNS route")
+	ErrInvalidEDNSSize          = appErrors.New(appErrors.ErrValidation, "invalid EDNS size")
+	ErrInvalidNotifier          = appErrors.New(appErrors.ErrValidation, "invalid notifier")
+	ErrInvalidAPIToken          = appErrors.New(appErrors.ErrValidation, "invalid API token")
+	ErrUnknownLeasesFormat      = appErrors.New(appErrors.ErrValidation, "unknown DHCP leases format")
+	ErrSubscriptionNotFound     = appErrors.New(appErrors.ErrNotFound, "subscription not found")
+	ErrSubscriptionNotHeld      = appErrors.New(appErrors.ErrConflict, "subscription has no held update")
+	ErrDomainNotCached          = appErrors.New(appErrors.ErrNotFound, "domain is not cached")
+	ErrInvalidDoHBlock          = appErrors.New(appErrors.ErrValidation, "invalid DoH block")
+	ErrUnknownOwnQueriesAction  = appErrors.New(appErrors.ErrValidation, "unknown own queries action")
+	ErrInvalidDNSPort           = appErrors.New(appErrors.ErrValidation, "invalid DNS port")
+	ErrInvalidStatsPath         = appErrors.New(appErrors.ErrValidation, "stats path must be absolute")
+	ErrUnknownStatsFormat       = appErrors.New(appErrors.ErrValidation, "unknown stats format")
+	ErrInvalidVerify            = appErrors.New(appErrors.ErrValidation, "invalid answer verification")
+	ErrUnknownConfigMode        = appErrors.New(appErrors.ErrValidation, "unknown config mode")
 )
 
+// DefaultFixProtect allows new connections through the interface in the firewall of Keenetic
+var DefaultFixProtect = []models.IPTablesSnippet{
+	{Table: "filter", Chain: "_NDM_SL_FORWARD", Rule: "-o {interface} -m state --state NEW -j _NDM_SL_PROTECT"},
+}
+
 var DefaultAppConfig = models.App{
 	DNSProxy: models.DNSProxy{
-		Host:            models.DNSProxyServer{Address: "[::]", Port: 3553},
-		Upstream:        models.DNSProxyServer{Address: "127.0.0.1", Port: 53},
-		DisableRemap53:  false,
-		DisableFakePTR:  false,
-		DisableDropAAAA: false,
+		Host:             models.DNSProxyServer{Address: "[::]", Port: 3553},
+		Upstream:         models.DNSProxyServer{Address: "127.0.0.1", Port: 53},
+		DisableRemap53:   false,
+		DisableFakePTR:   false,
+		DisableDropAAAA:  false,
+		EDNSSize:         dnsMitmProxy.DefaultUDPSize,
+		LocalZone:        models.LocalZone{TTL: 300},
+		ExplainDecisions: 100,
+		ResolvConf:       "/etc/resolv.conf",
 	},
 	Netfilter: models.Netfilter{
 		IPTables: models.IPTables{
@@ -44,6 +95,19 @@ var DefaultAppConfig = models.App{
 			TablePrefix:   "mt_",
 			AdditionalTTL: 3600,
 		},
+		MarksPath:  "/opt/var/lib/magitrickle/marks.json",
+		FixProtect: DefaultFixProtect,
+	},
+	GroupStatePath: "/opt/var/lib/magitrickle/groups.json",
+	API: models.API{
+		Host:    models.APIServer{Address: "[::]", Port: 8080},
+		Disable: false,
+	},
+	History: models.History{
+		Retention: 168,
+	},
+	Fleet: models.Fleet{
+		Interval: 300,
 	},
 	Link:     []string{"br0"},
 	LogLevel: "info",
@@ -57,14 +121,50 @@ type App struct {
 	nfHelper4 *netfilterHelper.NetfilterHelper
 	nfHelper6 *netfilterHelper.NetfilterHelper
 	records   *records.Records
+	dedup     *dedup.Cache
+	capture   *dnsCapture.Ring
+	decisions *decisions.Ring
+	learner   *learning.Learner
+	discovery *discovery.Sessions
+	dnsRoutes []dnsRoute
+	labels    *resolverLabels
+	verifier  *answerVerifier
 	groups    []*group.Group
-
-	isRunning     bool
+	marks     *markAllocator.Allocator
+	handoff   *Handoff // state of the previous daemon for the next start (see ImportHandoff)
+
+	groupState    *groupState
+	subscriptions subscriptions
+	doh           dohBlocker
+
+	matchEvents *matchEvents.Publisher
+	history     *history.Store
+	devices     *devices.Inventory
+	leases      *devices.LeaseFile
+	stats       *summary.Collector
+	metrics     *metrics.Ring
+	notifier    *notify.Notifier
+	lan         atomic.Pointer[lanAddresses]
+	wanUpstream models.DNSProxyServer // resolver of the WAN used instead of the configured upstream
+
+	lifecycle     lifecycle
+	memoryBackend bool // groups are kept in memory instead of netfilter (see ServeHarness)
 	dnsOverrider4 *netfilterHelper.PortRemap
 	dnsOverrider6 *netfilterHelper.PortRemap
+
+	controlSocket controlSocketCounters
+	statsExported string // day of the last daily stats export
+
+	health struct {
+		dnsUDPListening    atomic.Bool
+		dnsTCPListening    atomic.Bool
+		netfilterInstalled atomic.Bool
+		loopHeartbeat      atomic.Pointer[time.Time]
+	}
 }
 
 func (a *App) handleLink(event netlink.LinkUpdate) {
+	a.updateInterfaceState(event)
 	switch event.Change {
 	case 0x00000001:
 		log.Trace().
@@ -73,7 +173,8 @@ func (a *App) handleLink(event netlink.LinkUpdate) {
 			Msg("interface event")
 		ifaceName := event.Link.Attrs().Name
 		for _, group := range a.groups {
-			if group.Interface != ifaceName {
+			// Gateway groups without an interface may become reachable through any interface
+			if group.Interface != ifaceName && (group.Interface != "" || group.Gateway == "") {
 				continue
 			}
 
@@ -98,72 +199,156 @@ func (a *App) handleLink(event netlink.LinkUpdate) {
 	}
 }
 
-func (a *App) start(ctx context.Context) (err error) {
-	a.dnsMITM = &dnsMitmProxy.DNSMITMProxy{
+// newDNSProxy creates the DNS proxy with middlewares of the config, responses are passed to the pipeline
+func (a *App) newDNSProxy() *dnsMitmProxy.DNSMITMProxy {
+	dnsMITM := &dnsMitmProxy.DNSMITMProxy{
 		UpstreamDNSAddress: a.config.DNSProxy.Upstream.Address,
 		UpstreamDNSPort:    a.config.DNSProxy.Upstream.Port,
-		RequestHook: func(clientAddr net.Addr, reqMsg dns.Msg, network string) (*dns.Msg, *dns.Msg, error) {
-			if a.config.DNSProxy.DisableFakePTR {
-				return nil, nil, nil
+		RaceDNSAddress:     a.config.DNSProxy.RaceUpstream.Address,
+		RaceDNSPort:        a.config.DNSProxy.RaceUpstream.Port,
+		DNSSEC:             dnssecMode(a.config.DNSProxy.DNSSEC),
+		Coalesce:           !a.config.DNSProxy.DisableCoalesce,
+		UDPSize:            a.config.DNSProxy.EDNSSize,
+		OnUpstream: func(err error) {
+			a.stats.Upstream(time.Now(), err)
+		},
+		OnResponse: func(clientAddr net.Addr, reqMsg dns.Msg, respMsg dns.Msg, network string) {
+			a.stats.Query(time.Now())
+			a.observeDiscovery(clientAddr, &reqMsg)
+			if a.labels != nil {
+				a.labelResolver(clientAddr, &reqMsg, &respMsg)
 			}
-
-			// TODO: Проверить на интерфейс
-			if len(reqMsg.Question) == 1 && reqMsg.Question[0].Qtype == dns.TypePTR {
-				respMsg := &dns.Msg{
-					MsgHdr: dns.MsgHdr{
-						Id:                 reqMsg.Id,
-						Response:           true,
-						RecursionAvailable: true,
-						Rcode:              dns.RcodeNameError,
-					},
-					Question: reqMsg.Question,
-				}
-				return nil, respMsg, nil
+			// The client gets the original answer, the verified one is only routed
+			routedMsg := a.verifyAnswer(&respMsg)
+			matched := a.handleMessage(*routedMsg, clientAddr, &network)
+			if matched && a.capture != nil {
+				a.captureTransaction(clientAddr, &reqMsg, &respMsg)
+			}
+			if !matched && a.learner != nil {
+				a.learn(clientAddr, routedMsg)
 			}
-
-			return nil, nil, nil
 		},
-		ResponseHook: func(clientAddr net.Addr, reqMsg dns.Msg, respMsg dns.Msg, network string) (*dns.Msg, error) {
-			defer a.handleMessage(respMsg, clientAddr, &network)
+	}
+	if a.config.DNSProxy.RecoverClient {
+		dnsMITM.ResolveClient = a.originalClient
+	}
+	switch a.config.DNSProxy.OwnQueries.Action {
+	case models.OwnQueriesForward, models.OwnQueriesRefuse:
+		dnsMITM.Bypass = a.ownQuery
+	}
+	a.dnsRoutes = compileDNSRoutes(a.config.DNSProxy)
+	if len(a.dnsRoutes) != 0 {
+		dnsMITM.Route = a.routeUpstreams
+	}
+	a.verifier = compileVerify(a.config.DNSProxy.Verify)
+	a.labels = nil
+	for _, route := range a.dnsRoutes {
+		if route.iface != "" {
+			a.labels = newResolverLabels()
+			break
+		}
+	}
+	if len(a.config.DNSProxy.LocalZone.Records) != 0 {
+		dnsMITM.Use(dnsMitmProxy.LocalZone(localZoneRecords(a.config.DNSProxy.LocalZone.Records), a.config.DNSProxy.LocalZone.TTL))
+	}
+	if !a.config.DNSProxy.DisableFakePTR {
+		dnsMITM.Use(dnsMitmProxy.FakePTR(a.isLANClient))
+	}
+	if a.config.DNSProxy.StripECS {
+		dnsMITM.Use(dnsMitmProxy.StripECS())
+	}
+	if a.config.DNSProxy.TTLClamp.Min != 0 || a.config.DNSProxy.TTLClamp.Max != 0 {
+		dnsMITM.Use(dnsMitmProxy.ClampTTL(a.config.DNSProxy.TTLClamp.Min, a.config.DNSProxy.TTLClamp.Max))
+	}
+	if !a.config.DNSProxy.DisableDropAAAA {
+		dnsMITM.Use(dnsMitmProxy.FilterAAAA(nil))
+	} else {
+		// Groups without routeIPv6 still keep their domains off IPv6
+		dnsMITM.Use(dnsMitmProxy.FilterAAAA(a.dropsAAAA))
+	}
+	return dnsMITM
+}
 
-			if a.config.DNSProxy.DisableDropAAAA {
-				return nil, nil
-			}
+// initPipeline resets the records store used by the record to rule to ipset pipeline
+func (a *App) initPipeline() {
+	a.records = records.New()
+	if a.config.DNSProxy.DedupWindow != 0 {
+		a.dedup = dedup.New(time.Duration(a.config.DNSProxy.DedupWindow) * time.Second)
+	}
+	a.capture = nil
+	if a.config.DNSProxy.CaptureMatched != 0 {
+		a.capture = dnsCapture.New(int(a.config.DNSProxy.CaptureMatched))
+	}
+	a.learner = nil
+	if a.config.DNSProxy.LearnUnmatched {
+		a.learner = learning.New()
+	}
+	a.decisions = nil
+	if a.config.DNSProxy.ExplainDecisions != 0 {
+		a.decisions = decisions.New(int(a.config.DNSProxy.ExplainDecisions))
+	}
+}
 
-			var idx int
-			for _, answer := range respMsg.Answer {
-				if answer.Header().Rrtype == dns.TypeAAAA {
-					continue
-				}
-				respMsg.Answer[idx] = answer
-				idx++
-			}
-			respMsg.Answer = respMsg.Answer[:idx]
+func (a *App) start(ctx context.Context) (err error) {
+	a.dnsMITM = a.newDNSProxy()
+	a.wanUpstream = models.DNSProxyServer{}
+	a.refreshUpstream()
+	a.initPipeline()
+
+	if a.config.MatchEvents.Socket != "" {
+		a.matchEvents = matchEvents.New(a.config.MatchEvents.Socket)
+		defer func() { _ = a.matchEvents.Close() }()
+	}
 
-			return &respMsg, nil
-		},
+	if a.config.History.Path != "" {
+		a.history, err = history.Open(a.config.History.Path, time.Duration(a.config.History.Retention)*time.Hour)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = a.history.Close()
+			a.history = nil
+		}()
 	}
-	a.records = records.New()
 
-	nh4, err := netfilterHelper.New(false)
+	err = a.requireCapabilities()
 	if err != nil {
-		return fmt.Errorf("netfilter helper init fail: %w", err)
+		return err
 	}
-	err = nh4.CleanIPTables(a.config.Netfilter.IPTables.ChainPrefix)
+
+	err = a.enterNetns()
 	if err != nil {
-		return fmt.Errorf("failed to clear iptables: %w", err)
+		return err
+	}
+
+	caps, err := netfilterHelper.Detect(a.config.Netfilter.IPSet.TablePrefix + "probe")
+	if err != nil {
+		return err
 	}
-	a.nfHelper4 = nh4
 
-	nh6, err := netfilterHelper.New(true)
+	nh4, err := netfilterHelper.New(false)
 	if err != nil {
 		return fmt.Errorf("netfilter helper init fail: %w", err)
 	}
-	err = nh6.CleanIPTables(a.config.Netfilter.IPTables.ChainPrefix)
+	err = nh4.CleanIPTables(a.config.Netfilter.IPTables.ChainPrefix)
 	if err != nil {
 		return fmt.Errorf("failed to clear iptables: %w", err)
 	}
-	a.nfHelper6 = nh6
+	a.nfHelper4 = nh4
+
+	// Without ip6tables only IPv4 DNS is remapped
+	a.nfHelper6, a.dnsOverrider6 = nil, nil
+	if caps.IPv6 {
+		nh6, err := netfilterHelper.New(true)
+		if err != nil {
+			return fmt.Errorf("netfilter helper init fail: %w", err)
+		}
+		err = nh6.CleanIPTables(a.config.Netfilter.IPTables.ChainPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to clear iptables: %w", err)
+		}
+		a.nfHelper6 = nh6
+	}
 
 	newCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -171,90 +356,136 @@ func (a *App) start(ctx context.Context) (err error) {
 	errChan := make(chan error)
 
 	/*
-		DNS Proxy
+		Groups (before DNS Proxy, so preloaded addresses are routed right away)
 	*/
 
-	go func() {
-		addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", a.config.DNSProxy.Host.Address, a.config.DNSProxy.Host.Port))
+	// Groups are destroyed even if the start fails halfway, changes made while running are kept for the next start
+	groupsAdded := false
+	defer func() {
+		for _, group := range a.groups {
+			if groupsAdded {
+				err := group.SavePreload()
+				if err != nil {
+					log.Error().Str("group", group.ID.String()).Err(err).Msg("failed to save preload file")
+				}
+			}
+			_ = group.Destroy()
+		}
+		if groupsAdded {
+			a.unprocessedGroups = a.ExportConfig().Groups
+		}
+		a.groups = nil
+	}()
+	for _, group := range a.unprocessedGroups {
+		err := a.AddGroup(group)
 		if err != nil {
-			errChan <- fmt.Errorf("failed to resolve udp address: %v", err)
-			return
+			return err
 		}
-		err = a.dnsMITM.ListenUDP(newCtx, addr)
+	}
+	groupsAdded = true
+	for _, group := range a.groups {
+		err = a.enableGroup(group)
 		if err != nil {
-			errChan <- fmt.Errorf("failed to serve DNS UDP proxy: %v", err)
-			return
+			return fmt.Errorf("failed to enable group: %w", err)
 		}
-	}()
+		count, err := group.LoadPreload(a.config.Netfilter.IPSet.AdditionalTTL)
+		if err != nil {
+			log.Error().Str("group", group.ID.String()).Err(err).Msg("failed to preload addresses")
+		} else if count != 0 {
+			log.Info().Str("group", group.ID.String()).Int("addresses", count).Msg("preloaded addresses")
+		}
+	}
+	a.restoreHandoff()
+
+	err = a.startDoHBlock()
+	if err != nil {
+		return err
+	}
+	defer a.stopDoHBlock()
+
+	/*
+		DNS Proxy
+	*/
 
+	dnsAddr := net.JoinHostPort(a.config.DNSProxy.Host.Address, strconv.Itoa(int(a.config.DNSProxy.Host.Port)))
+
+	udpConn, err := listenPacket(dnsAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen udp port: %w", err)
+	}
+	a.health.dnsUDPListening.Store(true)
 	go func() {
-		addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", a.config.DNSProxy.Host.Address, a.config.DNSProxy.Host.Port))
+		defer a.health.dnsUDPListening.Store(false)
+		err := a.dnsMITM.ServeUDP(newCtx, udpConn)
 		if err != nil {
-			errChan <- fmt.Errorf("failed to resolve tcp address: %v", err)
-			return
+			errChan <- fmt.Errorf("failed to serve DNS UDP proxy: %v", err)
 		}
-		err = a.dnsMITM.ListenTCP(newCtx, addr)
+	}()
+
+	tcpListener, err := listenStream(dnsAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen tcp port: %w", err)
+	}
+	a.health.dnsTCPListening.Store(true)
+	go func() {
+		defer a.health.dnsTCPListening.Store(false)
+		err := a.dnsMITM.ServeTCP(newCtx, tcpListener)
 		if err != nil {
 			errChan <- fmt.Errorf("failed to serve DNS TCP proxy: %v", err)
-			return
 		}
 	}()
 
-	var addrList []netlink.Addr
-	for _, linkName := range a.config.Link {
-		link, err := netlink.LinkByName(linkName)
-		if err != nil {
-			return fmt.Errorf("failed to find link %s: %w", linkName, err)
-		}
-		linkAddrList, err := netlink.AddrList(link, nl.FAMILY_ALL)
-		if err != nil {
-			return fmt.Errorf("failed to list address of interface: %w", err)
-		}
-		addrList = append(addrList, linkAddrList...)
+	err = a.serveDNSListeners(newCtx, errChan)
+	if err != nil {
+		return err
+	}
+
+	addrList, err := a.linkAddresses()
+	if err != nil {
+		return err
 	}
+	a.setLANAddresses(addrList)
 
+	// While paused the remap is created disabled, Resume enables it
 	if !a.config.DNSProxy.DisableRemap53 {
 		a.dnsOverrider4 = a.nfHelper4.PortRemap(fmt.Sprintf("%sDNSOR", a.config.Netfilter.IPTables.ChainPrefix), 53, a.config.DNSProxy.Host.Port, addrList)
-		err = a.dnsOverrider4.Enable()
-		if err != nil {
-			return fmt.Errorf("failed to override DNS (IPv4): %v", err)
+		a.dnsOverrider4.Additional = a.dnsPortMappings()
+		if !a.Paused() {
+			err = a.dnsOverrider4.Enable()
+			if err != nil {
+				return fmt.Errorf("failed to override DNS (IPv4): %v", err)
+			}
 		}
 		defer func() { _ = a.dnsOverrider4.Disable() }()
 
-		a.dnsOverrider6 = a.nfHelper6.PortRemap(fmt.Sprintf("%sDNSOR", a.config.Netfilter.IPTables.ChainPrefix), 53, a.config.DNSProxy.Host.Port, addrList)
-		err = a.dnsOverrider6.Enable()
-		if err != nil {
-			return fmt.Errorf("failed to override DNS (IPv6): %v", err)
+		if a.nfHelper6 != nil {
+			a.dnsOverrider6 = a.nfHelper6.PortRemap(fmt.Sprintf("%sDNSOR", a.config.Netfilter.IPTables.ChainPrefix), 53, a.config.DNSProxy.Host.Port, addrList)
+			a.dnsOverrider6.Additional = a.dnsPortMappings()
+			if !a.Paused() {
+				err = a.dnsOverrider6.Enable()
+				if err != nil {
+					return fmt.Errorf("failed to override DNS (IPv6): %v", err)
+				}
+			}
+			defer func() { _ = a.dnsOverrider6.Disable() }()
 		}
-		defer func() { _ = a.dnsOverrider6.Disable() }()
 	}
 
-	/*
-		Groups
-	*/
-
-	for _, group := range a.unprocessedGroups {
-		err := a.AddGroup(group)
-		if err != nil {
-			return err
-		}
+	err = a.startProxyForwarders(newCtx, errChan)
+	if err != nil {
+		return err
 	}
-	for _, group := range a.groups {
-		err = group.Enable()
-		if err != nil {
-			return fmt.Errorf("failed to enable group: %w", err)
-		}
+	a.health.netfilterInstalled.Store(true)
+	defer a.health.netfilterInstalled.Store(false)
+
+	if a.config.WarmUp {
+		go a.warmUp(newCtx)
 	}
-	defer func() {
-		for _, group := range a.groups {
-			_ = group.Destroy()
-		}
-	}()
 
 	/*
 		Socket (for netfilter.d events)
 	*/
-	socketPath := "/opt/var/run/magitrickle.sock"
+	socketPath := ControlSocketPath
 	err = os.Remove(socketPath)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("failed to remove existed UNIX socket: %w", err)
@@ -268,69 +499,95 @@ func (a *App) start(ctx context.Context) (err error) {
 		_ = os.Remove(socketPath)
 	}()
 
-	go func() {
-		for {
-			if newCtx.Err() != nil {
-				return
-			}
-
-			conn, err := socket.Accept()
-			if err != nil {
-				if !strings.Contains(err.Error(), "use of closed network connection") {
-					log.Error().Err(err).Msg("error while listening unix socket")
-				}
-				break
-			}
-
-			go func(conn net.Conn) {
-				defer func() { _ = conn.Close() }()
-
-				buf := make([]byte, 1024)
-				n, err := conn.Read(buf)
-				if err != nil {
-					return
-				}
-
-				args := strings.Split(string(buf[:n]), ":")
-				if len(args) == 3 && args[0] == "netfilter.d" {
-					log.Debug().Str("table", args[2]).Msg("netfilter.d event")
-					err = a.dnsOverrider4.NetfilterDHook(args[2])
-					if err != nil {
-						log.Error().Err(err).Msg("error while fixing iptables after netfilter.d")
-					}
-					err = a.dnsOverrider6.NetfilterDHook(args[2])
-					if err != nil {
-						log.Error().Err(err).Msg("error while fixing iptables after netfilter.d")
-					}
-					for _, group := range a.groups {
-						err := group.NetfilterDHook(args[2])
-						if err != nil {
-							log.Error().Err(err).Msg("error while fixing iptables after netfilter.d")
-						}
-					}
-				}
-			}(conn)
-		}
-	}()
+	go a.serveControlSocket(newCtx, socket)
 
 	/*
 		Interface updates
 	*/
 	linkUpdateChannel := make(chan netlink.LinkUpdate)
 	linkUpdateDone := make(chan struct{})
-	err = netlink.LinkSubscribe(linkUpdateChannel, linkUpdateDone)
+	err = netlink.LinkSubscribeWithOptions(linkUpdateChannel, linkUpdateDone, netlink.LinkSubscribeOptions{
+		Namespace: netNamespace.Handle(),
+	})
 	if err != nil {
 		return fmt.Errorf("failed to subscribe to link updates: %w", err)
 	}
 	defer close(linkUpdateDone)
 
+	addrUpdateDone := make(chan struct{})
+	addrUpdateChannel, err := subscribeAddr(addrUpdateDone)
+	if err != nil {
+		return err
+	}
+	defer close(addrUpdateDone)
+
 	/*
 		Global loop
 	*/
+	heartbeatTicker := time.NewTicker(loopHeartbeatInterval)
+	defer heartbeatTicker.Stop()
+	a.heartbeat()
+	neighborsTicker := time.NewTicker(neighborsPollInterval)
+	defer neighborsTicker.Stop()
+	historyTicker := time.NewTicker(historyCompactInterval)
+	defer historyTicker.Stop()
+	summaryTicker := time.NewTicker(summaryRefreshInterval)
+	defer summaryTicker.Stop()
+	healTicker := time.NewTicker(healInterval)
+	defer healTicker.Stop()
+	upstreamTicker := time.NewTicker(upstreamRefreshInterval)
+	defer upstreamTicker.Stop()
+	expireTicker := time.NewTicker(recordsExpireInterval)
+	defer expireTicker.Stop()
+	subscriptionTicker := time.NewTicker(subscriptionCheckInterval)
+	defer subscriptionTicker.Stop()
+	a.subscriptions.results = make(chan subscriptionResult)
+	a.updateNeighbors()
+	a.initInterfaceStates()
+	a.refreshSummary()
+	a.checkStatsExport(time.Now())
+	a.checkSubscriptions(newCtx)
+	a.checkDoHList(newCtx)
+	a.started()
 	for {
 		select {
+		case <-heartbeatTicker.C:
+			a.heartbeat()
+			if addrUpdateChannel == nil {
+				addrUpdateChannel = a.resubscribeAddr(addrUpdateDone)
+			}
+		case <-neighborsTicker.C:
+			a.updateNeighbors()
+		case <-historyTicker.C:
+			err := a.history.Compact()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to compact history")
+			}
+		case <-summaryTicker.C:
+			a.refreshSummary()
+			a.checkStatsExport(time.Now())
+		case <-healTicker.C:
+			a.healGroups()
+		case <-upstreamTicker.C:
+			a.refreshUpstream()
+		case <-expireTicker.C:
+			a.expireRecords()
+		case <-subscriptionTicker.C:
+			a.checkSubscriptions(newCtx)
+			a.checkDoHList(newCtx)
+		case result := <-a.subscriptions.results:
+			a.handleSubscriptionResult(result)
+		case result := <-a.doh.results:
+			a.handleDoHListResult(result)
 		case event := <-linkUpdateChannel:
 			a.handleLink(event)
+		case event, ok := <-addrUpdateChannel:
+			if !ok {
+				log.Warn().Msg("address updates subscription closed, re-subscribing")
+				addrUpdateChannel = a.resubscribeAddr(addrUpdateDone)
+				continue
+			}
+			a.handleAddr(event)
 		case err := <-errChan:
 			return err
 		case <-ctx.Done():
@@ -339,13 +596,16 @@ func (a *App) start(ctx context.Context) (err error) {
 	}
 }
 
+// Start runs the app until the context is done or Stop is called. Everything installed by a failed
+// start is released, so the app can be started again.
 func (a *App) Start(ctx context.Context) (err error) {
-	if a.isRunning {
-		return ErrAlreadyRunning
+	ctx, err = a.begin(ctx)
+	if err != nil {
+		return err
 	}
-	a.isRunning = true
 	defer func() {
-		a.isRunning = false
+		a.resetHealth()
+		a.end()
 	}()
 
 	defer func() {
@@ -369,6 +629,12 @@ func (a *App) AddGroup(groupModel models.Group) error {
 		if groupModel.ID == group.ID {
 			return ErrGroupIDConflict
 		}
+		if groupModel.Slug != "" && groupModel.Slug == group.Slug {
+			return ErrGroupSlugConflict
+		}
+		if groupModel.Proxy.IsEnabled() && group.Proxy.IsEnabled() && groupModel.Proxy.Port == group.Proxy.Port {
+			return ErrProxyPortConflict
+		}
 	}
 	dup := make(map[[4]byte]struct{})
 	for _, rule := range groupModel.Rules {
@@ -378,40 +644,74 @@ func (a *App) AddGroup(groupModel models.Group) error {
 		dup[rule.ID] = struct{}{}
 	}
 
-	grp, err := group.NewGroup(groupModel, a.nfHelper4, a.config.Netfilter.IPTables.ChainPrefix, a.config.Netfilter.IPSet.TablePrefix)
+	grp, err := a.newGroup(groupModel)
 	if err != nil {
 		return fmt.Errorf("failed to create group: %w", err)
 	}
+	a.assignRouting(grp)
 	a.groups = append(a.groups, grp)
 
 	log.Debug().Str("id", grp.ID.String()).Str("name", grp.Name).Msg("added group")
 
-	if a.isRunning {
+	if a.isRunning() {
+		err = a.enableGroup(grp)
+		if err != nil {
+			return fmt.Errorf("failed to enable group: %w", err)
+		}
 		return grp.Sync(a.records)
 	}
 	return nil
 }
 
-func (a *App) ListInterfaces() ([]net.Interface, error) {
-	interfaceNames := make([]net.Interface, 0)
-
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get interfaces: %w", err)
+// FindGroup returns the group by its slug or hex ID
+func (a *App) FindGroup(key string) (models.Group, bool) {
+	for _, group := range a.ExportConfig().Groups {
+		if group.HasKey(key) {
+			return group, true
+		}
 	}
+	return models.Group{}, false
+}
 
-	for _, iface := range interfaces {
-		if iface.Flags&net.FlagPointToPoint == 0 {
+// GroupAddresses returns addresses of the group ipset (would-be contents for shadow groups)
+func (a *App) GroupAddresses(key string) ([]string, error) {
+	for _, group := range a.groups {
+		if !group.HasKey(key) {
 			continue
 		}
-
-		interfaceNames = append(interfaceNames, iface)
+		addresses, err := group.ListIP()
+		if err != nil {
+			return nil, err
+		}
+		list := make([]string, 0, len(addresses))
+		for addr := range addresses {
+			list = append(list, net.IP(addr).String())
+		}
+		sort.Strings(list)
+		return list, nil
 	}
+	return nil, ErrGroupNotFound
+}
 
-	return interfaceNames, nil
+// ResyncGroup rebuilds ipsets of the group (slug or hex ID) from its static rules and known addresses,
+// new ipsets are swapped in at once
+func (a *App) ResyncGroup(key string) error {
+	for _, group := range a.groups {
+		if !group.HasKey(key) {
+			continue
+		}
+		err := group.Resync(a.records)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("group", group.ID.String()).Msg("resynced group")
+		return nil
+	}
+	return ErrGroupNotFound
 }
 
-func (a *App) processARecord(aRecord dns.A, clientAddr net.Addr, network *string) {
+// processARecord adds the address to groups with matching rules, it reports whether any rule matched
+func (a *App) processARecord(aRecord dns.A, clientAddr net.Addr, network *string, qtype uint16) bool {
 	var clientAddrStr, networkStr string
 	if clientAddr != nil {
 		clientAddrStr = clientAddr.String()
@@ -432,37 +732,81 @@ func (a *App) processARecord(aRecord dns.A, clientAddr net.Addr, network *string
 	a.records.AddARecord(aRecord.Hdr.Name[:len(aRecord.Hdr.Name)-1], aRecord.A, ttlDuration)
 
 	names := a.records.GetAliases(aRecord.Hdr.Name[:len(aRecord.Hdr.Name)-1])
-	for _, group := range a.groups {
-	Rule:
-		for _, domain := range group.Rules {
-			if !domain.IsEnabled() {
-				continue
-			}
-			for _, name := range names {
-				if !domain.IsMatch(name) {
-					continue
-				}
-				// TODO: Check already existed
-				err := group.AddIP(aRecord.A, ttlDuration)
-				if err != nil {
-					log.Error().
-						Str("address", aRecord.A.String()).
-						Err(err).
-						Msg("failed to add address")
-				} else {
-					log.Debug().
-						Str("address", aRecord.A.String()).
-						Str("aRecordDomain", aRecord.Hdr.Name).
-						Str("cNameDomain", name).
-						Msg("add address")
-				}
-				break Rule
+	a.blockDoH(names, aRecord.A, ttlDuration)
+	ctx := a.matchContext(clientAddr, time.Now(), qtype)
+	matches := a.matchGroups("A", aRecord.Hdr.Name[:len(aRecord.Hdr.Name)-1], names, ctx)
+	matches = a.retargetResolver(matches, names)
+	// Expired records remove their addresses from ipsets, so the record lives as long as the longest rule TTL
+	recordTTL := ttlDuration
+	for _, match := range matches {
+		recordTTL = max(recordTTL, a.ruleTTL(match.rule, aRecord.Hdr.Ttl))
+	}
+	if recordTTL != ttlDuration {
+		a.records.AddARecord(aRecord.Hdr.Name[:len(aRecord.Hdr.Name)-1], aRecord.A, recordTTL)
+	}
+	for _, match := range matches {
+		group, rule, name := match.group, match.rule, match.name
+		ttl := a.ruleTTL(rule, aRecord.Hdr.Ttl)
+		if rule.IsLog() {
+			log.Info().
+				Str("group", group.ID.String()).
+				Str("rule", rule.ID.String()).
+				Str("address", aRecord.A.String()).
+				Str("aRecordDomain", aRecord.Hdr.Name).
+				Str("cNameDomain", name).
+				Msg("log rule matched")
+			a.publishMatch(group, rule, name, aRecord.Hdr.Name[:len(aRecord.Hdr.Name)-1], aRecord.A, ttl)
+			continue
+		}
+		if !rule.IsExclude() && !group.RoutesIPv4() {
+			continue
+		}
+		if rule.IsExclude() {
+			err := group.AddExcludedIP(aRecord.A, ttl)
+			if err != nil {
+				log.Error().
+					Str("address", aRecord.A.String()).
+					Err(err).
+					Msg("failed to exclude address")
+			} else {
+				log.Debug().
+					Str("address", aRecord.A.String()).
+					Str("aRecordDomain", aRecord.Hdr.Name).
+					Str("cNameDomain", name).
+					Msg("exclude address")
 			}
+			continue
+		}
+		// TODO: Check already existed
+		err := group.AddIP(aRecord.A, ttl)
+		if err != nil {
+			log.Error().
+				Str("address", aRecord.A.String()).
+				Err(err).
+				Msg("failed to add address")
+		} else {
+			log.Debug().
+				Str("address", aRecord.A.String()).
+				Str("aRecordDomain", aRecord.Hdr.Name).
+				Str("cNameDomain", name).
+				Msg("add address")
+			a.publishMatch(group, rule, name, aRecord.Hdr.Name[:len(aRecord.Hdr.Name)-1], aRecord.A, ttl)
 		}
 	}
+	return len(matches) != 0
 }
 
-func (a *App) processCNameRecord(cNameRecord dns.CNAME, clientAddr net.Addr, network *string) {
+// ruleTTL returns the ipset timeout of addresses of the record matched by the rule: the TTL of the record
+// and the additional TTL of the rule or of the config
+func (a *App) ruleTTL(rule *models.Rule, ttl uint32) uint32 {
+	if rule.AdditionalTTL != nil {
+		return ttl + *rule.AdditionalTTL
+	}
+	return ttl + a.config.Netfilter.IPSet.AdditionalTTL
+}
+
+// processCNameRecord adds known addresses of the target to groups with matching rules, it reports whether any rule matched
+func (a *App) processCNameRecord(cNameRecord dns.CNAME, clientAddr net.Addr, network *string, qtype uint16) bool {
 	var clientAddrStr, networkStr string
 	if clientAddr != nil {
 		clientAddrStr = clientAddr.String()
@@ -486,50 +830,204 @@ func (a *App) processCNameRecord(cNameRecord dns.CNAME, clientAddr net.Addr, net
 	now := time.Now()
 	aRecords := a.records.GetARecords(cNameRecord.Hdr.Name[:len(cNameRecord.Hdr.Name)-1])
 	names := a.records.GetAliases(cNameRecord.Hdr.Name[:len(cNameRecord.Hdr.Name)-1])
-	for _, group := range a.groups {
-	Rule:
-		for _, domain := range group.Rules {
-			if !domain.IsEnabled() {
+	ctx := a.matchContext(clientAddr, now, qtype)
+	matches := a.matchGroups("CNAME", cNameRecord.Hdr.Name[:len(cNameRecord.Hdr.Name)-1], names, ctx)
+	matches = a.retargetResolver(matches, names)
+	for _, match := range matches {
+		group, rule, name := match.group, match.rule, match.name
+		if !rule.IsExclude() && !rule.IsLog() && !group.RoutesIPv4() {
+			continue
+		}
+		for _, aRecord := range aRecords {
+			if !aRecord.Deadline.After(now) {
 				continue
 			}
-			for _, name := range names {
-				if !domain.IsMatch(name) {
-					continue
-				}
-				for _, aRecord := range aRecords {
-					err := group.AddIP(aRecord.Address, uint32(now.Sub(aRecord.Deadline).Seconds()))
-					if err != nil {
-						log.Error().
-							Str("address", aRecord.Address.String()).
-							Err(err).
-							Msg("failed to add address")
-					} else {
-						log.Debug().
-							Str("address", aRecord.Address.String()).
-							Str("cNameDomain", name).
-							Msg("add address")
-					}
+			ttl := uint32(aRecord.Deadline.Sub(now).Seconds())
+			// The record holds the address for the global TTL, a shorter TTL of the rule is kept
+			if rule.AdditionalTTL != nil {
+				ttl = min(ttl, a.ruleTTL(rule, cNameRecord.Hdr.Ttl))
+			}
+			if rule.IsLog() {
+				log.Info().
+					Str("group", group.ID.String()).
+					Str("rule", rule.ID.String()).
+					Str("address", aRecord.Address.String()).
+					Str("cNameDomain", name).
+					Msg("log rule matched")
+				a.publishMatch(group, rule, name, cNameRecord.Target[:len(cNameRecord.Target)-1], aRecord.Address, ttl)
+				continue
+			}
+			if rule.IsExclude() {
+				err := group.AddExcludedIP(aRecord.Address, ttl)
+				if err != nil {
+					log.Error().
+						Str("address", aRecord.Address.String()).
+						Err(err).
+						Msg("failed to exclude address")
+				} else {
+					log.Debug().
+						Str("address", aRecord.Address.String()).
+						Str("cNameDomain", name).
+						Msg("exclude address")
 				}
-				continue Rule
+				continue
+			}
+			err := group.AddIP(aRecord.Address, ttl)
+			if err != nil {
+				log.Error().
+					Str("address", aRecord.Address.String()).
+					Err(err).
+					Msg("failed to add address")
+			} else {
+				log.Debug().
+					Str("address", aRecord.Address.String()).
+					Str("cNameDomain", name).
+					Msg("add address")
+				a.publishMatch(group, rule, name, cNameRecord.Target[:len(cNameRecord.Target)-1], aRecord.Address, ttl)
 			}
 		}
 	}
+	return len(matches) != 0
 }
 
-func (a *App) handleRecord(rr dns.RR, clientAddr net.Addr, network *string) {
+func (a *App) publishMatch(group *group.Group, rule *models.Rule, domain, name string, address net.IP, ttl uint32) {
+	now := time.Now()
+	err := a.history.Record(history.Entry{
+		Time:    now,
+		Group:   group.ID.String(),
+		Rule:    rule.ID.String(),
+		Domain:  domain,
+		Address: address,
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to record history")
+	}
+	a.stats.Match(now, domain, group.ID.String())
+	a.stats.Hit(group.ID.String(), rule.ID.String())
+	// Shadow groups and log rules route nothing, so consumers must not act on them
+	if group.Shadow || rule.IsLog() {
+		return
+	}
+	a.matchEvents.Publish(matchEvents.Event{
+		Time:      now,
+		Group:     group.ID.String(),
+		GroupName: group.Name,
+		Interface: group.Interface,
+		Domain:    domain,
+		Name:      name,
+		Address:   address,
+		TTL:       ttl,
+	})
+}
+
+// handleRecord passes the record to the pipeline, it reports whether any rule matched
+func (a *App) handleRecord(rr dns.RR, clientAddr net.Addr, network *string, qtype uint16) bool {
+	if !isQualified(rr.Header().Name) {
+		a.stats.Drop(summary.DropMalformedName, 1)
+		return false
+	}
+	var matched bool
 	switch v := rr.(type) {
 	case *dns.A:
-		a.processARecord(*v, clientAddr, network)
+		matched = a.processARecord(*v, clientAddr, network, qtype)
 	case *dns.CNAME:
-		a.processCNameRecord(*v, clientAddr, network)
+		if !isQualified(v.Target) {
+			a.stats.Drop(summary.DropMalformedName, 1)
+			return false
+		}
+		matched = a.processCNameRecord(*v, clientAddr, network, qtype)
+	case *dns.HTTPS:
+		matched = a.processSVCBHints(v.SVCB, clientAddr, network, qtype)
+	case *dns.SVCB:
+		matched = a.processSVCBHints(*v, clientAddr, network, qtype)
 	default:
+		a.stats.Drop(summary.DropUnsupportedType, 1)
+		return false
 	}
+	if !matched {
+		a.stats.Drop(summary.DropUnmatched, 1)
+	}
+	return matched
 }
 
-func (a *App) handleMessage(msg dns.Msg, clientAddr net.Addr, network *string) {
+// isQualified reports whether the name is a non-root fully qualified name, the pipeline strips its trailing dot
+func isQualified(name string) bool {
+	return len(name) > 1 && dns.IsFqdn(name)
+}
+
+// processSVCBHints handles ipv4hint addresses of HTTPS/SVCB records as A records of the owner name,
+// clients may connect to them without resolving A
+func (a *App) processSVCBHints(record dns.SVCB, clientAddr net.Addr, network *string, qtype uint16) bool {
+	matched := false
+	for _, value := range record.Value {
+		hint, ok := value.(*dns.SVCBIPv4Hint)
+		if !ok {
+			continue
+		}
+		for _, address := range hint.Hint {
+			if a.processARecord(dns.A{
+				Hdr: dns.RR_Header{Name: record.Hdr.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: record.Hdr.Ttl},
+				A:   address,
+			}, clientAddr, network, qtype) {
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// handleMessage passes records of the answer to the pipeline, it reports whether any rule matched
+func (a *App) handleMessage(msg dns.Msg, clientAddr net.Addr, network *string) bool {
+	if clientAddr == nil {
+		clientAddr = SystemClient
+	}
+	if ip := clientIP(clientAddr); ip != nil {
+		a.devices.Seen(ip)
+	}
+	if a.dedup != nil && !a.hasExpressionRules() && a.dedup.Seen(dedup.Key(msg), time.Now()) {
+		log.Trace().Str("name", questionName(msg)).Msg("skipping duplicate answer")
+		a.stats.Drop(summary.DropDuplicate, len(msg.Answer))
+		return false
+	}
+	var qtype uint16
+	if len(msg.Question) != 0 {
+		qtype = msg.Question[0].Qtype
+	}
+	matched := false
 	for _, rr := range msg.Answer {
-		a.handleRecord(rr, clientAddr, network)
+		if a.handleRecord(rr, clientAddr, network, qtype) {
+			matched = true
+		}
+	}
+	if !a.config.DNSProxy.ProcessExtra {
+		return matched
+	}
+	// Only addresses are taken from other sections, CNAME and SVCB records there don't describe the answer
+	for _, section := range [][]dns.RR{msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if _, ok := rr.(*dns.A); ok && a.handleRecord(rr, clientAddr, network, qtype) {
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// hasExpressionRules reports whether answers must be matched per client, so they can't be deduplicated
+func (a *App) hasExpressionRules() bool {
+	for _, group := range a.groups {
+		if group.HasExpressionRules() {
+			return true
+		}
+	}
+	return false
+}
+
+func questionName(msg dns.Msg) string {
+	if len(msg.Question) == 0 {
+		return ""
 	}
+	return msg.Question[0].Name
 }
 
 func (a *App) ImportConfig(cfg models.Config) error {
@@ -543,6 +1041,10 @@ func (a *App) ImportConfig(cfg models.Config) error {
 	if cfg.App.DNSProxy.Upstream.Port != 0 {
 		a.config.DNSProxy.Upstream.Port = cfg.App.DNSProxy.Upstream.Port
 	}
+	a.config.DNSProxy.RaceUpstream = cfg.App.DNSProxy.RaceUpstream
+	if a.config.DNSProxy.RaceUpstream.Address != "" && a.config.DNSProxy.RaceUpstream.Port == 0 {
+		a.config.DNSProxy.RaceUpstream.Port = 53
+	}
 	if cfg.App.DNSProxy.Host.Address != "" {
 		a.config.DNSProxy.Host.Address = cfg.App.DNSProxy.Host.Address
 	}
@@ -550,8 +1052,80 @@ func (a *App) ImportConfig(cfg models.Config) error {
 		a.config.DNSProxy.Host.Port = cfg.App.DNSProxy.Host.Port
 	}
 	a.config.DNSProxy.DisableRemap53 = cfg.App.DNSProxy.DisableRemap53
+	err := validateDNSPorts(cfg.App.DNSProxy)
+	if err != nil {
+		return err
+	}
+	a.config.DNSProxy.ExtraPorts = cfg.App.DNSProxy.ExtraPorts
+	a.config.DNSProxy.Listen = cfg.App.DNSProxy.Listen
 	a.config.DNSProxy.DisableFakePTR = cfg.App.DNSProxy.DisableFakePTR
+	for _, subnet := range cfg.App.DNSProxy.FakePTRSubnets {
+		_, err := models.ParseCIDR(subnet)
+		if err != nil {
+			return fmt.Errorf("invalid fake PTR subnet: %w", err)
+		}
+	}
+	a.config.DNSProxy.FakePTRSubnets = cfg.App.DNSProxy.FakePTRSubnets
 	a.config.DNSProxy.DisableDropAAAA = cfg.App.DNSProxy.DisableDropAAAA
+	a.config.DNSProxy.StripECS = cfg.App.DNSProxy.StripECS
+	a.config.DNSProxy.DisableCoalesce = cfg.App.DNSProxy.DisableCoalesce
+	if cfg.App.DNSProxy.EDNSSize != 0 {
+		if cfg.App.DNSProxy.EDNSSize < dns.MinMsgSize {
+			return fmt.Errorf("%w: %d is less than %d", ErrInvalidEDNSSize, cfg.App.DNSProxy.EDNSSize, dns.MinMsgSize)
+		}
+		a.config.DNSProxy.EDNSSize = cfg.App.DNSProxy.EDNSSize
+	}
+	a.config.DNSProxy.TTLClamp = cfg.App.DNSProxy.TTLClamp
+	a.config.DNSProxy.DedupWindow = cfg.App.DNSProxy.DedupWindow
+	a.config.DNSProxy.ProcessExtra = cfg.App.DNSProxy.ProcessExtra
+	a.config.DNSProxy.CaptureMatched = cfg.App.DNSProxy.CaptureMatched
+	a.config.DNSProxy.RecoverClient = cfg.App.DNSProxy.RecoverClient
+	switch cfg.App.DNSProxy.OwnQueries.Action {
+	case "", models.OwnQueriesProcess, models.OwnQueriesForward, models.OwnQueriesRefuse:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownOwnQueriesAction, cfg.App.DNSProxy.OwnQueries.Action)
+	}
+	a.config.DNSProxy.OwnQueries = cfg.App.DNSProxy.OwnQueries
+	a.config.DNSProxy.LearnUnmatched = cfg.App.DNSProxy.LearnUnmatched
+	err = validateVerify(cfg.App.DNSProxy.Verify)
+	if err != nil {
+		return err
+	}
+	a.config.DNSProxy.Verify = cfg.App.DNSProxy.Verify
+	if cfg.App.DNSProxy.ExplainDecisions != 0 {
+		a.config.DNSProxy.ExplainDecisions = cfg.App.DNSProxy.ExplainDecisions
+	}
+	for _, record := range cfg.App.DNSProxy.LocalZone.Records {
+		if _, ok := dns.IsDomainName(record.Name); !ok || record.Name == "" {
+			return fmt.Errorf("%w: name %q", ErrInvalidLocalRecord, record.Name)
+		}
+		if net.ParseIP(record.Address) == nil {
+			return fmt.Errorf("%w: address %q", ErrInvalidLocalRecord, record.Address)
+		}
+	}
+	switch cfg.App.DNSProxy.DNSSEC {
+	case "", models.DNSSECIgnore, models.DNSSECPreserve, models.DNSSECStrip:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownDNSSECMode, cfg.App.DNSProxy.DNSSEC)
+	}
+	a.config.DNSProxy.DNSSEC = cfg.App.DNSProxy.DNSSEC
+	switch cfg.App.DNSProxy.UpstreamSource {
+	case "", models.UpstreamSourceResolvConf, models.UpstreamSourceRCI:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownUpstreamSource, cfg.App.DNSProxy.UpstreamSource)
+	}
+	err = validateUpstreams(cfg.App.DNSProxy)
+	if err != nil {
+		return err
+	}
+	a.config.DNSProxy.UpstreamSource = cfg.App.DNSProxy.UpstreamSource
+	if cfg.App.DNSProxy.ResolvConf != "" {
+		a.config.DNSProxy.ResolvConf = cfg.App.DNSProxy.ResolvConf
+	}
+	a.config.DNSProxy.LocalZone.Records = cfg.App.DNSProxy.LocalZone.Records
+	if cfg.App.DNSProxy.LocalZone.TTL != 0 {
+		a.config.DNSProxy.LocalZone.TTL = cfg.App.DNSProxy.LocalZone.TTL
+	}
 	if cfg.App.Netfilter.IPTables.ChainPrefix != "" {
 		a.config.Netfilter.IPTables.ChainPrefix = cfg.App.Netfilter.IPTables.ChainPrefix
 	}
@@ -559,13 +1133,126 @@ func (a *App) ImportConfig(cfg models.Config) error {
 		a.config.Netfilter.IPSet.TablePrefix = cfg.App.Netfilter.IPSet.TablePrefix
 	}
 	a.config.Netfilter.IPSet.AdditionalTTL = cfg.App.Netfilter.IPSet.AdditionalTTL
+	if cfg.App.Netfilter.MarksPath != "" {
+		a.config.Netfilter.MarksPath = cfg.App.Netfilter.MarksPath
+	}
+	if len(cfg.App.Netfilter.FixProtect) != 0 {
+		for idx, rule := range cfg.App.Netfilter.FixProtect {
+			err := rule.ValidateFixProtect()
+			if err != nil {
+				return fmt.Errorf("fix protect rule %d: %w", idx, err)
+			}
+		}
+		a.config.Netfilter.FixProtect = cfg.App.Netfilter.FixProtect
+	}
+	if cfg.App.API.Host.Address != "" {
+		a.config.API.Host.Address = cfg.App.API.Host.Address
+	}
+	if cfg.App.API.Host.Port != 0 {
+		a.config.API.Host.Port = cfg.App.API.Host.Port
+	}
+	a.config.API.Disable = cfg.App.API.Disable
+	tokens := make(map[string]struct{}, len(cfg.App.API.Tokens))
+	for idx, token := range cfg.App.API.Tokens {
+		switch token.Role {
+		case models.APIRoleViewer, models.APIRoleAdmin:
+		default:
+			return fmt.Errorf("%w: token %d: unknown role %q", ErrInvalidAPIToken, idx, token.Role)
+		}
+		if token.Token == "" {
+			return fmt.Errorf("%w: token %d is empty", ErrInvalidAPIToken, idx)
+		}
+		if _, exists := tokens[token.Token]; exists {
+			return fmt.Errorf("%w: token %d is duplicated", ErrInvalidAPIToken, idx)
+		}
+		tokens[token.Token] = struct{}{}
+	}
+	a.config.API.Tokens = cfg.App.API.Tokens
+	switch cfg.App.DHCPLeases.Format {
+	case "", models.DHCPLeasesDnsmasq, models.DHCPLeasesISC:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownLeasesFormat, cfg.App.DHCPLeases.Format)
+	}
+	a.config.DHCPLeases = cfg.App.DHCPLeases
+	switch cfg.App.DoHBlock.Action {
+	case "", models.DoHBlockReject, models.DoHBlockDrop:
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidDoHBlock, cfg.App.DoHBlock.Action)
+	}
+	for _, address := range cfg.App.DoHBlock.Addresses {
+		_, err := models.ParseCIDR(address)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidDoHBlock, err)
+		}
+	}
+	a.config.DoHBlock = cfg.App.DoHBlock
+	switch cfg.App.StatsExport.Format {
+	case "", models.StatsFormatJSON, models.StatsFormatCSV:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownStatsFormat, cfg.App.StatsExport.Format)
+	}
+	if cfg.App.StatsExport.Path != "" && !filepath.IsAbs(cfg.App.StatsExport.Path) {
+		return fmt.Errorf("%w: %s", ErrInvalidStatsPath, cfg.App.StatsExport.Path)
+	}
+	a.config.StatsExport = cfg.App.StatsExport
+	switch cfg.App.ConfigMode {
+	case "", models.ConfigModeLenient, models.ConfigModeStrict:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownConfigMode, cfg.App.ConfigMode)
+	}
+	a.config.ConfigMode = cfg.App.ConfigMode
+	a.config.MatchEvents = cfg.App.MatchEvents
+	a.config.History = cfg.App.History
+	if a.config.History.Retention == 0 {
+		a.config.History.Retention = DefaultAppConfig.History.Retention
+	}
+	if cfg.App.Fleet.URL != "" {
+		_, err := fleet.ParsePublicKey(cfg.App.Fleet.PublicKey)
+		if err != nil {
+			return err
+		}
+	}
+	a.config.Fleet.URL = cfg.App.Fleet.URL
+	a.config.Fleet.PublicKey = cfg.App.Fleet.PublicKey
+	if cfg.App.Fleet.Interval != 0 {
+		a.config.Fleet.Interval = cfg.App.Fleet.Interval
+	}
+	notifier, err := newNotifier(cfg.App.Notify)
+	if err != nil {
+		return err
+	}
+	a.notifier = notifier
+	a.config.Notify = cfg.App.Notify
+	a.config.WarmUp = cfg.App.WarmUp
+	if cfg.App.GroupStatePath != "" {
+		a.config.GroupStatePath = cfg.App.GroupStatePath
+	}
+	a.config.Netns = cfg.App.Netns
+	if len(cfg.App.Link) != 0 {
+		a.config.Link = cfg.App.Link
+	}
+
+	err = validateDNSRoutes(cfg.App.DNSProxy, cfg.Groups)
+	if err != nil {
+		return err
+	}
+	a.config.DNSProxy.Upstreams = cfg.App.DNSProxy.Upstreams
+	a.config.DNSProxy.Routes = cfg.App.DNSProxy.Routes
 
 	a.unprocessedGroups = cfg.Groups
 
 	return nil
 }
 
+// ExportConfig returns the config with groups of the running app, or groups to be started by the stopped one
 func (a *App) ExportConfig() models.Config {
+	if !a.isRunning() {
+		return models.Config{
+			ConfigVersion: "0.1.0",
+			App:           a.config,
+			Groups:        append([]models.Group(nil), a.unprocessedGroups...),
+		}
+	}
 	groups := make([]models.Group, len(a.groups))
 	for idx, group := range a.groups {
 		groups[idx] = group.Group
@@ -578,5 +1265,11 @@ func (a *App) ExportConfig() models.Config {
 }
 
 func New() *App {
-	return &App{config: DefaultAppConfig}
+	return &App{
+		config:    DefaultAppConfig,
+		devices:   devices.New(),
+		discovery: discovery.New(),
+		stats:     summary.New(),
+		metrics:   metrics.New(),
+	}
 }
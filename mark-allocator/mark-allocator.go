@@ -0,0 +1,150 @@
+// Package markAllocator derives firewall marks and routing tables of groups from their IDs.
+//
+// The numbers are a hash of the group ID, so they don't depend on the order of groups in the config.
+// Collisions are resolved by probing the next number and every assignment is persisted, so a group
+// keeps its mark and table across restarts even if a colliding group is added or removed later.
+package markAllocator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"magitrickle/models"
+)
+
+const (
+	// MarkBase and TableBase keep derived numbers away from marks and tables of the firmware
+	MarkBase  = 0x10000
+	TableBase = 1000
+	// Space is the number of marks and tables available for groups
+	Space = 0x8000
+)
+
+type Assignment struct {
+	Mark  uint32 `json:"mark"`
+	Table int    `json:"table"`
+}
+
+type Allocator struct {
+	mux      sync.Mutex
+	path     string
+	assigned map[string]Assignment
+}
+
+func slot(id models.ID) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(id[:])
+	return h.Sum32() % Space
+}
+
+func (a *Allocator) isTaken(id string, s uint32) bool {
+	for otherID, assignment := range a.assigned {
+		if otherID != id && assignment.Mark == MarkBase+s {
+			return true
+		}
+	}
+	return false
+}
+
+// Assign returns the mark and the table of the group, a new assignment is persisted
+func (a *Allocator) Assign(id models.ID) (Assignment, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	key := id.String()
+	if assignment, ok := a.assigned[key]; ok {
+		return assignment, nil
+	}
+
+	s := slot(id)
+	for probe := 0; a.isTaken(key, s); probe++ {
+		if probe == Space {
+			return Assignment{}, errors.New("no free marks")
+		}
+		s = (s + 1) % Space
+	}
+	assignment := Assignment{Mark: MarkBase + s, Table: TableBase + int(s)}
+	a.assigned[key] = assignment
+	return assignment, a.save()
+}
+
+func (a *Allocator) save() error {
+	if a.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.assigned, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(a.path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create marks directory: %w", err)
+	}
+	tmpPath := a.path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write marks: %w", err)
+	}
+	return os.Rename(tmpPath, a.path)
+}
+
+// Load reads assignments from the file, a missing file is an empty one. An empty path disables persistence.
+func Load(path string) (*Allocator, error) {
+	a := &Allocator{
+		path:     path,
+		assigned: make(map[string]Assignment),
+	}
+	if path == "" {
+		return a, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return a, nil
+		}
+		return nil, fmt.Errorf("failed to read marks: %w", err)
+	}
+	err = json.Unmarshal(data, &a.assigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse marks: %w", err)
+	}
+	return a, nil
+}
+
+// Assignments returns a copy of the assignments
+func (a *Allocator) Assignments() map[string]Assignment {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	assignments := make(map[string]Assignment, len(a.assigned))
+	for id, assignment := range a.assigned {
+		assignments[id] = assignment
+	}
+	return assignments
+}
+
+// Restore keeps assignments of another allocator (e.g. of the previous daemon) unless the group
+// or the mark is already assigned, restored assignments are persisted
+func (a *Allocator) Restore(assignments map[string]Assignment) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	restored := false
+	for id, assignment := range assignments {
+		if _, ok := a.assigned[id]; ok || assignment.Mark < MarkBase || assignment.Mark >= MarkBase+Space {
+			continue
+		}
+		if a.isTaken(id, assignment.Mark-MarkBase) {
+			continue
+		}
+		a.assigned[id] = assignment
+		restored = true
+	}
+	if !restored {
+		return nil
+	}
+	return a.save()
+}
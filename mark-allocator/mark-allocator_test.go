@@ -0,0 +1,91 @@
+package markAllocator
+
+import (
+	"path/filepath"
+	"testing"
+
+	"magitrickle/models"
+)
+
+// collidingIDs returns two IDs with the same slot
+func collidingIDs(t *testing.T) (models.ID, models.ID) {
+	seen := make(map[uint32]models.ID)
+	for i := uint32(0); i < 1<<20; i++ {
+		id := models.ID{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)}
+		if other, ok := seen[slot(id)]; ok {
+			return other, id
+		}
+		seen[slot(id)] = id
+	}
+	t.Fatal("no collision found")
+	return models.ID{}, models.ID{}
+}
+
+func TestAssign(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "marks.json")
+	first, second := collidingIDs(t)
+
+	a, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstAssignment, err := a.Assign(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondAssignment, err := a.Assign(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstAssignment.Mark == secondAssignment.Mark || firstAssignment.Table == secondAssignment.Table {
+		t.Fatalf("collision is not resolved: %+v %+v", firstAssignment, secondAssignment)
+	}
+
+	// Reordered groups keep their numbers
+	b, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignment, err := b.Assign(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assignment != secondAssignment {
+		t.Fatalf("assignment is not persisted: %+v != %+v", assignment, secondAssignment)
+	}
+	assignment, err = b.Assign(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assignment != firstAssignment {
+		t.Fatalf("assignment is not persisted: %+v != %+v", assignment, firstAssignment)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	first, second := collidingIDs(t)
+	previous, _ := Load("")
+	firstAssignment, _ := previous.Assign(first)
+	_, _ = previous.Assign(second)
+
+	// The second group took the slot of the first one in the previous daemon
+	a, _ := Load("")
+	err := a.Restore(map[string]Assignment{second.String(): firstAssignment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.Restore(previous.Assignments())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignments := a.Assignments()
+	if assignments[second.String()] != firstAssignment {
+		t.Fatalf("restored assignment is replaced: %v", assignments)
+	}
+	if _, ok := assignments[first.String()]; ok {
+		t.Fatalf("taken mark is restored: %v", assignments)
+	}
+	if assignment, _ := a.Assign(first); assignment.Mark == firstAssignment.Mark {
+		t.Fatalf("mark is assigned twice: %v", assignment)
+	}
+}
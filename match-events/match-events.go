@@ -0,0 +1,110 @@
+// Package matchEvents publishes newly routed address to domain mappings
+// to an external consumer over a unix datagram socket.
+//
+// Every datagram contains a single JSON object:
+//
+//	{
+//	  "time": "2024-01-02T15:04:05.999999999Z", // RFC 3339 time of the match
+//	  "group": "d663876a",                     // ID of the group
+//	  "groupName": "Routing 1",                // name of the group
+//	  "interface": "nwg0",                     // interface of the group
+//	  "domain": "www.example.com",             // domain matched by the rule
+//	  "name": "example.com",                   // owner of the A/AAAA record
+//	  "address": "93.184.216.34",              // routed address
+//	  "ttl": 3900                              // seconds until the address expires in the ipset
+//	}
+//
+// Delivery is best-effort: events are dropped when nobody listens on the socket
+// or the consumer is too slow.
+package matchEvents
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+// reconnectInterval limits how often the consumer socket is redialed
+const reconnectInterval = 5 * time.Second
+
+type Event struct {
+	Time      time.Time `json:"time"`
+	Group     string    `json:"group"`
+	GroupName string    `json:"groupName"`
+	Interface string    `json:"interface"`
+	Domain    string    `json:"domain"`
+	Name      string    `json:"name"`
+	Address   net.IP    `json:"address"`
+	TTL       uint32    `json:"ttl"`
+}
+
+type Publisher struct {
+	SocketPath string
+
+	mux      sync.Mutex
+	conn     net.Conn
+	lastDial time.Time
+}
+
+func (p *Publisher) connect() net.Conn {
+	if p.conn != nil {
+		return p.conn
+	}
+	if time.Since(p.lastDial) < reconnectInterval {
+		return nil
+	}
+	p.lastDial = time.Now()
+
+	conn, err := net.Dial("unixgram", p.SocketPath)
+	if err != nil {
+		return nil
+	}
+	p.conn = conn
+	return conn
+}
+
+func (p *Publisher) Publish(event Event) {
+	if p == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	conn := p.connect()
+	if conn == nil {
+		return
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Write(data)
+	if err != nil {
+		_ = conn.Close()
+		p.conn = nil
+	}
+}
+
+func (p *Publisher) Close() error {
+	if p == nil {
+		return nil
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+func New(socketPath string) *Publisher {
+	return &Publisher{SocketPath: socketPath}
+}
@@ -0,0 +1,48 @@
+package magitrickle
+
+import (
+	"time"
+
+	"magitrickle/metrics"
+	"magitrickle/netfilter-helper"
+)
+
+// sampleMetrics records key metrics to the in-memory ring, it's called with the summary refresh.
+// Series of groups are named "group.<id>.<metric>", counters of skipped records "droppedRecords.<reason>".
+func (a *App) sampleMetrics(now time.Time) {
+	snapshot := a.stats.Snapshot(now)
+	a.metrics.Record("qps", now, snapshot.QPS)
+	upstreamHealthy := 0.0
+	if snapshot.Upstream.Healthy {
+		upstreamHealthy = 1
+	}
+	a.metrics.Record("upstreamHealthy", now, upstreamHealthy)
+	recordsStats := a.RecordsStats()
+	a.metrics.Record("records.domains", now, float64(recordsStats.Domains))
+	a.metrics.Record("records.addresses", now, float64(recordsStats.Addresses))
+	a.metrics.Record("netfilterTimeouts", now, float64(netfilterHelper.Timeouts()))
+	for reason, count := range snapshot.DroppedRecords {
+		a.metrics.Record("droppedRecords."+reason, now, float64(count))
+	}
+	for _, group := range snapshot.Groups {
+		if !group.Enabled {
+			continue
+		}
+		prefix := "group." + group.ID + "."
+		a.metrics.Record(prefix+"addresses", now, float64(group.Addresses))
+		a.metrics.Record(prefix+"flows", now, float64(group.Flows))
+		a.metrics.Record(prefix+"bytes", now, float64(group.Bytes))
+		a.metrics.Record(prefix+"pendingRetries", now, float64(group.PendingRetries))
+	}
+}
+
+// MetricNames returns names of recorded metric series
+func (a *App) MetricNames() []string {
+	return a.metrics.Names()
+}
+
+// MetricSeries returns points of the metric since the time (5-minute averages for the last 24 hours),
+// ok is false for an unknown metric
+func (a *App) MetricSeries(name string, since time.Time) ([]metrics.Point, bool) {
+	return a.metrics.Series(name, since, time.Now())
+}
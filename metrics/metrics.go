@@ -0,0 +1,101 @@
+// Package metrics keeps a day of key metrics in memory for charts of the web UI,
+// so graphs are available on routers that never run Prometheus.
+//
+// Every series is a ring of Resolution slots covering Retention, samples falling into
+// the same slot are averaged. Nothing is written to disk, series start over on restart.
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	Resolution = 5 * time.Minute
+	Retention  = 24 * time.Hour
+
+	slots = int(Retention / Resolution)
+)
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type slot struct {
+	// start is the unix time of the slot beginning, 0 - empty
+	start int64
+	sum   float64
+	count int
+}
+
+type series [slots]slot
+
+// Ring keeps series by name
+type Ring struct {
+	mux    sync.Mutex
+	series map[string]*series
+}
+
+func New() *Ring {
+	return &Ring{series: make(map[string]*series)}
+}
+
+func slotStart(now time.Time) int64 {
+	return now.Truncate(Resolution).Unix()
+}
+
+// Record adds the sample of the series, it's averaged with other samples of the same slot
+func (r *Ring) Record(name string, now time.Time, value float64) {
+	start := slotStart(now)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	s, ok := r.series[name]
+	if !ok {
+		s = new(series)
+		r.series[name] = s
+	}
+	sl := &s[(start/int64(Resolution/time.Second))%int64(slots)]
+	if sl.start != start {
+		*sl = slot{start: start}
+	}
+	sl.sum += value
+	sl.count++
+}
+
+// Names returns sorted names of the series
+func (r *Ring) Names() []string {
+	r.mux.Lock()
+	names := make([]string, 0, len(r.series))
+	for name := range r.series {
+		names = append(names, name)
+	}
+	r.mux.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// Series returns points of the series since the time in chronological order, slots without samples
+// are skipped. ok is false for an unknown series.
+func (r *Ring) Series(name string, since, now time.Time) (points []Point, ok bool) {
+	oldest := slotStart(now.Add(-Retention + Resolution))
+	if from := slotStart(since); from > oldest {
+		oldest = from
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	s, ok := r.series[name]
+	if !ok {
+		return nil, false
+	}
+	points = make([]Point, 0, slots)
+	for _, sl := range s {
+		if sl.count == 0 || sl.start < oldest || sl.start > now.Unix() {
+			continue
+		}
+		points = append(points, Point{Time: time.Unix(sl.start, 0), Value: sl.sum / float64(sl.count)})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, true
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := New()
+	start := time.Unix(1700000000, 0).Truncate(Resolution)
+	r.Record("qps", start, 1)
+	r.Record("qps", start.Add(time.Minute), 3)
+	r.Record("qps", start.Add(Resolution), 5)
+
+	now := start.Add(Resolution + time.Minute)
+	points, ok := r.Series("qps", now.Add(-time.Hour), now)
+	if !ok || len(points) != 2 {
+		t.Fatalf("expected 2 points, got %v", points)
+	}
+	if points[0].Value != 2 || points[1].Value != 5 || !points[0].Time.Equal(start) {
+		t.Fatalf("unexpected points: %v", points)
+	}
+
+	// A day later the slot of the first point is reused
+	later := start.Add(Retention)
+	r.Record("qps", later, 7)
+	points, _ = r.Series("qps", later.Add(-Retention), later)
+	if len(points) != 2 || points[0].Value != 5 || points[1].Value != 7 {
+		t.Fatalf("unexpected points after wrap: %v", points)
+	}
+
+	if _, ok = r.Series("unknown", now, now); ok {
+		t.Fatal("expected unknown series")
+	}
+}
@@ -7,18 +7,276 @@ type Config struct {
 }
 
 type App struct {
-	DNSProxy  DNSProxy  `yaml:"dnsProxy"`
-	Netfilter Netfilter `yaml:"netfilter"`
-	Link      []string  `yaml:"link"`
-	LogLevel  string    `yaml:"logLevel"`
+	DNSProxy    DNSProxy    `yaml:"dnsProxy"`
+	Netfilter   Netfilter   `yaml:"netfilter"`
+	API         API         `yaml:"api"`
+	MatchEvents MatchEvents `yaml:"matchEvents"`
+	History     History     `yaml:"history"`
+	Fleet       Fleet       `yaml:"fleet,omitempty"`
+	Notify      []Notifier  `yaml:"notify,omitempty"`
+	Link        []string    `yaml:"link"`
+	WarmUp      bool        `yaml:"warmUp"`
+	Netns       string      `yaml:"netns,omitempty"`
+	LogLevel    string      `yaml:"logLevel"`
+	// GroupStatePath keeps groups disabled through the API across restarts
+	GroupStatePath string `yaml:"groupStatePath"`
+	// DHCPLeases names LAN devices and lets expression rules match clients by MAC and hostname
+	DHCPLeases DHCPLeases `yaml:"dhcpLeases,omitempty"`
+	// DoHBlock closes HTTPS to public DNS-over-HTTPS servers, so clients can't bypass routing with encrypted DNS
+	DoHBlock DoHBlock `yaml:"dohBlock,omitempty"`
+	// StatsExport writes statistics for spreadsheets once a day
+	StatsExport StatsExport `yaml:"statsExport,omitempty"`
+	// ConfigMode is lenient (default, unknown fields are ignored) or strict (unknown fields are errors)
+	ConfigMode string `yaml:"configMode,omitempty"`
+}
+
+const (
+	ConfigModeLenient = "lenient"
+	ConfigModeStrict  = "strict"
+)
+
+const (
+	StatsFormatJSON = "json"
+	StatsFormatCSV  = "csv"
+)
+
+type StatsExport struct {
+	// Path is the directory of daily exports (stats-YYYY-MM-DD.<format>), empty - disabled
+	Path string `yaml:"path,omitempty"`
+	// Format is json (default) or csv
+	Format string `yaml:"format,omitempty"`
+}
+
+const (
+	DoHBlockReject = "reject"
+	DoHBlockDrop   = "drop"
+)
+
+type DoHBlock struct {
+	Enable bool `yaml:"enable"`
+	// Action is reject (default, clients fall back to plain DNS right away) or drop
+	Action string `yaml:"action,omitempty"`
+	// Domains and Addresses extend the built-in lists
+	Domains   []string `yaml:"domains,omitempty"`
+	Addresses []string `yaml:"addresses,omitempty"`
+	// URL is a list of DoH servers in the subscription format, it's fetched every Interval seconds
+	URL      string `yaml:"url,omitempty"`
+	Interval uint32 `yaml:"interval,omitempty"`
+}
+
+// IntervalOrDefault returns the update interval of the list in seconds
+func (d DoHBlock) IntervalOrDefault() uint32 {
+	if d.Interval == 0 {
+		return DefaultSubscriptionInterval
+	}
+	return d.Interval
+}
+
+type DHCPLeases struct {
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
+}
+
+const (
+	// DHCPLeasesDnsmasq is "<expiry> <mac> <ip> <hostname> <client-id>" per line
+	DHCPLeasesDnsmasq = "dnsmasq"
+	// DHCPLeasesISC is the dhcpd.leases file of ISC DHCP server
+	DHCPLeasesISC = "isc"
+)
+
+type API struct {
+	Host    APIServer `yaml:"host"`
+	Disable bool      `yaml:"disable"`
+	// Tokens restrict access to the API, it's open to everyone without them
+	Tokens []APIToken `yaml:"tokens,omitempty"`
+}
+
+// APIToken is a bearer token of the API with its role
+type APIToken struct {
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+const (
+	// APIRoleViewer reads state, stats and records, but can't change anything
+	APIRoleViewer = "viewer"
+	// APIRoleAdmin has full access
+	APIRoleAdmin = "admin"
+)
+
+// MatchEvents publishes routed address to domain mappings to an external consumer
+type MatchEvents struct {
+	Socket string `yaml:"socket"`
+}
+
+// History records routed domains to a file, Retention is in hours
+type History struct {
+	Path      string `yaml:"path"`
+	Retention uint32 `yaml:"retention"`
+}
+
+// Fleet pulls signed configs from a central URL, Interval is in seconds
+type Fleet struct {
+	URL       string `yaml:"url"`
+	PublicKey string `yaml:"publicKey"`
+	Interval  uint32 `yaml:"interval"`
+}
+
+// Notifier sends events by exactly one transport, empty Events means all events
+type Notifier struct {
+	Events   []string          `yaml:"events,omitempty"`
+	Telegram *TelegramNotifier `yaml:"telegram,omitempty"`
+	SMTP     *SMTPNotifier     `yaml:"smtp,omitempty"`
+}
+
+type TelegramNotifier struct {
+	Token  string `yaml:"token"`
+	ChatID string `yaml:"chatID"`
+}
+
+type SMTPNotifier struct {
+	Address  string   `yaml:"address"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+type APIServer struct {
+	Address string `yaml:"address"`
+	Port    uint16 `yaml:"port"`
 }
 
 type DNSProxy struct {
 	Host            DNSProxyServer `yaml:"host"`
 	Upstream        DNSProxyServer `yaml:"upstream"`
+	RaceUpstream    DNSProxyServer `yaml:"raceUpstream"`
 	DisableRemap53  bool           `yaml:"disableRemap53"`
 	DisableFakePTR  bool           `yaml:"disableFakePTR"`
+	FakePTRSubnets  []string       `yaml:"fakePTRSubnets,omitempty"`
 	DisableDropAAAA bool           `yaml:"disableDropAAAA"`
+	StripECS        bool           `yaml:"stripECS"`
+	DisableCoalesce bool           `yaml:"disableCoalesce"`
+	EDNSSize        uint16         `yaml:"ednsSize"`
+	TTLClamp        TTLClamp       `yaml:"ttlClamp"`
+	LocalZone       LocalZone      `yaml:"localZone"`
+	DNSSEC          string         `yaml:"dnssec,omitempty"`
+	// DedupWindow is in seconds, identical answers within it are processed once (0 - disabled)
+	DedupWindow uint32 `yaml:"dedupWindow"`
+	// ProcessExtra matches A records of the additional and authority sections (glue of NS, targets of SRV)
+	ProcessExtra bool `yaml:"processExtra"`
+	// CaptureMatched is the number of last DNS transactions with rule matches kept for the pcap export (0 - disabled)
+	CaptureMatched uint32 `yaml:"captureMatched"`
+	// RecoverClient looks up the original client in conntrack when queries come from addresses of the router
+	// (SNAT or masquerading of LAN segments), so logs and per-client rules see the LAN device
+	RecoverClient bool `yaml:"recoverClient"`
+	// OwnQueries are queries of the router's own processes
+	OwnQueries OwnQueries `yaml:"ownQueries,omitempty"`
+	// LearnUnmatched records domains queried by clients which no rule matches for suggested rules
+	LearnUnmatched bool `yaml:"learnUnmatched"`
+	// ExplainDecisions is the number of last rule match decisions kept for /api/debug/decisions (0 - default)
+	ExplainDecisions uint32 `yaml:"explainDecisions"`
+	// UpstreamSource replaces Upstream by the first resolver of the WAN, Upstream is used until one is known
+	UpstreamSource string `yaml:"upstreamSource,omitempty"`
+	ResolvConf     string `yaml:"resolvConf,omitempty"`
+	// Upstreams are named sets of upstreams for Routes, requests to a set of several upstreams race
+	Upstreams []UpstreamSet `yaml:"upstreams,omitempty"`
+	// Routes send queries to upstream sets by the matched group and the query type, the first matching
+	// route wins, queries no route matches are sent to Upstream
+	Routes []DNSRoute `yaml:"routes,omitempty"`
+	// ExtraPorts are remapped together with 53, unless disableRemap53 is set
+	ExtraPorts []DNSPort `yaml:"extraPorts,omitempty"`
+	// Listen are additional addresses the proxy serves on
+	Listen []DNSProxyServer `yaml:"listen,omitempty"`
+	// Verify cross-checks suspicious answers against a trusted upstream, its answer is routed instead
+	Verify Verify `yaml:"verify,omitempty"`
+}
+
+// Verify asks the trusted upstream again when the answer looks filtered by the ISP: it has bogus addresses
+// or, with Empty, no addresses at all. Clients still get the original answer.
+type Verify struct {
+	// Upstream is the trusted upstream, an empty address disables the verification
+	Upstream DNSProxyServer `yaml:"upstream"`
+	// Bogus are networks of filtered answers, empty - 0.0.0.0/8 and 127.0.0.0/8
+	Bogus []string `yaml:"bogus,omitempty"`
+	// Empty verifies A answers without addresses (NXDOMAIN, NOERROR without records) too
+	Empty bool `yaml:"empty"`
+	// Always are domains (with subdomains) whose answers are verified even if they look fine,
+	// Never are domains which are never verified, Never wins
+	Always []string `yaml:"always,omitempty"`
+	Never  []string `yaml:"never,omitempty"`
+}
+
+const (
+	OwnQueriesProcess = "process"
+	OwnQueriesForward = "forward"
+	OwnQueriesRefuse  = "refuse"
+)
+
+// OwnQueries are queries sent from loopback addresses or addresses of the LAN interfaces
+type OwnQueries struct {
+	// Action is process (default, as queries of any client), forward (to Upstream without processing)
+	// or refuse (breaks the loop of a local upstream which forwards queries back to the proxy)
+	Action string `yaml:"action,omitempty"`
+	// Processes limits the action to queries of these processes (e.g. dnsmasq), empty - any process
+	Processes []string `yaml:"processes,omitempty"`
+}
+
+type UpstreamSet struct {
+	Name    string           `yaml:"name"`
+	Servers []DNSProxyServer `yaml:"servers"`
+	// Interface is the tunnel the servers are reached through, addresses of answers matched by rules
+	// with the resolver action are routed by the group of this interface
+	Interface string `yaml:"interface,omitempty"`
+}
+
+// DNSRoute matches queries for domains of the group (of any query if Group is empty) or for domains
+// no group matches if Unmatched is set. QTypes limit the query types (all if empty), Clients limit
+// the route to queries of these addresses and subnets of LAN clients (all if empty).
+type DNSRoute struct {
+	Group     string   `yaml:"group,omitempty"`
+	Unmatched bool     `yaml:"unmatched,omitempty"`
+	QTypes    []string `yaml:"qtypes,omitempty"`
+	Clients   []string `yaml:"clients,omitempty"`
+	Upstream  string   `yaml:"upstream"`
+}
+
+const (
+	// UpstreamSourceResolvConf reads nameservers of the ResolvConf file
+	UpstreamSourceResolvConf = "resolvConf"
+	// UpstreamSourceRCI reads name servers of the Keenetic firmware (provided by DHCP, PPP or set manually)
+	UpstreamSourceRCI = "rci"
+)
+
+const (
+	DNSSECIgnore   = "ignore"
+	DNSSECPreserve = "preserve"
+	DNSSECStrip    = "strip"
+)
+
+// LocalZone is answered by the proxy itself, a name may be listed several times for several addresses
+type LocalZone struct {
+	TTL     uint32        `yaml:"ttl"`
+	Records []LocalRecord `yaml:"records"`
+}
+
+type LocalRecord struct {
+	Name    string `yaml:"name"`
+	Address string `yaml:"address"`
+}
+
+type TTLClamp struct {
+	Min uint32 `yaml:"min"`
+	Max uint32 `yaml:"max"`
+}
+
+// DNSPort is a port of queries to addresses of the router on the link interfaces
+type DNSPort struct {
+	Port uint16 `yaml:"port"`
+	// Network is udp, tcp or empty for both
+	Network string `yaml:"network,omitempty"`
+	// To is the local port the queries are redirected to (e.g. a DoT terminator for 853), 0 - the port of the proxy
+	To uint16 `yaml:"to,omitempty"`
 }
 
 type DNSProxyServer struct {
@@ -29,6 +287,11 @@ type DNSProxyServer struct {
 type Netfilter struct {
 	IPTables IPTables `yaml:"iptables"`
 	IPSet    IPSet    `yaml:"ipset"`
+	// MarksPath keeps marks and tables of groups across restarts
+	MarksPath string `yaml:"marksPath"`
+	// FixProtect are rules installed for groups with fixProtect, {interface} is the interface of the group.
+	// The default allows new connections through the interface on Keenetic.
+	FixProtect []IPTablesSnippet `yaml:"fixProtect,omitempty"`
 }
 
 type IPTables struct {
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"magitrickle/app-errors"
+)
+
+var ErrInvalidExpression = appErrors.New(appErrors.ErrValidation, "invalid expression")
+
+// Expression is a node of a compound rule, exactly one field must be set.
+// All matches when every child matches, Any when at least one does.
+// Domain matches the domain with subdomains, Client the subnet of the requesting client,
+// MAC and Hostname the client by its DHCP lease or neighbor entry (case-insensitive),
+// Time the local time window "HH:MM-HH:MM" (may cross midnight).
+type Expression struct {
+	All      []*Expression `yaml:"all,omitempty"`
+	Any      []*Expression `yaml:"any,omitempty"`
+	Not      *Expression   `yaml:"not,omitempty"`
+	Domain   string        `yaml:"domain,omitempty"`
+	Client   string        `yaml:"client,omitempty"`
+	MAC      string        `yaml:"mac,omitempty"`
+	Hostname string        `yaml:"hostname,omitempty"`
+	Time     string        `yaml:"time,omitempty"`
+}
+
+// MatchContext is the input of expression evaluation, a nil Client never matches client conditions.
+// ClientMAC and ClientHostname are looked up by the address of the client when the answer is processed.
+// QType is the type of the query the answer is for, 0 matches rules of any types.
+type MatchContext struct {
+	Domain         string
+	Client         net.IP
+	ClientMAC      string
+	ClientHostname string
+	Time           time.Time
+	QType          uint16
+}
+
+// parseTimeWindow returns the window bounds in minutes since midnight
+func parseTimeWindow(value string) (from, to int, err error) {
+	fromStr, toStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("time window %q must be HH:MM-HH:MM", value)
+	}
+	for _, bound := range []struct {
+		value string
+		dst   *int
+	}{{fromStr, &from}, {toStr, &to}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound.value))
+		if err != nil {
+			return 0, 0, fmt.Errorf("time window %q: %w", value, err)
+		}
+		*bound.dst = t.Hour()*60 + t.Minute()
+	}
+	return from, to, nil
+}
+
+func (e *Expression) Validate() error {
+	set := 0
+	for _, isSet := range []bool{e.All != nil, e.Any != nil, e.Not != nil, e.Domain != "", e.Client != "", e.MAC != "", e.Hostname != "", e.Time != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("%w: node must have exactly one condition", ErrInvalidExpression)
+	}
+
+	switch {
+	case e.All != nil || e.Any != nil:
+		for _, child := range append(e.All, e.Any...) {
+			if child == nil {
+				return fmt.Errorf("%w: empty node", ErrInvalidExpression)
+			}
+			err := child.Validate()
+			if err != nil {
+				return err
+			}
+		}
+	case e.Not != nil:
+		return e.Not.Validate()
+	case e.Client != "":
+		_, err := ParseCIDR(e.Client)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidExpression, err)
+		}
+	case e.MAC != "":
+		_, err := net.ParseMAC(e.MAC)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidExpression, err)
+		}
+	case e.Time != "":
+		_, _, err := parseTimeWindow(e.Time)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidExpression, err)
+		}
+	}
+	return nil
+}
+
+func (e *Expression) Evaluate(ctx MatchContext) bool {
+	switch {
+	case e.All != nil:
+		for _, child := range e.All {
+			if !child.Evaluate(ctx) {
+				return false
+			}
+		}
+		return true
+	case e.Any != nil:
+		for _, child := range e.Any {
+			if child.Evaluate(ctx) {
+				return true
+			}
+		}
+		return false
+	case e.Not != nil:
+		return !e.Not.Evaluate(ctx)
+	case e.Domain != "":
+		return ctx.Domain == e.Domain || strings.HasSuffix(ctx.Domain, "."+e.Domain)
+	case e.Client != "":
+		if ctx.Client == nil {
+			return false
+		}
+		subnet, err := ParseCIDR(e.Client)
+		return err == nil && subnet.Contains(ctx.Client)
+	case e.MAC != "":
+		mac, err := net.ParseMAC(e.MAC)
+		return err == nil && ctx.ClientMAC != "" && strings.EqualFold(mac.String(), ctx.ClientMAC)
+	case e.Hostname != "":
+		return ctx.ClientHostname != "" && strings.EqualFold(e.Hostname, ctx.ClientHostname)
+	case e.Time != "":
+		if ctx.Time.IsZero() {
+			return false
+		}
+		from, to, err := parseTimeWindow(e.Time)
+		if err != nil {
+			return false
+		}
+		minutes := ctx.Time.Hour()*60 + ctx.Time.Minute()
+		if from <= to {
+			return minutes >= from && minutes < to
+		}
+		return minutes >= from || minutes < to
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestExpression_Evaluate(t *testing.T) {
+	rule := &Rule{
+		Type: "expression",
+		Expression: &Expression{All: []*Expression{
+			{Domain: "example.com"},
+			{Client: "192.168.1.0/24"},
+			{Time: "22:00-06:00"},
+		}},
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	night := time.Date(2024, 1, 1, 23, 30, 0, 0, time.Local)
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	client := net.ParseIP("192.168.1.10")
+
+	if !rule.IsMatchContext(MatchContext{Domain: "www.example.com", Client: client, Time: night}) {
+		t.Fatal("expression does not match")
+	}
+	if rule.IsMatchContext(MatchContext{Domain: "www.example.com", Client: client, Time: day}) {
+		t.Fatal("expression matches outside of the time window")
+	}
+	if rule.IsMatchContext(MatchContext{Domain: "www.example.com", Client: net.ParseIP("10.0.0.1"), Time: night}) {
+		t.Fatal("expression matches foreign client")
+	}
+	if rule.IsMatch("www.example.com") {
+		t.Fatal("expression matches without client")
+	}
+}
+
+func TestExpression_Device(t *testing.T) {
+	expression := &Expression{Any: []*Expression{
+		{MAC: "AA:BB:CC:DD:EE:01"},
+		{Hostname: "kids-tablet"},
+	}}
+	if err := expression.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !expression.Evaluate(MatchContext{ClientMAC: "aa:bb:cc:dd:ee:01"}) {
+		t.Fatal("expression does not match the MAC")
+	}
+	if !expression.Evaluate(MatchContext{ClientHostname: "Kids-Tablet"}) {
+		t.Fatal("expression does not match the hostname")
+	}
+	if expression.Evaluate(MatchContext{Client: net.ParseIP("192.168.1.10")}) {
+		t.Fatal("expression matches unknown device")
+	}
+}
+
+func TestExpression_Validate(t *testing.T) {
+	for _, expression := range []*Expression{
+		{},
+		{Domain: "example.com", Client: "10.0.0.0/8"},
+		{Client: "invalid"},
+		{Time: "25:00-01:00"},
+		{MAC: "not-a-mac"},
+		{Any: []*Expression{{Domain: "example.com"}, {}}},
+	} {
+		if err := expression.Validate(); err == nil {
+			t.Fatalf("invalid expression accepted: %+v", expression)
+		}
+	}
+}
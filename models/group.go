@@ -1,9 +1,322 @@
 package models
 
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"magitrickle/app-errors"
+)
+
+var (
+	ErrDuplicateRuleID   = appErrors.New(appErrors.ErrValidation, "duplicate rule id")
+	ErrDuplicateRuleSlug = appErrors.New(appErrors.ErrValidation, "duplicate rule slug")
+	ErrInvalidSlug       = appErrors.New(appErrors.ErrValidation, "invalid slug")
+	ErrInvalidProxy      = appErrors.New(appErrors.ErrValidation, "invalid proxy")
+	ErrInvalidRate       = appErrors.New(appErrors.ErrValidation, "invalid rate")
+	ErrInvalidTarget     = appErrors.New(appErrors.ErrValidation, "invalid group target")
+	ErrInvalidMirror     = appErrors.New(appErrors.ErrValidation, "invalid mirror ipset")
+	ErrNoAddressFamily   = appErrors.New(appErrors.ErrValidation, "group routes no address family")
+)
+
+var slugRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ipsetNameRegexp limits names to IPSET_MAXNAMELEN
+var ipsetNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,31}$`)
+
 type Group struct {
-	ID         ID      `yaml:"id"`
-	Name       string  `yaml:"name"`
-	Interface  string  `yaml:"interface"`
-	FixProtect bool    `yaml:"fixProtect"`
-	Rules      []*Rule `yaml:"rules"`
+	ID              ID              `yaml:"id"`
+	Slug            string          `yaml:"slug,omitempty"`
+	Name            string          `yaml:"name"`
+	Metadata        Metadata        `yaml:",inline"`
+	Interface       string          `yaml:"interface"`
+	Gateway         string          `yaml:"gateway,omitempty"`
+	Table           int             `yaml:"table,omitempty"`
+	FixProtect      bool            `yaml:"fixProtect"`
+	Shadow          bool            `yaml:"shadow,omitempty"`
+	PrefixPromotion PrefixPromotion `yaml:"prefixPromotion"`
+	Exclude         []string        `yaml:"exclude,omitempty"`
+	Proxy           Proxy           `yaml:"proxy,omitempty"`
+	RateLimit       string          `yaml:"rateLimit,omitempty"`
+	Preload         string          `yaml:"preload,omitempty"`
+	Mirrors         []string        `yaml:"mirrors,omitempty"`
+	Rules           []*Rule         `yaml:"rules"`
+	// RouteIPv4 adds addresses of A answers to the group, nil - true
+	RouteIPv4 *bool `yaml:"routeIPv4,omitempty"`
+	// RouteIPv6 keeps AAAA answers of matched domains, nil - false: they're dropped, so clients
+	// don't bypass tunnels without IPv6. IPv6 addresses are not routed by the group.
+	RouteIPv6 *bool `yaml:"routeIPv6,omitempty"`
+	// Subscriptions fill rules of the group from published lists, see Rule.Subscription
+	Subscriptions []Subscription `yaml:"subscriptions,omitempty"`
+	// IPTables are extra rules installed after the routing of the group and removed before it
+	IPTables []IPTablesSnippet `yaml:"iptables,omitempty"`
+}
+
+// GroupDefaults is the global policy of the config, a group inherits a field unless it sets the field itself
+type GroupDefaults struct {
+	Interface       string           `yaml:"interface,omitempty"`
+	FixProtect      *bool            `yaml:"fixProtect,omitempty"`
+	PrefixPromotion *PrefixPromotion `yaml:"prefixPromotion,omitempty"`
+	Exclude         []string         `yaml:"exclude,omitempty"`
+}
+
+// Apply fills fields of the group which keys are not in overridden (yaml keys of the group definition)
+func (d GroupDefaults) Apply(g *Group, overridden map[string]bool) {
+	// Interface is not inherited by groups with another target
+	if d.Interface != "" && !overridden["interface"] && !overridden["table"] && !g.Proxy.IsEnabled() {
+		g.Interface = d.Interface
+	}
+	if d.FixProtect != nil && !overridden["fixProtect"] {
+		g.FixProtect = *d.FixProtect
+	}
+	if d.PrefixPromotion != nil && !overridden["prefixPromotion"] {
+		g.PrefixPromotion = *d.PrefixPromotion
+	}
+	if d.Exclude != nil && !overridden["exclude"] {
+		g.Exclude = append([]string(nil), d.Exclude...)
+	}
+}
+
+// RoutesIPv4 reports whether addresses of A answers are added to the group
+func (g *Group) RoutesIPv4() bool {
+	return g.RouteIPv4 == nil || *g.RouteIPv4
+}
+
+// RoutesIPv6 reports whether AAAA answers of domains matched by the group are passed to clients
+func (g *Group) RoutesIPv6() bool {
+	return g.RouteIPv6 != nil && *g.RouteIPv6
+}
+
+// ValidateSlug checks the human-readable key, it must not be confused with a hex ID
+func ValidateSlug(slug string) error {
+	if !slugRegexp.MatchString(slug) {
+		return fmt.Errorf("%w: %s", ErrInvalidSlug, slug)
+	}
+	var id ID
+	if len(slug) == len(id)*2 && id.UnmarshalText([]byte(slug)) == nil {
+		return fmt.Errorf("%w: %s looks like an id", ErrInvalidSlug, slug)
+	}
+	return nil
+}
+
+// HasKey reports whether the key is the slug or the hex ID of the group
+func (g *Group) HasKey(key string) bool {
+	return (g.Slug != "" && key == g.Slug) || key == g.ID.String()
+}
+
+// FindRule returns the rule by its slug or hex ID
+func (g *Group) FindRule(key string) *Rule {
+	for _, rule := range g.Rules {
+		if rule.HasKey(key) {
+			return rule
+		}
+	}
+	return nil
+}
+
+var rateUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"gbit", 1000 * 1000 * 1000},
+	{"mbit", 1000 * 1000},
+	{"kbit", 1000},
+	{"bit", 1},
+}
+
+// ParseRate parses a rate in tc notation (e.g. "10mbit", "512kbit") to bits per second
+func ParseRate(value string) (uint64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	multiplier := uint64(1)
+	for _, unit := range rateUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	rate, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || rate == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidRate, value)
+	}
+	return rate * multiplier, nil
+}
+
+// ParseCIDR parses an address or a network in CIDR notation
+func ParseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address: %s", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// HasExclusions reports whether the group needs the exclusion ipset
+func (g *Group) HasExclusions() bool {
+	if len(g.Exclude) != 0 {
+		return true
+	}
+	for _, rule := range g.Rules {
+		if rule.IsExclude() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasExpressionRules reports whether matches of the group depend on the client and the time
+func (g *Group) HasExpressionRules() bool {
+	for _, rule := range g.Rules {
+		if rule.Type == "expression" && rule.IsEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Group) Validate() error {
+	if g.Slug != "" {
+		err := ValidateSlug(g.Slug)
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.ID.String(), err)
+		}
+	}
+	err := g.Metadata.Validate()
+	if err != nil {
+		return fmt.Errorf("group %s: %w", g.ID.String(), err)
+	}
+	if g.Proxy.IsEnabled() {
+		err := g.Proxy.Validate()
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.ID.String(), err)
+		}
+	}
+	if !g.RoutesIPv4() && !g.RoutesIPv6() {
+		return fmt.Errorf("group %s: %w", g.ID.String(), ErrNoAddressFamily)
+	}
+	if g.Gateway != "" {
+		if ip := net.ParseIP(g.Gateway); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("group %s: %w: gateway must be an IPv4 address", g.ID.String(), ErrInvalidTarget)
+		}
+	}
+	if g.Table != 0 {
+		if g.Table < 0 || g.Interface != "" || g.Gateway != "" {
+			return fmt.Errorf("group %s: %w: table excludes interface and gateway", g.ID.String(), ErrInvalidTarget)
+		}
+	}
+	if g.RateLimit != "" {
+		_, err := ParseRate(g.RateLimit)
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.ID.String(), err)
+		}
+		if g.Interface == "" && !g.Proxy.IsEnabled() {
+			return fmt.Errorf("group %s: %w: rate limit requires interface", g.ID.String(), ErrInvalidTarget)
+		}
+	}
+	for _, exclude := range g.Exclude {
+		_, err := ParseCIDR(exclude)
+		if err != nil {
+			return fmt.Errorf("group %s: invalid exclude: %w", g.ID.String(), err)
+		}
+	}
+	for _, mirror := range g.Mirrors {
+		if !ipsetNameRegexp.MatchString(mirror) {
+			return fmt.Errorf("group %s: %w: %q", g.ID.String(), ErrInvalidMirror, mirror)
+		}
+	}
+	if len(g.IPTables) != 0 && g.Shadow {
+		return fmt.Errorf("group %s: %w: shadow groups install no rules", g.ID.String(), ErrInvalidSnippet)
+	}
+	for idx, snippet := range g.IPTables {
+		err := snippet.Validate(g)
+		if err != nil {
+			return fmt.Errorf("group %s: iptables %d: %w", g.ID.String(), idx, err)
+		}
+	}
+	subscriptionIDs := make(map[ID]struct{})
+	for _, subscription := range g.Subscriptions {
+		if _, exists := subscriptionIDs[subscription.ID]; exists {
+			return fmt.Errorf("group %s: %w: %s", g.ID.String(), ErrDuplicateSubscription, subscription.ID.String())
+		}
+		subscriptionIDs[subscription.ID] = struct{}{}
+		err := subscription.Validate()
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.ID.String(), err)
+		}
+	}
+	ruleIDs := make(map[ID]struct{})
+	ruleSlugs := make(map[string]struct{})
+	for _, rule := range g.Rules {
+		if _, exists := ruleIDs[rule.ID]; exists {
+			return fmt.Errorf("group %s: %w: %s", g.ID.String(), ErrDuplicateRuleID, rule.ID.String())
+		}
+		ruleIDs[rule.ID] = struct{}{}
+		if rule.Slug != "" {
+			if _, exists := ruleSlugs[rule.Slug]; exists {
+				return fmt.Errorf("group %s: %w: %s", g.ID.String(), ErrDuplicateRuleSlug, rule.Slug)
+			}
+			ruleSlugs[rule.Slug] = struct{}{}
+		}
+		err := rule.Validate()
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.ID.String(), err)
+		}
+	}
+	return nil
+}
+
+// PrefixPromotion replaces addresses from the same prefix with the whole prefix
+// when more than Threshold of them are added within Window seconds
+type PrefixPromotion struct {
+	Threshold  int    `yaml:"threshold"`
+	Window     uint32 `yaml:"window"`
+	IPv4Prefix int    `yaml:"ipv4Prefix"`
+	IPv6Prefix int    `yaml:"ipv6Prefix"`
+}
+
+func (p PrefixPromotion) IsEnabled() bool {
+	return p.Threshold > 0
+}
+
+// Proxy sends matched TCP traffic through a SOCKS5 or HTTP proxy instead of the interface,
+// connections are redirected to the local Port and forwarded from there
+type Proxy struct {
+	URL  string `yaml:"url"`
+	Port uint16 `yaml:"port"`
+}
+
+func (p Proxy) IsEnabled() bool {
+	return p.URL != ""
+}
+
+func (p Proxy) Validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProxy, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return fmt.Errorf("%w: unsupported scheme %q", ErrInvalidProxy, u.Scheme)
+	}
+	if u.Port() == "" {
+		return fmt.Errorf("%w: no proxy port", ErrInvalidProxy)
+	}
+	if p.Port == 0 {
+		return fmt.Errorf("%w: no local port", ErrInvalidProxy)
+	}
+	return nil
 }
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseCIDR(t *testing.T) {
+	for value, expected := range map[string]string{
+		"192.168.1.1":   "192.168.1.1/32",
+		"10.0.0.0/8":    "10.0.0.0/8",
+		"10.1.2.3/8":    "10.0.0.0/8",
+		"2001:db8::1":   "2001:db8::1/128",
+		"2001:db8::/32": "2001:db8::/32",
+	} {
+		network, err := ParseCIDR(value)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q) returns error: %v", value, err)
+		}
+		if network.String() != expected {
+			t.Fatalf("ParseCIDR(%q) = %s, expected %s", value, network, expected)
+		}
+	}
+	if _, err := ParseCIDR("example.com"); err == nil {
+		t.Fatal("ParseCIDR(\"example.com\") returns no error")
+	}
+}
+
+func TestGroup_Validate(t *testing.T) {
+	group := &Group{
+		Exclude: []string{"10.0.0.0/8"},
+		Rules: []*Rule{
+			{ID: ID{0, 0, 0, 1}, Type: "domain", Rule: "example.com", Action: RuleActionExclude},
+		},
+	}
+	if err := group.Validate(); err != nil {
+		t.Fatalf("valid group returns error: %v", err)
+	}
+	if !group.HasExclusions() {
+		t.Fatal("group with exclusions returns HasExclusions() == false")
+	}
+
+	group.Rules = append(group.Rules, &Rule{ID: ID{0, 0, 0, 1}, Type: "domain"})
+	if err := group.Validate(); err == nil {
+		t.Fatal("group with duplicated rule IDs returns no error")
+	}
+
+	group.Rules = []*Rule{{Type: "domain", Action: "drop"}}
+	if err := group.Validate(); err == nil {
+		t.Fatal("group with unknown rule action returns no error")
+	}
+}
+
+func TestGroup_Slug(t *testing.T) {
+	group := &Group{
+		ID:   ID{0, 0, 0, 1},
+		Slug: "streaming",
+		Rules: []*Rule{
+			{ID: ID{0, 0, 0, 2}, Slug: "video", Type: "domain", Rule: "example.com"},
+		},
+	}
+	if err := group.Validate(); err != nil {
+		t.Fatalf("valid group returns error: %v", err)
+	}
+	if !group.HasKey("streaming") || !group.HasKey("00000001") || group.HasKey("") {
+		t.Fatal("group keys are not matched")
+	}
+	if group.FindRule("video") == nil || group.FindRule("00000002") == nil {
+		t.Fatal("rule is not found by key")
+	}
+
+	group.Rules = append(group.Rules, &Rule{ID: ID{0, 0, 0, 3}, Slug: "video", Type: "domain"})
+	if err := group.Validate(); !errors.Is(err, ErrDuplicateRuleSlug) {
+		t.Fatalf("expected duplicate rule slug, got %v", err)
+	}
+
+	for _, slug := range []string{"Streaming", "-video", "deadbeef", "a/b"} {
+		if err := ValidateSlug(slug); !errors.Is(err, ErrInvalidSlug) {
+			t.Fatalf("ValidateSlug(%q) returns %v", slug, err)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for value, expected := range map[string]uint64{
+		"10mbit":  10000000,
+		"512kbit": 512000,
+		"1Gbit":   1000000000,
+		"800":     800,
+	} {
+		rate, err := ParseRate(value)
+		if err != nil || rate != expected {
+			t.Fatalf("ParseRate(%q) returns %d, %v", value, rate, err)
+		}
+	}
+	for _, value := range []string{"", "0mbit", "fast", "10mb"} {
+		if _, err := ParseRate(value); err == nil {
+			t.Fatalf("ParseRate(%q) returns no error", value)
+		}
+	}
+}
+
+func TestGroup_Validate_Target(t *testing.T) {
+	for _, group := range []Group{
+		{Interface: "nwg0"},
+		{Gateway: "192.168.1.2"},
+		{Interface: "br0", Gateway: "192.168.1.2"},
+		{Table: 100},
+	} {
+		if err := group.Validate(); err != nil {
+			t.Fatalf("valid target %+v rejected: %v", group, err)
+		}
+	}
+	for _, group := range []Group{
+		{Gateway: "fd00::1"},
+		{Gateway: "gateway"},
+		{Interface: "nwg0", Table: 100},
+		{Table: -1},
+		{Table: 100, RateLimit: "10mbit"},
+	} {
+		if err := group.Validate(); !errors.Is(err, ErrInvalidTarget) {
+			t.Fatalf("invalid target %+v returns %v", group, err)
+		}
+	}
+}
+
+func TestGroup_AddressFamilies(t *testing.T) {
+	disabled, enabled := false, true
+	group := Group{Interface: "nwg0"}
+	if !group.RoutesIPv4() || group.RoutesIPv6() {
+		t.Fatal("group must route only IPv4 by default")
+	}
+	group.RouteIPv6 = &enabled
+	group.RouteIPv4 = &disabled
+	if err := group.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	group.RouteIPv6 = &disabled
+	if err := group.Validate(); !errors.Is(err, ErrNoAddressFamily) {
+		t.Fatalf("group without families returns %v", err)
+	}
+}
+
+func TestGroup_Metadata(t *testing.T) {
+	group := Group{
+		ID:        ID{1, 2, 3, 4},
+		Interface: "nwg0",
+		Metadata:  Metadata{Description: "Streaming", Color: "#3b82f6", Icon: "tv"},
+		Rules: []*Rule{{
+			ID:       ID{1},
+			Type:     "domain",
+			Rule:     "example.com",
+			Metadata: Metadata{Color: "#fff"},
+		}},
+	}
+	if err := group.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := yaml.Marshal(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported Group
+	if err = yaml.Unmarshal(data, &imported); err != nil {
+		t.Fatal(err)
+	}
+	if imported.Metadata != group.Metadata || imported.Rules[0].Metadata != group.Rules[0].Metadata {
+		t.Fatalf("metadata is not preserved:\n%s", data)
+	}
+
+	group.Rules[0].Metadata.Color = "blue"
+	if err = group.Validate(); !errors.Is(err, ErrInvalidColor) {
+		t.Fatalf("invalid color returns %v", err)
+	}
+}
+
+func TestIPTablesSnippet(t *testing.T) {
+	group := Group{ID: ID{1}, Interface: "nwg0"}
+	snippet := IPTablesSnippet{Table: "filter", Chain: "FORWARD", Rule: "-o {interface} -m set --match-set {ipset} dst -j ACCEPT"}
+	err := snippet.Validate(&group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := snippet.Args(map[string]string{SnippetInterface: "nwg0", SnippetIPSet: "mt_00000001"})
+	if len(args) != 9 || args[1] != "nwg0" || args[5] != "mt_00000001" {
+		t.Fatalf("unexpected args %v", args)
+	}
+
+	for _, invalid := range []IPTablesSnippet{
+		{Table: "security", Chain: "FORWARD", Rule: "-j ACCEPT"},
+		{Table: "filter", Chain: "", Rule: "-j ACCEPT"},
+		{Table: "filter", Chain: "FORWARD", Rule: "-o {iface} -j ACCEPT"},
+		{Table: "filter", Chain: "FORWARD", Rule: "-m set --match-set {excludeIpset} dst -j ACCEPT"},
+		{Table: "filter", Chain: "FORWARD", Rule: "-o {interface -j ACCEPT"},
+	} {
+		if err := invalid.Validate(&group); !errors.Is(err, ErrInvalidSnippet) {
+			t.Errorf("%+v: expected invalid snippet, got %v", invalid, err)
+		}
+	}
+}
+
+func TestValidateFixProtect(t *testing.T) {
+	rule := IPTablesSnippet{Table: "filter", Chain: "_NDM_SL_FORWARD", Rule: "-o {interface} -m state --state NEW -j _NDM_SL_PROTECT"}
+	err := rule.ValidateFixProtect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []IPTablesSnippet{
+		{Table: "filter", Chain: "forwarding_rule", Rule: "-o {interface} -m mark --mark {mark} -j ACCEPT"},
+		{Table: "filter", Chain: "forwarding_rule", Rule: ""},
+		{Table: "broute", Chain: "forwarding_rule", Rule: "-o {interface} -j ACCEPT"},
+	} {
+		if err := invalid.ValidateFixProtect(); !errors.Is(err, ErrInvalidSnippet) {
+			t.Errorf("%+v: expected invalid snippet, got %v", invalid, err)
+		}
+	}
+}
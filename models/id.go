@@ -1,16 +1,40 @@
 package models
 
 import (
+	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+
+	"magitrickle/app-errors"
 )
 
+// idAttempts is the number of random IDs tried before giving up, collisions are rare with few thousands of IDs
+const idAttempts = 64
+
+var ErrNoFreeID = appErrors.New(appErrors.ErrConflict, "no free ID")
+
 type ID [4]byte
 
-func (id *ID) String() string {
+// NewID returns a random non-zero ID which isn't taken
+func NewID(taken func(ID) bool) (ID, error) {
+	var id ID
+	for attempt := 0; attempt < idAttempts; attempt++ {
+		_, err := rand.Read(id[:])
+		if err != nil {
+			return ID{}, fmt.Errorf("failed to generate ID: %w", err)
+		}
+		if id != (ID{}) && !taken(id) {
+			return id, nil
+		}
+	}
+	return ID{}, ErrNoFreeID
+}
+
+func (id ID) String() string {
 	return hex.EncodeToString(id[:])
 }
 
-func (id *ID) MarshalText() ([]byte, error) {
+func (id ID) MarshalText() ([]byte, error) {
 	return []byte(id.String()), nil
 }
 
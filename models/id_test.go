@@ -0,0 +1,22 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewID(t *testing.T) {
+	taken := ID{1, 2, 3, 4}
+	id, err := NewID(func(id ID) bool { return id == taken })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == taken || id == (ID{}) {
+		t.Fatalf("unexpected ID %s", id)
+	}
+
+	_, err = NewID(func(ID) bool { return true })
+	if !errors.Is(err, ErrNoFreeID) {
+		t.Fatalf("expected no free ID, got %v", err)
+	}
+}
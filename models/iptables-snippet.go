@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"magitrickle/app-errors"
+)
+
+var ErrInvalidSnippet = appErrors.New(appErrors.ErrValidation, "invalid iptables snippet")
+
+// Placeholders of iptables snippets, they're replaced by values of the enabled group
+const (
+	SnippetMark         = "{mark}"
+	SnippetRouteTable   = "{routeTable}"
+	SnippetIPSet        = "{ipset}"
+	SnippetExcludeIPSet = "{excludeIpset}"
+	SnippetInterface    = "{interface}"
+	SnippetChain        = "{chain}"
+)
+
+var snippetPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// snippetChainRegexp limits names to XT_EXTENSION_MAXNAMELEN
+var snippetChainRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,28}$`)
+
+// IPTablesSnippet is an extra iptables rule installed and removed together with the group,
+// Rule is the arguments of the rule with placeholders (e.g. "-o {interface} -j ACCEPT"), quotes are not supported
+type IPTablesSnippet struct {
+	Table string `yaml:"table"`
+	Chain string `yaml:"chain"`
+	Rule  string `yaml:"rule"`
+}
+
+// Validate checks the table, the chain and placeholders, placeholders must have values in the group
+func (s IPTablesSnippet) Validate(g *Group) error {
+	err := s.validateTarget()
+	if err != nil {
+		return err
+	}
+	for _, placeholder := range snippetPlaceholderRegexp.FindAllString(s.Rule, -1) {
+		switch placeholder {
+		case SnippetIPSet, SnippetChain:
+		case SnippetMark, SnippetRouteTable:
+			if g.Proxy.IsEnabled() {
+				return fmt.Errorf("%w: %s of a proxy group", ErrInvalidSnippet, placeholder)
+			}
+		case SnippetExcludeIPSet:
+			if !g.HasExclusions() {
+				return fmt.Errorf("%w: %s of a group without exclusions", ErrInvalidSnippet, placeholder)
+			}
+		case SnippetInterface:
+			if g.Interface == "" {
+				return fmt.Errorf("%w: %s of a group without interface", ErrInvalidSnippet, placeholder)
+			}
+		default:
+			return fmt.Errorf("%w: unknown placeholder %s", ErrInvalidSnippet, placeholder)
+		}
+	}
+	return s.validateBraces()
+}
+
+// ValidateFixProtect checks the rule of fixProtect, only {interface} is replaced in it
+func (s IPTablesSnippet) ValidateFixProtect() error {
+	err := s.validateTarget()
+	if err != nil {
+		return err
+	}
+	for _, placeholder := range snippetPlaceholderRegexp.FindAllString(s.Rule, -1) {
+		if placeholder != SnippetInterface {
+			return fmt.Errorf("%w: unknown placeholder %s", ErrInvalidSnippet, placeholder)
+		}
+	}
+	return s.validateBraces()
+}
+
+func (s IPTablesSnippet) validateTarget() error {
+	switch s.Table {
+	case "filter", "mangle", "nat", "raw":
+	default:
+		return fmt.Errorf("%w: unknown table %q", ErrInvalidSnippet, s.Table)
+	}
+	if !snippetChainRegexp.MatchString(s.Chain) {
+		return fmt.Errorf("%w: invalid chain %q", ErrInvalidSnippet, s.Chain)
+	}
+	if strings.TrimSpace(s.Rule) == "" {
+		return fmt.Errorf("%w: empty rule", ErrInvalidSnippet)
+	}
+	return nil
+}
+
+func (s IPTablesSnippet) validateBraces() error {
+	if strings.ContainsAny(snippetPlaceholderRegexp.ReplaceAllString(s.Rule, ""), "{}") {
+		return fmt.Errorf("%w: unbalanced braces", ErrInvalidSnippet)
+	}
+	return nil
+}
+
+// Args returns arguments of the rule with placeholders replaced by the values
+func (s IPTablesSnippet) Args(values map[string]string) []string {
+	rule := snippetPlaceholderRegexp.ReplaceAllStringFunc(s.Rule, func(placeholder string) string {
+		return values[placeholder]
+	})
+	return strings.Fields(rule)
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+
+	"magitrickle/app-errors"
+)
+
+var ErrInvalidColor = appErrors.New(appErrors.ErrValidation, "invalid color")
+
+var colorRegexp = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Metadata is shown by frontends only, it doesn't affect routing
+type Metadata struct {
+	Description string `yaml:"description,omitempty"`
+	// Color is a CSS hex color (#rgb or #rrggbb)
+	Color string `yaml:"color,omitempty"`
+	// Icon is a name or an URL of the icon, it's up to the frontend
+	Icon string `yaml:"icon,omitempty"`
+}
+
+func (m Metadata) Validate() error {
+	if m.Color != "" && !colorRegexp.MatchString(m.Color) {
+		return fmt.Errorf("%w: %q", ErrInvalidColor, m.Color)
+	}
+	return nil
+}
@@ -1,26 +1,171 @@
 package models
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
+	"magitrickle/app-errors"
+
 	"github.com/IGLOU-EU/go-wildcard/v2"
+	"github.com/miekg/dns"
+)
+
+const (
+	RuleActionRoute   = "route"
+	RuleActionExclude = "exclude"
+	// RuleActionResolver routes addresses through the group of the interface their answer came from,
+	// see UpstreamSet.Interface
+	RuleActionResolver = "resolver"
+	// RuleActionLog records matches in history and stats without routing, to measure a rule before enabling it
+	RuleActionLog = "log"
+	// MaxAdditionalTTL is the limit of ipset timeouts
+	MaxAdditionalTTL = 2147483
 )
 
 type Rule struct {
-	ID     ID     `yaml:"id"`
-	Name   string `yaml:"name"`
-	Type   string `yaml:"type"`
-	Rule   string `yaml:"rule"`
-	Enable bool   `yaml:"enable"`
+	ID       ID       `yaml:"id"`
+	Slug     string   `yaml:"slug,omitempty"`
+	Name     string   `yaml:"name"`
+	Metadata Metadata `yaml:",inline"`
+	Type     string   `yaml:"type"`
+	Rule     string   `yaml:"rule"`
+	Action   string   `yaml:"action,omitempty"`
+	Enable   bool     `yaml:"enable"`
+	// Expression is used instead of Rule by the "expression" type
+	Expression *Expression `yaml:"expression,omitempty"`
+	// QTypes limits the rule to answers of queries of these types (A, HTTPS...), empty - any
+	QTypes []string `yaml:"qtypes,omitempty"`
+	// Subscription is the ID of the subscription which list the rule comes from, such rules are
+	// replaced on every update of the list
+	Subscription *ID `yaml:"subscription,omitempty"`
+	// AdditionalTTL overrides netfilter.ipset.additionalTTL for addresses matched by the rule
+	AdditionalTTL *uint32 `yaml:"additionalTTL,omitempty"`
+}
+
+var (
+	ErrUnknownRuleType   = appErrors.New(appErrors.ErrValidation, "unknown rule type")
+	ErrUnknownRuleAction = appErrors.New(appErrors.ErrValidation, "unknown rule action")
+	ErrUnknownQType      = appErrors.New(appErrors.ErrValidation, "unknown query type")
+	ErrInvalidRuleTTL    = appErrors.New(appErrors.ErrValidation, "invalid rule TTL")
+)
+
+func (d *Rule) Validate() error {
+	if d.Slug != "" {
+		err := ValidateSlug(d.Slug)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", d.ID.String(), err)
+		}
+	}
+	err := d.Metadata.Validate()
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", d.ID.String(), err)
+	}
+	switch d.Type {
+	case "wildcard", "domain", "namespace":
+	case "subnet":
+		_, err := ParseCIDR(d.Rule)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid subnet: %w", d.ID.String(), err)
+		}
+	case "expression":
+		if d.Expression == nil {
+			return fmt.Errorf("rule %s: %w: missing expression", d.ID.String(), ErrInvalidExpression)
+		}
+		err := d.Expression.Validate()
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", d.ID.String(), err)
+		}
+	case "regex":
+		_, err := regexp.Compile(d.Rule)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid regex: %w", d.ID.String(), err)
+		}
+	default:
+		return fmt.Errorf("rule %s: %w: %s", d.ID.String(), ErrUnknownRuleType, d.Type)
+	}
+	switch d.Action {
+	case "", RuleActionRoute, RuleActionExclude:
+	case RuleActionResolver, RuleActionLog:
+		if d.IsStatic() {
+			return fmt.Errorf("rule %s: %w: %s of a subnet", d.ID.String(), ErrUnknownRuleAction, d.Action)
+		}
+	default:
+		return fmt.Errorf("rule %s: %w: %s", d.ID.String(), ErrUnknownRuleAction, d.Action)
+	}
+	for _, qtype := range d.QTypes {
+		if _, ok := dns.StringToType[strings.ToUpper(qtype)]; !ok {
+			return fmt.Errorf("rule %s: %w: %s", d.ID.String(), ErrUnknownQType, qtype)
+		}
+	}
+	if d.AdditionalTTL != nil {
+		if d.IsStatic() {
+			return fmt.Errorf("rule %s: %w: subnets are permanent", d.ID.String(), ErrInvalidRuleTTL)
+		}
+		if *d.AdditionalTTL > MaxAdditionalTTL {
+			return fmt.Errorf("rule %s: %w: must be up to %d", d.ID.String(), ErrInvalidRuleTTL, MaxAdditionalTTL)
+		}
+	}
+	return nil
+}
+
+// HasQType reports whether the rule applies to answers of the query type, 0 is an unknown type
+func (d *Rule) HasQType(qtype uint16) bool {
+	if len(d.QTypes) == 0 || qtype == 0 {
+		return true
+	}
+	for _, name := range d.QTypes {
+		if dns.StringToType[strings.ToUpper(name)] == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// HasKey reports whether the key is the slug or the hex ID of the rule
+func (d *Rule) HasKey(key string) bool {
+	return (d.Slug != "" && key == d.Slug) || key == d.ID.String()
+}
+
+// IsExclude reports whether addresses of matched domains must bypass the group
+func (d *Rule) IsExclude() bool {
+	return d.Action == RuleActionExclude
+}
+
+// IsResolver reports whether addresses of matched domains are routed by the interface of the resolving upstream
+func (d *Rule) IsResolver() bool {
+	return d.Action == RuleActionResolver
+}
+
+// IsLog reports whether matches of the rule are only recorded, addresses aren't routed
+func (d *Rule) IsLog() bool {
+	return d.Action == RuleActionLog
+}
+
+// IsStatic reports whether the rule is an address or a network instead of a domain
+func (d *Rule) IsStatic() bool {
+	return d.Type == "subnet"
 }
 
 func (d *Rule) IsEnabled() bool {
 	return d.Enable
 }
 
+// IsMatchContext is IsMatch taking the query type, the client and the time into account
+func (d *Rule) IsMatchContext(ctx MatchContext) bool {
+	if !d.HasQType(ctx.QType) {
+		return false
+	}
+	if d.Type == "expression" {
+		return d.Expression != nil && d.Expression.Evaluate(ctx)
+	}
+	return d.IsMatch(ctx.Domain)
+}
+
 func (d *Rule) IsMatch(domainName string) bool {
 	switch d.Type {
+	case "expression":
+		return d.Expression != nil && d.Expression.Evaluate(MatchContext{Domain: domainName})
 	case "wildcard":
 		return wildcard.Match(d.Rule, domainName)
 	case "regex":
@@ -36,3 +181,8 @@ func (d *Rule) IsMatch(domainName string) bool {
 	}
 	return false
 }
+
+// FromSubscription reports whether the rule comes from the list of the subscription
+func (d *Rule) FromSubscription(id ID) bool {
+	return d.Subscription != nil && *d.Subscription == id
+}
@@ -1,6 +1,11 @@
 package models
 
-import "testing"
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
 
 func TestDomain_IsMatch_Domain(t *testing.T) {
 	rule := &Rule{
@@ -40,3 +45,61 @@ func TestDomain_IsMatch_RegEx(t *testing.T) {
 		t.Fatal("&Rule{Type: \"regex\", Rule: \"^ex[apm]{3}le.com$\"}.IsMatch(\"noexample.com\") returns true")
 	}
 }
+
+func TestDomain_Validate_Subnet(t *testing.T) {
+	rule := &Rule{
+		Type: "subnet",
+		Rule: "10.0.0.0/8",
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if rule.IsMatch("10.0.0.0/8") {
+		t.Fatal("&Rule{Type: \"subnet\"}.IsMatch(...) returns true")
+	}
+	rule.Rule = "10.0.0.0/33"
+	if err := rule.Validate(); err == nil {
+		t.Fatal("&Rule{Type: \"subnet\", Rule: \"10.0.0.0/33\"}.Validate() returns nil")
+	}
+}
+
+func TestRule_QTypes(t *testing.T) {
+	rule := &Rule{
+		Type:   "domain",
+		Rule:   "example.com",
+		QTypes: []string{"https"},
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !rule.IsMatchContext(MatchContext{Domain: "example.com", QType: dns.TypeHTTPS}) {
+		t.Fatal("rule must match HTTPS answers")
+	}
+	if rule.IsMatchContext(MatchContext{Domain: "example.com", QType: dns.TypeA}) {
+		t.Fatal("rule must not match A answers")
+	}
+	if !rule.IsMatchContext(MatchContext{Domain: "example.com"}) {
+		t.Fatal("rule must match answers of unknown queries")
+	}
+	rule.QTypes = []string{"NOPE"}
+	if err := rule.Validate(); err == nil {
+		t.Fatal("unknown query type must be rejected")
+	}
+}
+
+func TestRule_AdditionalTTL(t *testing.T) {
+	ttl := uint32(86400)
+	rule := &Rule{Type: "domain", Rule: "example.com", AdditionalTTL: &ttl}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ttl = MaxAdditionalTTL + 1
+	if err := rule.Validate(); !errors.Is(err, ErrInvalidRuleTTL) {
+		t.Fatalf("expected invalid TTL, got %v", err)
+	}
+	ttl = 60
+	rule.Type, rule.Rule = "subnet", "10.0.0.0/8"
+	if err := rule.Validate(); !errors.Is(err, ErrInvalidRuleTTL) {
+		t.Fatalf("expected invalid TTL of a subnet, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+)
+
+// SchemaDialect is the JSON Schema draft of generated schemas
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Schema returns the JSON Schema of the YAML form of the type generated from its fields and yaml tags.
+// Structs are definitions in $defs (so recursive types are possible), they don't allow unknown properties.
+func Schema(t reflect.Type) map[string]interface{} {
+	defs := make(map[string]interface{})
+	schema := schemaOf(t, defs)
+	schema["$schema"] = SchemaDialect
+	if len(defs) != 0 {
+		schema["$defs"] = defs
+	}
+	return schema
+}
+
+// ConfigSchema returns the JSON Schema of Config
+func ConfigSchema() map[string]interface{} {
+	return Schema(reflect.TypeOf(Config{}))
+}
+
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		if _, ok := defs[t.Name()]; !ok {
+			// The placeholder stops the recursion of self-referencing types
+			defs[t.Name()] = nil
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	addStructProperties(t, properties, defs)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// addStructProperties adds fields of the struct to properties, inline fields are flattened
+func addStructProperties(t reflect.Type, properties map[string]interface{}, defs map[string]interface{}) {
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if strings.Contains(","+options+",", ",inline,") {
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			addStructProperties(fieldType, properties, defs)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		properties[name] = schemaOf(field.Type, defs)
+	}
+}
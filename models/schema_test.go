@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatal(err)
+	}
+	defs := schema["$defs"].(map[string]interface{})
+
+	group := defs["Group"].(map[string]interface{})
+	properties := group["properties"].(map[string]interface{})
+	if group["additionalProperties"] != false {
+		t.Fatalf("unknown properties of groups are allowed: %v", group)
+	}
+	if properties["id"].(map[string]interface{})["type"] != "string" {
+		t.Fatalf("ID is not a string: %v", properties["id"])
+	}
+	// Metadata is inline
+	if _, ok := properties["color"]; !ok {
+		t.Fatalf("inline fields are missing: %v", properties)
+	}
+	if properties["routeIPv4"].(map[string]interface{})["type"] != "boolean" {
+		t.Fatalf("unexpected pointer field: %v", properties["routeIPv4"])
+	}
+
+	expression := defs["Expression"].(map[string]interface{})["properties"].(map[string]interface{})
+	if expression["not"].(map[string]interface{})["$ref"] != "#/$defs/Expression" {
+		t.Fatalf("unexpected recursive field: %v", expression["not"])
+	}
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+
+	"magitrickle/app-errors"
+)
+
+const (
+	// DefaultSubscriptionInterval is the interval of list updates in seconds
+	DefaultSubscriptionInterval = 86400
+	// DefaultHoldThreshold is the percent of removed rules which holds the update until it's approved
+	DefaultHoldThreshold = 50
+)
+
+var (
+	ErrInvalidSubscription   = appErrors.New(appErrors.ErrValidation, "invalid subscription")
+	ErrDuplicateSubscription = appErrors.New(appErrors.ErrValidation, "duplicate subscription id")
+)
+
+// Subscription fills rules of the group from the domain list published at the URL
+type Subscription struct {
+	ID  ID     `yaml:"id"`
+	URL string `yaml:"url"`
+	// Interval between updates in seconds, 0 - DefaultSubscriptionInterval
+	Interval uint32 `yaml:"interval,omitempty"`
+	// HoldThreshold is the percent of rules an update may remove before it waits for approval,
+	// 0 - DefaultHoldThreshold, 100 - updates are never held
+	HoldThreshold int `yaml:"holdThreshold,omitempty"`
+}
+
+func (s Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("subscription %s: %w: %v", s.ID.String(), ErrInvalidSubscription, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("subscription %s: %w: unsupported scheme %q", s.ID.String(), ErrInvalidSubscription, u.Scheme)
+	}
+	if s.HoldThreshold < 0 || s.HoldThreshold > 100 {
+		return fmt.Errorf("subscription %s: %w: hold threshold must be 0-100", s.ID.String(), ErrInvalidSubscription)
+	}
+	return nil
+}
+
+// IntervalOrDefault returns the update interval in seconds
+func (s Subscription) IntervalOrDefault() uint32 {
+	if s.Interval == 0 {
+		return DefaultSubscriptionInterval
+	}
+	return s.Interval
+}
+
+// HoldThresholdOrDefault returns the hold threshold in percent
+func (s Subscription) HoldThresholdOrDefault() int {
+	if s.HoldThreshold == 0 {
+		return DefaultHoldThreshold
+	}
+	return s.HoldThreshold
+}
+
+// FindSubscription returns the subscription of the group by its hex ID
+func (g *Group) FindSubscription(key string) *Subscription {
+	for idx := range g.Subscriptions {
+		if g.Subscriptions[idx].ID.String() == key {
+			return &g.Subscriptions[idx]
+		}
+	}
+	return nil
+}
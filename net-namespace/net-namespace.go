@@ -0,0 +1,149 @@
+// Package netNamespace binds netlink requests, iptables calls and listening
+// sockets of the daemon to a network namespace. Without Set everything works
+// in the namespace of the process.
+package netNamespace
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// Netlink is the handle for all netlink requests of the daemon
+var Netlink = &netlink.Handle{}
+
+var (
+	handle  = netns.None()
+	current string
+)
+
+// Path returns the namespace path by a name of "ip netns" or the path itself
+func Path(name string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	return filepath.Join("/var/run/netns", name)
+}
+
+// Set switches netlink, iptables and sockets to the namespace, it can't be changed later
+func Set(name string) error {
+	path := Path(name)
+	if current == path {
+		return nil
+	}
+	if current != "" {
+		return fmt.Errorf("network namespace is already set to %s", current)
+	}
+	nsHandle, err := netns.GetFromPath(path)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace: %w", err)
+	}
+	nlHandle, err := netlink.NewHandleAt(nsHandle)
+	if err != nil {
+		_ = nsHandle.Close()
+		return fmt.Errorf("failed to create netlink handle: %w", err)
+	}
+
+	err = wrapIPTables(path)
+	if err != nil {
+		nlHandle.Close()
+		_ = nsHandle.Close()
+		return err
+	}
+
+	handle = nsHandle
+	Netlink = nlHandle
+	current = path
+	return nil
+}
+
+// Handle returns the namespace for netlink subscriptions, nil if not set
+func Handle() *netns.NsHandle {
+	if !handle.IsOpen() {
+		return nil
+	}
+	return &handle
+}
+
+// wrapIPTables puts wrappers entering the namespace before iptables binaries in PATH,
+// go-iptables looks the binaries up by name
+func wrapIPTables(path string) error {
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return fmt.Errorf("nsenter is required to run iptables in network namespace: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "magitrickle-netns-")
+	if err != nil {
+		return fmt.Errorf("failed to create iptables wrappers: %w", err)
+	}
+	for _, name := range []string{"iptables", "ip6tables"} {
+		binary, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		script := fmt.Sprintf("#!/bin/sh\nexec %s --net=%s %s \"$@\"\n", nsenter, path, binary)
+		err = os.WriteFile(filepath.Join(dir, name), []byte(script), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create iptables wrappers: %w", err)
+		}
+	}
+	return os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// run calls fn with the OS thread switched to the namespace
+func run(fn func() error) error {
+	if !handle.IsOpen() {
+		return fn()
+	}
+
+	// The thread stays locked if the namespace cannot be restored, so it dies with the goroutine
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer func() {
+		if netns.Set(origin) == nil {
+			runtime.UnlockOSThread()
+		}
+		_ = origin.Close()
+	}()
+
+	err = netns.Set(handle)
+	if err != nil {
+		return fmt.Errorf("failed to enter network namespace: %w", err)
+	}
+	return fn()
+}
+
+// Listen is net.Listen inside the namespace
+func Listen(network, address string) (listener net.Listener, err error) {
+	err = run(func() error {
+		listener, err = net.Listen(network, address)
+		return err
+	})
+	return listener, err
+}
+
+// ListenPacket is net.ListenPacket inside the namespace
+func ListenPacket(network, address string) (conn net.PacketConn, err error) {
+	err = run(func() error {
+		conn, err = net.ListenPacket(network, address)
+		return err
+	})
+	return conn, err
+}
+
+// Run calls fn inside the namespace, e.g. for raw netlink requests not covered by Netlink
+func Run(fn func() error) error {
+	return run(fn)
+}
@@ -0,0 +1,131 @@
+package netfilterHelper
+
+import (
+	"os/exec"
+	"strings"
+	"sync/atomic"
+
+	"magitrickle/app-errors"
+	"magitrickle/net-namespace"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+)
+
+var ErrNoIPSet = appErrors.New(appErrors.ErrNetfilter, "kernel has no ipset support")
+
+// Capabilities are kernel and userspace features found at startup, old kernels of MIPS routers
+// often lack some of them
+type Capabilities struct {
+	IPSet bool `json:"ipset"`
+	// IPSetTimeout is support of entry timeouts, without it entries are deleted when their records expire
+	IPSetTimeout bool `json:"ipsetTimeout"`
+	// MarkMask is support of masks of fwmark in ip rules
+	MarkMask bool `json:"markMask"`
+	// NFTables is set when nft is installed or iptables is the nf_tables variant
+	NFTables bool `json:"nftables"`
+	IPv6     bool `json:"ipv6"`
+}
+
+var detected atomic.Pointer[Capabilities]
+
+// Detected returns capabilities found by the last Detect, nil if it wasn't called
+func Detected() *Capabilities {
+	return detected.Load()
+}
+
+// timeoutSupported reports whether entries may have timeouts, they're assumed supported until detected
+func timeoutSupported() bool {
+	caps := detected.Load()
+	return caps == nil || caps.IPSetTimeout
+}
+
+// Detect probes features of the kernel, probeName is the name of the temporary ipset.
+// Missing features are logged, the caller fails only on ErrNoIPSet.
+func Detect(probeName string) (Capabilities, error) {
+	var caps Capabilities
+
+	err := withTimeout("ipset protocol", func() error {
+		_, _, err := netNamespace.Netlink.IpsetProtocol()
+		return err
+	})
+	caps.IPSet = err == nil
+	if caps.IPSet {
+		caps.IPSetTimeout = probeIPSetTimeout(probeName)
+	}
+	caps.MarkMask = probeMarkMask()
+	caps.NFTables = probeNFTables()
+	_, err = iptables.New(iptables.IPFamily(iptables.ProtocolIPv6), iptables.Timeout(iptablesWait()))
+	caps.IPv6 = err == nil
+
+	detected.Store(&caps)
+
+	if !caps.IPSet {
+		return caps, ErrNoIPSet
+	}
+	if !caps.IPSetTimeout {
+		log.Warn().Msg("ipset has no timeout support, addresses are deleted when their records expire")
+	}
+	if !caps.MarkMask {
+		log.Warn().Msg("ip rules have no fwmark mask support")
+	}
+	if !caps.IPv6 {
+		log.Warn().Err(err).Msg("ip6tables is not available, IPv6 DNS remap is disabled")
+	}
+	log.Info().
+		Bool("ipsetTimeout", caps.IPSetTimeout).
+		Bool("markMask", caps.MarkMask).
+		Bool("nftables", caps.NFTables).
+		Bool("ipv6", caps.IPv6).
+		Msg("detected capabilities")
+	return caps, nil
+}
+
+func probeIPSetTimeout(name string) bool {
+	_ = withTimeout("ipset destroy", func() error {
+		return netNamespace.Netlink.IpsetDestroy(name)
+	})
+	err := withTimeout("ipset create", func() error {
+		return netNamespace.Netlink.IpsetCreate(name, "hash:net", netlink.IpsetCreateOptions{
+			Timeout: func(i uint32) *uint32 { return &i }(1),
+		})
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("ipset timeout probe failed")
+		return false
+	}
+	_ = withTimeout("ipset destroy", func() error {
+		return netNamespace.Netlink.IpsetDestroy(name)
+	})
+	return true
+}
+
+// probeMarkMask adds the rule looking up the main table, so it doesn't change routing while it exists
+func probeMarkMask() bool {
+	mask := uint32(0x80000000)
+	rule := netlink.NewRule()
+	rule.Mark = 0x80000000
+	rule.Mask = &mask
+	rule.Table = 254
+	rule.Priority = 32765
+	err := withTimeout("rule add", func() error {
+		return netNamespace.Netlink.RuleAdd(rule)
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("fwmark mask probe failed")
+		return false
+	}
+	_ = withTimeout("rule del", func() error {
+		return netNamespace.Netlink.RuleDel(rule)
+	})
+	return true
+}
+
+func probeNFTables() bool {
+	if _, err := exec.LookPath("nft"); err == nil {
+		return true
+	}
+	out, err := exec.Command("iptables", "-V").Output()
+	return err == nil && strings.Contains(string(out), "nf_tables")
+}
@@ -0,0 +1,38 @@
+package netfilterHelper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimeoutSupported(t *testing.T) {
+	origin := detected.Load()
+	defer detected.Store(origin)
+
+	detected.Store(nil)
+	if !timeoutSupported() {
+		t.Fatal("timeouts must be assumed supported until detected")
+	}
+	detected.Store(&Capabilities{IPSet: true})
+	if timeoutSupported() {
+		t.Fatal("timeouts must be dropped when ipset has no timeout support")
+	}
+	detected.Store(&Capabilities{IPSet: true, IPSetTimeout: true})
+	if !timeoutSupported() {
+		t.Fatal("timeouts must be kept when ipset supports them")
+	}
+}
+
+func TestParseCapEff(t *testing.T) {
+	status := "Name:\tmagitrickled\nCapInh:\t0000000000003000\nCapEff:\t0000000000003000\n"
+	effective, err := parseCapEff(strings.NewReader(status))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if effective&(1<<CapNetAdmin.Bit) == 0 || effective&(1<<CapNetRaw.Bit) == 0 || effective&(1<<CapSysAdmin.Bit) != 0 {
+		t.Fatalf("unexpected capabilities %x", effective)
+	}
+	if _, err := parseCapEff(strings.NewReader("Name:\tmagitrickled\n")); err == nil {
+		t.Fatal("expected error without CapEff")
+	}
+}
@@ -0,0 +1,54 @@
+package netfilterHelper
+
+import (
+	"fmt"
+	"net"
+
+	"magitrickle/net-namespace"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// OriginalSource looks up conntrack for the connection translated to the peer address and port
+// (e.g. by SNAT or masquerading on the way to the local port) and returns its original source.
+// ok is false if the connection is not translated or not found.
+func OriginalSource(proto uint8, peer net.IP, peerPort, localPort uint16) (net.IP, uint16, bool, error) {
+	family := netlink.FAMILY_V4
+	if peer.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	var flows []*netlink.ConntrackFlow
+	err := withTimeout("conntrack list", func() (err error) {
+		flows, err = netNamespace.Netlink.ConntrackTableList(netlink.ConntrackTable, netlink.InetFamily(family))
+		return err
+	})
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to list conntrack entries: %w", err)
+	}
+	src, srcPort, ok := findOriginal(flows, proto, peer, peerPort, localPort)
+	return src, srcPort, ok, nil
+}
+
+// findOriginal returns the original source of the flow which reply goes from the local port to the peer
+func findOriginal(flows []*netlink.ConntrackFlow, proto uint8, peer net.IP, peerPort, localPort uint16) (net.IP, uint16, bool) {
+	for _, flow := range flows {
+		if flow.Forward.Protocol != proto || flow.Reverse.SrcPort != localPort || flow.Reverse.DstPort != peerPort {
+			continue
+		}
+		if !flow.Reverse.DstIP.Equal(peer) {
+			continue
+		}
+		if flow.Forward.SrcIP.Equal(peer) && flow.Forward.SrcPort == peerPort {
+			return nil, 0, false
+		}
+		return flow.Forward.SrcIP, flow.Forward.SrcPort, true
+	}
+	return nil, 0, false
+}
+
+// Protocol numbers of OriginalSource
+const (
+	ProtoTCP = unix.IPPROTO_TCP
+	ProtoUDP = unix.IPPROTO_UDP
+)
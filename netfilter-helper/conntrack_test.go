@@ -0,0 +1,33 @@
+package netfilterHelper
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestFindOriginal(t *testing.T) {
+	router, client := net.IPv4(192, 168, 1, 1).To4(), net.IPv4(192, 168, 2, 20).To4()
+	flows := []*netlink.ConntrackFlow{
+		{
+			Forward: netlink.IPTuple{Protocol: ProtoUDP, SrcIP: net.IPv4(192, 168, 1, 30).To4(), SrcPort: 40000},
+			Reverse: netlink.IPTuple{Protocol: ProtoUDP, SrcPort: 3553, DstIP: net.IPv4(192, 168, 1, 30).To4(), DstPort: 40000},
+		},
+		{
+			Forward: netlink.IPTuple{Protocol: ProtoUDP, SrcIP: client, SrcPort: 50000, DstPort: 53},
+			Reverse: netlink.IPTuple{Protocol: ProtoUDP, SrcPort: 3553, DstIP: router, DstPort: 40000},
+		},
+	}
+
+	src, port, ok := findOriginal(flows, ProtoUDP, router, 40000, 3553)
+	if !ok || !src.Equal(client) || port != 50000 {
+		t.Fatalf("unexpected original source: %v:%d %v", src, port, ok)
+	}
+	if _, _, ok = findOriginal(flows, ProtoTCP, router, 40000, 3553); ok {
+		t.Fatal("flow of another protocol is matched")
+	}
+	if _, _, ok = findOriginal(flows, ProtoUDP, router, 40001, 3553); ok {
+		t.Fatal("flow of another port is matched")
+	}
+}
@@ -0,0 +1,108 @@
+package netfilterHelper
+
+import (
+	"fmt"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// IPSetBlock rejects (or drops) HTTPS forwarded to addresses of the ipset, TCP is reset and UDP (QUIC)
+// gets port-unreachable, so clients fall back fast
+type IPSetBlock struct {
+	IPTables  *iptables.IPTables
+	ChainName string
+	IPSetName string
+	Drop      bool
+
+	enabled bool
+}
+
+func (r *IPSetBlock) target(proto string) []string {
+	if r.Drop {
+		return []string{"-j", "DROP"}
+	}
+	if proto == "tcp" {
+		return []string{"-j", "REJECT", "--reject-with", "tcp-reset"}
+	}
+	return []string{"-j", "REJECT"}
+}
+
+func (r *IPSetBlock) insertIPTablesRules(table string) error {
+	if table == "" || table == "filter" {
+		err := r.IPTables.NewChain("filter", r.ChainName)
+		if err != nil {
+			// If not "AlreadyExists"
+			if eerr, eok := err.(*iptables.Error); !(eok && eerr.ExitStatus() == 1) {
+				return fmt.Errorf("failed to create chain: %w", err)
+			}
+		}
+
+		for _, proto := range []string{"tcp", "udp"} {
+			iptablesArgs := append([]string{"-p", proto, "--dport", "443"}, r.target(proto)...)
+			err = r.IPTables.AppendUnique("filter", r.ChainName, iptablesArgs...)
+			if err != nil {
+				return fmt.Errorf("failed to append rule: %w", err)
+			}
+		}
+
+		err = r.IPTables.InsertUnique("filter", "FORWARD", 1, "-m", "set", "--match-set", r.IPSetName, "dst", "-j", r.ChainName)
+		if err != nil {
+			return fmt.Errorf("failed to linking chain: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *IPSetBlock) deleteIPTablesRules() []error {
+	var errs []error
+
+	err := r.IPTables.DeleteIfExists("filter", "FORWARD", "-m", "set", "--match-set", r.IPSetName, "dst", "-j", r.ChainName)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
+	}
+
+	err = r.IPTables.ClearAndDeleteChain("filter", r.ChainName)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
+	}
+
+	return errs
+}
+
+func (r *IPSetBlock) Enable() error {
+	if r.enabled {
+		return nil
+	}
+
+	err := r.insertIPTablesRules("")
+	if err != nil {
+		r.Disable()
+		return err
+	}
+
+	r.enabled = true
+	return nil
+}
+
+func (r *IPSetBlock) Disable() []error {
+	errs := r.deleteIPTablesRules()
+	r.enabled = false
+	return errs
+}
+
+func (r *IPSetBlock) NetfilterDHook(table string) error {
+	if !r.enabled {
+		return nil
+	}
+	return r.insertIPTablesRules(table)
+}
+
+func (nh *NetfilterHelper) IPSetBlock(name, ipsetName string, drop bool) *IPSetBlock {
+	return &IPSetBlock{
+		IPTables:  nh.IPTables,
+		ChainName: name,
+		IPSetName: ipsetName,
+		Drop:      drop,
+	}
+}
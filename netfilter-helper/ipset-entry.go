@@ -0,0 +1,107 @@
+package netfilterHelper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"magitrickle/net-namespace"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// IPSetOptions are extensions of the created ipset
+type IPSetOptions struct {
+	// Counters keeps packet and byte counters of every entry (see Entries)
+	Counters bool
+	// Skbinfo allows entries to carry SkbMark, iptables "-j SET --map-set <name> dst --map-mark" applies it
+	Skbinfo bool
+}
+
+// Entry is an address or a network of the ipset with advanced options
+type Entry struct {
+	Network *net.IPNet
+	Timeout *uint32
+	// Nomatch makes the entry an exception of wider networks of the set (hash:net)
+	Nomatch bool
+	// SkbMark is the packet mark of the entry, the ipset must be created with Skbinfo
+	SkbMark *uint32
+	// SkbMask is the mask of SkbMark, 0 - the whole mark
+	SkbMask uint32
+}
+
+// entryData encodes the entry as IPSET_ATTR_DATA
+func entryData(entry Entry) *nl.RtAttr {
+	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+
+	ip, typ := entry.Network.IP, int(nl.NLA_F_NET_BYTEORDER)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, typ = ip4, typ|nl.IPSET_ATTR_IPADDR_IPV4
+	} else {
+		typ |= nl.IPSET_ATTR_IPADDR_IPV6
+	}
+	data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nl.NewRtAttr(typ, ip).Serialize()))
+	if ones, bits := entry.Network.Mask.Size(); ones != bits {
+		data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_CIDR, nl.Uint8Attr(uint8(ones))))
+	}
+
+	if entry.Timeout != nil {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: *entry.Timeout})
+	}
+	if entry.Nomatch {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: nl.IPSET_FLAG_NOMATCH})
+	}
+	if entry.SkbMark != nil {
+		mask := entry.SkbMask
+		if mask == 0 {
+			mask = 0xffffffff
+		}
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(*entry.SkbMark)<<32|uint64(mask))
+		data.AddChild(nl.NewRtAttr(nl.IPSET_ATTR_SKBMARK|int(nl.NLA_F_NET_BYTEORDER), value))
+	}
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_LINENO | nl.NLA_F_NET_BYTEORDER, Value: 0})
+	return data
+}
+
+// AddEntry adds or replaces the entry with its options, netlink.Handle doesn't support nomatch and skbinfo
+func (r *IPSet) AddEntry(entry Entry) error {
+	if !timeoutSupported() {
+		entry.Timeout = nil
+	}
+	req := nl.NewNetlinkRequest(nl.IPSET_CMD_ADD|(unix.NFNL_SUBSYS_IPSET<<8), nl.GetIpsetFlags(nl.IPSET_CMD_ADD))
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: uint8(unix.AF_NETLINK), Version: nl.NFNETLINK_V0})
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(r.SetName)))
+	req.AddData(entryData(entry))
+
+	err := withTimeout("ipset add", func() error {
+		return netNamespace.Run(func() error {
+			_, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+			if errno, ok := err.(syscall.Errno); ok && int(errno) >= nl.IPSET_ERR_PRIVATE {
+				err = nl.IPSetError(uintptr(errno))
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add entry: %w", err)
+	}
+	return nil
+}
+
+// Entries returns entries of the ipset, packet and byte counters are set for ipsets with Counters
+func (r *IPSet) Entries() ([]netlink.IPSetEntry, error) {
+	var list *netlink.IPSetResult
+	err := withTimeout("ipset list", func() (err error) {
+		list, err = netNamespace.Netlink.IpsetList(r.SetName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ipset: %w", err)
+	}
+	return list.Entries, nil
+}
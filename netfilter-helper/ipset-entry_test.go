@@ -0,0 +1,36 @@
+package netfilterHelper
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+func TestEntryData(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.1.0.0/16")
+	mark := uint32(0x100)
+	data := entryData(Entry{Network: network, Nomatch: true, SkbMark: &mark}).Serialize()
+
+	attrs, err := nl.ParseRouteAttr(data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[uint16][]byte)
+	for _, attr := range attrs {
+		found[attr.Attr.Type&nl.NLA_TYPE_MASK] = attr.Value
+	}
+	if cidr := found[nl.IPSET_ATTR_CIDR]; len(cidr) != 1 || cidr[0] != 16 {
+		t.Fatalf("unexpected cidr: %v", cidr)
+	}
+	if flags := found[nl.IPSET_ATTR_CADT_FLAGS]; len(flags) != 4 || binary.BigEndian.Uint32(flags) != nl.IPSET_FLAG_NOMATCH {
+		t.Fatalf("unexpected flags: %v", flags)
+	}
+	if skbmark := found[nl.IPSET_ATTR_SKBMARK]; len(skbmark) != 8 || binary.BigEndian.Uint64(skbmark) != 0x100ffffffff {
+		t.Fatalf("unexpected skbmark: %x", skbmark)
+	}
+	if _, ok := found[nl.IPSET_ATTR_TIMEOUT]; ok {
+		t.Fatal("unexpected timeout")
+	}
+}
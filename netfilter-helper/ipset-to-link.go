@@ -1,10 +1,14 @@
 package netfilterHelper
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strconv"
 
+	"magitrickle/net-namespace"
+
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/rs/zerolog/log"
 	"github.com/vishvananda/netlink"
@@ -16,6 +20,15 @@ type IPSetToLink struct {
 	ChainName string
 	IfaceName string
 	IPSetName string
+	// ExcludeIPSetName is optional, addresses of this set are never marked
+	ExcludeIPSetName string
+	// Gateway is optional, the default route of the table goes via this next hop (IfaceName may be empty)
+	Gateway net.IP
+	// ExistingTable is optional, marked traffic is routed by this table and no route is installed
+	ExistingTable int
+	// PreferredMark and PreferredTable are optional, they are used unless taken by another rule
+	PreferredMark  uint32
+	PreferredTable int
 
 	enabled bool
 	mark    uint32
@@ -24,6 +37,15 @@ type IPSetToLink struct {
 	ipRoute *netlink.Route
 }
 
+// matchArgs returns iptables arguments matching the traffic to route
+func (r *IPSetToLink) matchArgs() []string {
+	args := []string{"-m", "set", "--match-set", r.IPSetName, "dst"}
+	if r.ExcludeIPSetName != "" {
+		args = append(args, "-m", "set", "!", "--match-set", r.ExcludeIPSetName, "dst")
+	}
+	return args
+}
+
 func (r *IPSetToLink) insertIPTablesRules(table string) error {
 	var err error
 
@@ -47,7 +69,7 @@ func (r *IPSetToLink) insertIPTablesRules(table string) error {
 			}
 		}
 
-		err = r.IPTables.InsertUnique("mangle", "PREROUTING", 1, "-m", "set", "--match-set", r.IPSetName, "dst", "-j", r.ChainName)
+		err = r.IPTables.InsertUnique("mangle", "PREROUTING", 1, append(r.matchArgs(), "-j", r.ChainName)...)
 		if err != nil {
 			return fmt.Errorf("failed to append rule to PREROUTING: %w", err)
 		}
@@ -67,7 +89,7 @@ func (r *IPSetToLink) insertIPTablesRules(table string) error {
 			return fmt.Errorf("failed to create rule: %w", err)
 		}
 
-		err = r.IPTables.AppendUnique("nat", "POSTROUTING", "-m", "set", "--match-set", r.IPSetName, "dst", "-j", r.ChainName)
+		err = r.IPTables.AppendUnique("nat", "POSTROUTING", append(r.matchArgs(), "-j", r.ChainName)...)
 		if err != nil {
 			return fmt.Errorf("failed to append rule to POSTROUTING: %w", err)
 		}
@@ -79,7 +101,7 @@ func (r *IPSetToLink) insertIPTablesRules(table string) error {
 func (r *IPSetToLink) deleteIPTablesRules() []error {
 	var errs []error
 
-	err := r.IPTables.DeleteIfExists("mangle", "PREROUTING", "-m", "set", "--match-set", r.IPSetName, "dst", "-j", r.ChainName)
+	err := r.IPTables.DeleteIfExists("mangle", "PREROUTING", append(r.matchArgs(), "-j", r.ChainName)...)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
 	}
@@ -89,7 +111,7 @@ func (r *IPSetToLink) deleteIPTablesRules() []error {
 		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
 	}
 
-	err = r.IPTables.DeleteIfExists("nat", "POSTROUTING", "-m", "set", "--match-set", r.IPSetName, "dst", "-j", r.ChainName)
+	err = r.IPTables.DeleteIfExists("nat", "POSTROUTING", append(r.matchArgs(), "-j", r.ChainName)...)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
 	}
@@ -106,8 +128,10 @@ func (r *IPSetToLink) insertIPRule() error {
 	rule := netlink.NewRule()
 	rule.Mark = r.mark
 	rule.Table = r.table
-	_ = netlink.RuleDel(rule)
-	err := netlink.RuleAdd(rule)
+	err := withTimeout("rule add", func() error {
+		_ = netNamespace.Netlink.RuleDel(rule)
+		return netNamespace.Netlink.RuleAdd(rule)
+	})
 	if err != nil {
 		return fmt.Errorf("error while mapping mark with table: %w", err)
 	}
@@ -123,7 +147,9 @@ func (r *IPSetToLink) deleteIPRule() []error {
 		return nil
 	}
 
-	err := netlink.RuleDel(r.ipRule)
+	err := withTimeout("rule del", func() error {
+		return netNamespace.Netlink.RuleDel(r.ipRule)
+	})
 	if err != nil {
 		return []error{fmt.Errorf("error while deleting rule: %w", err)}
 	}
@@ -132,30 +158,51 @@ func (r *IPSetToLink) deleteIPRule() []error {
 }
 
 func (r *IPSetToLink) insertIPRoute() error {
-	// Find interface
-	iface, err := netlink.LinkByName(r.IfaceName)
-	if err != nil {
-		// TODO: Нормально отлавливать ошибку
-		if err.Error() == "Link not found" {
-			log.Debug().Str("iface", r.IfaceName).Msg("interface not found (waiting for it to exist)")
-			return nil
-		}
-		return fmt.Errorf("error while getting interface: %w", err)
+	if r.ExistingTable != 0 {
+		return nil
 	}
 
-	// Mapping iface with table
 	route := &netlink.Route{
-		LinkIndex: iface.Attrs().Index,
-		Table:     r.table,
-		Dst:       &net.IPNet{IP: []byte{0, 0, 0, 0}, Mask: []byte{0, 0, 0, 0}},
+		Table: r.table,
+		Dst:   &net.IPNet{IP: []byte{0, 0, 0, 0}, Mask: []byte{0, 0, 0, 0}},
+		Gw:    r.Gateway,
 	}
-	// Delete rule if exists
-	err = netlink.RouteAdd(route)
+
+	if r.IfaceName != "" {
+		// Find interface
+		iface, err := netNamespace.Netlink.LinkByName(r.IfaceName)
+		if err != nil {
+			// TODO: Нормально отлавливать ошибку
+			if err.Error() == "Link not found" {
+				log.Debug().Str("iface", r.IfaceName).Msg("interface not found (waiting for it to exist)")
+				return nil
+			}
+			return fmt.Errorf("error while getting interface: %w", err)
+		}
+		route.LinkIndex = iface.Attrs().Index
+
+		// Keenetic re-creates nwg/PPP interfaces on reconnect, the old route went away with the old ifindex
+		if r.ipRoute != nil && r.ipRoute.LinkIndex != route.LinkIndex {
+			log.Debug().Str("iface", r.IfaceName).Int("index", route.LinkIndex).Msg("interface index changed")
+			_ = netNamespace.Netlink.RouteDel(r.ipRoute)
+			r.ipRoute = nil
+		}
+	}
+
+	// Mapping iface with table
+	err := withTimeout("route add", func() error {
+		return netNamespace.Netlink.RouteAdd(route)
+	})
 	if err != nil {
 		// TODO: Нормально отлавливать ошибку
 		if err.Error() == "file exists" {
 			return nil
 		}
+		// The gateway is unreachable until its interface is up
+		if r.Gateway != nil && err.Error() == "network is unreachable" {
+			log.Debug().Str("gateway", r.Gateway.String()).Msg("gateway is unreachable (waiting for it)")
+			return nil
+		}
 		return fmt.Errorf("error while mapping iface with table: %w", err)
 	}
 	r.ipRoute = route
@@ -168,7 +215,9 @@ func (r *IPSetToLink) deleteIPRoute() []error {
 		return nil
 	}
 
-	err := netlink.RouteDel(r.ipRoute)
+	err := withTimeout("route del", func() error {
+		return netNamespace.Netlink.RouteDel(r.ipRoute)
+	})
 	if err != nil {
 		return []error{fmt.Errorf("error while deleting route: %w", err)}
 	}
@@ -181,23 +230,49 @@ func (r *IPSetToLink) getUnusedMarkAndTable() (mark uint32, table int, err error
 	markMap := make(map[uint32]struct{})
 	tableMap := map[int]struct{}{0: {}, 253: {}, 254: {}, 255: {}}
 
-	rules, err := netlink.RuleList(nl.FAMILY_ALL)
+	rules, err := netNamespace.Netlink.RuleList(nl.FAMILY_ALL)
 	if err != nil {
 		return 0, 0, fmt.Errorf("error while getting rules: %w", err)
 	}
+	ownLeftover := false
 	for _, rule := range rules {
+		// The rule left by the previous run is replaced on enable
+		if r.PreferredMark != 0 && rule.Mark == r.PreferredMark && rule.Table == r.PreferredTable {
+			ownLeftover = true
+			continue
+		}
 		markMap[rule.Mark] = struct{}{}
 		tableMap[rule.Table] = struct{}{}
 	}
 
-	routes, err := netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{}, netlink.RT_FILTER_TABLE)
+	routes, err := netNamespace.Netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{}, netlink.RT_FILTER_TABLE)
 	if err != nil {
 		return 0, 0, fmt.Errorf("error while getting routes: %w", err)
 	}
 	for _, route := range routes {
+		if ownLeftover && route.Table == r.PreferredTable {
+			continue
+		}
 		tableMap[route.Table] = struct{}{}
 	}
 
+	if r.PreferredMark != 0 {
+		_, markTaken := markMap[r.PreferredMark]
+		_, tableTaken := tableMap[r.PreferredTable]
+		if !markTaken && (!tableTaken || r.ExistingTable != 0) {
+			if ownLeftover && r.ExistingTable == 0 {
+				for _, err := range DeleteMarkRouting(r.PreferredMark, r.PreferredTable) {
+					log.Debug().Err(err).Msg("failed to delete leftover routing")
+				}
+			}
+			return r.PreferredMark, r.PreferredTable, nil
+		}
+		log.Warn().
+			Int("mark", int(r.PreferredMark)).
+			Int("table", r.PreferredTable).
+			Msg("preferred mark or table is taken, using a free one")
+	}
+
 	for table = 0; table < 0x7ffffffe; table++ {
 		if _, exists := tableMap[table]; !exists {
 			break
@@ -222,6 +297,9 @@ func (r *IPSetToLink) enable() error {
 	if err != nil {
 		return err
 	}
+	if r.ExistingTable != 0 {
+		r.table = r.ExistingTable
+	}
 
 	err = r.IPTables.ClearChain("mangle", r.ChainName)
 	if err != nil {
@@ -277,6 +355,14 @@ func (r *IPSetToLink) Disable() []error {
 	return errs
 }
 
+func (r *IPSetToLink) Mark() uint32 {
+	return r.mark
+}
+
+func (r *IPSetToLink) Table() int {
+	return r.table
+}
+
 func (r *IPSetToLink) NetfilterDHook(table string) error {
 	if !r.enabled {
 		return nil
@@ -285,12 +371,115 @@ func (r *IPSetToLink) NetfilterDHook(table string) error {
 }
 
 func (r *IPSetToLink) LinkUpdateHook(event netlink.LinkUpdate) error {
-	if !r.enabled || event.Change != 1 || event.Link.Attrs().Name != r.IfaceName {
+	if event.Change != 1 {
+		return nil
+	}
+	return r.InterfaceHook(event.Link.Attrs().Name)
+}
+
+// InterfaceHook re-installs the route after the interface came up (netlink or ndm hotplug event)
+func (r *IPSetToLink) InterfaceHook(ifaceName string) error {
+	if !r.enabled {
+		return nil
+	}
+	// Without an interface the gateway may become reachable through any of them
+	if r.IfaceName != "" && ifaceName != r.IfaceName {
 		return nil
 	}
 	return r.insertIPRoute()
 }
 
+// Repair re-installs the ip rule of the mark and the default route of the table if they went missing
+// (ndm rewrites rules and routes after provider reconnects), it reports what was re-installed
+func (r *IPSetToLink) Repair() (rule, route bool, err error) {
+	if !r.enabled {
+		return false, false, nil
+	}
+
+	var rules []netlink.Rule
+	err = withTimeout("rule list", func() (err error) {
+		rules, err = netNamespace.Netlink.RuleListFiltered(nl.FAMILY_V4, &netlink.Rule{Mark: r.mark, Table: r.table}, netlink.RT_FILTER_MARK|netlink.RT_FILTER_TABLE)
+		return err
+	})
+	if err != nil {
+		return false, false, fmt.Errorf("error while getting rules: %w", err)
+	}
+	if len(rules) == 0 {
+		err = r.insertIPRule()
+		if err != nil {
+			return false, false, err
+		}
+		rule = true
+	}
+
+	if r.ExistingTable != 0 {
+		return rule, false, nil
+	}
+	var routes []netlink.Route
+	err = withTimeout("route list", func() (err error) {
+		routes, err = netNamespace.Netlink.RouteListFiltered(nl.FAMILY_V4, &netlink.Route{Table: r.table}, netlink.RT_FILTER_TABLE)
+		return err
+	})
+	if err != nil {
+		return rule, false, fmt.Errorf("error while getting routes: %w", err)
+	}
+	for _, candidate := range routes {
+		if isDefaultRoute(candidate) {
+			return rule, false, nil
+		}
+	}
+	// The route is missing while its interface is down too, insertIPRoute waits for the interface then
+	r.ipRoute = nil
+	err = r.insertIPRoute()
+	if err != nil {
+		return rule, false, err
+	}
+	return rule, r.ipRoute != nil, nil
+}
+
+func isDefaultRoute(route netlink.Route) bool {
+	if route.Dst == nil {
+		return true
+	}
+	ones, _ := route.Dst.Mask.Size()
+	return ones == 0
+}
+
+// DeleteMarkRule removes the ip rule of the mark left by another process
+func DeleteMarkRule(mark uint32, table int) error {
+	rule := netlink.NewRule()
+	rule.Mark = mark
+	rule.Table = table
+	err := netNamespace.Netlink.RuleDel(rule)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error while deleting rule: %w", err)
+	}
+	return nil
+}
+
+// DeleteMarkRouting removes the ip rule of the mark and routes of the table left by another process
+func DeleteMarkRouting(mark uint32, table int) []error {
+	var errs []error
+
+	err := DeleteMarkRule(mark, table)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	routes, err := netNamespace.Netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return append(errs, fmt.Errorf("error while getting routes: %w", err))
+	}
+	for _, route := range routes {
+		err = netNamespace.Netlink.RouteDel(&route)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while deleting route: %w", err))
+		}
+	}
+
+	return errs
+}
+
 func (nh *NetfilterHelper) IPSetToLink(name string, ifaceName, ipsetName string) *IPSetToLink {
 	return &IPSetToLink{
 		IPTables:  nh.IPTables,
@@ -0,0 +1,110 @@
+package netfilterHelper
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// IPSetToProxy redirects TCP traffic to addresses of the ipset into a local port
+type IPSetToProxy struct {
+	IPTables  *iptables.IPTables
+	ChainName string
+	IPSetName string
+	// ExcludeIPSetName is optional, addresses of this set are never redirected
+	ExcludeIPSetName string
+	Port             uint16
+
+	enabled bool
+}
+
+func (r *IPSetToProxy) matchArgs() []string {
+	args := []string{"-p", "tcp", "-m", "set", "--match-set", r.IPSetName, "dst"}
+	if r.ExcludeIPSetName != "" {
+		args = append(args, "-m", "set", "!", "--match-set", r.ExcludeIPSetName, "dst")
+	}
+	return args
+}
+
+func (r *IPSetToProxy) insertIPTablesRules(table string) error {
+	if table == "" || table == "nat" {
+		err := r.IPTables.NewChain("nat", r.ChainName)
+		if err != nil {
+			// If not "AlreadyExists"
+			if eerr, eok := err.(*iptables.Error); !(eok && eerr.ExitStatus() == 1) {
+				return fmt.Errorf("failed to create chain: %w", err)
+			}
+		}
+
+		err = r.IPTables.AppendUnique("nat", r.ChainName, "-p", "tcp", "-j", "REDIRECT", "--to-port", strconv.Itoa(int(r.Port)))
+		if err != nil {
+			return fmt.Errorf("failed to append rule: %w", err)
+		}
+
+		err = r.IPTables.InsertUnique("nat", "PREROUTING", 1, append(r.matchArgs(), "-j", r.ChainName)...)
+		if err != nil {
+			return fmt.Errorf("failed to append rule to PREROUTING: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *IPSetToProxy) deleteIPTablesRules() []error {
+	var errs []error
+
+	err := r.IPTables.DeleteIfExists("nat", "PREROUTING", append(r.matchArgs(), "-j", r.ChainName)...)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
+	}
+
+	err = r.IPTables.ClearAndDeleteChain("nat", r.ChainName)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
+	}
+
+	return errs
+}
+
+func (r *IPSetToProxy) Enable() error {
+	if r.enabled {
+		return nil
+	}
+
+	err := r.IPTables.ClearChain("nat", r.ChainName)
+	if err != nil {
+		return fmt.Errorf("failed to clear chain: %w", err)
+	}
+
+	err = r.insertIPTablesRules("")
+	if err != nil {
+		r.Disable()
+		return err
+	}
+
+	r.enabled = true
+	return nil
+}
+
+func (r *IPSetToProxy) Disable() []error {
+	errs := r.deleteIPTablesRules()
+	r.enabled = false
+	return errs
+}
+
+func (r *IPSetToProxy) NetfilterDHook(table string) error {
+	if !r.enabled {
+		return nil
+	}
+	return r.insertIPTablesRules(table)
+}
+
+func (nh *NetfilterHelper) IPSetToProxy(name string, ipsetName string, port uint16) *IPSetToProxy {
+	return &IPSetToProxy{
+		IPTables:  nh.IPTables,
+		ChainName: name,
+		IPSetName: ipsetName,
+		Port:      port,
+	}
+}
@@ -1,22 +1,31 @@
 package netfilterHelper
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 
+	"magitrickle/net-namespace"
+
 	"github.com/vishvananda/netlink"
 )
 
 type IPSet struct {
 	SetName string
+	Options IPSetOptions
 }
 
 func (r *IPSet) AddIP(addr net.IP, timeout *uint32) error {
-	err := netlink.IpsetAdd(r.SetName, &netlink.IPSetEntry{
-		IP:      addr,
-		Timeout: timeout,
-		Replace: true,
+	if !timeoutSupported() {
+		timeout = nil
+	}
+	err := withTimeout("ipset add", func() error {
+		return netNamespace.Netlink.IpsetAdd(r.SetName, &netlink.IPSetEntry{
+			IP:      addr,
+			Timeout: timeout,
+			Replace: true,
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to add address: %w", err)
@@ -24,9 +33,30 @@ func (r *IPSet) AddIP(addr net.IP, timeout *uint32) error {
 	return nil
 }
 
+func (r *IPSet) AddNet(network *net.IPNet, timeout *uint32) error {
+	ones, _ := network.Mask.Size()
+	if !timeoutSupported() {
+		timeout = nil
+	}
+	err := withTimeout("ipset add", func() error {
+		return netNamespace.Netlink.IpsetAdd(r.SetName, &netlink.IPSetEntry{
+			IP:      network.IP,
+			CIDR:    uint8(ones),
+			Timeout: timeout,
+			Replace: true,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add network: %w", err)
+	}
+	return nil
+}
+
 func (r *IPSet) DelIP(addr net.IP) error {
-	err := netlink.IpsetDel(r.SetName, &netlink.IPSetEntry{
-		IP: addr,
+	err := withTimeout("ipset del", func() error {
+		return netNamespace.Netlink.IpsetDel(r.SetName, &netlink.IPSetEntry{
+			IP: addr,
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete address: %w", err)
@@ -35,40 +65,134 @@ func (r *IPSet) DelIP(addr net.IP) error {
 }
 
 func (r *IPSet) ListIPs() (map[string]*uint32, error) {
-	list, err := netlink.IpsetList(r.SetName)
+	var list *netlink.IPSetResult
+	err := withTimeout("ipset list", func() (err error) {
+		list, err = netNamespace.Netlink.IpsetList(r.SetName)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	addresses := make(map[string]*uint32)
 	for _, entry := range list.Entries {
+		// Networks are not addresses
+		if entry.CIDR != 0 && int(entry.CIDR) != len(entry.IP)*8 {
+			continue
+		}
 		addresses[string(entry.IP)] = entry.Timeout
 	}
 	return addresses, nil
 }
 
 func (r *IPSet) Destroy() error {
-	err := netlink.IpsetDestroy(r.SetName)
-	if err != nil && !os.IsNotExist(err) {
+	err := withTimeout("ipset destroy", func() error {
+		return netNamespace.Netlink.IpsetDestroy(r.SetName)
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("failed to destroy ipset: %w", err)
 	}
 	return nil
 }
 
+func (r *IPSet) create() error {
+	var timeout *uint32
+	if timeoutSupported() {
+		timeout = func(i uint32) *uint32 { return &i }(300)
+	}
+	err := withTimeout("ipset create", func() error {
+		return netNamespace.Netlink.IpsetCreate(r.SetName, "hash:net", netlink.IpsetCreateOptions{
+			Timeout:  timeout,
+			Counters: r.Options.Counters,
+			Skbinfo:  r.Options.Skbinfo,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ipset: %w", err)
+	}
+	return nil
+}
+
+// Rebuild fills a temporary ipset with the same options and swaps it with the ipset in one kernel
+// operation, so the ipset is never partially filled. The temporary ipset is destroyed.
+func (r *IPSet) Rebuild(fill func(temp *IPSet) error) error {
+	name := r.SetName
+	if len(name) > 29 {
+		name = name[:29]
+	}
+	temp := &IPSet{SetName: name + "_t", Options: r.Options}
+	err := temp.Destroy()
+	if err != nil {
+		return err
+	}
+	err = temp.create()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = temp.Destroy() }()
+
+	err = fill(temp)
+	if err != nil {
+		return err
+	}
+	err = withTimeout("ipset swap", func() error {
+		return netNamespace.Netlink.IpsetSwap(r.SetName, temp.SetName)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to swap ipset: %w", err)
+	}
+	return nil
+}
+
+// Ensure creates the ipset again if it was destroyed externally, it reports whether the ipset was created
+func (r *IPSet) Ensure() (bool, error) {
+	err := withTimeout("ipset list", func() error {
+		_, err := netNamespace.Netlink.IpsetList(r.SetName)
+		return err
+	})
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to list ipset: %w", err)
+	}
+	err = r.create()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (nh *NetfilterHelper) IPSet(name string) (*IPSet, error) {
+	return nh.IPSetWithOptions(name, IPSetOptions{})
+}
+
+// IPSetWithOptions re-creates the ipset with counters or skbinfo extensions
+func (nh *NetfilterHelper) IPSetWithOptions(name string, options IPSetOptions) (*IPSet, error) {
 	ipset := &IPSet{
 		SetName: name,
+		Options: options,
 	}
 	err := ipset.Destroy()
 	if err != nil {
 		return nil, err
 	}
 
-	err = netlink.IpsetCreate(ipset.SetName, "hash:net", netlink.IpsetCreateOptions{
-		Timeout: func(i uint32) *uint32 { return &i }(300),
-	})
+	err = ipset.create()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create ipset: %w", err)
+		return nil, err
 	}
 
 	return ipset, nil
 }
+
+// SharedIPSet returns the ipset which may be used by others, it's created if it doesn't exist and kept otherwise
+func (nh *NetfilterHelper) SharedIPSet(name string) (*IPSet, error) {
+	ipset := &IPSet{
+		SetName: name,
+	}
+	_, err := ipset.Ensure()
+	if err != nil {
+		return nil, err
+	}
+	return ipset, nil
+}
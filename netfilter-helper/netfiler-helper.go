@@ -17,7 +17,8 @@ func New(isIPv6 bool) (*NetfilterHelper, error) {
 		proto = iptables.ProtocolIPv6
 	}
 
-	ipt, err := iptables.New(iptables.IPFamily(proto))
+	// A wedged xtables lock fails the call instead of blocking it forever
+	ipt, err := iptables.New(iptables.IPFamily(proto), iptables.Timeout(iptablesWait()))
 	if err != nil {
 		return nil, fmt.Errorf("iptables init fail: %w", err)
 	}
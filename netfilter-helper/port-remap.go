@@ -9,16 +9,72 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
+// PortMapping redirects the port of one protocol ("udp" or "tcp") or of both if Proto is empty
+type PortMapping struct {
+	Proto string
+	From  uint16
+	To    uint16
+}
+
 type PortRemap struct {
 	IPTables  *iptables.IPTables
 	ChainName string
 	Addresses []netlink.Addr
 	From      uint16
 	To        uint16
+	// Additional are mappings redirected in the same chain
+	Additional []PortMapping
 
 	enabled bool
 }
 
+// mappings returns From to To of both protocols and additional mappings
+func (r *PortRemap) mappings() []PortMapping {
+	return append([]PortMapping{{From: r.From, To: r.To}}, r.Additional...)
+}
+
+func (m PortMapping) protos() []string {
+	if m.Proto != "" {
+		return []string{m.Proto}
+	}
+	return []string{"tcp", "udp"}
+}
+
+// natRules returns rules of the prerouting chain. Packets from the router itself (its addresses and loopback,
+// e.g. reflected back by hairpin NAT or coming from a network namespace) return untouched first, so queries
+// of local resolvers aren't redirected back to the proxy. Loopback addresses are never remapped, local
+// upstreams listen on them (e.g. dnsmasq on 127.0.0.1:53).
+func (r *PortRemap) natRules(proto iptables.Protocol) [][]string {
+	var own []net.IP
+	for _, addr := range r.Addresses {
+		if (proto == iptables.ProtocolIPv4) != (len(addr.IP) == net.IPv4len) || addr.IP.IsLoopback() {
+			continue
+		}
+		own = append(own, addr.IP)
+	}
+
+	loopback := "127.0.0.0/8"
+	if proto == iptables.ProtocolIPv6 {
+		loopback = "::1/128"
+	}
+	rules := [][]string{{"-s", loopback, "-j", "RETURN"}}
+	for _, ip := range own {
+		rules = append(rules, []string{"-s", ip.String(), "-j", "RETURN"})
+	}
+	for _, ip := range own {
+		for _, mapping := range r.mappings() {
+			for _, network := range mapping.protos() {
+				if proto != iptables.ProtocolIPv6 {
+					rules = append(rules, []string{"-p", network, "-d", ip.String(), "--dport", strconv.Itoa(int(mapping.From)), "-j", "REDIRECT", "--to-port", strconv.Itoa(int(mapping.To))})
+				} else {
+					rules = append(rules, []string{"-p", network, "-d", ip.String(), "--dport", strconv.Itoa(int(mapping.From)), "-j", "DNAT", "--to-destination", fmt.Sprintf(":%d", mapping.To)})
+				}
+			}
+		}
+	}
+	return rules
+}
+
 func (r *PortRemap) insertIPTablesRules(table string) error {
 	if table == "" || table == "nat" {
 		preroutingChain := r.ChainName + "_PRR"
@@ -30,31 +86,10 @@ func (r *PortRemap) insertIPTablesRules(table string) error {
 			}
 		}
 
-		for _, addr := range r.Addresses {
-			if !((r.IPTables.Proto() == iptables.ProtocolIPv4 && len(addr.IP) == net.IPv4len) || (r.IPTables.Proto() == iptables.ProtocolIPv6 && len(addr.IP) == net.IPv6len)) {
-				continue
-			}
-
-			if r.IPTables.Proto() != iptables.ProtocolIPv6 {
-				for _, iptablesArgs := range [][]string{
-					{"-p", "tcp", "-d", addr.IP.String(), "--dport", fmt.Sprintf("%d", r.From), "-j", "REDIRECT", "--to-port", fmt.Sprintf("%d", r.To)},
-					{"-p", "udp", "-d", addr.IP.String(), "--dport", fmt.Sprintf("%d", r.From), "-j", "REDIRECT", "--to-port", fmt.Sprintf("%d", r.To)},
-				} {
-					err = r.IPTables.AppendUnique("nat", preroutingChain, iptablesArgs...)
-					if err != nil {
-						return fmt.Errorf("failed to append rule: %w", err)
-					}
-				}
-			} else {
-				for _, iptablesArgs := range [][]string{
-					{"-p", "tcp", "-d", addr.IP.String(), "--dport", strconv.Itoa(int(r.From)), "-j", "DNAT", "--to-destination", fmt.Sprintf(":%d", r.To)},
-					{"-p", "udp", "-d", addr.IP.String(), "--dport", strconv.Itoa(int(r.From)), "-j", "DNAT", "--to-destination", fmt.Sprintf(":%d", r.To)},
-				} {
-					err = r.IPTables.AppendUnique("nat", preroutingChain, iptablesArgs...)
-					if err != nil {
-						return fmt.Errorf("failed to append rule: %w", err)
-					}
-				}
+		for _, rule := range r.natRules(r.IPTables.Proto()) {
+			err = r.IPTables.AppendUnique("nat", preroutingChain, rule...)
+			if err != nil {
+				return fmt.Errorf("failed to append rule: %w", err)
 			}
 		}
 
@@ -119,6 +154,20 @@ func (r *PortRemap) Disable() []error {
 	return errs
 }
 
+// SetAddresses replaces the remapped addresses, rules are re-installed if enabled
+func (r *PortRemap) SetAddresses(addr []netlink.Addr) error {
+	r.Addresses = addr
+	if !r.enabled {
+		return nil
+	}
+
+	err := r.IPTables.ClearChain("nat", r.ChainName+"_PRR")
+	if err != nil {
+		return fmt.Errorf("failed to clear chain: %w", err)
+	}
+	return r.insertIPTablesRules("nat")
+}
+
 func (r *PortRemap) NetfilterDHook(table string) error {
 	if !r.enabled {
 		return nil
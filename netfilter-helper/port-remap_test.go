@@ -0,0 +1,116 @@
+package netfilterHelper
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+)
+
+// remapTarget walks the rules like the chain does, it returns the port the packet is redirected to, 0 if it returns
+func remapTarget(t *testing.T, rules [][]string, proto, src, dst string, dport uint16) uint16 {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	for _, rule := range rules {
+		matched := true
+		target := ""
+		var toPort uint16
+		for idx := 0; idx+1 < len(rule); idx += 2 {
+			value := rule[idx+1]
+			switch rule[idx] {
+			case "-s":
+				if strings.Contains(value, ":") && !strings.Contains(value, "/") {
+					value += "/128"
+				} else if !strings.Contains(value, "/") {
+					value += "/32"
+				}
+				_, network, err := net.ParseCIDR(value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				matched = matched && network.Contains(srcIP)
+			case "-d":
+				matched = matched && net.ParseIP(value).Equal(dstIP)
+			case "-p":
+				matched = matched && value == proto
+			case "--dport":
+				matched = matched && value == strconv.Itoa(int(dport))
+			case "-j":
+				target = value
+			case "--to-port":
+				port, _ := strconv.Atoi(value)
+				toPort = uint16(port)
+			case "--to-destination":
+				port, _ := strconv.Atoi(value[1:])
+				toPort = uint16(port)
+			default:
+				t.Fatalf("unknown option %s", rule[idx])
+			}
+		}
+		if !matched {
+			continue
+		}
+		if target == "RETURN" {
+			return 0
+		}
+		return toPort
+	}
+	return 0
+}
+
+func addr(cidr string) netlink.Addr {
+	ip, network, _ := net.ParseCIDR(cidr)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	network.IP = ip
+	return netlink.Addr{IPNet: network}
+}
+
+func TestPortRemapHairpin(t *testing.T) {
+	// lo is listed by mistake, dnsmasq on 127.0.0.1:53 is the upstream of the proxy
+	r := &PortRemap{
+		Addresses:  []netlink.Addr{addr("192.168.1.1/24"), addr("127.0.0.1/8"), addr("fd00::1/64")},
+		From:       53,
+		To:         3553,
+		Additional: []PortMapping{{Proto: "tcp", From: 853, To: 8853}},
+	}
+	rules4 := r.natRules(iptables.ProtocolIPv4)
+	for _, tc := range []struct {
+		name, proto, src, dst string
+		dport, expected       uint16
+	}{
+		{"client", "udp", "192.168.1.20", "192.168.1.1", 53, 3553},
+		{"client over tcp", "tcp", "192.168.1.20", "192.168.1.1", 53, 3553},
+		{"client of extra port", "tcp", "192.168.1.20", "192.168.1.1", 853, 8853},
+		{"proxy to dnsmasq", "udp", "127.0.0.1", "127.0.0.1", 53, 0},
+		{"proxy to dnsmasq from lan address", "udp", "192.168.1.1", "127.0.0.1", 53, 0},
+		{"reflected query of the router", "udp", "192.168.1.1", "192.168.1.1", 53, 0},
+		{"loopback to lan address", "udp", "127.0.0.1", "192.168.1.1", 53, 0},
+		{"other port", "udp", "192.168.1.20", "192.168.1.1", 5353, 0},
+	} {
+		if got := remapTarget(t, rules4, tc.proto, tc.src, tc.dst, tc.dport); got != tc.expected {
+			t.Fatalf("%s: redirected to %d, expected %d (rules %v)", tc.name, got, tc.expected, rules4)
+		}
+	}
+
+	rules6 := r.natRules(iptables.ProtocolIPv6)
+	if got := remapTarget(t, rules6, "udp", "fd00::20", "fd00::1", 53); got != 3553 {
+		t.Fatalf("IPv6 client is redirected to %d", got)
+	}
+	if got := remapTarget(t, rules6, "udp", "::1", "fd00::1", 53); got != 0 {
+		t.Fatalf("IPv6 loopback is redirected to %d", got)
+	}
+	if got := remapTarget(t, rules6, "udp", "fd00::1", "fd00::1", 53); got != 0 {
+		t.Fatalf("reflected IPv6 query is redirected to %d", got)
+	}
+	for _, rule := range rules6 {
+		for _, value := range rule {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				t.Fatalf("IPv4 address in IPv6 rules: %v", rule)
+			}
+		}
+	}
+}
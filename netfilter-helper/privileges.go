@@ -0,0 +1,75 @@
+package netfilterHelper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"magitrickle/app-errors"
+)
+
+var ErrMissingCapability = appErrors.New(appErrors.ErrNetfilter, "missing capability")
+
+// Capability is a Linux capability, Bit is its number in capability sets
+type Capability struct {
+	Bit  uint
+	Name string
+}
+
+var (
+	CapNetBindService = Capability{Bit: 10, Name: "CAP_NET_BIND_SERVICE"}
+	// CapNetAdmin is needed by ipset, iptables, ip rules, routes and tc
+	CapNetAdmin = Capability{Bit: 12, Name: "CAP_NET_ADMIN"}
+	// CapNetRaw is needed by iptables to open raw sockets
+	CapNetRaw = Capability{Bit: 13, Name: "CAP_NET_RAW"}
+	// CapSysAdmin is needed to enter a network namespace
+	CapSysAdmin = Capability{Bit: 21, Name: "CAP_SYS_ADMIN"}
+)
+
+// RequireCapabilities checks the effective capabilities of the process, so a non-root run without them
+// fails at startup with the list of missing ones instead of failing on the first netfilter call
+func RequireCapabilities(caps ...Capability) error {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return fmt.Errorf("failed to read capabilities: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	effective, err := parseCapEff(file)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, capability := range caps {
+		if effective&(1<<capability.Bit) == 0 {
+			missing = append(missing, capability.Name)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("%w: %s (run as root or grant them as ambient capabilities)", ErrMissingCapability, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parseCapEff returns the effective capability set of /proc/<pid>/status
+func parseCapEff(status io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !found {
+			continue
+		}
+		effective, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse capabilities: %w", err)
+		}
+		return effective, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read capabilities: %w", err)
+	}
+	return 0, fmt.Errorf("failed to read capabilities: no CapEff")
+}
@@ -0,0 +1,188 @@
+package netfilterHelper
+
+import (
+	"fmt"
+
+	"magitrickle/net-namespace"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// shaperRootHandle is the handle (1:) of the HTB qdisc shared by shapers of the interface
+var shaperRootHandle = netlink.MakeHandle(1, 0)
+
+// Shaper limits the bandwidth of marked traffic leaving the interface
+// with an HTB class and a fw filter, unmarked traffic is not shaped
+type Shaper struct {
+	IfaceName string
+	Mark      uint32
+	// Rate in bits per second
+	Rate uint64
+
+	enabled bool
+}
+
+func (s *Shaper) classID() uint32 {
+	return netlink.MakeHandle(1, uint16(s.Mark+1))
+}
+
+func (s *Shaper) install() error {
+	link, err := netNamespace.Netlink.LinkByName(s.IfaceName)
+	if err != nil {
+		// TODO: Нормально отлавливать ошибку
+		if err.Error() == "Link not found" {
+			log.Debug().Str("iface", s.IfaceName).Msg("interface not found (waiting for it to exist)")
+			return nil
+		}
+		return fmt.Errorf("error while getting interface: %w", err)
+	}
+	linkIndex := link.Attrs().Index
+
+	qdiscs, err := netNamespace.Netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("error while getting qdiscs: %w", err)
+	}
+	hasRoot := false
+	for _, qdisc := range qdiscs {
+		attrs := qdisc.Attrs()
+		if attrs.Parent == netlink.HANDLE_ROOT && attrs.Handle == shaperRootHandle && qdisc.Type() == "htb" {
+			hasRoot = true
+			break
+		}
+	}
+	if !hasRoot {
+		err = netNamespace.Netlink.QdiscReplace(netlink.NewHtb(netlink.QdiscAttrs{
+			LinkIndex: linkIndex,
+			Handle:    shaperRootHandle,
+			Parent:    netlink.HANDLE_ROOT,
+		}))
+		if err != nil {
+			return fmt.Errorf("error while adding root qdisc: %w", err)
+		}
+	}
+
+	err = netNamespace.Netlink.ClassReplace(netlink.NewHtbClass(netlink.ClassAttrs{
+		LinkIndex: linkIndex,
+		Parent:    shaperRootHandle,
+		Handle:    s.classID(),
+	}, netlink.HtbClassAttrs{Rate: s.Rate}))
+	if err != nil {
+		return fmt.Errorf("error while adding class: %w", err)
+	}
+
+	err = netNamespace.Netlink.FilterReplace(&netlink.FwFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: linkIndex,
+			Parent:    shaperRootHandle,
+			Handle:    s.Mark,
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		ClassId: s.classID(),
+	})
+	if err != nil {
+		return fmt.Errorf("error while adding filter: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Shaper) uninstall() []error {
+	link, err := netNamespace.Netlink.LinkByName(s.IfaceName)
+	if err != nil {
+		return nil
+	}
+	linkIndex := link.Attrs().Index
+
+	var errs []error
+	err = netNamespace.Netlink.FilterDel(&netlink.FwFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: linkIndex,
+			Parent:    shaperRootHandle,
+			Handle:    s.Mark,
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error while deleting filter: %w", err))
+	}
+
+	err = netNamespace.Netlink.ClassDel(&netlink.HtbClass{ClassAttrs: netlink.ClassAttrs{
+		LinkIndex: linkIndex,
+		Parent:    shaperRootHandle,
+		Handle:    s.classID(),
+	}})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error while deleting class: %w", err))
+	}
+
+	// The root qdisc is removed with the last shaper of the interface
+	classes, err := netNamespace.Netlink.ClassList(link, shaperRootHandle)
+	if err == nil && len(classes) == 0 {
+		err = netNamespace.Netlink.QdiscDel(netlink.NewHtb(netlink.QdiscAttrs{
+			LinkIndex: linkIndex,
+			Handle:    shaperRootHandle,
+			Parent:    netlink.HANDLE_ROOT,
+		}))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while deleting root qdisc: %w", err))
+		}
+	}
+
+	return errs
+}
+
+func (s *Shaper) Enable() error {
+	if s.enabled {
+		return nil
+	}
+
+	err := s.install()
+	if err != nil {
+		s.uninstall()
+		return err
+	}
+
+	s.enabled = true
+	return nil
+}
+
+func (s *Shaper) Disable() []error {
+	if !s.enabled {
+		return nil
+	}
+	errs := s.uninstall()
+	s.enabled = false
+	return errs
+}
+
+func (s *Shaper) LinkUpdateHook(event netlink.LinkUpdate) error {
+	if event.Change != 1 {
+		return nil
+	}
+	return s.InterfaceHook(event.Link.Attrs().Name)
+}
+
+// InterfaceHook re-installs the qdisc after the interface came up (netlink or ndm hotplug event)
+func (s *Shaper) InterfaceHook(ifaceName string) error {
+	if !s.enabled || ifaceName != s.IfaceName {
+		return nil
+	}
+	return s.install()
+}
+
+// DeleteShaper removes the shaper of the mark left by another process
+func DeleteShaper(ifaceName string, mark uint32) []error {
+	return (&Shaper{IfaceName: ifaceName, Mark: mark}).uninstall()
+}
+
+func (nh *NetfilterHelper) Shaper(ifaceName string, mark uint32, rate uint64) *Shaper {
+	return &Shaper{
+		IfaceName: ifaceName,
+		Mark:      mark,
+		Rate:      rate,
+	}
+}
@@ -0,0 +1,72 @@
+package netfilterHelper
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"magitrickle/app-errors"
+)
+
+// OpTimeout bounds every ipset and route operation and the wait for the xtables lock of iptables
+var OpTimeout = 5 * time.Second
+
+// maxPendingOps is how many timed out operations may still hang before new ones fail at once
+const maxPendingOps = 32
+
+var ErrTimeout = appErrors.New(appErrors.ErrNetfilter, "netfilter operation timed out")
+
+var (
+	stalled  atomic.Bool
+	timeouts atomic.Uint64
+	pending  atomic.Int32
+)
+
+// Stalled reports whether the last netfilter operation timed out
+func Stalled() bool {
+	return stalled.Load()
+}
+
+// Timeouts returns the number of netfilter operations that timed out
+func Timeouts() uint64 {
+	return timeouts.Load()
+}
+
+// withTimeout runs the operation and gives up after OpTimeout, the kernel or iptables may still
+// finish it later, so callers queue it for retry rather than assume it failed.
+// Errors are of the netfilter kind.
+func withTimeout(op string, fn func() error) error {
+	if pending.Load() >= maxPendingOps {
+		timeouts.Add(1)
+		stalled.Store(true)
+		return fmt.Errorf("%w: %s (too many operations hang)", ErrTimeout, op)
+	}
+
+	done := make(chan error, 1)
+	pending.Add(1)
+	go func() {
+		defer pending.Add(-1)
+		done <- fn()
+	}()
+
+	timer := time.NewTimer(OpTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		stalled.Store(false)
+		return appErrors.Wrap(appErrors.ErrNetfilter, err)
+	case <-timer.C:
+		timeouts.Add(1)
+		stalled.Store(true)
+		return fmt.Errorf("%w: %s", ErrTimeout, op)
+	}
+}
+
+// iptablesWait is the -w argument of iptables in seconds
+func iptablesWait() int {
+	wait := int(OpTimeout / time.Second)
+	if wait < 1 {
+		wait = 1
+	}
+	return wait
+}
@@ -0,0 +1,35 @@
+package netfilterHelper
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	origin := OpTimeout
+	OpTimeout = 20 * time.Millisecond
+	defer func() { OpTimeout = origin }()
+
+	release := make(chan struct{})
+	err := withTimeout("wedged", func() error {
+		<-release
+		return nil
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if !Stalled() || Timeouts() == 0 {
+		t.Fatalf("expected stalled state, got %v with %d timeouts", Stalled(), Timeouts())
+	}
+	close(release)
+
+	failed := errors.New("failed")
+	err = withTimeout("failing", func() error { return failed })
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected operation error, got %v", err)
+	}
+	if Stalled() {
+		t.Fatal("expected stalled state to be cleared by the completed operation")
+	}
+}
@@ -0,0 +1,20 @@
+package magitrickle
+
+import (
+	"magitrickle/net-namespace"
+
+	"github.com/rs/zerolog/log"
+)
+
+// enterNetns binds netfilter management to the configured network namespace
+func (a *App) enterNetns() error {
+	if a.config.Netns == "" {
+		return nil
+	}
+	err := netNamespace.Set(a.config.Netns)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("netns", netNamespace.Path(a.config.Netns)).Msg("using network namespace")
+	return nil
+}
@@ -0,0 +1,48 @@
+package magitrickle
+
+import (
+	"fmt"
+
+	"magitrickle/models"
+	"magitrickle/notify"
+)
+
+func newNotifier(notifiers []models.Notifier) (*notify.Notifier, error) {
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+	n := notify.New()
+	for idx, notifier := range notifiers {
+		var transport notify.Transport
+		switch {
+		case notifier.Telegram != nil && notifier.SMTP == nil:
+			if notifier.Telegram.Token == "" || notifier.Telegram.ChatID == "" {
+				return nil, fmt.Errorf("%w %d: telegram token and chat ID are required", ErrInvalidNotifier, idx)
+			}
+			transport = &notify.Telegram{Token: notifier.Telegram.Token, ChatID: notifier.Telegram.ChatID}
+		case notifier.SMTP != nil && notifier.Telegram == nil:
+			if notifier.SMTP.Address == "" || notifier.SMTP.From == "" || len(notifier.SMTP.To) == 0 {
+				return nil, fmt.Errorf("%w %d: smtp address, from and to are required", ErrInvalidNotifier, idx)
+			}
+			transport = &notify.SMTP{
+				Address:  notifier.SMTP.Address,
+				Username: notifier.SMTP.Username,
+				Password: notifier.SMTP.Password,
+				From:     notifier.SMTP.From,
+				To:       notifier.SMTP.To,
+			}
+		default:
+			return nil, fmt.Errorf("%w %d: exactly one of telegram and smtp must be set", ErrInvalidNotifier, idx)
+		}
+		err := n.Add(transport, notifier.Events)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d: %w", ErrInvalidNotifier, idx, err)
+		}
+	}
+	return n, nil
+}
+
+// Notify sends the event to configured notifiers, it never blocks
+func (a *App) Notify(eventType, message string) {
+	a.notifier.Notify(eventType, message)
+}
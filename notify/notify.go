@@ -0,0 +1,83 @@
+// Package notify delivers events (interface down, failed config apply...) to the user
+// through Telegram or e-mail, so breakage is noticed before a site stops opening.
+package notify
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	EventInterfaceDown      = "interfaceDown"
+	EventConfigApplyFailed  = "configApplyFailed"
+	EventSubscriptionFailed = "subscriptionFailed"
+	EventSubscriptionHeld   = "subscriptionHeld"
+	EventRoutingRepaired    = "routingRepaired"
+)
+
+// sendTimeout limits the delivery of one event by one transport
+const sendTimeout = 30 * time.Second
+
+var ErrUnknownEvent = errors.New("unknown event type")
+
+// Events lists known event types
+var Events = []string{EventInterfaceDown, EventConfigApplyFailed, EventSubscriptionFailed, EventSubscriptionHeld, EventRoutingRepaired}
+
+type Event struct {
+	Type    string
+	Message string
+	Time    time.Time
+}
+
+type Transport interface {
+	Send(ctx context.Context, event Event) error
+}
+
+type route struct {
+	transport Transport
+	events    []string
+}
+
+// Notifier sends events to transports subscribed to them, delivery never blocks the caller
+type Notifier struct {
+	routes []route
+}
+
+// Add subscribes the transport to the event types, no types means all of them
+func (n *Notifier) Add(transport Transport, events []string) error {
+	for _, event := range events {
+		if !slices.Contains(Events, event) {
+			return ErrUnknownEvent
+		}
+	}
+	n.routes = append(n.routes, route{transport: transport, events: events})
+	return nil
+}
+
+func (n *Notifier) Notify(eventType, message string) {
+	if n == nil {
+		return
+	}
+	event := Event{Type: eventType, Message: message, Time: time.Now()}
+	for _, r := range n.routes {
+		if len(r.events) != 0 && !slices.Contains(r.events, eventType) {
+			continue
+		}
+		go func(transport Transport) {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+			err := transport.Send(ctx, event)
+			if err != nil {
+				log.Error().Str("event", eventType).Err(err).Msg("failed to send notification")
+			}
+		}(r.transport)
+	}
+}
+
+func New() *Notifier {
+	return &Notifier{}
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeTransport struct {
+	events chan Event
+}
+
+func (f *fakeTransport) Send(_ context.Context, event Event) error {
+	f.events <- event
+	return nil
+}
+
+func TestNotifier(t *testing.T) {
+	all := &fakeTransport{events: make(chan Event, 2)}
+	down := &fakeTransport{events: make(chan Event, 2)}
+	n := New()
+	if err := n.Add(all, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add(down, []string{EventInterfaceDown}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add(down, []string{"nope"}); err == nil {
+		t.Fatal("unknown event must be rejected")
+	}
+
+	n.Notify(EventConfigApplyFailed, "failed")
+	select {
+	case event := <-all.events:
+		if event.Type != EventConfigApplyFailed || event.Message != "failed" {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+	select {
+	case event := <-down.events:
+		t.Fatalf("unsubscribed event delivered: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	var path, chatID, text string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, chatID, text = r.URL.Path, r.URL.Query().Get("chat_id"), r.URL.Query().Get("text")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	telegram := &Telegram{Token: "123:abc", ChatID: "42", BaseURL: server.URL + "/bot"}
+	err := telegram.Send(context.Background(), Event{Type: EventInterfaceDown, Message: "interface nwg0 is down"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot123:abc/sendMessage" || chatID != "42" || text != "MagiTrickle: interface nwg0 is down" {
+		t.Fatalf("unexpected request: %s %s %s", path, chatID, text)
+	}
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type SMTP struct {
+	// Address is host:port of the server, STARTTLS is used when the server offers it
+	Address  string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTP) message(event Event) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + strings.Join(s.To, ", ") + "\r\n")
+	b.WriteString("Subject: MagiTrickle: " + event.Type + "\r\n")
+	b.WriteString("Date: " + event.Time.Format("Mon, 02 Jan 2006 15:04:05 -0700") + "\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(event.Message + "\r\n")
+	return []byte(b.String())
+}
+
+func (s *SMTP) Send(ctx context.Context, event Event) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Address)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Address, auth, s.From, s.To, s.message(event))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send mail: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
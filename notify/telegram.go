@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// TelegramAPI is the Bot API endpoint, the token is appended to it
+const TelegramAPI = "https://api.telegram.org/bot"
+
+type Telegram struct {
+	Token  string
+	ChatID string
+	// BaseURL is optional, TelegramAPI is used by default
+	BaseURL string
+}
+
+func (t *Telegram) Send(ctx context.Context, event Event) error {
+	baseURL := t.BaseURL
+	if baseURL == "" {
+		baseURL = TelegramAPI
+	}
+	form := url.Values{
+		"chat_id": {t.ChatID},
+		"text":    {"MagiTrickle: " + event.Message},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+t.Token+"/sendMessage", nil)
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = form.Encode()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error contains the URL with the token
+		return fmt.Errorf("failed to send telegram message: %w", errors.Unwrap(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("failed to decode telegram response: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram error: %s", result.Description)
+	}
+	return nil
+}
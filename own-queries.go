@@ -0,0 +1,63 @@
+package magitrickle
+
+import (
+	"net"
+	"slices"
+
+	"magitrickle/dns-mitm-proxy"
+	"magitrickle/models"
+	"magitrickle/socket-owner"
+
+	"github.com/rs/zerolog/log"
+)
+
+// commLength is the length of process names in /proc/<pid>/comm, longer names are truncated
+const commLength = 15
+
+// ownQuery decides how the query is handled if it comes from the router itself: from a loopback
+// address or an address of the LAN interfaces, and from one of the configured processes if any
+func (a *App) ownQuery(peerAddr net.Addr, network string) dnsMitmProxy.BypassAction {
+	ip := clientIP(peerAddr)
+	if ip == nil || !a.isRouterAddress(ip) {
+		return dnsMitmProxy.BypassNone
+	}
+	cfg := a.config.DNSProxy.OwnQueries
+	var process string
+	if len(cfg.Processes) != 0 {
+		port := 0
+		switch v := peerAddr.(type) {
+		case *net.UDPAddr:
+			port = v.Port
+		case *net.TCPAddr:
+			port = v.Port
+		}
+		var err error
+		process, err = socketOwner.Process(network, uint16(port))
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to find the process of the DNS query")
+			return dnsMitmProxy.BypassNone
+		}
+		if process == "" || !slices.ContainsFunc(cfg.Processes, func(name string) bool {
+			return name == process || (len(name) > commLength && name[:commLength] == process)
+		}) {
+			return dnsMitmProxy.BypassNone
+		}
+	}
+	log.Trace().Str("peer", peerAddr.String()).Str("process", process).Str("action", cfg.Action).Msg("own DNS query")
+	switch cfg.Action {
+	case models.OwnQueriesForward:
+		return dnsMitmProxy.BypassForward
+	case models.OwnQueriesRefuse:
+		return dnsMitmProxy.BypassRefuse
+	}
+	return dnsMitmProxy.BypassNone
+}
+
+// isRouterAddress reports whether the address is a loopback one or an address of the LAN interfaces
+func (a *App) isRouterAddress(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	lan := a.lan.Load()
+	return lan != nil && slices.ContainsFunc(lan.own, ip.Equal)
+}
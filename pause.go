@@ -0,0 +1,87 @@
+package magitrickle
+
+import (
+	"errors"
+
+	"magitrickle/netfilter-helper"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Paused reports whether marking rules of groups and the remap of DNS port are removed by Pause
+func (a *App) Paused() bool {
+	return a.groupStates().paused
+}
+
+// Pause removes marking rules, routes of all groups and the remap of DNS port at once, so the router
+// behaves as without the app until Resume. Ipsets are kept and the DNS proxy still answers on its own port.
+// The pause persists across restarts, rules are removed even if some of them fail.
+func (a *App) Pause() error {
+	state := a.groupStates()
+	if state.paused {
+		return nil
+	}
+	state.paused = true
+
+	var errs []error
+	if a.isRunning() {
+		for _, grp := range a.groups {
+			errs = append(errs, grp.Disable()...)
+		}
+		for _, dnsOverrider := range []*netfilterHelper.PortRemap{a.dnsOverrider4, a.dnsOverrider6} {
+			if dnsOverrider != nil {
+				errs = append(errs, dnsOverrider.Disable()...)
+			}
+		}
+	}
+
+	log.Warn().Msg("paused, marking rules and DNS remap are removed")
+	return errors.Join(errors.Join(errs...), state.save())
+}
+
+// Resume installs the rules removed by Pause again and re-fills ipsets from known records,
+// groups disabled through the API stay disabled
+func (a *App) Resume() error {
+	state := a.groupStates()
+	if !state.paused {
+		return nil
+	}
+	state.paused = false
+
+	if a.isRunning() {
+		for _, dnsOverrider := range []*netfilterHelper.PortRemap{a.dnsOverrider4, a.dnsOverrider6} {
+			if dnsOverrider == nil {
+				continue
+			}
+			err := dnsOverrider.Enable()
+			if err != nil {
+				return errors.Join(err, a.Pause())
+			}
+		}
+		for _, grp := range a.groups {
+			err := a.enableGroup(grp)
+			if err == nil && grp.IsEnabled() {
+				err = grp.Sync(a.records)
+			}
+			if err != nil {
+				// Half-resumed routing is worse than none, the pause is restored
+				return errors.Join(err, a.Pause())
+			}
+		}
+	}
+
+	log.Info().Msg("resumed, marking rules and DNS remap are installed")
+	return state.save()
+}
+
+// TogglePause pauses the running app or resumes the paused one (e.g. by a single hardware button),
+// it returns whether the app is paused now
+func (a *App) TogglePause() (bool, error) {
+	var err error
+	if a.Paused() {
+		err = a.Resume()
+	} else {
+		err = a.Pause()
+	}
+	return a.Paused(), err
+}
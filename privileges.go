@@ -0,0 +1,39 @@
+package magitrickle
+
+import (
+	"net"
+	"strconv"
+
+	"magitrickle/netfilter-helper"
+	"magitrickle/socket-activation"
+)
+
+// requireCapabilities checks capabilities needed by the config before anything is installed
+func (a *App) requireCapabilities() error {
+	caps := []netfilterHelper.Capability{netfilterHelper.CapNetAdmin, netfilterHelper.CapNetRaw}
+	if a.config.Netns != "" {
+		caps = append(caps, netfilterHelper.CapSysAdmin)
+	}
+	// Port 0 is a random port, passed sockets are already bound
+	privilegedPort := func(address string, port uint16, networks ...string) bool {
+		if port == 0 || port >= 1024 {
+			return false
+		}
+		addr := net.JoinHostPort(address, strconv.Itoa(int(port)))
+		for _, network := range networks {
+			if !socketActivation.Inherited().Has(network, addr) {
+				return true
+			}
+		}
+		return false
+	}
+	bindService := privilegedPort(a.config.DNSProxy.Host.Address, a.config.DNSProxy.Host.Port, "udp", "tcp") ||
+		(!a.config.API.Disable && privilegedPort(a.config.API.Host.Address, a.config.API.Host.Port, "tcp"))
+	for _, server := range a.config.DNSProxy.Listen {
+		bindService = bindService || privilegedPort(server.Address, server.Port, "udp", "tcp")
+	}
+	if bindService {
+		caps = append(caps, netfilterHelper.CapNetBindService)
+	}
+	return netfilterHelper.RequireCapabilities(caps...)
+}
@@ -0,0 +1,70 @@
+package proxyForwarder
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPConnectDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	requests := make(chan *http.Request, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		requests <- req
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\npong")
+	}()
+
+	forwarder, err := New("http://user:pass@" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := forwarder.dialer.Dial("tcp", "192.0.2.1:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req := <-requests
+	if req.Method != http.MethodConnect || req.Host != "192.0.2.1:443" {
+		t.Fatalf("unexpected request: %s %s", req.Method, req.Host)
+	}
+	if req.Header.Get("Proxy-Authorization") == "" {
+		t.Fatal("no proxy authorization")
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "pong" {
+		t.Fatalf("unexpected tunnel data: %q", data)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, proxyURL := range []string{"socks5://127.0.0.1:1080", "http://127.0.0.1:3128"} {
+		if _, err := New(proxyURL); err != nil {
+			t.Fatalf("New(%q) returns %v", proxyURL, err)
+		}
+	}
+	for _, proxyURL := range []string{"ftp://127.0.0.1:21", "socks5://127.0.0.1"} {
+		if _, err := New(proxyURL); err == nil {
+			t.Fatalf("New(%q) returns no error", proxyURL)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package proxyForwarder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST of linux/netfilter_ipv4.h
+const soOriginalDst = 80
+
+// originalDestination returns the destination of the connection before REDIRECT
+func originalDestination(conn net.Conn) (*net.TCPAddr, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("not a tcp connection")
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var addr *net.TCPAddr
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		// sockaddr_in fits into ipv6_mreq, that's the usual way to read it without cgo
+		var mreq *unix.IPv6Mreq
+		mreq, sockErr = unix.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if sockErr != nil {
+			return
+		}
+		raw := mreq.Multiaddr
+		addr = &net.TCPAddr{
+			IP:   net.IPv4(raw[4], raw[5], raw[6], raw[7]),
+			Port: int(binary.BigEndian.Uint16(raw[2:4])),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, fmt.Errorf("failed to get SO_ORIGINAL_DST: %w", sockErr)
+	}
+	return addr, nil
+}
@@ -0,0 +1,121 @@
+// Package proxyForwarder accepts TCP connections redirected by iptables (REDIRECT)
+// and forwards them to their original destination through a SOCKS5 or HTTP proxy.
+package proxyForwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/proxy"
+)
+
+const dialTimeout = 10 * time.Second
+
+type Forwarder struct {
+	ProxyURL *url.URL
+
+	dialer proxy.Dialer
+}
+
+// Serve accepts redirected connections until the context is done
+func (f *Forwarder) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		go f.handle(ctx, conn)
+	}
+}
+
+func (f *Forwarder) handle(ctx context.Context, clientConn net.Conn) {
+	defer func() { _ = clientConn.Close() }()
+
+	dst, err := originalDestination(clientConn)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get original destination")
+		return
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	upstreamConn, err := f.dial(dialCtx, dst.String())
+	if err != nil {
+		log.Debug().Str("destination", dst.String()).Err(err).Msg("failed to dial through proxy")
+		return
+	}
+	defer func() { _ = upstreamConn.Close() }()
+
+	log.Trace().
+		Str("client", clientConn.RemoteAddr().String()).
+		Str("destination", dst.String()).
+		Msg("forwarding connection")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = conn.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go pipe(upstreamConn, clientConn)
+	go pipe(clientConn, upstreamConn)
+	wg.Wait()
+}
+
+func (f *Forwarder) dial(ctx context.Context, address string) (net.Conn, error) {
+	if dialer, ok := f.dialer.(proxy.ContextDialer); ok {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	return f.dialer.Dial("tcp", address)
+}
+
+// New creates the forwarder for the proxy URL (socks5://[user:pass@]host:port or http://[user:pass@]host:port)
+func New(proxyURL string) (*Forwarder, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy url: %w", err)
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy url has no host")
+	}
+	if u.Port() == "" {
+		return nil, errors.New("proxy url has no port")
+	}
+	if _, err := strconv.Atoi(u.Port()); err != nil {
+		return nil, fmt.Errorf("invalid proxy port: %w", err)
+	}
+
+	dialer, err := proxy.FromURL(u, &net.Dialer{Timeout: dialTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("unsupported proxy: %w", err)
+	}
+	return &Forwarder{
+		ProxyURL: u,
+		dialer:   dialer,
+	}, nil
+}
+
+func init() {
+	proxy.RegisterDialerType("http", newHTTPConnectDialer)
+}
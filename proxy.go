@@ -0,0 +1,44 @@
+package magitrickle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"magitrickle/net-namespace"
+	"magitrickle/proxy-forwarder"
+
+	"github.com/rs/zerolog/log"
+)
+
+// startProxyForwarders serves redirected connections of proxy groups until the context is done
+func (a *App) startProxyForwarders(ctx context.Context, errChan chan<- error) error {
+	for _, group := range a.groups {
+		if !group.Proxy.IsEnabled() {
+			continue
+		}
+
+		forwarder, err := proxyForwarder.New(group.Proxy.URL)
+		if err != nil {
+			return fmt.Errorf("group %s: %w", group.ID.String(), err)
+		}
+		listener, err := netNamespace.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(group.Proxy.Port))))
+		if err != nil {
+			return fmt.Errorf("group %s: failed to listen proxy port: %w", group.ID.String(), err)
+		}
+
+		log.Debug().
+			Str("group", group.ID.String()).
+			Str("proxy", forwarder.ProxyURL.Redacted()).
+			Int("port", int(group.Proxy.Port)).
+			Msg("serving proxy forwarder")
+		go func(groupID string) {
+			err := forwarder.Serve(ctx, listener)
+			if err != nil {
+				errChan <- fmt.Errorf("group %s: failed to serve proxy forwarder: %w", groupID, err)
+			}
+		}(group.ID.String())
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package records
+
+import (
+	"net"
+	"sync"
+)
+
+// ExpireFunc is called with an address contributed by the subscriber once no record of the store has it
+type ExpireFunc func(addr net.IP)
+
+// subscriptions are addresses contributed by subscribers (e.g. added to ipsets of groups),
+// addresses of dropped records are candidates to be expired. The mutex is taken after the one of a shard
+// (by drop), never before it.
+type subscriptions struct {
+	mux           sync.Mutex
+	next          uint32
+	subscribers   map[uint32]ExpireFunc
+	contributions map[[net.IPv4len]byte][]uint32
+	candidates    map[[net.IPv4len]byte]struct{}
+}
+
+// Subscribe registers the function to be notified about expired addresses, the returned id is used by Contribute
+func (r *Records) Subscribe(fn ExpireFunc) uint32 {
+	r.subs.mux.Lock()
+	defer r.subs.mux.Unlock()
+	r.subs.next++
+	r.subs.subscribers[r.subs.next] = fn
+	return r.subs.next
+}
+
+// Unsubscribe removes the subscriber, its contributions are forgotten when their addresses expire
+func (r *Records) Unsubscribe(id uint32) {
+	r.subs.mux.Lock()
+	defer r.subs.mux.Unlock()
+	delete(r.subs.subscribers, id)
+}
+
+// Contribute marks the address as used by the subscriber, it's notified when the address expires
+func (r *Records) Contribute(addr net.IP, id uint32) {
+	ip4 := addr.To4()
+	if ip4 == nil {
+		return
+	}
+	var key [net.IPv4len]byte
+	copy(key[:], ip4)
+
+	r.subs.mux.Lock()
+	defer r.subs.mux.Unlock()
+	for _, subscriber := range r.subs.contributions[key] {
+		if subscriber == id {
+			return
+		}
+	}
+	r.subs.contributions[key] = append(r.subs.contributions[key], id)
+}
+
+// drop marks the address of the removed record as a candidate to be expired
+func (r *Records) drop(entry address) {
+	r.subs.mux.Lock()
+	defer r.subs.mux.Unlock()
+	if _, ok := r.subs.contributions[entry.ip]; ok {
+		r.subs.candidates[entry.ip] = struct{}{}
+	}
+}
+
+// Expire removes expired records and notifies subscribers about contributed addresses
+// which are not held by any record anymore. It returns the number of notifications.
+func (r *Records) Expire() int {
+	type notification struct {
+		fn   ExpireFunc
+		addr net.IP
+	}
+	var notifications []notification
+
+	r.cleanupRecords()
+
+	// Candidates are copied, so shards are scanned one at a time without the lock of subscriptions
+	r.subs.mux.Lock()
+	held := make(map[[net.IPv4len]byte]bool, len(r.subs.candidates))
+	for ip := range r.subs.candidates {
+		held[ip] = false
+	}
+	r.subs.mux.Unlock()
+	if len(held) == 0 {
+		return 0
+	}
+
+	for idx := range r.shards {
+		sh := &r.shards[idx]
+		sh.mux.RLock()
+		for _, d := range sh.records {
+			for _, entry := range d.addresses {
+				if _, ok := held[entry.ip]; ok {
+					held[entry.ip] = true
+				}
+			}
+		}
+		sh.mux.RUnlock()
+	}
+
+	// Addresses dropped during the scan stay candidates for the next Expire
+	r.subs.mux.Lock()
+	for ip, isHeld := range held {
+		if isHeld {
+			delete(r.subs.candidates, ip)
+			continue
+		}
+		for _, id := range r.subs.contributions[ip] {
+			if fn, ok := r.subs.subscribers[id]; ok {
+				notifications = append(notifications, notification{fn: fn, addr: net.IPv4(ip[0], ip[1], ip[2], ip[3]).To4()})
+			}
+		}
+		delete(r.subs.contributions, ip)
+		delete(r.subs.candidates, ip)
+	}
+	r.subs.mux.Unlock()
+
+	// Subscribers may use the store, so they are called without the lock
+	for _, n := range notifications {
+		n.fn(n.addr)
+	}
+	return len(notifications)
+}
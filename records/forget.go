@@ -0,0 +1,92 @@
+package records
+
+// Forget removes the domain, domains which are aliases of it and the CNAME chain of it, targets of the chain
+// which other domains alias are kept. Addresses of removed records become candidates of Expire.
+// It returns the number of removed domains.
+func (r *Records) Forget(domainName string) int {
+	names := make(map[string]struct{})
+	for _, name := range r.GetAliases(domainName) {
+		names[name] = struct{}{}
+	}
+	for _, target := range r.chain(domainName) {
+		if r.aliasedExcept(target, names) {
+			break
+		}
+		names[target] = struct{}{}
+	}
+
+	count := 0
+	for name := range names {
+		sh := r.shardOf(name)
+		sh.mux.Lock()
+		if d, ok := sh.records[name]; ok {
+			for _, entry := range d.addresses {
+				r.drop(entry)
+			}
+			delete(sh.records, name)
+			count++
+		}
+		sh.mux.Unlock()
+	}
+	return count
+}
+
+// Flush removes all domains, their addresses become candidates of Expire. It returns the number of removed domains.
+func (r *Records) Flush() int {
+	count := 0
+	for idx := range r.shards {
+		sh := &r.shards[idx]
+		sh.mux.Lock()
+		for _, d := range sh.records {
+			for _, entry := range d.addresses {
+				r.drop(entry)
+			}
+		}
+		count += len(sh.records)
+		sh.records = make(map[string]*domain)
+		sh.mux.Unlock()
+	}
+	return count
+}
+
+// chain returns targets of the CNAME chain of the domain in order
+func (r *Records) chain(domainName string) []string {
+	var targets []string
+	seen := map[string]struct{}{domainName: {}}
+	for {
+		sh := r.shardOf(domainName)
+		sh.mux.RLock()
+		d, ok := sh.records[domainName]
+		var alias string
+		if ok {
+			alias = d.alias
+		}
+		sh.mux.RUnlock()
+		if alias == "" {
+			return targets
+		}
+		if _, ok := seen[alias]; ok {
+			return targets
+		}
+		seen[alias] = struct{}{}
+		targets = append(targets, alias)
+		domainName = alias
+	}
+}
+
+// aliasedExcept reports whether a domain out of the names is an alias of the target
+func (r *Records) aliasedExcept(target string, names map[string]struct{}) bool {
+	for idx := range r.shards {
+		sh := &r.shards[idx]
+		sh.mux.RLock()
+		for name, d := range sh.records {
+			if _, ok := names[name]; ok || d.alias != target {
+				continue
+			}
+			sh.mux.RUnlock()
+			return true
+		}
+		sh.mux.RUnlock()
+	}
+	return false
+}
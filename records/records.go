@@ -1,10 +1,11 @@
 package records
 
 import (
-	"bytes"
 	"net"
+	"sort"
 	"sync"
 	"time"
+	"unsafe"
 )
 
 type ARecord struct {
@@ -17,9 +18,85 @@ type CNameRecord struct {
 	Deadline time.Time
 }
 
-type Records struct {
+// address is a compact A record, the deadline is in nanoseconds of the monotonic clock (see monotonicNow)
+type address struct {
+	ip       [net.IPv4len]byte
+	deadline int64
+}
+
+// clockBase is the start of the clock of deadlines. Routers often boot with a wrong time and correct it
+// minutes later, deadlines counted by the monotonic clock aren't moved by such jumps.
+var clockBase = time.Now()
+
+// monotonicNow returns nanoseconds of the monotonic clock since clockBase
+func monotonicNow() int64 {
+	return int64(time.Since(clockBase))
+}
+
+// deadlineTime converts the deadline to the time relative to now, so comparisons with time.Now()
+// use the monotonic clock too
+func deadlineTime(deadline int64) time.Time {
+	return time.Now().Add(time.Duration(deadline - monotonicNow()))
+}
+
+// domain holds either a CNAME or A records of the name. Alias shares the memory
+// with the name of the target domain (interning), so a popular CDN name is stored once.
+type domain struct {
+	name          string
+	alias         string
+	aliasDeadline int64
+	addresses     []address
+}
+
+func (d *domain) isEmpty() bool {
+	return d.alias == "" && len(d.addresses) == 0
+}
+
+// shardCount is the number of independently locked parts of the store, a power of two
+const shardCount = 32
+
+// shard is a part of the store with domains of the same name hash, so answers of different
+// domains don't wait for each other or for a scan of the whole store
+type shard struct {
 	mux     sync.RWMutex
-	records map[string]interface{}
+	records map[string]*domain
+}
+
+type Records struct {
+	shards [shardCount]shard
+	subs   subscriptions
+}
+
+// shardOf returns the shard of the name by its FNV-1a hash
+func (r *Records) shardOf(name string) *shard {
+	hash := uint32(2166136261)
+	for idx := 0; idx < len(name); idx++ {
+		hash ^= uint32(name[idx])
+		hash *= 16777619
+	}
+	return &r.shards[hash&(shardCount-1)]
+}
+
+// Stats is the memory usage of the store, Bytes is an estimate of the steady-state heap usage
+type Stats struct {
+	Domains   int `json:"domains"`
+	Aliases   int `json:"aliases"`
+	Addresses int `json:"addresses"`
+	Bytes     int `json:"bytes"`
+}
+
+// mapEntryOverhead is an approximate cost of a map entry with string key and pointer value
+const mapEntryOverhead = 48
+
+// intern returns the stored copy of the name, creating an empty domain for it if needed.
+// The shard of the name must be locked.
+func (sh *shard) intern(name string) *domain {
+	d, ok := sh.records[name]
+	if !ok {
+		d = &domain{name: name}
+		sh.records[name] = d
+	}
+	return d
 }
 
 func (r *Records) AddCNameRecord(domainName, alias string, ttl uint32) {
@@ -27,59 +104,78 @@ func (r *Records) AddCNameRecord(domainName, alias string, ttl uint32) {
 		return
 	}
 
-	r.mux.Lock()
-	r.records[domainName] = &CNameRecord{
-		Alias:    alias,
-		Deadline: time.Now().Add(time.Duration(ttl) * time.Second),
+	targetShard := r.shardOf(alias)
+	targetShard.mux.Lock()
+	target := targetShard.intern(alias).name
+	targetShard.mux.Unlock()
+
+	sh := r.shardOf(domainName)
+	sh.mux.Lock()
+	defer sh.mux.Unlock()
+
+	d := sh.intern(domainName)
+	d.alias = target
+	d.aliasDeadline = monotonicNow() + int64(time.Duration(ttl)*time.Second)
+	for _, entry := range d.addresses {
+		r.drop(entry)
 	}
-	r.mux.Unlock()
+	d.addresses = nil
 }
 
 func (r *Records) AddARecord(domainName string, addr net.IP, ttl uint32) {
-	r.mux.Lock()
-	defer r.mux.Unlock()
+	ip4 := addr.To4()
+	if ip4 == nil {
+		return
+	}
 
-	deadline := time.Now().Add(time.Duration(ttl) * time.Second)
+	sh := r.shardOf(domainName)
+	sh.mux.Lock()
+	defer sh.mux.Unlock()
 
-	aRecords, _ := r.records[domainName].([]*ARecord)
-	for _, aRecord := range aRecords {
-		if bytes.Compare(aRecord.Address, addr) != 0 {
-			continue
+	deadline := monotonicNow() + int64(time.Duration(ttl)*time.Second)
+
+	d := sh.intern(domainName)
+	d.alias = ""
+	for idx := range d.addresses {
+		if net.IP(d.addresses[idx].ip[:]).Equal(ip4) {
+			d.addresses[idx].deadline = deadline
+			return
 		}
-		aRecord.Deadline = deadline
-		return
 	}
 
-	r.records[domainName] = append(aRecords, &ARecord{
-		Address:  addr,
-		Deadline: deadline,
-	})
+	entry := address{deadline: deadline}
+	copy(entry.ip[:], ip4)
+	d.addresses = append(d.addresses, entry)
 }
 
+// GetAliases returns the name and names which are aliases of it directly or through other aliases,
+// expired aliases are skipped
 func (r *Records) GetAliases(domainName string) []string {
-	r.mux.Lock()
-	defer r.mux.Unlock()
-	r.cleanupRecords()
+	now := monotonicNow()
 
 	domains := make(map[string]struct{})
 	domains[domainName] = struct{}{}
 
 	for {
 		var addedNew bool
-		for name, aRecord := range r.records {
-			if _, ok := domains[name]; ok {
-				continue
-			}
-			cname, ok := aRecord.(*CNameRecord)
-			if !ok {
-				continue
-			}
-			if _, ok = domains[cname.Alias]; !ok {
-				continue
-			}
+		for idx := range r.shards {
+			sh := &r.shards[idx]
+			sh.mux.RLock()
+			for name, d := range sh.records {
+				if _, ok := domains[name]; ok {
+					continue
+				}
+				if d.alias == "" || now > d.aliasDeadline {
+					continue
+				}
+				if _, ok := domains[d.alias]; !ok {
+					continue
+				}
 
-			domains[name] = struct{}{}
-			addedNew = true
+				domains[name] = struct{}{}
+				addedNew = true
+			}
+			sh.mux.RUnlock()
 		}
 		if !addedNew {
 			break
@@ -88,7 +184,7 @@ func (r *Records) GetAliases(domainName string) []string {
 
 	domainList := make([]string, len(domains))
 	idx := 0
-	for name, _ := range domains {
+	for name := range domains {
 		domainList[idx] = name
 		idx++
 	}
@@ -96,72 +192,155 @@ func (r *Records) GetAliases(domainName string) []string {
 	return domainList
 }
 
+// GetARecords returns live addresses of the name following its aliases, nil if there are none
 func (r *Records) GetARecords(domainName string) []*ARecord {
-	r.mux.Lock()
-	defer r.mux.Unlock()
-	r.cleanupRecords()
+	now := monotonicNow()
 
 	loopDetect := make(map[string]struct{})
 	loopDetect[domainName] = struct{}{}
 	for {
-		switch v := r.records[domainName].(type) {
-		case *CNameRecord:
-			if _, ok := loopDetect[v.Alias]; ok {
+		sh := r.shardOf(domainName)
+		sh.mux.RLock()
+		d, ok := sh.records[domainName]
+		if !ok {
+			sh.mux.RUnlock()
+			return nil
+		}
+		if d.alias != "" && now <= d.aliasDeadline {
+			alias := d.alias
+			sh.mux.RUnlock()
+			if _, ok := loopDetect[alias]; ok {
 				return nil
 			}
-			domainName = v.Alias
-			loopDetect[v.Alias] = struct{}{}
-		case []*ARecord:
-			return v
-		default:
-			return nil
+			domainName = alias
+			loopDetect[alias] = struct{}{}
+			continue
 		}
+		var aRecords []*ARecord
+		for _, entry := range d.addresses {
+			if now > entry.deadline {
+				continue
+			}
+			aRecords = append(aRecords, &ARecord{
+				Address:  net.IPv4(entry.ip[0], entry.ip[1], entry.ip[2], entry.ip[3]).To4(),
+				Deadline: deadlineTime(entry.deadline),
+			})
+		}
+		sh.mux.RUnlock()
+		return aRecords
 	}
 }
 
 func (r *Records) ListKnownDomains() []string {
-	r.mux.Lock()
-	defer r.mux.Unlock()
 	r.cleanupRecords()
 
-	domainsList := make([]string, len(r.records))
-	i := 0
-	for name, _ := range r.records {
-		domainsList[i] = name
-		i++
+	var domainsList []string
+	for idx := range r.shards {
+		sh := &r.shards[idx]
+		sh.mux.RLock()
+		for name := range sh.records {
+			domainsList = append(domainsList, name)
+		}
+		sh.mux.RUnlock()
 	}
 	return domainsList
 }
 
+// Entry is a domain of the store with either the alias or the addresses
+type Entry struct {
+	Domain    string
+	Alias     string
+	Deadline  time.Time
+	Addresses []ARecord
+}
+
+// List returns domains of the store sorted by name
+func (r *Records) List() []Entry {
+	r.cleanupRecords()
+
+	var entries []Entry
+	for idx := range r.shards {
+		sh := &r.shards[idx]
+		sh.mux.RLock()
+		for name, d := range sh.records {
+			entry := Entry{Domain: name}
+			if d.alias != "" {
+				entry.Alias = d.alias
+				entry.Deadline = deadlineTime(d.aliasDeadline)
+			}
+			for _, addr := range d.addresses {
+				entry.Addresses = append(entry.Addresses, ARecord{
+					Address:  net.IPv4(addr.ip[0], addr.ip[1], addr.ip[2], addr.ip[3]).To4(),
+					Deadline: deadlineTime(addr.deadline),
+				})
+			}
+			entries = append(entries, entry)
+		}
+		sh.mux.RUnlock()
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Domain < entries[j].Domain })
+	return entries
+}
+
+// Stats returns the memory usage of the store
+func (r *Records) Stats() Stats {
+	r.cleanupRecords()
+
+	var stats Stats
+	for idx := range r.shards {
+		sh := &r.shards[idx]
+		sh.mux.RLock()
+		stats.Domains += len(sh.records)
+		for _, d := range sh.records {
+			if d.alias != "" {
+				stats.Aliases++
+			}
+			stats.Addresses += len(d.addresses)
+			stats.Bytes += mapEntryOverhead + int(unsafe.Sizeof(*d)) + len(d.name) + cap(d.addresses)*int(unsafe.Sizeof(address{}))
+		}
+		sh.mux.RUnlock()
+	}
+	return stats
+}
+
+// cleanupRecords removes expired records locking one shard at a time
 func (r *Records) cleanupRecords() {
-	now := time.Now()
-	for name, records := range r.records {
-		switch v := records.(type) {
-		case []*ARecord:
+	now := monotonicNow()
+	for idx := range r.shards {
+		sh := &r.shards[idx]
+		sh.mux.Lock()
+		for name, d := range sh.records {
+			if d.alias != "" && now > d.aliasDeadline {
+				d.alias = ""
+			}
 			idx := 0
-			for _, aRecord := range v {
-				if now.After(aRecord.Deadline) {
+			for _, entry := range d.addresses {
+				if now > entry.deadline {
+					r.drop(entry)
 					continue
 				}
-				v[idx] = aRecord
+				d.addresses[idx] = entry
 				idx++
 			}
-			if idx == 0 {
-				delete(r.records, name)
-				break
-			}
-			r.records[name] = v[:idx]
-		case *CNameRecord:
-			if !now.After(v.Deadline) {
-				continue
+			d.addresses = d.addresses[:idx]
+			if d.isEmpty() {
+				delete(sh.records, name)
 			}
-			delete(r.records, name)
 		}
+		sh.mux.Unlock()
 	}
 }
 
 func New() *Records {
-	return &Records{
-		records: make(map[string]interface{}),
+	r := &Records{
+		subs: subscriptions{
+			subscribers:   make(map[uint32]ExpireFunc),
+			contributions: make(map[[net.IPv4len]byte][]uint32),
+			candidates:    make(map[[net.IPv4len]byte]struct{}),
+		},
+	}
+	for idx := range r.shards {
+		r.shards[idx].records = make(map[string]*domain)
 	}
+	return r
 }
@@ -0,0 +1,96 @@
+package records
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// legacyRecords is the previous design (map of interfaces with pointer records), kept for comparison
+type legacyRecords map[string]interface{}
+
+func (r legacyRecords) addA(name string, addr net.IP) {
+	aRecords, _ := r[name].([]*ARecord)
+	for _, aRecord := range aRecords {
+		if aRecord.Address.Equal(addr) {
+			aRecord.Deadline = time.Now().Add(time.Minute)
+			return
+		}
+	}
+	r[name] = append(aRecords, &ARecord{Address: addr, Deadline: time.Now().Add(time.Minute)})
+}
+
+func (r legacyRecords) addCName(name, alias string) {
+	r[name] = &CNameRecord{Alias: alias, Deadline: time.Now().Add(time.Minute)}
+}
+
+// fill resolves CDN-like names: every name is a CNAME to one of few edge names with 4 addresses
+func fill(n int, addA func(string, net.IP), addCName func(string, string)) {
+	for i := 0; i < n; i++ {
+		edge := fmt.Sprintf("edge-%d.cdn.example.net", i%64)
+		addCName(fmt.Sprintf("static-%d.service-%d.example.com", i, i%100), fmt.Sprintf("edge-%d.cdn.example.net", i%64))
+		for j := 0; j < 4; j++ {
+			addA(edge, net.IPv4(10, byte(i%64), byte(j), 1))
+		}
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
+
+const benchDomains = 10000
+
+func BenchmarkMemory(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heapInUse()
+			r := make(legacyRecords)
+			fill(benchDomains, r.addA, r.addCName)
+			b.ReportMetric(float64(heapInUse()-before)/benchDomains, "B/domain")
+			runtime.KeepAlive(r)
+		}
+	})
+	b.Run("interned", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heapInUse()
+			r := New()
+			fill(benchDomains, func(name string, addr net.IP) { r.AddARecord(name, addr, 60) }, func(name, alias string) { r.AddCNameRecord(name, alias, 60) })
+			b.ReportMetric(float64(heapInUse()-before)/benchDomains, "B/domain")
+			runtime.KeepAlive(r)
+		}
+	})
+}
+
+func BenchmarkGetARecords(b *testing.B) {
+	r := New()
+	fill(benchDomains, func(name string, addr net.IP) { r.AddARecord(name, addr, 60) }, func(name, alias string) { r.AddCNameRecord(name, alias, 60) })
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.GetARecords(fmt.Sprintf("static-%d.service-%d.example.com", i%benchDomains, i%100))
+	}
+}
+
+// BenchmarkParallel adds answers while the whole store is scanned, as Sync of groups does
+func BenchmarkParallel(b *testing.B) {
+	r := New()
+	fill(benchDomains, func(name string, addr net.IP) { r.AddARecord(name, addr, 60) }, func(name, alias string) { r.AddCNameRecord(name, alias, 60) })
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%100 == 0 {
+				r.ListKnownDomains()
+			}
+			r.AddARecord(fmt.Sprintf("edge-%d.cdn.example.net", i%64), net.IPv4(10, byte(i%64), 0, 1), 60)
+			r.GetARecords(fmt.Sprintf("static-%d.service-%d.example.com", i%benchDomains, i%100))
+			i++
+		}
+	})
+}
@@ -2,6 +2,8 @@ package records
 
 import (
 	"bytes"
+	"fmt"
+	"net"
 	"slices"
 	"testing"
 	"time"
@@ -108,3 +110,133 @@ func TestAliases(t *testing.T) {
 		t.Fatal("no 5")
 	}
 }
+
+func TestStats(t *testing.T) {
+	r := New()
+	r.AddARecord("example.com", []byte{1, 2, 3, 4}, 60)
+	r.AddARecord("example.com", []byte{1, 2, 3, 5}, 60)
+	r.AddCNameRecord("www.example.com", "example.com", 60)
+	r.AddCNameRecord("cdn.example.com", "missing.example.com", 60)
+
+	stats := r.Stats()
+	if stats.Domains != 3 || stats.Aliases != 2 || stats.Addresses != 2 || stats.Bytes == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	r := New()
+	var expired []string
+	id := r.Subscribe(func(addr net.IP) { expired = append(expired, addr.String()) })
+
+	r.AddARecord("example.com", []byte{1, 2, 3, 4}, 0)
+	r.AddARecord("cdn.example.com", []byte{5, 6, 7, 8}, 60)
+	r.Contribute(net.IP{1, 2, 3, 4}, id)
+	r.Contribute(net.IP{5, 6, 7, 8}, id)
+	// Addresses replaced by an alias are dropped as well
+	r.AddARecord("alias.example.com", []byte{9, 9, 9, 9}, 60)
+	r.Contribute(net.IP{9, 9, 9, 9}, id)
+	r.AddCNameRecord("alias.example.com", "cdn.example.com", 60)
+
+	time.Sleep(time.Millisecond)
+	r.Expire()
+	slices.Sort(expired)
+	if !slices.Equal(expired, []string{"1.2.3.4", "9.9.9.9"}) {
+		t.Fatalf("unexpected expired addresses: %v", expired)
+	}
+
+	expired = nil
+	r.Expire()
+	if len(expired) != 0 {
+		t.Fatalf("addresses are expired twice: %v", expired)
+	}
+}
+
+func TestList(t *testing.T) {
+	r := New()
+	r.AddARecord("b.example.com", []byte{1, 2, 3, 4}, 60)
+	r.AddCNameRecord("a.example.com", "b.example.com", 60)
+
+	entries := r.List()
+	if len(entries) != 2 || entries[0].Domain != "a.example.com" || entries[1].Domain != "b.example.com" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if entries[0].Alias != "b.example.com" || len(entries[1].Addresses) != 1 || !entries[1].Addresses[0].Address.Equal(net.IP{1, 2, 3, 4}) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	r := New()
+	id := r.Subscribe(func(net.IP) {})
+	done := make(chan struct{})
+	for worker := 0; worker < 4; worker++ {
+		go func(worker int) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; i < 200; i++ {
+				name := fmt.Sprintf("%d-%d.example.com", worker, i)
+				address := net.IPv4(10, byte(worker), byte(i), 1)
+				r.AddCNameRecord("www."+name, name, 60)
+				r.AddARecord(name, address, 0)
+				r.Contribute(address, id)
+				r.GetAliases(name)
+				r.GetARecords("www." + name)
+				r.Expire()
+			}
+		}(worker)
+	}
+	for worker := 0; worker < 4; worker++ {
+		<-done
+	}
+	if stats := r.Stats(); stats.Aliases != 800 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestForget(t *testing.T) {
+	r := New()
+	expired := make(map[string]struct{})
+	id := r.Subscribe(func(addr net.IP) { expired[addr.String()] = struct{}{} })
+	r.AddCNameRecord("www.example.com", "cdn.example.net", 60)
+	r.AddCNameRecord("static.example.com", "shared.example.net", 60)
+	r.AddCNameRecord("other.example.org", "shared.example.net", 60)
+	r.AddARecord("cdn.example.net", []byte{1, 2, 3, 4}, 60)
+	r.AddARecord("shared.example.net", []byte{1, 2, 3, 5}, 60)
+	r.Contribute([]byte{1, 2, 3, 4}, id)
+	r.Contribute([]byte{1, 2, 3, 5}, id)
+
+	if count := r.Forget("www.example.com"); count != 2 {
+		t.Fatalf("unexpected forgotten domains %d", count)
+	}
+	// The target of other aliases stays
+	if count := r.Forget("static.example.com"); count != 1 {
+		t.Fatalf("unexpected forgotten domains %d", count)
+	}
+	r.Expire()
+	if _, ok := expired["1.2.3.4"]; !ok || len(expired) != 1 {
+		t.Fatalf("unexpected expired addresses %v", expired)
+	}
+	if r.GetARecords("other.example.org") == nil {
+		t.Fatal("shared target is forgotten")
+	}
+
+	if count := r.Flush(); count != 2 {
+		t.Fatalf("unexpected flushed domains %d", count)
+	}
+	r.Expire()
+	if _, ok := expired["1.2.3.5"]; !ok {
+		t.Fatalf("unexpected expired addresses %v", expired)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	r := New()
+	r.AddARecord("example.com", []byte{1, 2, 3, 4}, 60)
+	records := r.GetARecords("example.com")
+	if len(records) != 1 {
+		t.Fatal("no records")
+	}
+	if ttl := time.Until(records[0].Deadline); ttl <= 59*time.Second || ttl > 60*time.Second {
+		t.Fatalf("unexpected TTL %s", ttl)
+	}
+}
@@ -0,0 +1,120 @@
+package magitrickle
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"magitrickle/group"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+type resolverLabel struct {
+	iface    string
+	deadline time.Time
+}
+
+// resolverLabels keeps the interface of the upstream set which answered for a domain,
+// while addresses of the answer live
+type resolverLabels struct {
+	mutex  sync.Mutex
+	labels map[string]resolverLabel
+}
+
+func newResolverLabels() *resolverLabels {
+	return &resolverLabels{labels: make(map[string]resolverLabel)}
+}
+
+func (l *resolverLabels) set(name, iface string, deadline time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.labels[name] = resolverLabel{iface: iface, deadline: deadline}
+}
+
+// get returns the interface of the first labeled name, empty if no name is labeled
+func (l *resolverLabels) get(names []string, now time.Time) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, name := range names {
+		label, ok := l.labels[strings.ToLower(name)]
+		if ok && now.Before(label.deadline) {
+			return label.iface
+		}
+	}
+	return ""
+}
+
+func (l *resolverLabels) expire(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for name, label := range l.labels {
+		if !now.Before(label.deadline) {
+			delete(l.labels, name)
+		}
+	}
+}
+
+// labelResolver labels names of the answer by the interface of the upstream set the query was routed to
+func (a *App) labelResolver(clientAddr net.Addr, reqMsg, respMsg *dns.Msg) {
+	route := a.routeQuery(clientAddr, reqMsg)
+	if route == nil || route.iface == "" {
+		return
+	}
+	var ttl uint32
+	for _, rr := range respMsg.Answer {
+		if rr.Header().Ttl > ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	deadline := time.Now().Add(time.Duration(ttl+a.config.Netfilter.IPSet.AdditionalTTL) * time.Second)
+	for _, name := range responseNames(respMsg, dns.TypeA) {
+		a.labels.set(name, route.iface, deadline)
+	}
+}
+
+// retargetResolver replaces matches of resolver rules by the enabled group of the interface which resolved
+// one of the names. Matches without a labeled name or a group of the interface are dropped.
+// Sync of the target group doesn't know these addresses, they return with the next answer.
+func (a *App) retargetResolver(matches []groupMatch, names []string) []groupMatch {
+	var iface string
+	labeled := false
+	retargeted := matches[:0]
+	for _, match := range matches {
+		if !match.rule.IsResolver() {
+			retargeted = append(retargeted, match)
+			continue
+		}
+		if !labeled && a.labels != nil {
+			iface = a.labels.get(names, time.Now())
+			labeled = true
+		}
+		target := a.interfaceGroup(iface)
+		if target == nil {
+			log.Debug().
+				Str("group", match.group.ID.String()).
+				Str("name", match.name).
+				Str("interface", iface).
+				Msg("no group of the resolving interface")
+			continue
+		}
+		match.group = target
+		retargeted = append(retargeted, match)
+	}
+	return retargeted
+}
+
+// interfaceGroup returns the first enabled group routing through the interface
+func (a *App) interfaceGroup(iface string) *group.Group {
+	if iface == "" {
+		return nil
+	}
+	for _, grp := range a.groups {
+		if grp.IsEnabled() && grp.Interface == iface {
+			return grp
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package magitrickle
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// SystemClient is the client of queries made by the app itself (warm-up, prefetch, etc.)
+var SystemClient net.Addr = systemAddr{}
+
+const systemNetwork = "internal"
+
+type systemAddr struct{}
+
+func (systemAddr) Network() string { return systemNetwork }
+func (systemAddr) String() string  { return "system" }
+
+// clientIP extracts the IP address of the DNS client
+func clientIP(addr net.Addr) net.IP {
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		return v.IP
+	case *net.TCPAddr:
+		return v.IP
+	}
+	return nil
+}
+
+// resolve queries the upstream on behalf of the system client
+// and passes the answer through the same pipeline as client responses
+func (a *App) resolve(name string, qtype uint16) (*dns.Msg, error) {
+	reqMsg := new(dns.Msg)
+	reqMsg.SetQuestion(dns.Fqdn(name), qtype)
+	respMsg, err := a.dnsMITM.Exchange(reqMsg)
+	if err != nil {
+		return nil, err
+	}
+	network := systemNetwork
+	a.handleMessage(*a.verifyAnswer(respMsg), SystemClient, &network)
+	return respMsg, nil
+}
@@ -0,0 +1,179 @@
+// Package secrets encrypts values of the config (API tokens, proxy and resolver credentials),
+// so backups of the config don't leak them.
+//
+// An encrypted value is "enc:v1:" followed by base64 of salt, nonce and AES-256-GCM ciphertext.
+// The key is derived from the salt and either a device-local key file or a passphrase.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	Prefix = "enc:v1:"
+
+	keySize   = 32
+	saltSize  = 16
+	nonceSize = 12
+	// passphraseIterations is the PBKDF2 cost, derived keys are cached per salt
+	passphraseIterations = 100000
+)
+
+var (
+	ErrNoKey         = errors.New("no secrets key")
+	ErrInvalidSecret = errors.New("invalid encrypted value")
+)
+
+type Keyring struct {
+	secret     []byte
+	passphrase bool
+
+	mux     sync.Mutex
+	salt    []byte
+	derived map[string]cipher.AEAD
+}
+
+// IsEncrypted reports whether the config value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// pbkdf2 is PBKDF2-HMAC-SHA256 producing a single block, which is the key size
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func (k *Keyring) aead(salt []byte) (cipher.AEAD, error) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+
+	if aead, ok := k.derived[string(salt)]; ok {
+		return aead, nil
+	}
+	var key []byte
+	if k.passphrase {
+		key = pbkdf2(k.secret, salt, passphraseIterations)
+	} else {
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(salt)
+		key = mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k.derived[string(salt)] = aead
+	return aead, nil
+}
+
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	k.mux.Lock()
+	if k.salt == nil {
+		k.salt = make([]byte, saltSize)
+		_, err := rand.Read(k.salt)
+		if err != nil {
+			k.mux.Unlock()
+			return "", err
+		}
+	}
+	salt := k.salt
+	k.mux.Unlock()
+
+	aead, err := k.aead(salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, nonceSize)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	data := append(append(append([]byte(nil), salt...), nonce...), aead.Seal(nil, nonce, []byte(plaintext), nil)...)
+	return Prefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt returns the plaintext of the encrypted value, other values are returned as is
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil || len(data) < saltSize+nonceSize {
+		return "", ErrInvalidSecret
+	}
+	aead, err := k.aead(data[:saltSize])
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aead.Open(nil, data[saltSize:saltSize+nonceSize], data[saltSize+nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: wrong key or corrupted value", ErrInvalidSecret)
+	}
+	return string(plaintext), nil
+}
+
+func FromPassphrase(passphrase string) *Keyring {
+	return &Keyring{
+		secret:     []byte(passphrase),
+		passphrase: true,
+		derived:    make(map[string]cipher.AEAD),
+	}
+}
+
+// FromFile reads the device-local key, a missing key is generated if create is set
+func FromFile(path string, create bool) (*Keyring, error) {
+	secret, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return nil, fmt.Errorf("%w: %s does not exist", ErrNoKey, path)
+		}
+		secret = make([]byte, keySize)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(path, secret, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key: %w", err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%w: %s is empty", ErrNoKey, path)
+	}
+	return &Keyring{
+		secret:  secret,
+		derived: make(map[string]cipher.AEAD),
+	}, nil
+}
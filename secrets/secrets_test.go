@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11 test vector
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1)
+	if hex.EncodeToString(key) != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" {
+		t.Fatalf("unexpected key: %x", key)
+	}
+}
+
+func TestFileKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.key")
+	if _, err := FromFile(path, false); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("missing key must not be created: %v", err)
+	}
+	keyring, err := FromFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := keyring.Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(value) {
+		t.Fatalf("value is not encrypted: %s", value)
+	}
+
+	reloaded, err := FromFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := reloaded.Decrypt(value)
+	if err != nil || plaintext != "token" {
+		t.Fatalf("unexpected plaintext %q: %v", plaintext, err)
+	}
+	plaintext, err = reloaded.Decrypt("plain")
+	if err != nil || plaintext != "plain" {
+		t.Fatalf("plain values must be returned as is: %q %v", plaintext, err)
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	value, err := FromPassphrase("correct").Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := FromPassphrase("correct").Decrypt(value)
+	if err != nil || plaintext != "token" {
+		t.Fatalf("unexpected plaintext %q: %v", plaintext, err)
+	}
+	if _, err = FromPassphrase("wrong").Decrypt(value); !errors.Is(err, ErrInvalidSecret) {
+		t.Fatalf("wrong passphrase must fail: %v", err)
+	}
+}
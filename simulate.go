@@ -0,0 +1,204 @@
+package magitrickle
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"magitrickle/group"
+	"magitrickle/models"
+)
+
+type GroupSummary struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Interface string `json:"interface"`
+	Rules     int    `json:"rules"`
+}
+
+type GroupChange struct {
+	ID                      string   `json:"id"`
+	Name                    string   `json:"name"`
+	OldInterface            string   `json:"oldInterface,omitempty"`
+	NewInterface            string   `json:"newInterface,omitempty"`
+	SettingsChanged         bool     `json:"settingsChanged"`
+	RulesAdded              []string `json:"rulesAdded"`
+	RulesRemoved            []string `json:"rulesRemoved"`
+	RulesChanged            []string `json:"rulesChanged"`
+	EstimatedIPSetDeletions int      `json:"estimatedIPSetDeletions"`
+}
+
+// ConfigDiff is the simulated effect of applying a candidate config
+type ConfigDiff struct {
+	AppChanged              bool           `json:"appChanged"`
+	GroupsAdded             []GroupSummary `json:"groupsAdded"`
+	GroupsRemoved           []GroupSummary `json:"groupsRemoved"`
+	GroupsChanged           []GroupChange  `json:"groupsChanged"`
+	Interfaces              []string       `json:"interfaces"`
+	EstimatedIPSetDeletions int            `json:"estimatedIPSetDeletions"`
+}
+
+func summarizeGroup(grp models.Group) GroupSummary {
+	return GroupSummary{
+		ID:        grp.ID.String(),
+		Name:      grp.Name,
+		Interface: grp.Interface,
+		Rules:     len(grp.Rules),
+	}
+}
+
+func diffRules(oldRules, newRules []*models.Rule) (added, removed, changed []string) {
+	oldMap := make(map[models.ID]*models.Rule)
+	for _, rule := range oldRules {
+		oldMap[rule.ID] = rule
+	}
+	newMap := make(map[models.ID]struct{})
+	for _, rule := range newRules {
+		newMap[rule.ID] = struct{}{}
+		oldRule, ok := oldMap[rule.ID]
+		if !ok {
+			added = append(added, rule.ID.String())
+			continue
+		}
+		if !reflect.DeepEqual(oldRule, rule) {
+			changed = append(changed, rule.ID.String())
+		}
+	}
+	for _, rule := range oldRules {
+		if _, ok := newMap[rule.ID]; !ok {
+			removed = append(removed, rule.ID.String())
+		}
+	}
+	return added, removed, changed
+}
+
+// ipsetSize returns the number of addresses in the group ipset, zero if unknown
+func ipsetSize(grp *group.Group) int {
+	addresses, err := grp.ListIP()
+	if err != nil {
+		return 0
+	}
+	return len(addresses)
+}
+
+// SimulateConfig compares the candidate config with the current state without applying it
+func (a *App) SimulateConfig(cfg models.Config) (*ConfigDiff, error) {
+	err := validateConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply defaults the same way as import does
+	candidateApp := New()
+	err = candidateApp.ImportConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ConfigDiff{
+		AppChanged:    !reflect.DeepEqual(a.config, candidateApp.config),
+		GroupsAdded:   []GroupSummary{},
+		GroupsRemoved: []GroupSummary{},
+		GroupsChanged: []GroupChange{},
+	}
+
+	interfaces := make(map[string]struct{})
+	current := make(map[models.ID]*group.Group)
+	for _, grp := range a.groups {
+		current[grp.ID] = grp
+	}
+	candidate := make(map[models.ID]struct{})
+
+	for _, newGroup := range cfg.Groups {
+		candidate[newGroup.ID] = struct{}{}
+		if newGroup.Interface != "" {
+			interfaces[newGroup.Interface] = struct{}{}
+		}
+
+		oldGroup, ok := current[newGroup.ID]
+		if !ok {
+			diff.GroupsAdded = append(diff.GroupsAdded, summarizeGroup(newGroup))
+			continue
+		}
+
+		change := GroupChange{
+			ID:   newGroup.ID.String(),
+			Name: newGroup.Name,
+		}
+		if oldGroup.Interface != newGroup.Interface {
+			change.OldInterface = oldGroup.Interface
+			change.NewInterface = newGroup.Interface
+			interfaces[oldGroup.Interface] = struct{}{}
+		}
+		oldSettings, newSettings := oldGroup.Group, newGroup
+		oldSettings.Rules, newSettings.Rules = nil, nil
+		change.SettingsChanged = !reflect.DeepEqual(oldSettings, newSettings)
+		change.RulesAdded, change.RulesRemoved, change.RulesChanged = diffRules(oldGroup.Rules, newGroup.Rules)
+		if !change.SettingsChanged && change.RulesAdded == nil && change.RulesRemoved == nil && change.RulesChanged == nil {
+			continue
+		}
+
+		if a.records != nil && (change.RulesRemoved != nil || change.RulesChanged != nil) {
+			addresses := group.MatchedAddresses(newGroup.Rules, a.records)
+			currentAddresses, err := oldGroup.ListIP()
+			if err == nil {
+				for addr := range currentAddresses {
+					if _, ok := addresses[addr]; !ok {
+						change.EstimatedIPSetDeletions++
+					}
+				}
+			}
+		}
+		diff.EstimatedIPSetDeletions += change.EstimatedIPSetDeletions
+		diff.GroupsChanged = append(diff.GroupsChanged, change)
+	}
+
+	for _, oldGroup := range a.groups {
+		if _, ok := candidate[oldGroup.ID]; ok {
+			continue
+		}
+		interfaces[oldGroup.Interface] = struct{}{}
+		diff.GroupsRemoved = append(diff.GroupsRemoved, summarizeGroup(oldGroup.Group))
+		diff.EstimatedIPSetDeletions += ipsetSize(oldGroup)
+	}
+
+	diff.Interfaces = make([]string, 0, len(interfaces))
+	for iface := range interfaces {
+		diff.Interfaces = append(diff.Interfaces, iface)
+	}
+	sort.Strings(diff.Interfaces)
+
+	return diff, nil
+}
+
+func validateConfig(cfg models.Config) error {
+	if !strings.HasPrefix(cfg.ConfigVersion, "0.1.") {
+		return ErrConfigUnsupportedVersion
+	}
+	groupIDs := make(map[models.ID]struct{})
+	groupSlugs := make(map[string]struct{})
+	proxyPorts := make(map[uint16]struct{})
+	for idx := range cfg.Groups {
+		if _, exists := groupIDs[cfg.Groups[idx].ID]; exists {
+			return ErrGroupIDConflict
+		}
+		groupIDs[cfg.Groups[idx].ID] = struct{}{}
+		if slug := cfg.Groups[idx].Slug; slug != "" {
+			if _, exists := groupSlugs[slug]; exists {
+				return ErrGroupSlugConflict
+			}
+			groupSlugs[slug] = struct{}{}
+		}
+		if proxy := cfg.Groups[idx].Proxy; proxy.IsEnabled() {
+			if _, exists := proxyPorts[proxy.Port]; exists {
+				return ErrProxyPortConflict
+			}
+			proxyPorts[proxy.Port] = struct{}{}
+		}
+		err := cfg.Groups[idx].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
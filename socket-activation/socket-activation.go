@@ -0,0 +1,281 @@
+// Package socketActivation takes over listening sockets passed by the parent process by the systemd
+// protocol (LISTEN_PID, LISTEN_FDS, sockets from fd 3): systemd socket activation or a previous daemon
+// handing its sockets to the new one. Sockets are matched to listeners by their bound address, so the
+// order of passed sockets doesn't matter, sockets nobody takes stay open. Open sockets of Listen and
+// ListenPacket are passed on to the next process by Files.
+package socketActivation
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// listenFDsStart is the first passed descriptor
+const listenFDsStart = 3
+
+var ErrInvalidEnv = errors.New("invalid socket activation environment")
+
+type socket struct {
+	listener   net.Listener
+	packetConn net.PacketConn
+}
+
+// Sockets are passed sockets not taken yet
+type Sockets struct {
+	mux     sync.Mutex
+	sockets []socket
+}
+
+var (
+	inherited     *Sockets
+	inheritedOnce sync.Once
+)
+
+// Inherited returns sockets passed to the process, the environment is read once and cleared,
+// so child processes don't take the sockets
+func Inherited() *Sockets {
+	inheritedOnce.Do(func() {
+		count, err := parseEnv(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getpid())
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+		if err != nil {
+			log.Warn().Err(err).Msg("ignoring passed sockets")
+		}
+		files := make([]*os.File, 0, count)
+		for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+			syscall.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd)))
+		}
+		inherited = New(files)
+		if count != 0 {
+			log.Info().Int("sockets", count).Msg("received passed sockets")
+		}
+	})
+	return inherited
+}
+
+// parseEnv returns the number of passed sockets, LISTEN_PID may be omitted by the parent which can't know it
+func parseEnv(listenPID, listenFDs string, pid int) (int, error) {
+	if listenFDs == "" {
+		return 0, nil
+	}
+	if listenPID != "" {
+		expected, err := strconv.Atoi(listenPID)
+		if err != nil {
+			return 0, fmt.Errorf("%w: LISTEN_PID %q", ErrInvalidEnv, listenPID)
+		}
+		if expected != pid {
+			// Sockets are passed to another process
+			return 0, nil
+		}
+	}
+	count, err := strconv.Atoi(listenFDs)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("%w: LISTEN_FDS %q", ErrInvalidEnv, listenFDs)
+	}
+	return count, nil
+}
+
+// New returns sockets of the files, files are closed (sockets keep their own descriptors)
+func New(files []*os.File) *Sockets {
+	s := &Sockets{}
+	for _, file := range files {
+		if listener, err := net.FileListener(file); err == nil {
+			s.sockets = append(s.sockets, socket{listener: listener})
+		} else if packetConn, err := net.FilePacketConn(file); err == nil {
+			s.sockets = append(s.sockets, socket{packetConn: packetConn})
+		} else {
+			log.Warn().Str("file", file.Name()).Err(err).Msg("passed descriptor is not a socket")
+		}
+		_ = file.Close()
+	}
+	return s
+}
+
+// Listener takes the passed stream socket bound to the address of the network ("tcp" or "unix"), nil if there is none
+func (s *Sockets) Listener(network, address string) net.Listener {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for idx, sock := range s.sockets {
+		if sock.listener != nil && sameAddr(sock.listener.Addr(), network, address) {
+			s.sockets = append(s.sockets[:idx], s.sockets[idx+1:]...)
+			return sock.listener
+		}
+	}
+	return nil
+}
+
+// PacketConn takes the passed datagram socket bound to the address of the network ("udp"), nil if there is none
+func (s *Sockets) PacketConn(network, address string) net.PacketConn {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for idx, sock := range s.sockets {
+		if sock.packetConn != nil && sameAddr(sock.packetConn.LocalAddr(), network, address) {
+			s.sockets = append(s.sockets[:idx], s.sockets[idx+1:]...)
+			return sock.packetConn
+		}
+	}
+	return nil
+}
+
+// Has reports whether the passed socket bound to the address of the network isn't taken yet
+func (s *Sockets) Has(network, address string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, sock := range s.sockets {
+		if sock.listener != nil && sameAddr(sock.listener.Addr(), network, address) ||
+			sock.packetConn != nil && sameAddr(sock.packetConn.LocalAddr(), network, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of sockets not taken yet
+func (s *Sockets) Len() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.sockets)
+}
+
+// filer is a socket which can be duplicated
+type filer interface {
+	File() (*os.File, error)
+}
+
+var (
+	trackedMux sync.Mutex
+	tracked    = make(map[filer]struct{})
+)
+
+func track(socket interface{}) {
+	if f, ok := socket.(filer); ok {
+		trackedMux.Lock()
+		tracked[f] = struct{}{}
+		trackedMux.Unlock()
+	}
+}
+
+func untrack(socket interface{}) {
+	if f, ok := socket.(filer); ok {
+		trackedMux.Lock()
+		delete(tracked, f)
+		trackedMux.Unlock()
+	}
+}
+
+type trackedListener struct {
+	net.Listener
+}
+
+func (l *trackedListener) Close() error {
+	untrack(l.Listener)
+	return l.Listener.Close()
+}
+
+type trackedPacketConn struct {
+	net.PacketConn
+}
+
+func (c *trackedPacketConn) Close() error {
+	untrack(c.PacketConn)
+	return c.PacketConn.Close()
+}
+
+// Listen takes the passed stream socket of the address or binds a new one by listen,
+// the socket is passed on by Files until it's closed
+func Listen(network, address string, listen func(network, address string) (net.Listener, error)) (net.Listener, error) {
+	listener := Inherited().Listener(network, address)
+	if listener != nil {
+		log.Info().Str("network", network).Str("address", address).Msg("using passed socket")
+	} else {
+		var err error
+		listener, err = listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	track(listener)
+	return &trackedListener{Listener: listener}, nil
+}
+
+// ListenPacket takes the passed datagram socket of the address or binds a new one by listen,
+// the socket is passed on by Files until it's closed
+func ListenPacket(network, address string, listen func(network, address string) (net.PacketConn, error)) (net.PacketConn, error) {
+	conn := Inherited().PacketConn(network, address)
+	if conn != nil {
+		log.Info().Str("network", network).Str("address", address).Msg("using passed socket")
+	} else {
+		var err error
+		conn, err = listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	track(conn)
+	return &trackedPacketConn{PacketConn: conn}, nil
+}
+
+// Files returns duplicates of open sockets of Listen and ListenPacket for the next process,
+// the caller closes them
+func Files() []*os.File {
+	trackedMux.Lock()
+	defer trackedMux.Unlock()
+	files := make([]*os.File, 0, len(tracked))
+	for socket := range tracked {
+		file, err := socket.File()
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to duplicate socket")
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// sameAddr reports whether the socket is bound to the address, unspecified addresses of both families are the same
+func sameAddr(addr net.Addr, network, address string) bool {
+	var ip net.IP
+	var port int
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		if network != "tcp" && network != "tcp4" && network != "tcp6" {
+			return false
+		}
+		ip, port = addr.IP, addr.Port
+	case *net.UDPAddr:
+		if network != "udp" && network != "udp4" && network != "udp6" {
+			return false
+		}
+		ip, port = addr.IP, addr.Port
+	case *net.UnixAddr:
+		return network == addr.Net && address == addr.Name
+	default:
+		return false
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	expectedPort, err := strconv.Atoi(portStr)
+	if err != nil || expectedPort == 0 || expectedPort != port {
+		return false
+	}
+	if host == "" {
+		return ip.IsUnspecified()
+	}
+	expectedIP := net.ParseIP(host)
+	if expectedIP == nil {
+		return false
+	}
+	return expectedIP.Equal(ip) || expectedIP.IsUnspecified() && ip.IsUnspecified()
+}
@@ -0,0 +1,115 @@
+package socketActivation
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	if count, err := parseEnv("42", "2", 42); err != nil || count != 2 {
+		t.Fatalf("unexpected result %d, %v", count, err)
+	}
+	if count, err := parseEnv("", "1", 42); err != nil || count != 1 {
+		t.Fatalf("unexpected result without pid %d, %v", count, err)
+	}
+	if count, err := parseEnv("43", "2", 42); err != nil || count != 0 {
+		t.Fatalf("sockets of another process are taken: %d, %v", count, err)
+	}
+	if count, err := parseEnv("", "", 42); err != nil || count != 0 {
+		t.Fatalf("unexpected result without sockets %d, %v", count, err)
+	}
+	if _, err := parseEnv("42", "two", 42); !errors.Is(err, ErrInvalidEnv) {
+		t.Fatalf("expected invalid env, got %v", err)
+	}
+}
+
+func TestSockets(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	listenerFile, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connFile, err := conn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New([]*os.File{connFile, listenerFile})
+	if s.Len() != 2 || !s.Has("udp", conn.LocalAddr().String()) {
+		t.Fatalf("unexpected sockets %d", s.Len())
+	}
+
+	if s.Listener("tcp", conn.LocalAddr().String()) != nil || s.PacketConn("udp", listener.Addr().String()) != nil {
+		t.Fatal("socket of another address is taken")
+	}
+	passedConn := s.PacketConn("udp", conn.LocalAddr().String())
+	if passedConn == nil {
+		t.Fatal("udp socket is not taken")
+	}
+	defer func() { _ = passedConn.Close() }()
+	passedListener := s.Listener("tcp", listener.Addr().String())
+	if passedListener == nil {
+		t.Fatal("tcp socket is not taken")
+	}
+	defer func() { _ = passedListener.Close() }()
+	if s.Len() != 0 || s.Listener("tcp", listener.Addr().String()) != nil {
+		t.Fatal("socket is taken twice")
+	}
+}
+
+func TestSameAddr(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv6unspecified, Port: 3553}
+	for address, expected := range map[string]bool{
+		":3553":         true,
+		"0.0.0.0:3553":  true,
+		"[::]:3553":     true,
+		"[::]:53":       false,
+		"10.0.0.1:3553": false,
+	} {
+		if sameAddr(addr, "udp", address) != expected {
+			t.Fatalf("unexpected match of %s", address)
+		}
+	}
+	if sameAddr(addr, "tcp", ":3553") {
+		t.Fatal("udp socket matches tcp")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	listener, err := Listen("tcp", "127.0.0.1:0", net.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := Files()
+	if len(files) != 1 {
+		t.Fatalf("unexpected files %v", files)
+	}
+	passed := New(files)
+	if !passed.Has("tcp", listener.Addr().String()) {
+		t.Fatal("socket is not passed")
+	}
+
+	_ = listener.Close()
+	if files := Files(); len(files) != 0 {
+		t.Fatalf("closed socket is passed: %v", files)
+	}
+	passedListener := passed.Listener("tcp", listener.Addr().String())
+	defer func() { _ = passedListener.Close() }()
+	// The duplicate still accepts connections after the original is closed
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
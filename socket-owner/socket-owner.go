@@ -0,0 +1,98 @@
+// Package socketOwner finds the process which owns a local socket by /proc, it's used to tell queries
+// of the router's own resolver (e.g. dnsmasq) from queries of other local processes.
+package socketOwner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procRoot is replaced by tests
+var procRoot = "/proc"
+
+// Process returns the name (comm) of the process owning the socket bound to the local port,
+// empty if no process is found
+func Process(network string, port uint16) (string, error) {
+	inodes := make(map[string]struct{})
+	for _, table := range []string{network, network + "6"} {
+		file, err := os.Open(filepath.Join(procRoot, "net", table))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("failed to read sockets: %w", err)
+		}
+		for _, inode := range parseSockets(file, port) {
+			inodes[inode] = struct{}{}
+		}
+		_ = file.Close()
+	}
+	if len(inodes) == 0 {
+		return "", nil
+	}
+
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return "", fmt.Errorf("failed to list processes: %w", err)
+	}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		if !ownsSocket(filepath.Join(procRoot, entry.Name()), inodes) {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		return strings.TrimSpace(string(comm)), nil
+	}
+	return "", nil
+}
+
+// ownsSocket reports whether the process has one of the socket inodes open, processes which are gone
+// or not accessible don't own anything
+func ownsSocket(procDir string, inodes map[string]struct{}) bool {
+	fds, err := os.ReadDir(filepath.Join(procDir, "fd"))
+	if err != nil {
+		return false
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(procDir, "fd", fd.Name()))
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if _, ok := inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSockets returns inodes of sockets of /proc/net/{udp,tcp}[6] bound to the local port
+func parseSockets(r io.Reader, port uint16) []string {
+	var inodes []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		idx := strings.LastIndexByte(fields[1], ':')
+		if idx == -1 {
+			continue
+		}
+		localPort, err := strconv.ParseUint(fields[1][idx+1:], 16, 16)
+		if err != nil || uint16(localPort) != port || fields[9] == "0" {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+	return inodes
+}
@@ -0,0 +1,67 @@
+package socketOwner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const udpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  101: 0100007F:9C40 0100007F:0DE1 01 00000000:00000000 00:00000000 00000000     0        0 4242 2 0000000000000000 0
+  102: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 1717 2 0000000000000000 0
+`
+
+func TestParseSockets(t *testing.T) {
+	inodes := parseSockets(strings.NewReader(udpTable), 40000)
+	if len(inodes) != 1 || inodes[0] != "4242" {
+		t.Fatalf("unexpected inodes %v", inodes)
+	}
+	if inodes := parseSockets(strings.NewReader(udpTable), 53); len(inodes) != 1 || inodes[0] != "1717" {
+		t.Fatalf("unexpected inodes %v", inodes)
+	}
+	if inodes := parseSockets(strings.NewReader(udpTable), 5353); len(inodes) != 0 {
+		t.Fatalf("unexpected inodes %v", inodes)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	root := t.TempDir()
+	procRoot = root
+	t.Cleanup(func() { procRoot = "/proc" })
+
+	for path, content := range map[string]string{
+		"net/udp":    udpTable,
+		"1234/comm":  "dnsmasq\n",
+		"5678/comm":  "ntpd\n",
+		"self/dummy": "",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pid, inode := range map[string]string{"1234": "4242", "5678": "1717"} {
+		if err := os.MkdirAll(filepath.Join(root, pid, "fd"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("socket:["+inode+"]", filepath.Join(root, pid, "fd", "3")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name, err := Process("udp", 40000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "dnsmasq" {
+		t.Fatalf("unexpected process %q", name)
+	}
+	name, err = Process("udp", 5353)
+	if err != nil || name != "" {
+		t.Fatalf("unexpected process %q, %v", name, err)
+	}
+}
@@ -0,0 +1,133 @@
+package magitrickle
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"magitrickle/history"
+	"magitrickle/models"
+	"magitrickle/summary"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// statsExportTopDomains is the number of top domains of the last statsExportPeriod in the export
+	statsExportTopDomains = 100
+	statsExportPeriod     = 24 * time.Hour
+)
+
+// StatsExport is a snapshot of statistics for spreadsheets, top domains need the history
+type StatsExport struct {
+	Time       time.Time             `json:"time"`
+	Groups     []summary.Group       `json:"groups"`
+	Rules      []summary.RuleHits    `json:"rules"`
+	TopDomains []history.DomainCount `json:"topDomains"`
+}
+
+// ExportStats returns address counts of groups, hit counters of rules and top domains of the last day
+func (a *App) ExportStats() (StatsExport, error) {
+	now := time.Now()
+	top, err := a.history.TopDomains("", now.Add(-statsExportPeriod), statsExportTopDomains)
+	if err != nil {
+		return StatsExport{}, err
+	}
+	return StatsExport{
+		Time:       now,
+		Groups:     a.stats.Groups(),
+		Rules:      a.stats.RuleHits(),
+		TopDomains: top,
+	}, nil
+}
+
+// WriteStats writes the export as JSON or CSV (models.StatsFormatJSON, models.StatsFormatCSV)
+func WriteStats(w io.Writer, export StatsExport, format string) error {
+	if format == models.StatsFormatCSV {
+		return writeStatsCSV(w, export)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// writeStatsCSV writes one row per group, rule and domain: type, group, rule, domain, count.
+// The count of a group is its addresses, of a rule its hits, of a domain its matches.
+func writeStatsCSV(w io.Writer, export StatsExport) error {
+	writer := csv.NewWriter(w)
+	rows := [][]string{{"type", "group", "rule", "domain", "count"}}
+	for _, group := range export.Groups {
+		rows = append(rows, []string{"group", group.ID, "", "", strconv.Itoa(group.Addresses)})
+	}
+	for _, rule := range export.Rules {
+		rows = append(rows, []string{"rule", rule.Group, rule.Rule, "", strconv.FormatUint(rule.Hits, 10)})
+	}
+	for _, domain := range export.TopDomains {
+		rows = append(rows, []string{"domain", "", "", domain.Domain, strconv.Itoa(domain.Count)})
+	}
+	return writer.WriteAll(rows)
+}
+
+// ExportStatsFile writes the export to the file, CSV if its extension is .csv and JSON otherwise
+func (a *App) ExportStatsFile(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%w: %s", ErrInvalidStatsPath, path)
+	}
+	format := models.StatsFormatJSON
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		format = models.StatsFormatCSV
+	}
+	export, err := a.ExportStats()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create stats directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	err = WriteStats(file, export, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// checkStatsExport writes the daily export once a day, the first one right after the start
+func (a *App) checkStatsExport(now time.Time) {
+	cfg := a.config.StatsExport
+	if cfg.Path == "" {
+		return
+	}
+	day := now.Format(time.DateOnly)
+	if a.statsExported == day {
+		return
+	}
+	a.statsExported = day
+	format := cfg.Format
+	if format == "" {
+		format = models.StatsFormatJSON
+	}
+	path := filepath.Join(cfg.Path, "stats-"+day+"."+format)
+	err := a.ExportStatsFile(path)
+	if err != nil {
+		log.Error().Str("path", path).Err(err).Msg("failed to export stats")
+		return
+	}
+	log.Info().Str("path", path).Msg("stats exported")
+}
@@ -0,0 +1,152 @@
+// Package subscription fetches domain lists published by third parties and turns them into group rules.
+//
+// A list is a text file with one entry per line: a domain (the domain and its subdomains), a wildcard
+// (*.example.com) or a network (10.0.0.0/8). Comments start with "#", lines of hosts files
+// ("0.0.0.0 example.com") are taken by the last field.
+package subscription
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"magitrickle/models"
+)
+
+const (
+	maxListSize    = 8 << 20
+	requestTimeout = 30 * time.Second
+)
+
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// Fetch downloads the list and returns its entries
+func Fetch(ctx context.Context, url string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch list: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch list: unexpected status: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list: %w", err)
+	}
+	if len(data) > maxListSize {
+		return nil, fmt.Errorf("list is larger than %d bytes", maxListSize)
+	}
+	return Parse(data), nil
+}
+
+// Parse returns sorted unique entries of the list
+func Parse(data []byte) []string {
+	unique := make(map[string]struct{})
+	for _, line := range strings.Split(string(data), "\n") {
+		if idx := strings.IndexByte(line, '#'); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry := strings.ToLower(strings.TrimSuffix(fields[len(fields)-1], "."))
+		if entry == "" || entry == "localhost" {
+			continue
+		}
+		unique[entry] = struct{}{}
+	}
+	entries := make([]string, 0, len(unique))
+	for entry := range unique {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// Rules returns rules of the list entries, IDs are derived from the subscription and the entry,
+// so the same entry keeps its rule across updates. Invalid entries are skipped.
+func Rules(id models.ID, entries []string) []*models.Rule {
+	rules := make([]*models.Rule, 0, len(entries))
+	for _, entry := range entries {
+		rule := &models.Rule{
+			ID:           ruleID(id, entry),
+			Name:         entry,
+			Type:         "namespace",
+			Rule:         entry,
+			Enable:       true,
+			Subscription: &id,
+		}
+		switch {
+		case strings.ContainsAny(entry, "*?"):
+			rule.Type = "wildcard"
+		case strings.ContainsAny(entry, ":/") || isAddress(entry):
+			rule.Type = "subnet"
+		}
+		if rule.Validate() != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func isAddress(entry string) bool {
+	_, err := models.ParseCIDR(entry)
+	return err == nil
+}
+
+func ruleID(id models.ID, entry string) models.ID {
+	sum := sha256.Sum256(append(id[:], entry...))
+	return models.ID(sum[:4])
+}
+
+// Entries returns entries of the rules which come from the subscription
+func Entries(id models.ID, rules []*models.Rule) []string {
+	var entries []string
+	for _, rule := range rules {
+		if rule.FromSubscription(id) {
+			entries = append(entries, rule.Rule)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// Diff counts entries added and removed by the update, both lists are sorted
+func Diff(current, next []string) (added, removed int) {
+	i, j := 0, 0
+	for i < len(current) || j < len(next) {
+		switch {
+		case j == len(next) || (i < len(current) && current[i] < next[j]):
+			removed++
+			i++
+		case i == len(current) || next[j] < current[i]:
+			added++
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return added, removed
+}
+
+// Hold reports whether the update removes more than threshold percent of the current entries,
+// the first fetch of a list is never held
+func Hold(current []string, removed, threshold int) bool {
+	if threshold >= 100 || len(current) == 0 {
+		return false
+	}
+	return removed*100 > threshold*len(current)
+}
@@ -0,0 +1,48 @@
+package subscription
+
+import (
+	"slices"
+	"testing"
+
+	"magitrickle/models"
+)
+
+func TestParse(t *testing.T) {
+	entries := Parse([]byte("# list\nExample.com.\n0.0.0.0 ads.example.net # hosts\n*.example.org\n\nexample.com\n10.0.0.0/8\nlocalhost\n"))
+	expected := []string{"*.example.org", "10.0.0.0/8", "ads.example.net", "example.com"}
+	if !slices.Equal(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+
+	rules := Rules(models.ID{1}, append(entries, "bad/entry"))
+	types := make([]string, len(rules))
+	for idx, rule := range rules {
+		types[idx] = rule.Type
+		if !rule.FromSubscription(models.ID{1}) {
+			t.Fatalf("rule %s is not marked by the subscription", rule.Rule)
+		}
+	}
+	if !slices.Equal(types, []string{"wildcard", "subnet", "namespace", "namespace"}) {
+		t.Fatalf("unexpected rule types: %v", types)
+	}
+	if again := Rules(models.ID{1}, entries[3:]); again[0].ID != rules[3].ID {
+		t.Fatal("rule ID of the same entry changed")
+	}
+}
+
+func TestHold(t *testing.T) {
+	current := []string{"a.com", "b.com", "c.com", "d.com"}
+	added, removed := Diff(current, []string{"a.com", "e.com"})
+	if added != 1 || removed != 3 {
+		t.Fatalf("expected 1 added and 3 removed, got %d and %d", added, removed)
+	}
+	if !Hold(current, removed, 50) {
+		t.Fatal("expected update removing 75% to be held")
+	}
+	if Hold(current, 2, 50) {
+		t.Fatal("expected update removing 50% to be applied")
+	}
+	if Hold(current, removed, 100) || Hold(nil, 0, 50) {
+		t.Fatal("expected update to be applied")
+	}
+}
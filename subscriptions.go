@@ -0,0 +1,409 @@
+package magitrickle
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"magitrickle/models"
+	"magitrickle/notify"
+	"magitrickle/subscription"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// subscriptionCheckInterval is how often subscriptions are checked for due updates
+	subscriptionCheckInterval = time.Minute
+	// subscriptionFetchLimit is how many lists are fetched at once
+	subscriptionFetchLimit = 2
+	// subscriptionMaxJitter bounds the random delay added to the interval, so lists aren't fetched all at once
+	subscriptionMaxJitter = 30 * time.Minute
+	// subscriptionRetryDelay is the delay before the first retry of a failed fetch, it doubles up to the interval
+	subscriptionRetryDelay = time.Minute
+)
+
+// SubscriptionSource is the fetch status of a list URL, subscriptions of the same URL share one fetch
+type SubscriptionSource struct {
+	URL         string     `json:"url"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	// Failures is the number of consecutive failed fetches
+	Failures  int       `json:"failures"`
+	LastError string    `json:"lastError,omitempty"`
+	NextFetch time.Time `json:"nextFetch"`
+	Fetching  bool      `json:"fetching"`
+}
+
+// HeldUpdate is an update of the subscription list which removes too many rules, it's applied
+// only after approval (see ApproveSubscription), so vandalism of the list doesn't break routing
+type HeldUpdate struct {
+	GroupID        models.ID `json:"groupId"`
+	SubscriptionID models.ID `json:"subscriptionId"`
+	URL            string    `json:"url"`
+	Current        int       `json:"current"`
+	Added          int       `json:"added"`
+	Removed        int       `json:"removed"`
+	Fetched        time.Time `json:"fetched"`
+
+	entries []string
+}
+
+type subscriptionKey struct {
+	group        models.ID
+	subscription models.ID
+}
+
+type subscriptionResult struct {
+	url     string
+	entries []string
+	err     error
+}
+
+// subscriptions tracks updates of subscription lists, fetches run outside the main loop
+// and their results are applied by it
+type subscriptions struct {
+	mux     sync.Mutex
+	sources map[string]*SubscriptionSource
+	held    map[subscriptionKey]*HeldUpdate
+	// rejected are entries of rejected updates, the same list is not held again
+	rejected map[subscriptionKey][]string
+	results  chan subscriptionResult
+	// slots limits concurrent fetches
+	slots chan struct{}
+}
+
+// subscriptionJitter returns a random delay up to a tenth of the interval
+func subscriptionJitter(interval time.Duration) time.Duration {
+	limit := min(interval/10, subscriptionMaxJitter)
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// subscriptionRetry returns the delay after the failed fetch, it doubles with every consecutive failure
+// up to the interval
+func subscriptionRetry(failures int, interval time.Duration) time.Duration {
+	delay := subscriptionRetryDelay
+	for i := 1; i < failures && delay < interval; i++ {
+		delay *= 2
+	}
+	return max(min(delay, interval), subscriptionRetryDelay)
+}
+
+// subscriptionSources returns URLs of subscriptions with the shortest interval of subscriptions of the URL
+func (a *App) subscriptionSources() map[string]time.Duration {
+	sources := make(map[string]time.Duration)
+	for _, grp := range a.groups {
+		for _, sub := range grp.Subscriptions {
+			interval := time.Duration(sub.IntervalOrDefault()) * time.Second
+			if current, ok := sources[sub.URL]; !ok || interval < current {
+				sources[sub.URL] = interval
+			}
+		}
+	}
+	return sources
+}
+
+// checkSubscriptions starts fetches of lists which are due. New lists are due right away, then the
+// interval with a random delay is waited, so lists of the same interval spread out over time. Fetches
+// wait for a free slot, so only a few of them run at once.
+func (a *App) checkSubscriptions(ctx context.Context) {
+	s := &a.subscriptions
+	now := time.Now()
+	urls := a.subscriptionSources()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.sources == nil {
+		s.sources = make(map[string]*SubscriptionSource)
+	}
+	if s.slots == nil {
+		s.slots = make(chan struct{}, subscriptionFetchLimit)
+	}
+	for url, source := range s.sources {
+		if _, ok := urls[url]; !ok && !source.Fetching {
+			delete(s.sources, url)
+		}
+	}
+	for url := range urls {
+		source, ok := s.sources[url]
+		if !ok {
+			source = &SubscriptionSource{URL: url, NextFetch: now}
+			s.sources[url] = source
+		}
+		if source.Fetching || now.Before(source.NextFetch) {
+			continue
+		}
+		attempt := now
+		source.Fetching = true
+		source.LastAttempt = &attempt
+		go func(url string) {
+			select {
+			case s.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			entries, err := subscription.Fetch(ctx, url)
+			<-s.slots
+			select {
+			case s.results <- subscriptionResult{url: url, entries: entries, err: err}:
+			case <-ctx.Done():
+			}
+		}(url)
+	}
+}
+
+// handleSubscriptionResult applies the fetched list to subscriptions of its URL or schedules a retry,
+// the failure is notified once until the list is fetched again
+func (a *App) handleSubscriptionResult(result subscriptionResult) {
+	interval := a.subscriptionSources()[result.url]
+	now := time.Now()
+	failures := 0
+	a.subscriptions.mux.Lock()
+	if source := a.subscriptions.sources[result.url]; source != nil {
+		source.Fetching = false
+		if result.err != nil {
+			source.Failures++
+			source.LastError = result.err.Error()
+			source.NextFetch = now.Add(subscriptionRetry(source.Failures, interval))
+		} else {
+			source.Failures = 0
+			source.LastError = ""
+			source.LastSuccess = &now
+			source.NextFetch = now.Add(interval + subscriptionJitter(interval))
+		}
+		failures = source.Failures
+	}
+	a.subscriptions.mux.Unlock()
+
+	if result.err != nil {
+		log.Error().Str("url", result.url).Int("failures", failures).Err(result.err).Msg("failed to fetch subscription")
+		if failures <= 1 {
+			a.Notify(notify.EventSubscriptionFailed, fmt.Sprintf("failed to fetch subscription list %s: %v", result.url, result.err))
+		}
+		return
+	}
+	// Updates replace groups, so keys are collected first
+	var keys []subscriptionKey
+	for _, grp := range a.groups {
+		for _, sub := range grp.Subscriptions {
+			if sub.URL == result.url {
+				keys = append(keys, subscriptionKeyOf(grp.ID, sub.ID))
+			}
+		}
+	}
+	for _, key := range keys {
+		err := a.updateSubscription(key, result.entries)
+		if err != nil {
+			log.Error().
+				Str("group", key.group.String()).
+				Str("subscription", key.subscription.String()).
+				Err(err).
+				Msg("failed to apply subscription")
+		}
+	}
+}
+
+// SubscriptionSources returns fetch statuses of lists of subscriptions by the URL
+func (a *App) SubscriptionSources() []SubscriptionSource {
+	a.subscriptions.mux.Lock()
+	sources := make([]SubscriptionSource, 0, len(a.subscriptions.sources))
+	for _, source := range a.subscriptions.sources {
+		sources = append(sources, *source)
+	}
+	a.subscriptions.mux.Unlock()
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].URL < sources[j].URL
+	})
+	return sources
+}
+
+// findSubscription returns the group and its subscription by the key
+func (a *App) findSubscription(key subscriptionKey) (models.Group, *models.Subscription, error) {
+	groupModel, ok := a.FindGroup(key.group.String())
+	if !ok {
+		return groupModel, nil, ErrGroupNotFound
+	}
+	sub := groupModel.FindSubscription(key.subscription.String())
+	if sub == nil {
+		return groupModel, nil, ErrSubscriptionNotFound
+	}
+	return groupModel, sub, nil
+}
+
+// updateSubscription replaces rules of the subscription by the entries of the list,
+// the update is held if it removes more rules than the threshold of the subscription allows
+func (a *App) updateSubscription(key subscriptionKey, entries []string) error {
+	groupModel, sub, err := a.findSubscription(key)
+	if err != nil {
+		return err
+	}
+	current := subscription.Entries(sub.ID, groupModel.Rules)
+	added, removed := subscription.Diff(current, entries)
+
+	s := &a.subscriptions
+	s.mux.Lock()
+	if added == 0 && removed == 0 {
+		delete(s.held, key)
+		s.mux.Unlock()
+		return nil
+	}
+	if subscription.Hold(current, removed, sub.HoldThresholdOrDefault()) {
+		if slices.Equal(s.rejected[key], entries) {
+			s.mux.Unlock()
+			log.Debug().Str("subscription", sub.ID.String()).Msg("subscription update was rejected")
+			return nil
+		}
+		previous := s.held[key]
+		if s.held == nil {
+			s.held = make(map[subscriptionKey]*HeldUpdate)
+		}
+		s.held[key] = &HeldUpdate{
+			GroupID:        key.group,
+			SubscriptionID: key.subscription,
+			URL:            sub.URL,
+			Current:        len(current),
+			Added:          added,
+			Removed:        removed,
+			Fetched:        time.Now(),
+			entries:        entries,
+		}
+		s.mux.Unlock()
+		if previous == nil || !slices.Equal(previous.entries, entries) {
+			log.Warn().
+				Str("group", key.group.String()).
+				Str("subscription", sub.ID.String()).
+				Int("current", len(current)).
+				Int("removed", removed).
+				Msg("subscription update is held until approved")
+			a.Notify(notify.EventSubscriptionHeld, fmt.Sprintf("update of subscription %s of group %s removes %d of %d rules and waits for approval",
+				sub.ID.String(), groupModel.Name, removed, len(current)))
+		}
+		return nil
+	}
+	delete(s.held, key)
+	delete(s.rejected, key)
+	s.mux.Unlock()
+
+	return a.applySubscription(groupModel, sub.ID, entries)
+}
+
+// applySubscription replaces rules of the subscription in the group, generated rules don't replace
+// rules with the same ID defined by the user
+func (a *App) applySubscription(groupModel models.Group, id models.ID, entries []string) error {
+	rules := make([]*models.Rule, 0, len(groupModel.Rules)+len(entries))
+	taken := make(map[models.ID]struct{})
+	for _, rule := range groupModel.Rules {
+		if rule.FromSubscription(id) {
+			continue
+		}
+		rules = append(rules, rule)
+		taken[rule.ID] = struct{}{}
+	}
+	for _, rule := range subscription.Rules(id, entries) {
+		if _, exists := taken[rule.ID]; exists {
+			continue
+		}
+		rules = append(rules, rule)
+		taken[rule.ID] = struct{}{}
+	}
+	groupModel.Rules = rules
+
+	err := a.UpdateGroup(groupModel)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("group", groupModel.ID.String()).Str("subscription", id.String()).Int("rules", len(entries)).Msg("updated subscription")
+	return nil
+}
+
+// takeHeld removes the held update of the subscription
+func (a *App) takeHeld(groupKey, subKey string) (*HeldUpdate, error) {
+	groupModel, ok := a.FindGroup(groupKey)
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	sub := groupModel.FindSubscription(subKey)
+	if sub == nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	key := subscriptionKeyOf(groupModel.ID, sub.ID)
+
+	a.subscriptions.mux.Lock()
+	defer a.subscriptions.mux.Unlock()
+	held, ok := a.subscriptions.held[key]
+	if !ok {
+		return nil, ErrSubscriptionNotHeld
+	}
+	delete(a.subscriptions.held, key)
+	return held, nil
+}
+
+func subscriptionKeyOf(groupID, subscriptionID models.ID) subscriptionKey {
+	return subscriptionKey{group: groupID, subscription: subscriptionID}
+}
+
+// ApproveSubscription applies the held update of the subscription of the group (slug or hex ID)
+func (a *App) ApproveSubscription(groupKey, subKey string) error {
+	held, err := a.takeHeld(groupKey, subKey)
+	if err != nil {
+		return err
+	}
+	groupModel, sub, err := a.findSubscription(subscriptionKeyOf(held.GroupID, held.SubscriptionID))
+	if err != nil {
+		return err
+	}
+	log.Info().Str("group", held.GroupID.String()).Str("subscription", held.SubscriptionID.String()).Msg("subscription update approved")
+	return a.applySubscription(groupModel, sub.ID, held.entries)
+}
+
+// RejectSubscription drops the held update of the subscription, the same list is not held again
+func (a *App) RejectSubscription(groupKey, subKey string) error {
+	held, err := a.takeHeld(groupKey, subKey)
+	if err != nil {
+		return err
+	}
+	a.subscriptions.mux.Lock()
+	if a.subscriptions.rejected == nil {
+		a.subscriptions.rejected = make(map[subscriptionKey][]string)
+	}
+	a.subscriptions.rejected[subscriptionKeyOf(held.GroupID, held.SubscriptionID)] = held.entries
+	a.subscriptions.mux.Unlock()
+	log.Info().Str("group", held.GroupID.String()).Str("subscription", held.SubscriptionID.String()).Msg("subscription update rejected")
+	return nil
+}
+
+// HeldUpdates returns updates of subscriptions waiting for approval
+func (a *App) HeldUpdates() []HeldUpdate {
+	a.subscriptions.mux.Lock()
+	updates := make([]HeldUpdate, 0, len(a.subscriptions.held))
+	for _, held := range a.subscriptions.held {
+		updates = append(updates, *held)
+	}
+	a.subscriptions.mux.Unlock()
+	sort.Slice(updates, func(i, j int) bool {
+		if updates[i].GroupID != updates[j].GroupID {
+			return updates[i].GroupID.String() < updates[j].GroupID.String()
+		}
+		return updates[i].SubscriptionID.String() < updates[j].SubscriptionID.String()
+	})
+	return updates
+}
+
+// handleSubscriptionCommand handles "subscription:approve:<group>:<subscription>" (reject) of the control socket
+func (a *App) handleSubscriptionCommand(action, groupKey, subKey string) error {
+	switch strings.ToLower(action) {
+	case "approve":
+		return a.ApproveSubscription(groupKey, subKey)
+	case "reject":
+		return a.RejectSubscription(groupKey, subKey)
+	default:
+		return fmt.Errorf("unknown subscription action: %s", action)
+	}
+}
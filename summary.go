@@ -0,0 +1,120 @@
+package magitrickle
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"magitrickle/group"
+	"magitrickle/net-namespace"
+	"magitrickle/notify"
+	"magitrickle/records"
+	"magitrickle/summary"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+)
+
+// summaryRefreshInterval is how often per-group address counters are refreshed
+const summaryRefreshInterval = 30 * time.Second
+
+// Summary returns aggregated counters for the dashboard
+func (a *App) Summary() summary.Summary {
+	return a.stats.Snapshot(time.Now())
+}
+
+func (a *App) refreshSummary() {
+	previous := make(map[string]summary.Group)
+	for _, group := range a.stats.Groups() {
+		previous[group.ID] = group
+	}
+	flows := a.sampleFlows()
+	groups := make([]summary.Group, 0, len(a.groups))
+	for idx, group := range a.groups {
+		if !group.IsEnabled() {
+			frozen := previous[group.ID.String()]
+			frozen.ID, frozen.Name, frozen.Interface, frozen.Enabled = group.ID.String(), group.Name, group.Interface, false
+			groups = append(groups, frozen)
+			continue
+		}
+		groups = append(groups, summary.Group{
+			ID:             group.ID.String(),
+			Name:           group.Name,
+			Interface:      group.Interface,
+			Enabled:        true,
+			Addresses:      ipsetSize(group),
+			Flows:          flows[idx].flows,
+			Bytes:          flows[idx].bytes,
+			PendingRetries: group.PendingRetries(),
+		})
+	}
+	a.stats.SetGroups(groups)
+	a.sampleMetrics(time.Now())
+}
+
+// initInterfaceStates records the current state of group interfaces, later it is kept by link updates
+func (a *App) initInterfaceStates() {
+	for _, group := range a.groups {
+		if group.Interface == "" {
+			continue
+		}
+		link, err := netNamespace.Netlink.LinkByName(group.Interface)
+		if err != nil {
+			log.Debug().Str("interface", group.Interface).Err(err).Msg("failed to get interface state")
+			a.stats.SetInterface(group.Interface, false)
+			continue
+		}
+		a.stats.SetInterface(group.Interface, link.Attrs().Flags&net.FlagUp != 0)
+	}
+}
+
+func (a *App) updateInterfaceState(event netlink.LinkUpdate) {
+	ifaceName := event.Link.Attrs().Name
+	for _, group := range a.groups {
+		if group.Interface != ifaceName {
+			continue
+		}
+		// 17 is RTM_DELLINK
+		up := event.Header.Type != 17 && event.Link.Attrs().Flags&net.FlagUp != 0
+		if a.stats.SetInterface(ifaceName, up) && !up {
+			a.Notify(notify.EventInterfaceDown, fmt.Sprintf("interface %s of group %s is down", ifaceName, group.Name))
+		}
+		return
+	}
+}
+
+// RecordsStats returns the memory usage of the DNS records store
+func (a *App) RecordsStats() records.Stats {
+	if a.records == nil {
+		return records.Stats{}
+	}
+	return a.records.Stats()
+}
+
+// ListRecords returns cached DNS records sorted by domain
+func (a *App) ListRecords() []records.Entry {
+	if a.records == nil {
+		return nil
+	}
+	return a.records.List()
+}
+
+// GroupMatcherStats are counters of the rule index of the group
+type GroupMatcherStats struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	group.MatcherStats
+}
+
+// MatcherStats returns counters of rule indexes of the groups
+func (a *App) MatcherStats() []GroupMatcherStats {
+	stats := make([]GroupMatcherStats, 0, len(a.groups))
+	for _, grp := range a.groups {
+		stats = append(stats, GroupMatcherStats{
+			ID:           grp.ID.String(),
+			Name:         grp.Name,
+			MatcherStats: grp.MatcherStats(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,259 @@
+// Package summary keeps aggregated counters for the dashboard, they are updated
+// as events happen so a snapshot never touches ipsets or history files.
+package summary
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// QPSWindow is the period the DNS query rate is averaged over
+	QPSWindow = 5 * time.Minute
+	// RecentDomains is the number of recently matched domains kept
+	RecentDomains = 10
+)
+
+// Reasons of skipped answer records, they tell whether nothing is routed because of rules or because of traffic
+const (
+	// DropUnsupportedType is a record of a type the pipeline doesn't handle (AAAA, TXT...)
+	DropUnsupportedType = "unsupportedType"
+	// DropMalformedName is a record with an empty or not fully qualified name
+	DropMalformedName = "malformedName"
+	// DropUnmatched is a record no rule of enabled groups matched
+	DropUnmatched = "unmatched"
+	// DropDuplicate is a record of an answer skipped as a duplicate (see dedupWindow)
+	DropDuplicate = "duplicate"
+)
+
+type RecentDomain struct {
+	Domain    string    `json:"domain"`
+	Group     string    `json:"group"`
+	Count     uint64    `json:"count"`
+	LastMatch time.Time `json:"lastMatch"`
+}
+
+// RuleHits is the number of addresses the rule matched since the start
+type RuleHits struct {
+	Group string `json:"group"`
+	Rule  string `json:"rule"`
+	Hits  uint64 `json:"hits"`
+}
+
+type ruleKey struct {
+	group string
+	rule  string
+}
+
+type Upstream struct {
+	Healthy             bool      `json:"healthy"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           time.Time `json:"lastError"`
+	LastErrorText       string    `json:"lastErrorText,omitempty"`
+	ConsecutiveFailures uint64    `json:"consecutiveFailures"`
+}
+
+// Verification counts suspicious answers checked by the trusted upstream
+type Verification struct {
+	// Replaced answers are routed by the answer of the trusted upstream
+	Replaced uint64 `json:"replaced"`
+	// Failed answers are routed as is, the trusted upstream didn't answer
+	Failed uint64 `json:"failed"`
+}
+
+type Group struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Interface string `json:"interface"`
+	// Enabled is false for groups disabled through the API, their counters are frozen
+	Enabled   bool `json:"enabled"`
+	Addresses int  `json:"addresses"`
+	// Flows and Bytes are active conntrack entries to routed addresses (bytes need nf_conntrack_acct)
+	Flows int    `json:"flows"`
+	Bytes uint64 `json:"bytes"`
+	// PendingRetries are addresses that failed to be added to the ipset and wait for the retry
+	PendingRetries int `json:"pendingRetries"`
+}
+
+type Summary struct {
+	Groups        []Group         `json:"groups"`
+	RecentDomains []RecentDomain  `json:"recentDomains"`
+	QPS           float64         `json:"qps"`
+	Upstream      Upstream        `json:"upstream"`
+	Interfaces    map[string]bool `json:"interfaces"`
+	// DroppedRecords are counters of skipped answer records by the reason since the start
+	DroppedRecords map[string]uint64 `json:"droppedRecords"`
+	Verification   Verification      `json:"verification"`
+}
+
+type bucket struct {
+	second int64
+	count  uint64
+}
+
+type Collector struct {
+	mux        sync.Mutex
+	buckets    [int(QPSWindow / time.Second)]bucket
+	recent     []RecentDomain
+	upstream   Upstream
+	groups     []Group
+	interfaces map[string]bool
+	dropped    map[string]uint64
+	hits       map[ruleKey]uint64
+	verified   Verification
+}
+
+func New() *Collector {
+	return &Collector{interfaces: make(map[string]bool), dropped: make(map[string]uint64), hits: make(map[ruleKey]uint64)}
+}
+
+// Hit counts an address matched by the rule of the group
+func (c *Collector) Hit(group, rule string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.hits[ruleKey{group: group, rule: rule}]++
+}
+
+// RuleHits returns hit counters of rules from the most hit
+func (c *Collector) RuleHits() []RuleHits {
+	c.mux.Lock()
+	hits := make([]RuleHits, 0, len(c.hits))
+	for key, count := range c.hits {
+		hits = append(hits, RuleHits{Group: key.group, Rule: key.rule, Hits: count})
+	}
+	c.mux.Unlock()
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Hits != hits[j].Hits {
+			return hits[i].Hits > hits[j].Hits
+		}
+		if hits[i].Group != hits[j].Group {
+			return hits[i].Group < hits[j].Group
+		}
+		return hits[i].Rule < hits[j].Rule
+	})
+	return hits
+}
+
+// Drop counts skipped answer records by the reason
+func (c *Collector) Drop(reason string, count int) {
+	if count == 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.dropped[reason] += uint64(count)
+}
+
+// Verify counts a suspicious answer checked by the trusted upstream, replaced is false if it failed
+func (c *Collector) Verify(replaced bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if replaced {
+		c.verified.Replaced++
+	} else {
+		c.verified.Failed++
+	}
+}
+
+// Query counts a served DNS response
+func (c *Collector) Query(now time.Time) {
+	second := now.Unix()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	b := &c.buckets[second%int64(len(c.buckets))]
+	if b.second != second {
+		b.second = second
+		b.count = 0
+	}
+	b.count++
+}
+
+// Upstream records the result of an upstream request
+func (c *Collector) Upstream(now time.Time, err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err != nil {
+		c.upstream.LastError = now
+		c.upstream.LastErrorText = err.Error()
+		c.upstream.ConsecutiveFailures++
+		return
+	}
+	c.upstream.LastSuccess = now
+	c.upstream.ConsecutiveFailures = 0
+}
+
+// Match moves the domain to the top of recently matched ones
+func (c *Collector) Match(now time.Time, domain, group string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	entry := RecentDomain{Domain: domain, Group: group}
+	for idx, recent := range c.recent {
+		if recent.Domain == domain && recent.Group == group {
+			entry = recent
+			c.recent = append(c.recent[:idx], c.recent[idx+1:]...)
+			break
+		}
+	}
+	entry.Count++
+	entry.LastMatch = now
+	if len(c.recent) >= RecentDomains {
+		c.recent = c.recent[:RecentDomains-1]
+	}
+	c.recent = append([]RecentDomain{entry}, c.recent...)
+}
+
+// SetGroups replaces the per-group address counters
+func (c *Collector) SetGroups(groups []Group) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.groups = groups
+}
+
+// Groups returns the per-group counters
+func (c *Collector) Groups() []Group {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]Group{}, c.groups...)
+}
+
+// SetInterface records the state of the interface and reports whether it changed
+func (c *Collector) SetInterface(name string, up bool) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	previous, known := c.interfaces[name]
+	c.interfaces[name] = up
+	return !known || previous != up
+}
+
+func (c *Collector) Snapshot(now time.Time) Summary {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var total uint64
+	from := now.Add(-QPSWindow).Unix()
+	for _, b := range c.buckets {
+		if b.second > from && b.second <= now.Unix() {
+			total += b.count
+		}
+	}
+
+	summary := Summary{
+		Groups:         append([]Group{}, c.groups...),
+		RecentDomains:  append([]RecentDomain{}, c.recent...),
+		QPS:            float64(total) / QPSWindow.Seconds(),
+		Upstream:       c.upstream,
+		Interfaces:     make(map[string]bool, len(c.interfaces)),
+		DroppedRecords: make(map[string]uint64, len(c.dropped)),
+		Verification:   c.verified,
+	}
+	summary.Upstream.Healthy = c.upstream.ConsecutiveFailures == 0 && !c.upstream.LastSuccess.IsZero()
+	for name, up := range c.interfaces {
+		summary.Interfaces[name] = up
+	}
+	for reason, count := range c.dropped {
+		summary.DroppedRecords[reason] = count
+	}
+	sort.Slice(summary.Groups, func(i, j int) bool { return summary.Groups[i].Name < summary.Groups[j].Name })
+	return summary
+}
@@ -0,0 +1,67 @@
+package summary
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	c := New()
+	now := time.Unix(1700000000, 0)
+
+	// Outside of the window
+	c.Query(now.Add(-QPSWindow))
+	for i := 299; i >= 0; i-- {
+		c.Query(now.Add(-time.Duration(i) * time.Second))
+	}
+	if qps := c.Snapshot(now).QPS; qps != 1 {
+		t.Fatalf("unexpected qps: %v", qps)
+	}
+
+	for i := 0; i < RecentDomains+2; i++ {
+		c.Match(now, fmt.Sprintf("%d.example.com", i), "g")
+	}
+	c.Match(now, "5.example.com", "g")
+	recent := c.Snapshot(now).RecentDomains
+	if len(recent) != RecentDomains || recent[0].Domain != "5.example.com" || recent[0].Count != 2 {
+		t.Fatalf("unexpected recent domains: %+v", recent)
+	}
+
+	if c.Snapshot(now).Upstream.Healthy {
+		t.Fatal("upstream healthy without requests")
+	}
+	c.Upstream(now, nil)
+	if !c.Snapshot(now).Upstream.Healthy {
+		t.Fatal("upstream unhealthy after success")
+	}
+	c.Upstream(now, errors.New("timeout"))
+	if upstream := c.Snapshot(now).Upstream; upstream.Healthy || upstream.ConsecutiveFailures != 1 {
+		t.Fatalf("unexpected upstream state: %+v", upstream)
+	}
+
+	c.Drop(DropUnmatched, 2)
+	c.Drop(DropUnmatched, 1)
+	c.Drop(DropDuplicate, 0)
+	if dropped := c.Snapshot(now).DroppedRecords; len(dropped) != 1 || dropped[DropUnmatched] != 3 {
+		t.Fatalf("unexpected dropped records: %v", dropped)
+	}
+	c.Verify(true)
+	c.Verify(false)
+	c.Verify(true)
+	if verification := c.Snapshot(now).Verification; verification != (Verification{Replaced: 2, Failed: 1}) {
+		t.Fatalf("unexpected verification counters: %+v", verification)
+	}
+}
+
+func TestRuleHits(t *testing.T) {
+	c := New()
+	c.Hit("g1", "r1")
+	c.Hit("g2", "r1")
+	c.Hit("g2", "r1")
+	hits := c.RuleHits()
+	if len(hits) != 2 || hits[0] != (RuleHits{Group: "g2", Rule: "r1", Hits: 2}) || hits[1] != (RuleHits{Group: "g1", Rule: "r1", Hits: 1}) {
+		t.Fatalf("unexpected hits: %+v", hits)
+	}
+}
@@ -0,0 +1,116 @@
+package magitrickle
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"magitrickle/dns-mitm-proxy"
+	"magitrickle/keenetic-rci"
+	"magitrickle/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// upstreamRefreshInterval is how often resolvers of the WAN are re-read
+const upstreamRefreshInterval = 10 * time.Second
+
+// validateUpstreams checks DNS-over-HTTPS URLs of the upstream and the race upstream, resolvers of the WAN
+// would replace the DoH upstream, so they can't be combined
+func validateUpstreams(cfg models.DNSProxy) error {
+	for _, upstream := range []models.DNSProxyServer{cfg.Upstream, cfg.RaceUpstream} {
+		if !dnsMitmProxy.IsDoH(upstream.Address) {
+			continue
+		}
+		err := dnsMitmProxy.ParseDoH(upstream.Address)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidUpstream, err)
+		}
+	}
+	if cfg.UpstreamSource != "" && dnsMitmProxy.IsDoH(cfg.Upstream.Address) {
+		return fmt.Errorf("%w: upstreamSource replaces the DNS-over-HTTPS upstream", ErrInvalidUpstream)
+	}
+	return nil
+}
+
+// parseResolvConf returns nameservers of the resolv.conf file
+func parseResolvConf(data []byte) []models.DNSProxyServer {
+	var servers []models.DNSProxyServer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		// Zone of link-local addresses is not supported by the proxy
+		address, _, _ := strings.Cut(fields[1], "%")
+		servers = append(servers, models.DNSProxyServer{Address: address, Port: 53})
+	}
+	return servers
+}
+
+// wanResolvers returns resolvers of the configured source
+func (a *App) wanResolvers() ([]models.DNSProxyServer, error) {
+	switch a.config.DNSProxy.UpstreamSource {
+	case models.UpstreamSourceResolvConf:
+		data, err := os.ReadFile(a.config.DNSProxy.ResolvConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read resolv.conf: %w", err)
+		}
+		return parseResolvConf(data), nil
+	case models.UpstreamSourceRCI:
+		ctx, cancel := context.WithTimeout(context.Background(), rciTimeout)
+		defer cancel()
+		nameServers, err := keeneticRCI.NameServers(ctx, keeneticRCI.DefaultURL)
+		if err != nil {
+			return nil, err
+		}
+		servers := make([]models.DNSProxyServer, 0, len(nameServers))
+		for _, server := range nameServers {
+			servers = append(servers, models.DNSProxyServer{Address: server.Address, Port: server.Port})
+		}
+		return servers, nil
+	}
+	return nil, nil
+}
+
+// wanUpstream returns the first resolver that is not the router itself (127.0.0.1 forwards to the firmware resolver)
+func wanUpstream(servers []models.DNSProxyServer) (models.DNSProxyServer, bool) {
+	for _, server := range servers {
+		ip := net.ParseIP(server.Address)
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+			continue
+		}
+		return server, true
+	}
+	return models.DNSProxyServer{}, false
+}
+
+// refreshUpstream switches the DNS proxy to the current resolver of the WAN, the configured upstream is kept
+// until one is known
+func (a *App) refreshUpstream() {
+	if a.config.DNSProxy.UpstreamSource == "" || a.dnsMITM == nil {
+		return
+	}
+	servers, err := a.wanResolvers()
+	if err != nil {
+		log.Warn().Err(err).Str("source", a.config.DNSProxy.UpstreamSource).Msg("failed to read WAN resolvers")
+		return
+	}
+	upstream, ok := wanUpstream(servers)
+	if !ok || upstream == a.wanUpstream {
+		return
+	}
+	a.wanUpstream = upstream
+	a.dnsMITM.SetUpstream(upstream.Address, upstream.Port)
+	log.Info().
+		Str("address", upstream.Address).
+		Uint16("port", upstream.Port).
+		Str("source", a.config.DNSProxy.UpstreamSource).
+		Msg("upstream changed to WAN resolver")
+}
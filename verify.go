@@ -0,0 +1,144 @@
+package magitrickle
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"magitrickle/models"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultBogusNetworks are networks ISPs answer with for filtered domains
+var DefaultBogusNetworks = []string{"0.0.0.0/8", "127.0.0.0/8"}
+
+type answerVerifier struct {
+	upstream string
+	bogus    []*net.IPNet
+	empty    bool
+	always   []string
+	never    []string
+}
+
+// validateVerify checks the trusted upstream and bogus networks of the verification
+func validateVerify(verify models.Verify) error {
+	if verify.Upstream.Address == "" {
+		return nil
+	}
+	if net.ParseIP(verify.Upstream.Address) == nil {
+		return fmt.Errorf("%w: invalid upstream address %q", ErrInvalidVerify, verify.Upstream.Address)
+	}
+	for _, network := range verify.Bogus {
+		_, err := models.ParseCIDR(network)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidVerify, err)
+		}
+	}
+	return nil
+}
+
+// compileVerify returns the verifier of the config, nil if the verification is disabled. The config must be validated.
+func compileVerify(verify models.Verify) *answerVerifier {
+	if verify.Upstream.Address == "" {
+		return nil
+	}
+	port := verify.Upstream.Port
+	if port == 0 {
+		port = 53
+	}
+	v := &answerVerifier{
+		upstream: net.JoinHostPort(verify.Upstream.Address, strconv.Itoa(int(port))),
+		empty:    verify.Empty,
+		always:   normalizeDomains(verify.Always),
+		never:    normalizeDomains(verify.Never),
+	}
+	bogus := verify.Bogus
+	if len(bogus) == 0 {
+		bogus = DefaultBogusNetworks
+	}
+	for _, network := range bogus {
+		ipNet, _ := models.ParseCIDR(network)
+		v.bogus = append(v.bogus, ipNet)
+	}
+	return v
+}
+
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		if domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized
+}
+
+// hasDomain reports whether the name is one of the domains or their subdomain
+func hasDomain(domains []string, name string) bool {
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// suspicious reports whether the answer to the A question must be checked by the trusted upstream
+func (v *answerVerifier) suspicious(name string, msg *dns.Msg) bool {
+	if hasDomain(v.never, name) {
+		return false
+	}
+	if hasDomain(v.always, name) {
+		return true
+	}
+	hasAddress := false
+	for _, rr := range msg.Answer {
+		record, ok := rr.(*dns.A)
+		if !ok {
+			continue
+		}
+		hasAddress = true
+		for _, network := range v.bogus {
+			if network.Contains(record.A) {
+				return true
+			}
+		}
+	}
+	return !hasAddress && v.empty && (msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError)
+}
+
+// verifyAnswer returns the answer to be routed: the answer of the trusted upstream if the original one
+// is suspicious, the original one otherwise or if the trusted upstream fails
+func (a *App) verifyAnswer(respMsg *dns.Msg) *dns.Msg {
+	if a.verifier == nil || len(respMsg.Question) != 1 || respMsg.Question[0].Qtype != dns.TypeA {
+		return respMsg
+	}
+	question := respMsg.Question[0]
+	name := strings.TrimSuffix(strings.ToLower(question.Name), ".")
+	if !a.verifier.suspicious(name, respMsg) {
+		return respMsg
+	}
+
+	reqMsg := new(dns.Msg)
+	reqMsg.SetQuestion(dns.Fqdn(question.Name), question.Qtype)
+	trustedMsg, err := a.dnsMITM.ExchangeWith(reqMsg, []string{a.verifier.upstream})
+	if err == nil && trustedMsg.Rcode != dns.RcodeSuccess && trustedMsg.Rcode != dns.RcodeNameError {
+		err = fmt.Errorf("trusted upstream answered %s", dns.RcodeToString[trustedMsg.Rcode])
+	}
+	if err != nil {
+		log.Debug().Str("name", question.Name).Err(err).Msg("failed to verify answer")
+		a.stats.Verify(false)
+		return respMsg
+	}
+	log.Debug().
+		Str("name", question.Name).
+		Int("answers", len(respMsg.Answer)).
+		Int("trustedAnswers", len(trustedMsg.Answer)).
+		Msg("routing the answer of the trusted upstream")
+	a.stats.Verify(true)
+	return trustedMsg
+}
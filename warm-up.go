@@ -0,0 +1,67 @@
+package magitrickle
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+const warmUpConcurrency = 4
+
+// warmUpDomains returns literal (non-wildcard) domains of the enabled rules
+func (a *App) warmUpDomains() []string {
+	domains := make(map[string]struct{})
+	for _, group := range a.groups {
+		for _, rule := range group.Rules {
+			if !rule.IsEnabled() {
+				continue
+			}
+			switch rule.Type {
+			case "domain", "namespace":
+				domains[rule.Rule] = struct{}{}
+			}
+		}
+	}
+
+	domainList := make([]string, 0, len(domains))
+	for domain := range domains {
+		domainList = append(domainList, domain)
+	}
+	return domainList
+}
+
+// warmUp resolves literal rule domains through the upstream to fill ipsets
+// before clients make their first queries
+func (a *App) warmUp(ctx context.Context) {
+	domains := a.warmUpDomains()
+	log.Info().Int("domains", len(domains)).Msg("warming up")
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, warmUpConcurrency)
+	for _, domain := range domains {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(domain string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			_, err := a.resolve(domain, dns.TypeA)
+			if err != nil {
+				log.Debug().Str("domain", domain).Err(err).Msg("failed to warm up domain")
+			}
+		}(domain)
+	}
+	wg.Wait()
+
+	log.Info().Msg("warm up finished")
+}